@@ -41,6 +41,7 @@ type IdExtractorSource string
 type IdExtractorType string
 type AuthTypeEnum string
 type RoutingTriggerOnType string
+type RateLimitAlgorithm string
 
 type SubscriptionType string
 
@@ -62,6 +63,11 @@ const (
 	GrpcDriver     MiddlewareDriver = "grpc"
 	GoPluginDriver MiddlewareDriver = "goplugin"
 
+	// RateLimitGCRA and RateLimitSlidingWindow are the rate limiting algorithms evaluated by an
+	// atomic Redis script, using O(1) memory per key.
+	RateLimitGCRA          RateLimitAlgorithm = "gcra"
+	RateLimitSlidingWindow RateLimitAlgorithm = "sliding_window"
+
 	BodySource        IdExtractorSource = "body"
 	HeaderSource      IdExtractorSource = "header"
 	QuerystringSource IdExtractorSource = "querystring"
@@ -226,6 +232,10 @@ type CacheMeta struct {
 	Path                   string `bson:"path" json:"path"`
 	CacheKeyRegex          string `bson:"cache_key_regex" json:"cache_key_regex"`
 	CacheOnlyResponseCodes []int  `bson:"cache_response_codes" json:"cache_response_codes"`
+	// StaleWhileRevalidate overrides the stale-while-revalidate window of the API for the endpoint.
+	StaleWhileRevalidate int64 `bson:"stale_while_revalidate" json:"stale_while_revalidate"`
+	// StaleIfError overrides the stale-if-error window of the API for the endpoint.
+	StaleIfError int64 `bson:"stale_if_error" json:"stale_if_error"`
 }
 
 type RequestInputType string
@@ -265,6 +275,87 @@ type HardTimeoutMeta struct {
 	TimeOut  int    `bson:"timeout" json:"timeout"`
 }
 
+// RetryPolicyMeta overrides the API retry policy for an endpoint.
+type RetryPolicyMeta struct {
+	Disabled bool   `bson:"disabled" json:"disabled"`
+	Path     string `bson:"path" json:"path"`
+	Method   string `bson:"method" json:"method"`
+
+	MaxAttempts        int     `bson:"max_attempts" json:"max_attempts"`
+	RetryOnStatusCodes []int   `bson:"retry_on_status_codes" json:"retry_on_status_codes"`
+	PerTryTimeout      float64 `bson:"per_try_timeout" json:"per_try_timeout"`
+	BaseBackoff        int64   `bson:"base_backoff" json:"base_backoff"`
+	MaxBackoff         int64   `bson:"max_backoff" json:"max_backoff"`
+}
+
+// RetryPolicy returns the retry policy configured by the endpoint meta.
+func (m RetryPolicyMeta) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Enabled:            !m.Disabled,
+		MaxAttempts:        m.MaxAttempts,
+		RetryOnStatusCodes: m.RetryOnStatusCodes,
+		PerTryTimeout:      m.PerTryTimeout,
+		BaseBackoff:        m.BaseBackoff,
+		MaxBackoff:         m.MaxBackoff,
+	}
+}
+
+// MirrorMeta overrides the API traffic mirroring for an endpoint.
+type MirrorMeta struct {
+	Disabled bool   `bson:"disabled" json:"disabled"`
+	Path     string `bson:"path" json:"path"`
+	Method   string `bson:"method" json:"method"`
+
+	Target      string  `bson:"target" json:"target"`
+	Percentage  float64 `bson:"percentage" json:"percentage"`
+	Timeout     float64 `bson:"timeout" json:"timeout"`
+	MaxInFlight int     `bson:"max_in_flight" json:"max_in_flight"`
+}
+
+// Mirror returns the traffic mirroring configured by the endpoint meta.
+func (m MirrorMeta) Mirror() MirrorConfig {
+	return MirrorConfig{
+		Enabled:     !m.Disabled,
+		Target:      m.Target,
+		Percentage:  m.Percentage,
+		Timeout:     m.Timeout,
+		MaxInFlight: m.MaxInFlight,
+	}
+}
+
+// ConcurrencyLimitMeta overrides the API concurrency limit for an endpoint. The endpoint
+// gets its own slots, which don't count towards the limit of the API.
+type ConcurrencyLimitMeta struct {
+	Disabled bool   `bson:"disabled" json:"disabled"`
+	Path     string `bson:"path" json:"path"`
+	Method   string `bson:"method" json:"method"`
+
+	MaxConcurrency int                       `bson:"max_concurrency" json:"max_concurrency"`
+	MaxQueueSize   int                       `bson:"max_queue_size" json:"max_queue_size"`
+	QueueTimeout   float64                   `bson:"queue_timeout" json:"queue_timeout"`
+	Adaptive       AdaptiveConcurrencyConfig `bson:"adaptive" json:"adaptive"`
+}
+
+// ConcurrencyLimit returns the concurrency limit configured by the endpoint meta.
+func (m ConcurrencyLimitMeta) ConcurrencyLimit() ConcurrencyLimitConfig {
+	return ConcurrencyLimitConfig{
+		Enabled:        !m.Disabled,
+		MaxConcurrency: m.MaxConcurrency,
+		MaxQueueSize:   m.MaxQueueSize,
+		QueueTimeout:   m.QueueTimeout,
+		Adaptive:       m.Adaptive,
+	}
+}
+
+// RateLimitRuleMeta replaces the rate limit rules of the API for an endpoint. The endpoint isn't
+// rate limited by rules if disabled.
+type RateLimitRuleMeta struct {
+	Disabled bool            `bson:"disabled" json:"disabled"`
+	Path     string          `bson:"path" json:"path"`
+	Method   string          `bson:"method" json:"method"`
+	Rules    []RateLimitRule `bson:"rules" json:"rules"`
+}
+
 type TrackEndpointMeta struct {
 	Path   string `bson:"path" json:"path"`
 	Method string `bson:"method" json:"method"`
@@ -373,31 +464,35 @@ type GoPluginMeta struct {
 }
 
 type ExtendedPathsSet struct {
-	Ignored                 []EndPointMeta        `bson:"ignored" json:"ignored,omitempty"`
-	WhiteList               []EndPointMeta        `bson:"white_list" json:"white_list,omitempty"`
-	BlackList               []EndPointMeta        `bson:"black_list" json:"black_list,omitempty"`
-	MockResponse            []MockResponseMeta    `bson:"mock_response" json:"mock_response,omitempty"`
-	Cached                  []string              `bson:"cache" json:"cache,omitempty"`
-	AdvanceCacheConfig      []CacheMeta           `bson:"advance_cache_config" json:"advance_cache_config,omitempty"`
-	Transform               []TemplateMeta        `bson:"transform" json:"transform,omitempty"`
-	TransformResponse       []TemplateMeta        `bson:"transform_response" json:"transform_response,omitempty"`
-	TransformJQ             []TransformJQMeta     `bson:"transform_jq" json:"transform_jq,omitempty"`
-	TransformJQResponse     []TransformJQMeta     `bson:"transform_jq_response" json:"transform_jq_response,omitempty"`
-	TransformHeader         []HeaderInjectionMeta `bson:"transform_headers" json:"transform_headers,omitempty"`
-	TransformResponseHeader []HeaderInjectionMeta `bson:"transform_response_headers" json:"transform_response_headers,omitempty"`
-	HardTimeouts            []HardTimeoutMeta     `bson:"hard_timeouts" json:"hard_timeouts,omitempty"`
-	CircuitBreaker          []CircuitBreakerMeta  `bson:"circuit_breakers" json:"circuit_breakers,omitempty"`
-	URLRewrite              []URLRewriteMeta      `bson:"url_rewrites" json:"url_rewrites,omitempty"`
-	Virtual                 []VirtualMeta         `bson:"virtual" json:"virtual,omitempty"`
-	SizeLimit               []RequestSizeMeta     `bson:"size_limits" json:"size_limits,omitempty"`
-	MethodTransforms        []MethodTransformMeta `bson:"method_transforms" json:"method_transforms,omitempty"`
-	TrackEndpoints          []TrackEndpointMeta   `bson:"track_endpoints" json:"track_endpoints,omitempty"`
-	DoNotTrackEndpoints     []TrackEndpointMeta   `bson:"do_not_track_endpoints" json:"do_not_track_endpoints,omitempty"`
-	ValidateJSON            []ValidatePathMeta    `bson:"validate_json" json:"validate_json,omitempty"`
-	ValidateRequest         []ValidateRequestMeta `bson:"validate_request" json:"validate_request,omitempty"`
-	Internal                []InternalMeta        `bson:"internal" json:"internal,omitempty"`
-	GoPlugin                []GoPluginMeta        `bson:"go_plugin" json:"go_plugin,omitempty"`
-	PersistGraphQL          []PersistGraphQLMeta  `bson:"persist_graphql" json:"persist_graphql"`
+	Ignored                 []EndPointMeta         `bson:"ignored" json:"ignored,omitempty"`
+	WhiteList               []EndPointMeta         `bson:"white_list" json:"white_list,omitempty"`
+	BlackList               []EndPointMeta         `bson:"black_list" json:"black_list,omitempty"`
+	MockResponse            []MockResponseMeta     `bson:"mock_response" json:"mock_response,omitempty"`
+	Cached                  []string               `bson:"cache" json:"cache,omitempty"`
+	AdvanceCacheConfig      []CacheMeta            `bson:"advance_cache_config" json:"advance_cache_config,omitempty"`
+	Transform               []TemplateMeta         `bson:"transform" json:"transform,omitempty"`
+	TransformResponse       []TemplateMeta         `bson:"transform_response" json:"transform_response,omitempty"`
+	TransformJQ             []TransformJQMeta      `bson:"transform_jq" json:"transform_jq,omitempty"`
+	TransformJQResponse     []TransformJQMeta      `bson:"transform_jq_response" json:"transform_jq_response,omitempty"`
+	TransformHeader         []HeaderInjectionMeta  `bson:"transform_headers" json:"transform_headers,omitempty"`
+	TransformResponseHeader []HeaderInjectionMeta  `bson:"transform_response_headers" json:"transform_response_headers,omitempty"`
+	HardTimeouts            []HardTimeoutMeta      `bson:"hard_timeouts" json:"hard_timeouts,omitempty"`
+	CircuitBreaker          []CircuitBreakerMeta   `bson:"circuit_breakers" json:"circuit_breakers,omitempty"`
+	URLRewrite              []URLRewriteMeta       `bson:"url_rewrites" json:"url_rewrites,omitempty"`
+	Virtual                 []VirtualMeta          `bson:"virtual" json:"virtual,omitempty"`
+	SizeLimit               []RequestSizeMeta      `bson:"size_limits" json:"size_limits,omitempty"`
+	MethodTransforms        []MethodTransformMeta  `bson:"method_transforms" json:"method_transforms,omitempty"`
+	TrackEndpoints          []TrackEndpointMeta    `bson:"track_endpoints" json:"track_endpoints,omitempty"`
+	DoNotTrackEndpoints     []TrackEndpointMeta    `bson:"do_not_track_endpoints" json:"do_not_track_endpoints,omitempty"`
+	ValidateJSON            []ValidatePathMeta     `bson:"validate_json" json:"validate_json,omitempty"`
+	ValidateRequest         []ValidateRequestMeta  `bson:"validate_request" json:"validate_request,omitempty"`
+	Internal                []InternalMeta         `bson:"internal" json:"internal,omitempty"`
+	GoPlugin                []GoPluginMeta         `bson:"go_plugin" json:"go_plugin,omitempty"`
+	PersistGraphQL          []PersistGraphQLMeta   `bson:"persist_graphql" json:"persist_graphql"`
+	RetryPolicies           []RetryPolicyMeta      `bson:"retry_policies" json:"retry_policies,omitempty"`
+	Mirrors                 []MirrorMeta           `bson:"mirrors" json:"mirrors,omitempty"`
+	ConcurrencyLimits       []ConcurrencyLimitMeta `bson:"concurrency_limits" json:"concurrency_limits,omitempty"`
+	RateLimitRules          []RateLimitRuleMeta    `bson:"rate_limit_rules" json:"rate_limit_rules,omitempty"`
 }
 
 type VersionDefinition struct {
@@ -507,6 +602,33 @@ type CacheOptions struct {
 	EnableUpstreamCacheControl bool     `bson:"enable_upstream_cache_control" json:"enable_upstream_cache_control"`
 	CacheControlTTLHeader      string   `bson:"cache_control_ttl_header" json:"cache_control_ttl_header"`
 	CacheByHeaders             []string `bson:"cache_by_headers" json:"cache_by_headers"`
+	// EnableHTTPCacheSemantics caches responses following the HTTP caching semantics of RFC 9111,
+	// from the Cache-Control, Expires, ETag, Last-Modified and Vary headers of the upstream.
+	EnableHTTPCacheSemantics bool `bson:"enable_http_cache_semantics" json:"enable_http_cache_semantics"`
+	// StaleWhileRevalidate is the number of seconds an expired response is served for while it
+	// is refreshed in the background.
+	StaleWhileRevalidate int64 `bson:"stale_while_revalidate" json:"stale_while_revalidate"`
+	// StaleIfError is the number of seconds an expired response is served for when the upstream
+	// fails, times out or its circuit breaker is open.
+	StaleIfError int64 `bson:"stale_if_error" json:"stale_if_error"`
+	// EnableRequestCoalescing sends a single upstream request per node for identical concurrent
+	// requests missing the cache, and serves its response to all of them.
+	EnableRequestCoalescing bool `bson:"enable_request_coalescing" json:"enable_request_coalescing"`
+	// CoalesceUncachedRequests coalesces identical concurrent GET and HEAD requests on the paths
+	// that aren't cached too, even if the cache is disabled.
+	CoalesceUncachedRequests bool `bson:"coalesce_uncached_requests" json:"coalesce_uncached_requests"`
+	// CoalescingMaxWait is the number of seconds coalesced requests wait for the response of the
+	// first request before being sent upstream themselves, defaults to 5.
+	CoalescingMaxWait float64 `bson:"coalescing_max_wait" json:"coalescing_max_wait"`
+	// EnableLocalCache keeps the hot cached responses in memory on each node, in front of Redis,
+	// if the local response cache is enabled in the gateway configuration.
+	EnableLocalCache bool `bson:"enable_local_cache" json:"enable_local_cache"`
+	// LocalCacheQuota is the maximum size of the cached responses of the API kept in memory on
+	// each node, in bytes. The API can use up to the whole local cache if unset.
+	LocalCacheQuota int64 `bson:"local_cache_quota" json:"local_cache_quota"`
+	// EnableInvalidationIndexes indexes the cached responses by request path, surrogate key and
+	// key, so that they can be invalidated by the cache invalidation routes.
+	EnableInvalidationIndexes bool `bson:"enable_invalidation_indexes" json:"enable_invalidation_indexes"`
 }
 
 type ResponseProcessor struct {
@@ -541,8 +663,33 @@ type ServiceDiscoveryConfiguration struct {
 	UseTargetList       bool   `bson:"use_target_list" json:"use_target_list"`
 	CacheTimeout        int64  `bson:"cache_timeout" json:"cache_timeout"`
 	EndpointReturnsList bool   `bson:"endpoint_returns_list" json:"endpoint_returns_list"`
+	// Provider is where the targets are discovered from, defaults to the JSON HTTP endpoint.
+	Provider ServiceDiscoveryProvider `bson:"provider" json:"provider"`
+	// DNSServer is the host:port of the DNS server SRV records are queried from,
+	// defaults to the nameservers of the system.
+	DNSServer string `bson:"dns_server" json:"dns_server"`
 }
 
+// ServiceDiscoveryProvider is the kind of service the targets of an API are discovered from.
+type ServiceDiscoveryProvider string
+
+const (
+	// HTTPServiceDiscovery requests QueryEndpoint and reads the targets from the JSON response with
+	// the configured data paths. This is the default.
+	HTTPServiceDiscovery ServiceDiscoveryProvider = "http"
+	// DNSSRVServiceDiscovery resolves QueryEndpoint as the name of DNS SRV records, such as
+	// _http._tcp.example.com. The targets of the lowest priority with targets up are used until
+	// the TTL of the records expires. The weights of the records are applied by the
+	// weighted_round_robin and consistent_hash load balancing algorithms.
+	DNSSRVServiceDiscovery ServiceDiscoveryProvider = "dns_srv"
+	// FileServiceDiscovery reads the targets from the JSON or YAML file at the QueryEndpoint path,
+	// which is watched for changes.
+	FileServiceDiscovery ServiceDiscoveryProvider = "file"
+	// ConsulServiceDiscovery streams the healthy instances of the Consul service named QueryEndpoint
+	// with blocking queries, using the Consul configuration of the gateway.
+	ConsulServiceDiscovery ServiceDiscoveryProvider = "consul"
+)
+
 type OIDProviderConfig struct {
 	Issuer    string            `bson:"issuer" json:"issuer"`
 	ClientIDs map[string]string `bson:"client_ids" json:"client_ids"`
@@ -669,6 +816,9 @@ type APIDefinition struct {
 	ConfigDataDisabled                   bool                   `bson:"config_data_disabled" json:"config_data_disabled"`
 	TagHeaders                           []string               `bson:"tag_headers" json:"tag_headers"`
 	GlobalRateLimit                      GlobalRateLimit        `bson:"global_rate_limit" json:"global_rate_limit"`
+	RateLimitAlgorithm                   RateLimitAlgorithm     `bson:"rate_limit_algorithm" json:"rate_limit_algorithm,omitempty"` // Overrides the rate limiting algorithm of the gateway, either `gcra` or `sliding_window`.
+	RateLimitRules                       []RateLimitRule        `bson:"rate_limit_rules" json:"rate_limit_rules,omitempty"`
+	EnableRateLimitHeaders               bool                   `bson:"enable_rate_limit_headers" json:"enable_rate_limit_headers,omitempty"`
 	StripAuthData                        bool                   `bson:"strip_auth_data" json:"strip_auth_data"`
 	EnableDetailedRecording              bool                   `bson:"enable_detailed_recording" json:"enable_detailed_recording"`
 	GraphQL                              GraphQLConfig          `bson:"graphql" json:"graphql"`
@@ -727,6 +877,53 @@ type GlobalRateLimit struct {
 	Per  float64 `bson:"per" json:"per"`
 }
 
+// RateLimitKeySource is a request attribute rate limit rules are keyed on.
+type RateLimitKeySource string
+
+const (
+	// RateLimitKeyIP keys the rule on the IP address of the client.
+	RateLimitKeyIP RateLimitKeySource = "ip"
+	// RateLimitKeyHeader keys the rule on a request header.
+	RateLimitKeyHeader RateLimitKeySource = "header"
+	// RateLimitKeyClaim keys the rule on a claim of the JWT.
+	RateLimitKeyClaim RateLimitKeySource = "claim"
+	// RateLimitKeyMetadata keys the rule on a metadata field of the session.
+	RateLimitKeyMetadata RateLimitKeySource = "metadata"
+)
+
+// RateLimitKey is a request attribute a rate limit rule is keyed on.
+type RateLimitKey struct {
+	Source RateLimitKeySource `bson:"source" json:"source"`
+	// Name is the name of the header, the claim or the metadata field.
+	Name string `bson:"name" json:"name"`
+}
+
+// RateLimitMissingKey is what a rate limit rule does with the requests missing an attribute it is keyed on.
+type RateLimitMissingKey string
+
+const (
+	// RateLimitMissingKeySkip doesn't limit the requests missing an attribute with the rule.
+	RateLimitMissingKeySkip RateLimitMissingKey = "skip"
+	// RateLimitMissingKeyReject rejects the requests missing an attribute with a 400 response.
+	RateLimitMissingKeyReject RateLimitMissingKey = "reject"
+)
+
+// RateLimitRule limits the requests of every client, identified by the values of the request
+// attributes the rule is keyed on, to Rate requests every Per seconds. Rules apply to keyless
+// APIs too.
+type RateLimitRule struct {
+	// Name identifies the counters of the rule, so they are kept when the rules are reordered.
+	Name  string         `bson:"name" json:"name"`
+	KeyBy []RateLimitKey `bson:"key_by" json:"key_by"`
+	Rate  float64        `bson:"rate" json:"rate"`
+	Per   float64        `bson:"per" json:"per"`
+	// Burst is the number of requests a client can send at once. Defaults to the rate.
+	Burst int64 `bson:"burst" json:"burst"`
+	// MissingKey is what the rule does with the requests missing an attribute it is keyed on,
+	// either `skip` or `reject`. Defaults to `skip`.
+	MissingKey RateLimitMissingKey `bson:"missing_key" json:"missing_key,omitempty"`
+}
+
 type BundleManifest struct {
 	FileList         []string          `bson:"file_list" json:"file_list"`
 	CustomMiddleware MiddlewareSection `bson:"custom_middleware" json:"custom_middleware"`
@@ -755,6 +952,15 @@ type ProxyConfig struct {
 	StructuredTargetList        *HostList                     `bson:"-" json:"-"`
 	CheckHostAgainstUptimeTests bool                          `bson:"check_host_against_uptime_tests" json:"check_host_against_uptime_tests"`
 	ServiceDiscovery            ServiceDiscoveryConfiguration `bson:"service_discovery" json:"service_discovery"`
+	LoadBalancing               LoadBalancingConfig           `bson:"load_balancing" json:"load_balancing"`
+	OutlierDetection            OutlierDetectionConfig        `bson:"outlier_detection" json:"outlier_detection"`
+	RetryPolicy                 RetryPolicy                   `bson:"retry_policy" json:"retry_policy"`
+	TrafficSplit                TrafficSplitConfig            `bson:"traffic_split" json:"traffic_split"`
+	Mirror                      MirrorConfig                  `bson:"mirror" json:"mirror"`
+	ConcurrencyLimit            ConcurrencyLimitConfig        `bson:"concurrency_limit" json:"concurrency_limit"`
+	GRPCTranscoding             GRPCTranscodingConfig         `bson:"grpc_transcoding" json:"grpc_transcoding"`
+	GRPCWeb                     GRPCWebConfig                 `bson:"grpc_web" json:"grpc_web"`
+	UpstreamAuth                UpstreamAuthConfig            `bson:"upstream_auth" json:"upstream_auth"`
 	Transport                   struct {
 		SSLInsecureSkipVerify   bool     `bson:"ssl_insecure_skip_verify" json:"ssl_insecure_skip_verify"`
 		SSLCipherSuites         []string `bson:"ssl_ciphers" json:"ssl_ciphers"`
@@ -762,9 +968,292 @@ type ProxyConfig struct {
 		SSLMaxVersion           uint16   `bson:"ssl_max_version" json:"ssl_max_version"`
 		SSLForceCommonNameCheck bool     `json:"ssl_force_common_name_check"`
 		ProxyURL                string   `bson:"proxy_url" json:"proxy_url"`
+		// ProxyProtocol sends the address of the client to the upstream in a PROXY protocol header.
+		ProxyProtocol UpstreamProxyProtocolConfig `bson:"proxy_protocol" json:"proxy_protocol"`
 	} `bson:"transport" json:"transport"`
 }
 
+// LoadBalancingAlgorithm is the strategy used to pick an upstream target from the target list.
+type LoadBalancingAlgorithm string
+
+const (
+	// RoundRobinLoadBalancing cycles through the targets in order. This is the default.
+	RoundRobinLoadBalancing LoadBalancingAlgorithm = "round_robin"
+	// WeightedRoundRobinLoadBalancing cycles through the targets proportionally to their weights.
+	WeightedRoundRobinLoadBalancing LoadBalancingAlgorithm = "weighted_round_robin"
+	// LeastConnectionsLoadBalancing picks the target with the fewest outstanding requests.
+	LeastConnectionsLoadBalancing LoadBalancingAlgorithm = "least_connections"
+	// RandomTwoLoadBalancing picks two random targets and uses the one with fewer outstanding requests.
+	RandomTwoLoadBalancing LoadBalancingAlgorithm = "random_two"
+	// ConsistentHashLoadBalancing sends requests with the same hash key to the same target.
+	ConsistentHashLoadBalancing LoadBalancingAlgorithm = "consistent_hash"
+)
+
+// UsesWeights reports whether the algorithm picks the targets according to their weights.
+func (a LoadBalancingAlgorithm) UsesWeights() bool {
+	return a == WeightedRoundRobinLoadBalancing || a == ConsistentHashLoadBalancing
+}
+
+// HashKeySource is the part of the request the consistent hash key is read from.
+type HashKeySource string
+
+const (
+	// HashOnClientIP hashes on the real client IP. This is the default.
+	HashOnClientIP HashKeySource = "client_ip"
+	// HashOnHeader hashes on the value of a request header.
+	HashOnHeader HashKeySource = "header"
+	// HashOnCookie hashes on the value of a request cookie.
+	HashOnCookie HashKeySource = "cookie"
+	// HashOnSession hashes on the key hash of the authenticated session.
+	HashOnSession HashKeySource = "session"
+)
+
+// LoadBalancingConfig holds the configuration of how upstream targets are selected
+// when load balancing is enabled.
+type LoadBalancingConfig struct {
+	// Algorithm is the target selection strategy, defaults to round robin.
+	Algorithm LoadBalancingAlgorithm `bson:"algorithm" json:"algorithm"`
+	// TargetWeights assigns weights to the targets in the target list, in the upstream groups and
+	// returned by HTTP service discovery. Targets without a weight default to 1.
+	TargetWeights []TargetWeight `bson:"target_weights" json:"target_weights"`
+	// HashOn configures the key used by the consistent hash algorithm.
+	HashOn ConsistentHashConfig `bson:"hash_on" json:"hash_on"`
+}
+
+// ConsistentHashConfig configures where the consistent hash key is read from.
+// Requests without a value for the configured source are hashed on the client IP.
+type ConsistentHashConfig struct {
+	Source HashKeySource `bson:"source" json:"source"`
+	// Name is the header or cookie name, used with the header and cookie sources.
+	Name string `bson:"name" json:"name"`
+}
+
+// TargetWeight is the weight of a single upstream target.
+type TargetWeight struct {
+	Target string `bson:"target" json:"target"`
+	Weight int    `bson:"weight" json:"weight"`
+}
+
+// Weights returns the weights of the given targets in the same order, as configured in TargetWeights.
+func (l LoadBalancingConfig) Weights(targets []string) []int {
+	if len(l.TargetWeights) == 0 {
+		return nil
+	}
+
+	byTarget := make(map[string]int, len(l.TargetWeights))
+	for _, tw := range l.TargetWeights {
+		byTarget[tw.Target] = tw.Weight
+	}
+
+	weights := make([]int, len(targets))
+	for i, target := range targets {
+		weight, ok := byTarget[target]
+		if !ok {
+			weight = 1
+		}
+		weights[i] = weight
+	}
+
+	return weights
+}
+
+// OutlierDetectionConfig configures passive health checking of the upstream targets.
+// Targets that keep failing proxied requests are temporarily ejected from load balancing.
+type OutlierDetectionConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// ConsecutiveFailures is the number of consecutive 5xx responses or connection errors
+	// after which a target is ejected. Defaults to 5.
+	ConsecutiveFailures int `bson:"consecutive_failures" json:"consecutive_failures"`
+	// BaseEjectionTime is the ejection time in seconds for the first ejection of a target,
+	// doubled on every subsequent ejection. Defaults to 30.
+	BaseEjectionTime int64 `bson:"base_ejection_time" json:"base_ejection_time"`
+	// MaxEjectionTime caps the ejection time in seconds. Defaults to 300.
+	MaxEjectionTime int64 `bson:"max_ejection_time" json:"max_ejection_time"`
+	// MaxEjectionPercent is the maximum percentage of targets that can be ejected at once. Defaults to 50.
+	MaxEjectionPercent int `bson:"max_ejection_percent" json:"max_ejection_percent"`
+}
+
+// RetryPolicy configures retrying of idempotent requests that failed with a connection
+// error or a retryable status code. Retries go to a different target where possible and
+// are limited by the retry budget of the gateway.
+type RetryPolicy struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// MaxAttempts is the maximum number of attempts, including the first one. Defaults to 3.
+	MaxAttempts int `bson:"max_attempts" json:"max_attempts"`
+	// RetryOnStatusCodes are the upstream response codes that are retried. Defaults to 502, 503 and 504.
+	RetryOnStatusCodes []int `bson:"retry_on_status_codes" json:"retry_on_status_codes"`
+	// PerTryTimeout is the time in seconds each attempt waits for the response headers. Disabled if 0.
+	PerTryTimeout float64 `bson:"per_try_timeout" json:"per_try_timeout"`
+	// BaseBackoff is the backoff in milliseconds before the first retry, doubled on every
+	// subsequent retry and randomised with full jitter. Defaults to 25.
+	BaseBackoff int64 `bson:"base_backoff" json:"base_backoff"`
+	// MaxBackoff caps the backoff in milliseconds. Defaults to 250.
+	MaxBackoff int64 `bson:"max_backoff" json:"max_backoff"`
+}
+
+// MirrorConfig configures copying a share of the requests to a shadow upstream, e.g. to
+// validate a new version of a service against live traffic. Shadow requests are sent in the
+// background and their responses are discarded, so they never affect the client. They are
+// recorded in analytics under the API ID suffixed with `-shadow`.
+type MirrorConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Target is the URL of the shadow upstream.
+	Target string `bson:"target" json:"target"`
+	// Percentage is the percentage of the requests that are mirrored.
+	Percentage float64 `bson:"percentage" json:"percentage"`
+	// Timeout is the timeout in seconds of a shadow request. Defaults to 10.
+	Timeout float64 `bson:"timeout" json:"timeout"`
+	// MaxInFlight is the maximum number of outstanding shadow requests, beyond which requests
+	// aren't mirrored. Defaults to 100.
+	MaxInFlight int `bson:"max_in_flight" json:"max_in_flight"`
+}
+
+// ConcurrencyLimitConfig limits the number of requests to an API that are processed at once.
+// Requests over the limit wait in a bounded queue, and are shed with a 503 response when the
+// queue is full or they have waited for too long.
+type ConcurrencyLimitConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// MaxConcurrency is the maximum number of requests processed at once. In adaptive mode it is
+	// the upper bound of the adaptive limit. Defaults to 100.
+	MaxConcurrency int `bson:"max_concurrency" json:"max_concurrency"`
+	// MaxQueueSize is the maximum number of requests waiting for a slot. Requests over the limit
+	// are shed right away if 0.
+	MaxQueueSize int `bson:"max_queue_size" json:"max_queue_size"`
+	// QueueTimeout is the time in seconds a request waits for a slot before it is shed. Defaults to 1.
+	QueueTimeout float64 `bson:"queue_timeout" json:"queue_timeout"`
+	// Adaptive adjusts the limit to the observed upstream latency.
+	Adaptive AdaptiveConcurrencyConfig `bson:"adaptive" json:"adaptive"`
+}
+
+// AdaptiveConcurrencyConfig configures the gradient algorithm that lowers the concurrency limit
+// when the upstream latency grows above its long-term average, and raises it back otherwise.
+type AdaptiveConcurrencyConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// MinConcurrency is the lower bound of the adaptive limit. Defaults to 1.
+	MinConcurrency int `bson:"min_concurrency" json:"min_concurrency"`
+	// Tolerance is the ratio of the upstream latency to its long-term average that is tolerated
+	// before the limit is lowered. Defaults to 1.5.
+	Tolerance float64 `bson:"tolerance" json:"tolerance"`
+}
+
+// GRPCStreamingFormat is the format server streaming gRPC responses are transcoded to.
+type GRPCStreamingFormat string
+
+const (
+	// GRPCStreamingJSON writes every message as a JSON object on its own line of a chunked response.
+	GRPCStreamingJSON GRPCStreamingFormat = "json"
+	// GRPCStreamingSSE writes every message as a server-sent event.
+	GRPCStreamingSSE GRPCStreamingFormat = "sse"
+)
+
+// GRPCTranscodingConfig exposes the unary and server streaming methods of a gRPC upstream as JSON
+// endpoints, routed by the google.api.http annotations of a protobuf descriptor set. The upstream
+// has to be reached over HTTP/2, e.g. with an h2c:// target.
+type GRPCTranscodingConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// DescriptorSet is the base64 encoded binary FileDescriptorSet of the services, including their
+	// imports, as written by protoc --include_imports --descriptor_set_out.
+	DescriptorSet string `bson:"descriptor_set" json:"descriptor_set"`
+	// DescriptorSetPath is the path of a binary FileDescriptorSet file, used if DescriptorSet is empty.
+	DescriptorSetPath string `bson:"descriptor_set_path" json:"descriptor_set_path"`
+	// Services restricts transcoding to the listed fully qualified service names. All the services
+	// of the descriptor set are transcoded if empty.
+	Services []string `bson:"services" json:"services"`
+	// StreamingFormat is the format of server streaming responses, json or sse. Defaults to json.
+	StreamingFormat GRPCStreamingFormat `bson:"streaming_format" json:"streaming_format"`
+}
+
+// GRPCWebConfig translates gRPC-Web requests from browsers to native gRPC requests, and the
+// responses back. The upstream has to be reached over HTTP/2, e.g. with an h2c:// target.
+type GRPCWebConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+}
+
+// UpstreamOAuthAuthStyle is how the client credentials are sent to the token endpoint.
+type UpstreamOAuthAuthStyle string
+
+const (
+	// UpstreamOAuthAuthInHeader sends the client credentials with HTTP basic authentication.
+	UpstreamOAuthAuthInHeader UpstreamOAuthAuthStyle = "header"
+	// UpstreamOAuthAuthInParams sends the client credentials in the form body.
+	UpstreamOAuthAuthInParams UpstreamOAuthAuthStyle = "params"
+)
+
+// UpstreamAuthConfig configures how the gateway authenticates itself to the upstream.
+type UpstreamAuthConfig struct {
+	Enabled bool                `bson:"enabled" json:"enabled"`
+	OAuth   UpstreamOAuthConfig `bson:"oauth" json:"oauth"`
+}
+
+// UpstreamOAuthConfig fetches bearer tokens for the upstream with the OAuth2 client credentials
+// grant. Tokens are cached in Redis, shared by all the gateways, until shortly before they expire.
+// The token URL and the client credentials may reference secrets, e.g. secrets://, env:// or vault://.
+type UpstreamOAuthConfig struct {
+	TokenURL     string   `bson:"token_url" json:"token_url"`
+	ClientID     string   `bson:"client_id" json:"client_id"`
+	ClientSecret string   `bson:"client_secret" json:"client_secret"`
+	Scopes       []string `bson:"scopes" json:"scopes"`
+	// EndpointParams are extra parameters sent to the token endpoint, e.g. an audience.
+	EndpointParams map[string]string `bson:"endpoint_params" json:"endpoint_params"`
+	// AuthStyle is how the client credentials are sent, header or params. Defaults to header.
+	AuthStyle UpstreamOAuthAuthStyle `bson:"auth_style" json:"auth_style"`
+	// HeaderName is the header the token is sent to the upstream in. Defaults to Authorization.
+	HeaderName string `bson:"header_name" json:"header_name"`
+	// ExpiryDelta is the number of seconds before its expiry a token is refreshed. Defaults to 10.
+	ExpiryDelta float64 `bson:"expiry_delta" json:"expiry_delta"`
+}
+
+// UpstreamProxyProtocolConfig configures the PROXY protocol header written to upstream connections,
+// carrying the address of the client the connection is opened for. Upstream HTTP connections
+// aren't reused when it's enabled, as they are bound to a client, and it isn't sent to h2c upstreams.
+type UpstreamProxyProtocolConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Version is the PROXY protocol version, 1 or 2. Defaults to 1.
+	Version int `bson:"version" json:"version"`
+	// TrustForwardedFor takes the address of the client from the X-Real-IP and X-Forwarded-For
+	// headers instead of the connection. Only enable it if the gateway is behind proxies setting them.
+	TrustForwardedFor bool `bson:"trust_forwarded_for" json:"trust_forwarded_for"`
+}
+
+// TrafficSplitSource is the part of the request a traffic split override is matched against.
+type TrafficSplitSource string
+
+const (
+	TrafficSplitOnHeader   TrafficSplitSource = "header"
+	TrafficSplitOnCookie   TrafficSplitSource = "cookie"
+	TrafficSplitOnMetadata TrafficSplitSource = "metadata"
+)
+
+// TrafficSplitConfig splits the traffic of an API between named groups of upstream targets,
+// e.g. for canary releases. It replaces the target URL and the load balancing targets,
+// whose algorithm balances the targets of each group, and can't be used with service discovery.
+type TrafficSplitConfig struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Groups are the upstream groups. Requests that match no override are sent to a group
+	// picked randomly in proportion to the group weights.
+	Groups []UpstreamGroup `bson:"groups" json:"groups"`
+	// Overrides pin matching requests to a group, regardless of the group weights.
+	// The first matching override wins.
+	Overrides []TrafficSplitOverride `bson:"overrides" json:"overrides"`
+}
+
+// UpstreamGroup is a named list of upstream targets that receives a share of the traffic.
+type UpstreamGroup struct {
+	Name    string   `bson:"name" json:"name"`
+	Targets []string `bson:"targets" json:"targets"`
+	// Weight is the share of the traffic the group receives, relative to the weights of the other groups.
+	Weight int `bson:"weight" json:"weight"`
+}
+
+// TrafficSplitOverride sends the requests whose header, cookie or key metadata named Name has
+// the given Value to Group. An empty Value matches any non-empty value.
+type TrafficSplitOverride struct {
+	Source TrafficSplitSource `bson:"source" json:"source"`
+	Name   string             `bson:"name" json:"name"`
+	Value  string             `bson:"value" json:"value"`
+	Group  string             `bson:"group" json:"group"`
+}
+
 type CORSConfig struct {
 	Enable             bool     `bson:"enable" json:"enable"`
 	AllowedOrigins     []string `bson:"allowed_origins" json:"allowed_origins"`
@@ -6,8 +6,9 @@ import (
 )
 
 type HostList struct {
-	hMutex sync.RWMutex
-	hosts  []string
+	hMutex  sync.RWMutex
+	hosts   []string
+	weights []int
 }
 
 func NewHostList() *HostList {
@@ -27,6 +28,17 @@ func (h *HostList) Set(newList []string) {
 	defer h.hMutex.Unlock()
 
 	h.hosts = newList
+	h.weights = nil
+}
+
+// SetWithWeights sets the host list along with a weight for each host.
+// Weights are matched to hosts by index, missing weights default to 1.
+func (h *HostList) SetWithWeights(newList []string, weights []int) {
+	h.hMutex.Lock()
+	defer h.hMutex.Unlock()
+
+	h.hosts = newList
+	h.weights = weights
 }
 
 func (h *HostList) All() []string {
@@ -47,6 +59,19 @@ func (h *HostList) GetIndex(i int) (string, error) {
 	return h.hosts[i], nil
 }
 
+// GetWeight returns the weight of the host at index i. Hosts without
+// an explicit positive weight have a weight of 1.
+func (h *HostList) GetWeight(i int) int {
+	h.hMutex.RLock()
+	defer h.hMutex.RUnlock()
+
+	if i < 0 || i > len(h.weights)-1 || h.weights[i] < 1 {
+		return 1
+	}
+
+	return h.weights[i]
+}
+
 func (h *HostList) Len() int {
 	h.hMutex.RLock()
 	defer h.hMutex.RUnlock()
@@ -382,6 +382,62 @@ type Cache struct {
 	//
 	// Tyk classic API definition: `cache_options.cache_control_ttl_header`
 	ControlTTLHeaderName string `bson:"controlTTLHeaderName,omitempty" json:"controlTTLHeaderName,omitempty"`
+
+	// EnableHTTPCacheSemantics caches responses following the HTTP caching semantics of RFC 9111. The freshness of the
+	// responses is read from the `Cache-Control` and `Expires` headers of the upstream, conditional requests are answered
+	// from the cache, stale responses are revalidated with the upstream and responses are cached per `Vary` header.
+	// `Timeout` is used for responses without explicit freshness, and as the time stale responses are kept for revalidation.
+	//
+	// Tyk classic API definition: `cache_options.enable_http_cache_semantics`
+	EnableHTTPCacheSemantics bool `bson:"enableHTTPCacheSemantics,omitempty" json:"enableHTTPCacheSemantics,omitempty"`
+
+	// StaleWhileRevalidate is the number of seconds an expired response is served for while a single background request
+	// refreshes it.
+	//
+	// Tyk classic API definition: `cache_options.stale_while_revalidate`
+	StaleWhileRevalidate int64 `bson:"staleWhileRevalidate,omitempty" json:"staleWhileRevalidate,omitempty"`
+
+	// StaleIfError is the number of seconds an expired response is served for when the upstream responds with a 5xx error,
+	// times out or its circuit breaker is open.
+	//
+	// Tyk classic API definition: `cache_options.stale_if_error`
+	StaleIfError int64 `bson:"staleIfError,omitempty" json:"staleIfError,omitempty"`
+
+	// EnableRequestCoalescing sends a single upstream request per Gateway for identical concurrent requests missing the
+	// cache, and serves its response to all of them.
+	//
+	// Tyk classic API definition: `cache_options.enable_request_coalescing`
+	EnableRequestCoalescing bool `bson:"enableRequestCoalescing,omitempty" json:"enableRequestCoalescing,omitempty"`
+
+	// CoalesceUncachedRequests coalesces identical concurrent `GET` and `HEAD` requests on the paths that aren't cached too,
+	// even if the cache is disabled.
+	//
+	// Tyk classic API definition: `cache_options.coalesce_uncached_requests`
+	CoalesceUncachedRequests bool `bson:"coalesceUncachedRequests,omitempty" json:"coalesceUncachedRequests,omitempty"`
+
+	// CoalescingMaxWait is the number of seconds coalesced requests wait for the response of the first request before being
+	// sent upstream themselves. Defaults to 5.
+	//
+	// Tyk classic API definition: `cache_options.coalescing_max_wait`
+	CoalescingMaxWait float64 `bson:"coalescingMaxWait,omitempty" json:"coalescingMaxWait,omitempty"`
+
+	// EnableLocalCache keeps the hot cached responses in memory on each node, in front of Redis, if the local response cache
+	// is enabled in the gateway configuration.
+	//
+	// Tyk classic API definition: `cache_options.enable_local_cache`
+	EnableLocalCache bool `bson:"enableLocalCache,omitempty" json:"enableLocalCache,omitempty"`
+
+	// LocalCacheQuota is the maximum size of the cached responses of the API kept in memory on each node, in bytes.
+	// The API can use up to the whole local cache if unset.
+	//
+	// Tyk classic API definition: `cache_options.local_cache_quota`
+	LocalCacheQuota int64 `bson:"localCacheQuota,omitempty" json:"localCacheQuota,omitempty"`
+
+	// EnableInvalidationIndexes indexes the cached responses by request path, surrogate key and key, so that they can be
+	// invalidated by the cache invalidation routes.
+	//
+	// Tyk classic API definition: `cache_options.enable_invalidation_indexes`
+	EnableInvalidationIndexes bool `bson:"enableInvalidationIndexes,omitempty" json:"enableInvalidationIndexes,omitempty"`
 }
 
 // Fill fills *Cache from apidef.CacheOptions.
@@ -393,6 +449,15 @@ func (c *Cache) Fill(cache apidef.CacheOptions) {
 	c.CacheByHeaders = cache.CacheByHeaders
 	c.EnableUpstreamCacheControl = cache.EnableUpstreamCacheControl
 	c.ControlTTLHeaderName = cache.CacheControlTTLHeader
+	c.EnableHTTPCacheSemantics = cache.EnableHTTPCacheSemantics
+	c.StaleWhileRevalidate = cache.StaleWhileRevalidate
+	c.StaleIfError = cache.StaleIfError
+	c.EnableRequestCoalescing = cache.EnableRequestCoalescing
+	c.CoalesceUncachedRequests = cache.CoalesceUncachedRequests
+	c.CoalescingMaxWait = cache.CoalescingMaxWait
+	c.EnableLocalCache = cache.EnableLocalCache
+	c.LocalCacheQuota = cache.LocalCacheQuota
+	c.EnableInvalidationIndexes = cache.EnableInvalidationIndexes
 }
 
 // ExtractTo extracts *Cache into *apidef.CacheOptions.
@@ -404,6 +469,15 @@ func (c *Cache) ExtractTo(cache *apidef.CacheOptions) {
 	cache.CacheByHeaders = c.CacheByHeaders
 	cache.EnableUpstreamCacheControl = c.EnableUpstreamCacheControl
 	cache.CacheControlTTLHeader = c.ControlTTLHeaderName
+	cache.EnableHTTPCacheSemantics = c.EnableHTTPCacheSemantics
+	cache.StaleWhileRevalidate = c.StaleWhileRevalidate
+	cache.StaleIfError = c.StaleIfError
+	cache.EnableRequestCoalescing = c.EnableRequestCoalescing
+	cache.CoalesceUncachedRequests = c.CoalesceUncachedRequests
+	cache.CoalescingMaxWait = c.CoalescingMaxWait
+	cache.EnableLocalCache = c.EnableLocalCache
+	cache.LocalCacheQuota = c.LocalCacheQuota
+	cache.EnableInvalidationIndexes = c.EnableInvalidationIndexes
 }
 
 // Paths is a mapping of API endpoints to Path plugin configurations.
@@ -830,6 +904,12 @@ type CachePlugin struct {
 
 	// CacheResponseCodes contains a list of valid response codes for responses that are okay to add to the cache.
 	CacheResponseCodes []int `bson:"cacheResponseCodes,omitempty" json:"cacheResponseCodes,omitempty"`
+
+	// StaleWhileRevalidate overrides the stale-while-revalidate window of the API for the endpoint, in seconds.
+	StaleWhileRevalidate int64 `bson:"staleWhileRevalidate,omitempty" json:"staleWhileRevalidate,omitempty"`
+
+	// StaleIfError overrides the stale-if-error window of the API for the endpoint, in seconds.
+	StaleIfError int64 `bson:"staleIfError,omitempty" json:"staleIfError,omitempty"`
 }
 
 // Fill fills *CachePlugin from apidef.CacheMeta.
@@ -837,6 +917,8 @@ func (a *CachePlugin) Fill(cm apidef.CacheMeta) {
 	a.Enabled = !cm.Disabled
 	a.CacheByRegex = cm.CacheKeyRegex
 	a.CacheResponseCodes = cm.CacheOnlyResponseCodes
+	a.StaleWhileRevalidate = cm.StaleWhileRevalidate
+	a.StaleIfError = cm.StaleIfError
 }
 
 // ExtractTo extracts *CachePlugin values to *apidef.CacheMeta.
@@ -844,6 +926,8 @@ func (a *CachePlugin) ExtractTo(cm *apidef.CacheMeta) {
 	cm.Disabled = !a.Enabled
 	cm.CacheKeyRegex = a.CacheByRegex
 	cm.CacheOnlyResponseCodes = a.CacheResponseCodes
+	cm.StaleWhileRevalidate = a.StaleWhileRevalidate
+	cm.StaleIfError = a.StaleIfError
 }
 
 // EnforceTimeout holds the configuration for enforcing request timeouts.
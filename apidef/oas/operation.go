@@ -49,6 +49,15 @@ type Operation struct {
 
 	// PostPlugins contains endpoint level post plugins configuration.
 	PostPlugins EndpointPostPlugins `bson:"postPlugins,omitempty" json:"postPlugins,omitempty"`
+
+	// RetryPolicy overrides the upstream retry policy for the operation.
+	RetryPolicy *RetryPolicy `bson:"retryPolicy,omitempty" json:"retryPolicy,omitempty"`
+
+	// Mirror overrides the upstream traffic mirroring for the operation.
+	Mirror *Mirror `bson:"mirror,omitempty" json:"mirror,omitempty"`
+
+	// ConcurrencyLimit overrides the upstream concurrency limit for the operation, which gets its own slots.
+	ConcurrencyLimit *ConcurrencyLimit `bson:"concurrencyLimit,omitempty" json:"concurrencyLimit,omitempty"`
 }
 
 // AllowanceType holds the valid allowance types values.
@@ -121,6 +130,9 @@ func (s *OAS) fillPathsAndOperations(ep apidef.ExtendedPathsSet) {
 	s.fillOASValidateRequest(ep.ValidateJSON)
 	s.fillVirtualEndpoint(ep.Virtual)
 	s.fillEndpointPostPlugins(ep.GoPlugin)
+	s.fillRetryPolicy(ep.RetryPolicies)
+	s.fillMirror(ep.Mirrors)
+	s.fillConcurrencyLimit(ep.ConcurrencyLimits)
 }
 
 func (s *OAS) extractPathsAndOperations(ep *apidef.ExtendedPathsSet) {
@@ -143,6 +155,9 @@ func (s *OAS) extractPathsAndOperations(ep *apidef.ExtendedPathsSet) {
 					tykOp.extractEnforceTimeoutTo(ep, path, method)
 					tykOp.extractVirtualEndpointTo(ep, path, method)
 					tykOp.extractEndpointPostPluginTo(ep, path, method)
+					tykOp.extractRetryPolicyTo(ep, path, method)
+					tykOp.extractMirrorTo(ep, path, method)
+					tykOp.extractConcurrencyLimitTo(ep, path, method)
 					break found
 				}
 			}
@@ -242,6 +257,51 @@ func (s *OAS) fillEnforceTimeout(metas []apidef.HardTimeoutMeta) {
 	}
 }
 
+func (s *OAS) fillRetryPolicy(metas []apidef.RetryPolicyMeta) {
+	for _, meta := range metas {
+		operationID := s.getOperationID(meta.Path, meta.Method)
+		operation := s.GetTykExtension().getOperation(operationID)
+		if operation.RetryPolicy == nil {
+			operation.RetryPolicy = &RetryPolicy{}
+		}
+
+		operation.RetryPolicy.Fill(meta.RetryPolicy())
+		if ShouldOmit(operation.RetryPolicy) {
+			operation.RetryPolicy = nil
+		}
+	}
+}
+
+func (s *OAS) fillMirror(metas []apidef.MirrorMeta) {
+	for _, meta := range metas {
+		operationID := s.getOperationID(meta.Path, meta.Method)
+		operation := s.GetTykExtension().getOperation(operationID)
+		if operation.Mirror == nil {
+			operation.Mirror = &Mirror{}
+		}
+
+		operation.Mirror.Fill(meta.Mirror())
+		if ShouldOmit(operation.Mirror) {
+			operation.Mirror = nil
+		}
+	}
+}
+
+func (s *OAS) fillConcurrencyLimit(metas []apidef.ConcurrencyLimitMeta) {
+	for _, meta := range metas {
+		operationID := s.getOperationID(meta.Path, meta.Method)
+		operation := s.GetTykExtension().getOperation(operationID)
+		if operation.ConcurrencyLimit == nil {
+			operation.ConcurrencyLimit = &ConcurrencyLimit{}
+		}
+
+		operation.ConcurrencyLimit.Fill(meta.ConcurrencyLimit())
+		if ShouldOmit(operation.ConcurrencyLimit) {
+			operation.ConcurrencyLimit = nil
+		}
+	}
+}
+
 func (o *Operation) extractAllowanceTo(ep *apidef.ExtendedPathsSet, path string, method string, typ AllowanceType) {
 	allowance := o.Allow
 	endpointMetas := &ep.WhiteList
@@ -307,6 +367,61 @@ func (o *Operation) extractEnforceTimeoutTo(ep *apidef.ExtendedPathsSet, path st
 	ep.HardTimeouts = append(ep.HardTimeouts, meta)
 }
 
+func (o *Operation) extractRetryPolicyTo(ep *apidef.ExtendedPathsSet, path string, method string) {
+	if o.RetryPolicy == nil {
+		return
+	}
+
+	var policy apidef.RetryPolicy
+	o.RetryPolicy.ExtractTo(&policy)
+	ep.RetryPolicies = append(ep.RetryPolicies, apidef.RetryPolicyMeta{
+		Disabled:           !policy.Enabled,
+		Path:               path,
+		Method:             method,
+		MaxAttempts:        policy.MaxAttempts,
+		RetryOnStatusCodes: policy.RetryOnStatusCodes,
+		PerTryTimeout:      policy.PerTryTimeout,
+		BaseBackoff:        policy.BaseBackoff,
+		MaxBackoff:         policy.MaxBackoff,
+	})
+}
+
+func (o *Operation) extractMirrorTo(ep *apidef.ExtendedPathsSet, path string, method string) {
+	if o.Mirror == nil {
+		return
+	}
+
+	var conf apidef.MirrorConfig
+	o.Mirror.ExtractTo(&conf)
+	ep.Mirrors = append(ep.Mirrors, apidef.MirrorMeta{
+		Disabled:    !conf.Enabled,
+		Path:        path,
+		Method:      method,
+		Target:      conf.Target,
+		Percentage:  conf.Percentage,
+		Timeout:     conf.Timeout,
+		MaxInFlight: conf.MaxInFlight,
+	})
+}
+
+func (o *Operation) extractConcurrencyLimitTo(ep *apidef.ExtendedPathsSet, path string, method string) {
+	if o.ConcurrencyLimit == nil {
+		return
+	}
+
+	var conf apidef.ConcurrencyLimitConfig
+	o.ConcurrencyLimit.ExtractTo(&conf)
+	ep.ConcurrencyLimits = append(ep.ConcurrencyLimits, apidef.ConcurrencyLimitMeta{
+		Disabled:       !conf.Enabled,
+		Path:           path,
+		Method:         method,
+		MaxConcurrency: conf.MaxConcurrency,
+		MaxQueueSize:   conf.MaxQueueSize,
+		QueueTimeout:   conf.QueueTimeout,
+		Adaptive:       conf.Adaptive,
+	})
+}
+
 // detect possible regex pattern:
 // - character match ([a-z])
 // - greedy match (.*)
@@ -25,6 +25,34 @@ type Upstream struct {
 
 	// CertificatePinning contains the configuration related to certificate pinning.
 	CertificatePinning *CertificatePinning `bson:"certificatePinning,omitempty" json:"certificatePinning,omitempty"`
+
+	// RetryPolicy contains the configuration related to retrying failed upstream requests.
+	// Tyk classic API definition: `proxy.retry_policy`
+	RetryPolicy *RetryPolicy `bson:"retryPolicy,omitempty" json:"retryPolicy,omitempty"`
+
+	// TrafficSplit contains the configuration related to splitting traffic between upstream groups.
+	// Tyk classic API definition: `proxy.traffic_split`
+	TrafficSplit *TrafficSplit `bson:"trafficSplit,omitempty" json:"trafficSplit,omitempty"`
+
+	// Mirror contains the configuration related to mirroring traffic to a shadow upstream.
+	// Tyk classic API definition: `proxy.mirror`
+	Mirror *Mirror `bson:"mirror,omitempty" json:"mirror,omitempty"`
+
+	// ConcurrencyLimit contains the configuration related to limiting the number of requests processed at once.
+	// Tyk classic API definition: `proxy.concurrency_limit`
+	ConcurrencyLimit *ConcurrencyLimit `bson:"concurrencyLimit,omitempty" json:"concurrencyLimit,omitempty"`
+
+	// GRPCTranscoding contains the configuration related to exposing gRPC methods as JSON endpoints.
+	// Tyk classic API definition: `proxy.grpc_transcoding`
+	GRPCTranscoding *GRPCTranscoding `bson:"grpcTranscoding,omitempty" json:"grpcTranscoding,omitempty"`
+
+	// GRPCWeb contains the configuration related to translating gRPC-Web requests to native gRPC.
+	// Tyk classic API definition: `proxy.grpc_web`
+	GRPCWeb *GRPCWeb `bson:"grpcWeb,omitempty" json:"grpcWeb,omitempty"`
+
+	// Authentication contains the configuration related to authenticating the gateway to the upstream.
+	// Tyk classic API definition: `proxy.upstream_auth`
+	Authentication *UpstreamAuth `bson:"authentication,omitempty" json:"authentication,omitempty"`
 }
 
 // Fill fills *Upstream from apidef.APIDefinition.
@@ -66,6 +94,69 @@ func (u *Upstream) Fill(api apidef.APIDefinition) {
 	if ShouldOmit(u.CertificatePinning) {
 		u.CertificatePinning = nil
 	}
+
+	if u.RetryPolicy == nil {
+		u.RetryPolicy = &RetryPolicy{}
+	}
+
+	u.RetryPolicy.Fill(api.Proxy.RetryPolicy)
+	if ShouldOmit(u.RetryPolicy) {
+		u.RetryPolicy = nil
+	}
+
+	if u.TrafficSplit == nil {
+		u.TrafficSplit = &TrafficSplit{}
+	}
+
+	u.TrafficSplit.Fill(api.Proxy.TrafficSplit)
+	if ShouldOmit(u.TrafficSplit) {
+		u.TrafficSplit = nil
+	}
+
+	if u.Mirror == nil {
+		u.Mirror = &Mirror{}
+	}
+
+	u.Mirror.Fill(api.Proxy.Mirror)
+	if ShouldOmit(u.Mirror) {
+		u.Mirror = nil
+	}
+
+	if u.ConcurrencyLimit == nil {
+		u.ConcurrencyLimit = &ConcurrencyLimit{}
+	}
+
+	u.ConcurrencyLimit.Fill(api.Proxy.ConcurrencyLimit)
+	if ShouldOmit(u.ConcurrencyLimit) {
+		u.ConcurrencyLimit = nil
+	}
+
+	if u.GRPCTranscoding == nil {
+		u.GRPCTranscoding = &GRPCTranscoding{}
+	}
+
+	u.GRPCTranscoding.Fill(api.Proxy.GRPCTranscoding)
+	if ShouldOmit(u.GRPCTranscoding) {
+		u.GRPCTranscoding = nil
+	}
+
+	if u.GRPCWeb == nil {
+		u.GRPCWeb = &GRPCWeb{}
+	}
+
+	u.GRPCWeb.Fill(api.Proxy.GRPCWeb)
+	if ShouldOmit(u.GRPCWeb) {
+		u.GRPCWeb = nil
+	}
+
+	if u.Authentication == nil {
+		u.Authentication = &UpstreamAuth{}
+	}
+
+	u.Authentication.Fill(api.Proxy.UpstreamAuth)
+	if ShouldOmit(u.Authentication) {
+		u.Authentication = nil
+	}
 }
 
 // ExtractTo extracts *Upstream into *apidef.APIDefinition.
@@ -87,6 +178,34 @@ func (u *Upstream) ExtractTo(api *apidef.APIDefinition) {
 	if u.CertificatePinning != nil {
 		u.CertificatePinning.ExtractTo(api)
 	}
+
+	if u.RetryPolicy != nil {
+		u.RetryPolicy.ExtractTo(&api.Proxy.RetryPolicy)
+	}
+
+	if u.TrafficSplit != nil {
+		u.TrafficSplit.ExtractTo(&api.Proxy.TrafficSplit)
+	}
+
+	if u.Mirror != nil {
+		u.Mirror.ExtractTo(&api.Proxy.Mirror)
+	}
+
+	if u.ConcurrencyLimit != nil {
+		u.ConcurrencyLimit.ExtractTo(&api.Proxy.ConcurrencyLimit)
+	}
+
+	if u.GRPCTranscoding != nil {
+		u.GRPCTranscoding.ExtractTo(&api.Proxy.GRPCTranscoding)
+	}
+
+	if u.GRPCWeb != nil {
+		u.GRPCWeb.ExtractTo(&api.Proxy.GRPCWeb)
+	}
+
+	if u.Authentication != nil {
+		u.Authentication.ExtractTo(&api.Proxy.UpstreamAuth)
+	}
 }
 
 // ServiceDiscovery holds configuration required for service discovery.
@@ -181,6 +300,21 @@ type ServiceDiscovery struct {
 	//
 	// Tyk classic API definition: `service_discovery.endpoint_returns_list`
 	EndpointReturnsList bool `bson:"endpointReturnsList,omitempty" json:"endpointReturnsList,omitempty"`
+
+	// Provider is where the targets are discovered from. Valid values are:
+	// - `http`, `queryEndpoint` is requested and the targets are read from the JSON response with the data paths,
+	// - `dns_srv`, `queryEndpoint` is resolved as the name of DNS SRV records, such as `_http._tcp.example.com`. The targets of the lowest priority with targets up are used, weighted by the records, until the TTL of the records expires,
+	// - `file`, the targets are read from the JSON or YAML file at the `queryEndpoint` path, which is watched for changes,
+	// - `consul`, the healthy instances of the Consul service named `queryEndpoint` are streamed with blocking queries, using the Consul configuration of the gateway.
+	// Defaults to `http`.
+	//
+	// Tyk classic API definition: `service_discovery.provider`
+	Provider string `bson:"provider,omitempty" json:"provider,omitempty"`
+
+	// DNSServer is the `host:port` of the DNS server SRV records are queried from, defaults to the nameservers of the system.
+	//
+	// Tyk classic API definition: `service_discovery.dns_server`
+	DNSServer string `bson:"dnsServer,omitempty" json:"dnsServer,omitempty"`
 }
 
 // Fill fills *ServiceDiscovery from apidef.ServiceDiscoveryConfiguration.
@@ -195,6 +329,8 @@ func (sd *ServiceDiscovery) Fill(serviceDiscovery apidef.ServiceDiscoveryConfigu
 	sd.UseNestedQuery = serviceDiscovery.UseNestedQuery
 	sd.DataPath = serviceDiscovery.DataPath
 	sd.PortDataPath = serviceDiscovery.PortDataPath
+	sd.Provider = string(serviceDiscovery.Provider)
+	sd.DNSServer = serviceDiscovery.DNSServer
 }
 
 // ExtractTo extracts *ServiceDiscovery into *apidef.ServiceDiscoveryConfiguration.
@@ -209,6 +345,8 @@ func (sd *ServiceDiscovery) ExtractTo(serviceDiscovery *apidef.ServiceDiscoveryC
 	serviceDiscovery.UseNestedQuery = sd.UseNestedQuery
 	serviceDiscovery.DataPath = sd.DataPath
 	serviceDiscovery.PortDataPath = sd.PortDataPath
+	serviceDiscovery.Provider = apidef.ServiceDiscoveryProvider(sd.Provider)
+	serviceDiscovery.DNSServer = sd.DNSServer
 }
 
 // Test holds the test configuration for service discovery.
@@ -363,3 +501,492 @@ func (cp *CertificatePinning) ExtractTo(api *apidef.APIDefinition) {
 		cp.DomainToPublicKeysMapping.ExtractTo(api.PinnedPublicKeys)
 	}
 }
+
+// RetryPolicy holds the configuration for retrying idempotent requests that failed with a connection error
+// or a retryable response code. Retries go to a different target where possible, and are limited by the
+// retry budget of the gateway.
+type RetryPolicy struct {
+	// Enabled enables retrying failed upstream requests.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// MaxAttempts is the maximum number of attempts, including the first one. Defaults to 3.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.max_attempts`
+	MaxAttempts int `bson:"maxAttempts,omitempty" json:"maxAttempts,omitempty"`
+
+	// RetryOnStatusCodes are the upstream response codes that are retried. Defaults to 502, 503 and 504.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.retry_on_status_codes`
+	RetryOnStatusCodes []int `bson:"retryOnStatusCodes,omitempty" json:"retryOnStatusCodes,omitempty"`
+
+	// PerTryTimeout is the time in seconds each attempt waits for the response headers.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.per_try_timeout`
+	PerTryTimeout float64 `bson:"perTryTimeout,omitempty" json:"perTryTimeout,omitempty"`
+
+	// BaseBackoff is the backoff in milliseconds before the first retry, doubled on every subsequent retry. Defaults to 25.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.base_backoff`
+	BaseBackoff int64 `bson:"baseBackoff,omitempty" json:"baseBackoff,omitempty"`
+
+	// MaxBackoff caps the backoff in milliseconds. Defaults to 250.
+	//
+	// Tyk classic API definition: `proxy.retry_policy.max_backoff`
+	MaxBackoff int64 `bson:"maxBackoff,omitempty" json:"maxBackoff,omitempty"`
+}
+
+// Fill fills *RetryPolicy from apidef.RetryPolicy.
+func (r *RetryPolicy) Fill(policy apidef.RetryPolicy) {
+	r.Enabled = policy.Enabled
+	r.MaxAttempts = policy.MaxAttempts
+	r.RetryOnStatusCodes = policy.RetryOnStatusCodes
+	r.PerTryTimeout = policy.PerTryTimeout
+	r.BaseBackoff = policy.BaseBackoff
+	r.MaxBackoff = policy.MaxBackoff
+}
+
+// ExtractTo extracts *RetryPolicy into *apidef.RetryPolicy.
+func (r *RetryPolicy) ExtractTo(policy *apidef.RetryPolicy) {
+	policy.Enabled = r.Enabled
+	policy.MaxAttempts = r.MaxAttempts
+	policy.RetryOnStatusCodes = r.RetryOnStatusCodes
+	policy.PerTryTimeout = r.PerTryTimeout
+	policy.BaseBackoff = r.BaseBackoff
+	policy.MaxBackoff = r.MaxBackoff
+}
+
+// TrafficSplit holds the configuration for splitting the traffic of an API between named groups of upstream targets,
+// e.g. for canary releases. It replaces the upstream URL, and can't be used with service discovery.
+type TrafficSplit struct {
+	// Enabled enables traffic splitting.
+	//
+	// Tyk classic API definition: `proxy.traffic_split.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// Groups are the upstream groups. Requests that match no override are sent to a group picked randomly
+	// in proportion to the group weights.
+	//
+	// Tyk classic API definition: `proxy.traffic_split.groups`
+	Groups []UpstreamGroup `bson:"groups,omitempty" json:"groups,omitempty"`
+
+	// Overrides pin matching requests to a group, regardless of the group weights. The first matching override wins.
+	//
+	// Tyk classic API definition: `proxy.traffic_split.overrides`
+	Overrides []TrafficSplitOverride `bson:"overrides,omitempty" json:"overrides,omitempty"`
+}
+
+// UpstreamGroup is a named list of upstream targets that receives a share of the traffic.
+type UpstreamGroup struct {
+	// Name is the name of the group, recorded in analytics as the `upstream-group-<name>` tag.
+	Name string `bson:"name" json:"name"` // required
+
+	// Targets are the upstream URLs of the group.
+	Targets []string `bson:"targets" json:"targets"` // required
+
+	// Weight is the share of the traffic the group receives, relative to the weights of the other groups.
+	Weight int `bson:"weight" json:"weight"`
+}
+
+// TrafficSplitOverride sends the requests whose header, cookie or key metadata matches a value to a group.
+type TrafficSplitOverride struct {
+	// Source is the part of the request that is matched. Valid values are `header`, `cookie` and `metadata`.
+	Source string `bson:"source" json:"source"` // required
+
+	// Name is the name of the header, cookie or key metadata.
+	Name string `bson:"name" json:"name"` // required
+
+	// Value is the value to match. An empty value matches any non-empty value.
+	Value string `bson:"value,omitempty" json:"value,omitempty"`
+
+	// Group is the name of the group the matching requests are sent to.
+	Group string `bson:"group" json:"group"` // required
+}
+
+// Fill fills *TrafficSplit from apidef.TrafficSplitConfig.
+func (ts *TrafficSplit) Fill(conf apidef.TrafficSplitConfig) {
+	ts.Enabled = conf.Enabled
+
+	ts.Groups = nil
+	for _, group := range conf.Groups {
+		ts.Groups = append(ts.Groups, UpstreamGroup{Name: group.Name, Targets: group.Targets, Weight: group.Weight})
+	}
+
+	ts.Overrides = nil
+	for _, override := range conf.Overrides {
+		ts.Overrides = append(ts.Overrides, TrafficSplitOverride{
+			Source: string(override.Source),
+			Name:   override.Name,
+			Value:  override.Value,
+			Group:  override.Group,
+		})
+	}
+}
+
+// ExtractTo extracts *TrafficSplit into *apidef.TrafficSplitConfig.
+func (ts *TrafficSplit) ExtractTo(conf *apidef.TrafficSplitConfig) {
+	conf.Enabled = ts.Enabled
+
+	conf.Groups = nil
+	for _, group := range ts.Groups {
+		conf.Groups = append(conf.Groups, apidef.UpstreamGroup{Name: group.Name, Targets: group.Targets, Weight: group.Weight})
+	}
+
+	conf.Overrides = nil
+	for _, override := range ts.Overrides {
+		conf.Overrides = append(conf.Overrides, apidef.TrafficSplitOverride{
+			Source: apidef.TrafficSplitSource(override.Source),
+			Name:   override.Name,
+			Value:  override.Value,
+			Group:  override.Group,
+		})
+	}
+}
+
+// Mirror holds the configuration for copying a share of the requests to a shadow upstream, e.g. to validate a new
+// version of a service against live traffic. Shadow requests are sent in the background and their responses are
+// discarded. They are recorded in analytics under the API ID suffixed with `-shadow`, with the `shadow` and
+// `shadow-status-<code>` tags.
+type Mirror struct {
+	// Enabled enables traffic mirroring.
+	//
+	// Tyk classic API definition: `proxy.mirror.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// Target is the URL of the shadow upstream.
+	//
+	// Tyk classic API definition: `proxy.mirror.target`
+	Target string `bson:"target" json:"target"` // required
+
+	// Percentage is the percentage of the requests that are mirrored.
+	//
+	// Tyk classic API definition: `proxy.mirror.percentage`
+	Percentage float64 `bson:"percentage" json:"percentage"`
+
+	// Timeout is the timeout in seconds of a shadow request. Defaults to 10.
+	//
+	// Tyk classic API definition: `proxy.mirror.timeout`
+	Timeout float64 `bson:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// MaxInFlight is the maximum number of outstanding shadow requests, beyond which requests aren't mirrored.
+	// Defaults to 100.
+	//
+	// Tyk classic API definition: `proxy.mirror.max_in_flight`
+	MaxInFlight int `bson:"maxInFlight,omitempty" json:"maxInFlight,omitempty"`
+}
+
+// Fill fills *Mirror from apidef.MirrorConfig.
+func (m *Mirror) Fill(conf apidef.MirrorConfig) {
+	m.Enabled = conf.Enabled
+	m.Target = conf.Target
+	m.Percentage = conf.Percentage
+	m.Timeout = conf.Timeout
+	m.MaxInFlight = conf.MaxInFlight
+}
+
+// ExtractTo extracts *Mirror into *apidef.MirrorConfig.
+func (m *Mirror) ExtractTo(conf *apidef.MirrorConfig) {
+	conf.Enabled = m.Enabled
+	conf.Target = m.Target
+	conf.Percentage = m.Percentage
+	conf.Timeout = m.Timeout
+	conf.MaxInFlight = m.MaxInFlight
+}
+
+// ConcurrencyLimit holds the configuration for limiting the number of requests processed at once. Requests over the
+// limit wait in a bounded queue, and are shed with a 503 response and a `Retry-After` header when the queue is full
+// or they have waited for too long.
+type ConcurrencyLimit struct {
+	// Enabled enables the concurrency limit.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// MaxConcurrency is the maximum number of requests processed at once. In adaptive mode it is the upper bound
+	// of the adaptive limit. Defaults to 100.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.max_concurrency`
+	MaxConcurrency int `bson:"maxConcurrency,omitempty" json:"maxConcurrency,omitempty"`
+
+	// MaxQueueSize is the maximum number of requests waiting for a slot. Requests over the limit are shed right
+	// away if 0.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.max_queue_size`
+	MaxQueueSize int `bson:"maxQueueSize,omitempty" json:"maxQueueSize,omitempty"`
+
+	// QueueTimeout is the time in seconds a request waits for a slot before it is shed. Defaults to 1.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.queue_timeout`
+	QueueTimeout float64 `bson:"queueTimeout,omitempty" json:"queueTimeout,omitempty"`
+
+	// Adaptive contains the configuration for adjusting the limit to the observed upstream latency.
+	Adaptive *AdaptiveConcurrency `bson:"adaptive,omitempty" json:"adaptive,omitempty"`
+}
+
+// Fill fills *ConcurrencyLimit from apidef.ConcurrencyLimitConfig.
+func (c *ConcurrencyLimit) Fill(conf apidef.ConcurrencyLimitConfig) {
+	c.Enabled = conf.Enabled
+	c.MaxConcurrency = conf.MaxConcurrency
+	c.MaxQueueSize = conf.MaxQueueSize
+	c.QueueTimeout = conf.QueueTimeout
+
+	if c.Adaptive == nil {
+		c.Adaptive = &AdaptiveConcurrency{}
+	}
+
+	c.Adaptive.Fill(conf.Adaptive)
+	if ShouldOmit(c.Adaptive) {
+		c.Adaptive = nil
+	}
+}
+
+// ExtractTo extracts *ConcurrencyLimit into *apidef.ConcurrencyLimitConfig.
+func (c *ConcurrencyLimit) ExtractTo(conf *apidef.ConcurrencyLimitConfig) {
+	conf.Enabled = c.Enabled
+	conf.MaxConcurrency = c.MaxConcurrency
+	conf.MaxQueueSize = c.MaxQueueSize
+	conf.QueueTimeout = c.QueueTimeout
+
+	if c.Adaptive != nil {
+		c.Adaptive.ExtractTo(&conf.Adaptive)
+	}
+}
+
+// AdaptiveConcurrency holds the configuration of the gradient algorithm that lowers the concurrency limit when the
+// upstream latency grows above its long-term average, and raises it back otherwise.
+type AdaptiveConcurrency struct {
+	// Enabled enables the adaptive concurrency limit.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.adaptive.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// MinConcurrency is the lower bound of the adaptive limit. Defaults to 1.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.adaptive.min_concurrency`
+	MinConcurrency int `bson:"minConcurrency,omitempty" json:"minConcurrency,omitempty"`
+
+	// Tolerance is the ratio of the upstream latency to its long-term average that is tolerated before the limit is
+	// lowered. Defaults to 1.5.
+	//
+	// Tyk classic API definition: `proxy.concurrency_limit.adaptive.tolerance`
+	Tolerance float64 `bson:"tolerance,omitempty" json:"tolerance,omitempty"`
+}
+
+// Fill fills *AdaptiveConcurrency from apidef.AdaptiveConcurrencyConfig.
+func (a *AdaptiveConcurrency) Fill(conf apidef.AdaptiveConcurrencyConfig) {
+	a.Enabled = conf.Enabled
+	a.MinConcurrency = conf.MinConcurrency
+	a.Tolerance = conf.Tolerance
+}
+
+// ExtractTo extracts *AdaptiveConcurrency into *apidef.AdaptiveConcurrencyConfig.
+func (a *AdaptiveConcurrency) ExtractTo(conf *apidef.AdaptiveConcurrencyConfig) {
+	conf.Enabled = a.Enabled
+	conf.MinConcurrency = a.MinConcurrency
+	conf.Tolerance = a.Tolerance
+}
+
+// GRPCTranscoding holds the configuration for exposing the unary and server streaming methods of a gRPC upstream as
+// JSON endpoints, routed by the `google.api.http` annotations of a protobuf descriptor set. The upstream has to be
+// reached over HTTP/2, e.g. with an `h2c://` URL.
+type GRPCTranscoding struct {
+	// Enabled enables gRPC-JSON transcoding.
+	//
+	// Tyk classic API definition: `proxy.grpc_transcoding.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// DescriptorSet is the base64 encoded binary `FileDescriptorSet` of the services, including their imports, as
+	// written by `protoc --include_imports --descriptor_set_out`.
+	//
+	// Tyk classic API definition: `proxy.grpc_transcoding.descriptor_set`
+	DescriptorSet string `bson:"descriptorSet,omitempty" json:"descriptorSet,omitempty"`
+
+	// DescriptorSetPath is the path of a binary `FileDescriptorSet` file, used if DescriptorSet is empty.
+	//
+	// Tyk classic API definition: `proxy.grpc_transcoding.descriptor_set_path`
+	DescriptorSetPath string `bson:"descriptorSetPath,omitempty" json:"descriptorSetPath,omitempty"`
+
+	// Services restricts transcoding to the listed fully qualified service names. All the services of the descriptor
+	// set are transcoded if empty.
+	//
+	// Tyk classic API definition: `proxy.grpc_transcoding.services`
+	Services []string `bson:"services,omitempty" json:"services,omitempty"`
+
+	// StreamingFormat is the format of server streaming responses. Valid values are:
+	// - `json`, every message is written as a JSON object on its own line, as `{"result": ...}`,
+	// - `sse`, every message is written as a server-sent event.
+	// Defaults to `json`.
+	//
+	// Tyk classic API definition: `proxy.grpc_transcoding.streaming_format`
+	StreamingFormat string `bson:"streamingFormat,omitempty" json:"streamingFormat,omitempty"`
+}
+
+// Fill fills *GRPCTranscoding from apidef.GRPCTranscodingConfig.
+func (g *GRPCTranscoding) Fill(conf apidef.GRPCTranscodingConfig) {
+	g.Enabled = conf.Enabled
+	g.DescriptorSet = conf.DescriptorSet
+	g.DescriptorSetPath = conf.DescriptorSetPath
+	g.Services = conf.Services
+	g.StreamingFormat = string(conf.StreamingFormat)
+}
+
+// ExtractTo extracts *GRPCTranscoding into *apidef.GRPCTranscodingConfig.
+func (g *GRPCTranscoding) ExtractTo(conf *apidef.GRPCTranscodingConfig) {
+	conf.Enabled = g.Enabled
+	conf.DescriptorSet = g.DescriptorSet
+	conf.DescriptorSetPath = g.DescriptorSetPath
+	conf.Services = g.Services
+	conf.StreamingFormat = apidef.GRPCStreamingFormat(g.StreamingFormat)
+}
+
+// GRPCWeb holds the configuration for accepting `application/grpc-web` and `application/grpc-web-text` requests from
+// browsers. They are translated to native gRPC requests, and the responses back, with the trailers sent in the body.
+// The upstream has to be reached over HTTP/2, e.g. with an `h2c://` URL. When CORS is enabled, the headers gRPC-Web
+// clients use are allowed and exposed.
+type GRPCWeb struct {
+	// Enabled enables gRPC-Web translation.
+	//
+	// Tyk classic API definition: `proxy.grpc_web.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+}
+
+// Fill fills *GRPCWeb from apidef.GRPCWebConfig.
+func (g *GRPCWeb) Fill(conf apidef.GRPCWebConfig) {
+	g.Enabled = conf.Enabled
+}
+
+// ExtractTo extracts *GRPCWeb into *apidef.GRPCWebConfig.
+func (g *GRPCWeb) ExtractTo(conf *apidef.GRPCWebConfig) {
+	conf.Enabled = g.Enabled
+}
+
+// UpstreamAuth holds the configuration for authenticating the gateway to the upstream.
+type UpstreamAuth struct {
+	// Enabled enables upstream authentication.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.enabled`
+	Enabled bool `bson:"enabled" json:"enabled"` // required
+
+	// OAuth contains the configuration of the OAuth2 client credentials grant the upstream tokens are fetched with.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth`
+	OAuth *UpstreamOAuth `bson:"oauth,omitempty" json:"oauth,omitempty"`
+}
+
+// Fill fills *UpstreamAuth from apidef.UpstreamAuthConfig.
+func (u *UpstreamAuth) Fill(conf apidef.UpstreamAuthConfig) {
+	u.Enabled = conf.Enabled
+
+	if u.OAuth == nil {
+		u.OAuth = &UpstreamOAuth{}
+	}
+
+	u.OAuth.Fill(conf.OAuth)
+	if ShouldOmit(u.OAuth) {
+		u.OAuth = nil
+	}
+}
+
+// ExtractTo extracts *UpstreamAuth into *apidef.UpstreamAuthConfig.
+func (u *UpstreamAuth) ExtractTo(conf *apidef.UpstreamAuthConfig) {
+	conf.Enabled = u.Enabled
+
+	if u.OAuth != nil {
+		u.OAuth.ExtractTo(&conf.OAuth)
+	}
+}
+
+// UpstreamOAuth holds the configuration for fetching bearer tokens for the upstream with the OAuth2 client credentials
+// grant. Tokens are cached in Redis, shared by all the gateways, until shortly before they expire.
+type UpstreamOAuth struct {
+	// TokenURL is the URL of the token endpoint. It can reference a secret, e.g. `env://token_url`.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.token_url`
+	TokenURL string `bson:"tokenUrl" json:"tokenUrl"` // required
+
+	// ClientID is the client ID. It can reference a secret, e.g. `secrets://client_id`.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.client_id`
+	ClientID string `bson:"clientId" json:"clientId"` // required
+
+	// ClientSecret is the client secret. It can reference a secret, e.g. `vault://upstream.client_secret`.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.client_secret`
+	ClientSecret string `bson:"clientSecret" json:"clientSecret"` // required
+
+	// Scopes are the scopes requested for the tokens.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.scopes`
+	Scopes []string `bson:"scopes,omitempty" json:"scopes,omitempty"`
+
+	// EndpointParams are extra parameters sent to the token endpoint, e.g. an audience.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.endpoint_params`
+	EndpointParams []UpstreamOAuthParam `bson:"endpointParams,omitempty" json:"endpointParams,omitempty"`
+
+	// AuthStyle is how the client credentials are sent to the token endpoint. Valid values are:
+	// - `header`, with HTTP basic authentication,
+	// - `params`, in the form body.
+	// Defaults to `header`.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.auth_style`
+	AuthStyle string `bson:"authStyle,omitempty" json:"authStyle,omitempty"`
+
+	// HeaderName is the header the token is sent to the upstream in. Defaults to `Authorization`.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.header_name`
+	HeaderName string `bson:"headerName,omitempty" json:"headerName,omitempty"`
+
+	// ExpiryDelta is the number of seconds before its expiry a token is refreshed. Defaults to 10.
+	//
+	// Tyk classic API definition: `proxy.upstream_auth.oauth.expiry_delta`
+	ExpiryDelta float64 `bson:"expiryDelta,omitempty" json:"expiryDelta,omitempty"`
+}
+
+// UpstreamOAuthParam is a parameter sent to the token endpoint.
+type UpstreamOAuthParam struct {
+	// Name is the name of the parameter.
+	Name string `bson:"name" json:"name"`
+	// Value is the value of the parameter.
+	Value string `bson:"value" json:"value"`
+}
+
+// Fill fills *UpstreamOAuth from apidef.UpstreamOAuthConfig.
+func (u *UpstreamOAuth) Fill(conf apidef.UpstreamOAuthConfig) {
+	u.TokenURL = conf.TokenURL
+	u.ClientID = conf.ClientID
+	u.ClientSecret = conf.ClientSecret
+	u.Scopes = conf.Scopes
+	u.AuthStyle = string(conf.AuthStyle)
+	u.HeaderName = conf.HeaderName
+	u.ExpiryDelta = conf.ExpiryDelta
+
+	u.EndpointParams = nil
+	for name, value := range conf.EndpointParams {
+		u.EndpointParams = append(u.EndpointParams, UpstreamOAuthParam{Name: name, Value: value})
+	}
+
+	sort.Slice(u.EndpointParams, func(i, j int) bool {
+		return u.EndpointParams[i].Name < u.EndpointParams[j].Name
+	})
+}
+
+// ExtractTo extracts *UpstreamOAuth into *apidef.UpstreamOAuthConfig.
+func (u *UpstreamOAuth) ExtractTo(conf *apidef.UpstreamOAuthConfig) {
+	conf.TokenURL = u.TokenURL
+	conf.ClientID = u.ClientID
+	conf.ClientSecret = u.ClientSecret
+	conf.Scopes = u.Scopes
+	conf.AuthStyle = apidef.UpstreamOAuthAuthStyle(u.AuthStyle)
+	conf.HeaderName = u.HeaderName
+	conf.ExpiryDelta = u.ExpiryDelta
+
+	conf.EndpointParams = nil
+	if len(u.EndpointParams) > 0 {
+		conf.EndpointParams = make(map[string]string, len(u.EndpointParams))
+		for _, param := range u.EndpointParams {
+			conf.EndpointParams[param.Name] = param.Value
+		}
+	}
+}
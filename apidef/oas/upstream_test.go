@@ -1,6 +1,7 @@
 package oas
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -358,3 +359,153 @@ func TestCertificatePinning(t *testing.T) {
 		assert.Equal(t, emptyCertificatePinnning, resultCertificatePinning)
 	})
 }
+
+func TestRetryPolicy(t *testing.T) {
+	var emptyRetryPolicy RetryPolicy
+
+	var convertedRetryPolicy apidef.RetryPolicy
+	emptyRetryPolicy.ExtractTo(&convertedRetryPolicy)
+
+	var resultRetryPolicy RetryPolicy
+	resultRetryPolicy.Fill(convertedRetryPolicy)
+
+	assert.Equal(t, emptyRetryPolicy, resultRetryPolicy)
+}
+
+func TestTrafficSplit(t *testing.T) {
+	var emptyTrafficSplit TrafficSplit
+
+	var convertedTrafficSplit apidef.TrafficSplitConfig
+	emptyTrafficSplit.ExtractTo(&convertedTrafficSplit)
+
+	var resultTrafficSplit TrafficSplit
+	resultTrafficSplit.Fill(convertedTrafficSplit)
+
+	assert.Equal(t, emptyTrafficSplit, resultTrafficSplit)
+
+	t.Run("groups and overrides", func(t *testing.T) {
+		var trafficSplit TrafficSplit
+		Fill(t, &trafficSplit, 0)
+
+		var converted apidef.TrafficSplitConfig
+		trafficSplit.ExtractTo(&converted)
+
+		var result TrafficSplit
+		result.Fill(converted)
+
+		assert.Equal(t, trafficSplit, result)
+	})
+}
+
+func TestMirror(t *testing.T) {
+	var emptyMirror Mirror
+
+	var convertedMirror apidef.MirrorConfig
+	emptyMirror.ExtractTo(&convertedMirror)
+
+	var resultMirror Mirror
+	resultMirror.Fill(convertedMirror)
+
+	assert.Equal(t, emptyMirror, resultMirror)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		var emptyConcurrencyLimit ConcurrencyLimit
+
+		var convertedConcurrencyLimit apidef.ConcurrencyLimitConfig
+		emptyConcurrencyLimit.ExtractTo(&convertedConcurrencyLimit)
+
+		var resultConcurrencyLimit ConcurrencyLimit
+		resultConcurrencyLimit.Fill(convertedConcurrencyLimit)
+
+		assert.Equal(t, emptyConcurrencyLimit, resultConcurrencyLimit)
+	})
+
+	t.Run("filled", func(t *testing.T) {
+		var concurrencyLimit ConcurrencyLimit
+		Fill(t, &concurrencyLimit, 0)
+
+		var converted apidef.ConcurrencyLimitConfig
+		concurrencyLimit.ExtractTo(&converted)
+
+		var result ConcurrencyLimit
+		result.Fill(converted)
+
+		assert.Equal(t, concurrencyLimit, result)
+	})
+}
+
+func TestGRPCTranscoding(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		var emptyGRPCTranscoding GRPCTranscoding
+
+		var convertedGRPCTranscoding apidef.GRPCTranscodingConfig
+		emptyGRPCTranscoding.ExtractTo(&convertedGRPCTranscoding)
+
+		var resultGRPCTranscoding GRPCTranscoding
+		resultGRPCTranscoding.Fill(convertedGRPCTranscoding)
+
+		assert.Equal(t, emptyGRPCTranscoding, resultGRPCTranscoding)
+	})
+
+	t.Run("filled", func(t *testing.T) {
+		var grpcTranscoding GRPCTranscoding
+		Fill(t, &grpcTranscoding, 0)
+
+		var converted apidef.GRPCTranscodingConfig
+		grpcTranscoding.ExtractTo(&converted)
+
+		var result GRPCTranscoding
+		result.Fill(converted)
+
+		assert.Equal(t, grpcTranscoding, result)
+	})
+}
+
+func TestGRPCWeb(t *testing.T) {
+	var emptyGRPCWeb GRPCWeb
+
+	var convertedGRPCWeb apidef.GRPCWebConfig
+	emptyGRPCWeb.ExtractTo(&convertedGRPCWeb)
+
+	var resultGRPCWeb GRPCWeb
+	resultGRPCWeb.Fill(convertedGRPCWeb)
+
+	assert.Equal(t, emptyGRPCWeb, resultGRPCWeb)
+
+	grpcWeb := GRPCWeb{Enabled: true}
+	grpcWeb.ExtractTo(&convertedGRPCWeb)
+	assert.True(t, convertedGRPCWeb.Enabled)
+}
+
+func TestUpstreamAuth(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		var emptyUpstreamAuth UpstreamAuth
+
+		var convertedUpstreamAuth apidef.UpstreamAuthConfig
+		emptyUpstreamAuth.ExtractTo(&convertedUpstreamAuth)
+
+		var resultUpstreamAuth UpstreamAuth
+		resultUpstreamAuth.Fill(convertedUpstreamAuth)
+
+		assert.Equal(t, emptyUpstreamAuth, resultUpstreamAuth)
+	})
+
+	t.Run("filled", func(t *testing.T) {
+		var upstreamAuth UpstreamAuth
+		Fill(t, &upstreamAuth, 0)
+		// endpoint params are sorted by name
+		sort.Slice(upstreamAuth.OAuth.EndpointParams, func(i, j int) bool {
+			return upstreamAuth.OAuth.EndpointParams[i].Name < upstreamAuth.OAuth.EndpointParams[j].Name
+		})
+
+		var converted apidef.UpstreamAuthConfig
+		upstreamAuth.ExtractTo(&converted)
+
+		var result UpstreamAuth
+		result.Fill(converted)
+
+		assert.Equal(t, upstreamAuth, result)
+	})
+}
@@ -54,6 +54,7 @@ type ValidationRuleSet []ValidationRule
 var DefaultValidationRuleSet = ValidationRuleSet{
 	&RuleUniqueDataSourceNames{},
 	&RuleAtLeastEnableOneAuthSource{},
+	&RuleTrafficSplitWithoutServiceDiscovery{},
 }
 
 func Validate(definition *APIDefinition, ruleSet ValidationRuleSet) ValidationResult {
@@ -137,3 +138,14 @@ func shouldValidateAuthSource(authType string, apiDef *APIDefinition) bool {
 
 	return false
 }
+
+var ErrTrafficSplitWithServiceDiscovery = errors.New("traffic split can't be used with service discovery")
+
+type RuleTrafficSplitWithoutServiceDiscovery struct{}
+
+func (r *RuleTrafficSplitWithoutServiceDiscovery) Validate(apiDef *APIDefinition, validationResult *ValidationResult) {
+	if apiDef.Proxy.TrafficSplit.Enabled && apiDef.Proxy.ServiceDiscovery.UseDiscoveryService {
+		validationResult.IsValid = false
+		validationResult.AppendError(ErrTrafficSplitWithServiceDiscovery)
+	}
+}
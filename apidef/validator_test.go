@@ -217,3 +217,34 @@ func TestRuleAtLeastEnableOneAuthConfig_Validate(t *testing.T) {
 		},
 	))
 }
+
+func TestRuleTrafficSplitWithoutServiceDiscovery_Validate(t *testing.T) {
+	ruleSet := ValidationRuleSet{
+		&RuleTrafficSplitWithoutServiceDiscovery{},
+	}
+
+	apiDef := &APIDefinition{}
+	apiDef.Proxy.TrafficSplit.Enabled = true
+	t.Run("should return valid when service discovery isn't used", runValidationTest(
+		apiDef,
+		ruleSet,
+		ValidationResult{
+			IsValid: true,
+			Errors:  nil,
+		},
+	))
+
+	withDiscovery := &APIDefinition{}
+	withDiscovery.Proxy.TrafficSplit.Enabled = true
+	withDiscovery.Proxy.ServiceDiscovery.UseDiscoveryService = true
+	t.Run("should return invalid when service discovery is used", runValidationTest(
+		withDiscovery,
+		ruleSet,
+		ValidationResult{
+			IsValid: false,
+			Errors: []error{
+				ErrTrafficSplitWithServiceDiscovery,
+			},
+		},
+	))
+}
@@ -36,7 +36,7 @@ module "tyk" {
 { branch    = "master",
 	reviewers = "2",
 	convos    = "false",
-	required_tests = ["Go 1.16 Redis 5","1.16","1.16-el7"]},
+	required_tests = ["Go 1.21 Redis 5","1.21","1.21-el7"]},
 { branch    = "release-4.0.10",
 	reviewers = "0",
 	convos    = "false",
@@ -81,11 +81,11 @@ module "tyk" {
 	reviewers = "0",
 	convos    = "false",
 	source_branch  = "master",
-	required_tests = ["Go 1.16 Redis 5","1.16","1.16-el7"]},
+	required_tests = ["Go 1.21 Redis 5","1.21","1.21-el7"]},
 { branch    = "release-5.0",
 	reviewers = "0",
 	convos    = "false",
 	source_branch  = "master",
-	required_tests = ["Go 1.16 Redis 5","1.16","1.16-el7"]},
+	required_tests = ["Go 1.21 Redis 5","1.21","1.21-el7"]},
 ]
 }
\ No newline at end of file
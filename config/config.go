@@ -220,6 +220,15 @@ type HealthCheckConfig struct {
 	HealthCheckValueTimeout int64 `json:"health_check_value_timeouts"`
 }
 
+type RetryBudgetConfig struct {
+	// The maximum ratio of upstream retries to requests over the last 10 seconds, across all APIs using a retry policy.
+	// Retries above the budget are not attempted, so retries can't amplify an upstream outage. Defaults to 0.2.
+	Ratio float64 `json:"ratio"`
+
+	// The number of retries per second that are always allowed, regardless of the ratio. Defaults to 10.
+	MinRetriesPerSecond int `json:"min_retries_per_second"`
+}
+
 type LivenessCheckConfig struct {
 	// Frequencies of performing interval healthchecks for Redis, Dashboard, and RPC layer. Default: 10 seconds.
 	CheckDuration time.Duration `json:"check_duration"`
@@ -335,6 +344,35 @@ type LocalSessionCacheConf struct {
 	CachedSessionTimeout int `json:"cached_session_timeout"`
 	CacheSessionEviction int `json:"cached_session_eviction"`
 }
+
+type LocalResponseCacheConfig struct {
+	// Set this to `true` to keep the hot cached responses of the APIs enabling `cache_options.enable_local_cache` in memory on the node,
+	// in front of Redis. Entries expire with their TTL, and are removed from all the nodes when the cache of an API is invalidated.
+	Enabled bool `json:"enabled"`
+
+	// The maximum size of the cached responses kept in memory, in bytes. Defaults to 64MB.
+	MaxSize int64 `json:"max_size"`
+
+	// The policy evicting entries once the cache is full, either `lru` (least recently used) or `lfu` (least frequently used).
+	// Defaults to `lru`.
+	EvictionPolicy string `json:"eviction_policy"`
+}
+
+type HybridRateLimiterConfig struct {
+	// Set this to `true` to enforce the rate limits with a token bucket local to each node, which synchronises the requests it consumed with Redis
+	// in batches. Rate limiting costs no Redis round trip on the request path, while the limits are shared by all the nodes.
+	// The scripted rate limiters of `rate_limit_algorithm` take precedence.
+	Enabled bool `json:"enabled"`
+
+	// How frequently, in milliseconds, the nodes synchronise the requests they consumed with Redis. Defaults to 100.
+	SyncInterval int `json:"sync_interval"`
+
+	// The fraction of the rate each node may consume before synchronising with Redis, bounding how much the rate limits can be
+	// overshot on each node between synchronisations. For example, with `0.1` a node synchronises at least every 10 requests on a rate
+	// limit of 100 requests. Defaults to 0.1.
+	MaxOvershoot float64 `json:"max_overshoot"`
+}
+
 type CertsData []CertData
 
 func (certs *CertsData) Decode(value string) error {
@@ -365,6 +403,10 @@ type HttpServerOptionsConfig struct {
 	// Enable HTTP2 protocol handling
 	EnableHttp2 bool `json:"enable_http2"`
 
+	// Enable HTTP3 over QUIC on the UDP side of the TLS listeners. Responses sent over
+	// HTTP1.1 and HTTP2 advertise it with an Alt-Svc header. Requires `use_ssl`.
+	EnableHttp3 bool `json:"enable_http3"`
+
 	// EnableStrictRoutes changes the routing to avoid nearest-neighbour requests on overlapping routes
 	//
 	// - if disabled, `/apple` will route to `/app`, the current default behavior,
@@ -705,6 +747,14 @@ type Config struct {
 	// Controls which algorthm to use as a fallback when your distributed rate limiter can't be used.
 	DRLEnableSentinelRateLimiter bool `json:"drl_enable_sentinel_rate_limiter"`
 
+	// Selects a rate limiter evaluated by a single atomic Redis script, using constant memory per key instead of one entry per request:
+	// `gcra` for the generic cell rate algorithm, allowing bursts up to the rate, or `sliding_window` for a sliding window counter.
+	// Takes precedence over the other rate limiters, and can be overridden per API with `rate_limit_algorithm`.
+	RateLimitAlgorithm apidef.RateLimitAlgorithm `json:"rate_limit_algorithm"`
+
+	// Hybrid rate limiter, enforcing the rate limits locally and synchronising them with Redis asynchronously.
+	HybridRateLimiter HybridRateLimiterConfig `json:"hybrid_rate_limiter"`
+
 	// Allows you to dynamically configure analytics expiration on a per organisation level
 	EnforceOrgDataAge bool `json:"enforce_org_data_age"`
 
@@ -788,6 +838,9 @@ type Config struct {
 	// This can cause a file-handler limit to be exceeded. Setting to false can have performance benefits as the connection can be reused.
 	ProxyCloseConnections bool `json:"proxy_close_connections"`
 
+	// Limits the retries done by API retry policies on this node.
+	RetryBudget RetryBudgetConfig `json:"retry_budget"`
+
 	// Tyk nodes can provide uptime awareness, uptime testing and analytics for your underlying APIs uptime and availability.
 	// Tyk can also notify you when a service goes down.
 	UptimeTests UptimeTestsConfig `json:"uptime_tests"`
@@ -857,6 +910,9 @@ type Config struct {
 	// This does not affect rate limiting.
 	LocalSessionCache LocalSessionCacheConf `json:"local_session_cache"`
 
+	// Tyk can keep the hot entries of the API response cache in memory on each node, saving a Redis round trip per cache hit.
+	LocalResponseCache LocalResponseCacheConfig `json:"local_response_cache"`
+
 	// Enable to use a separate Redis for cache storage
 	EnableSeperateCacheStore bool               `json:"enable_separate_cache_store"`
 	CacheStorage             StorageOptionsConf `json:"cache_storage"`
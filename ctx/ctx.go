@@ -47,6 +47,30 @@ const (
 
 	// CacheOptions holds cache options required for cache writer middleware.
 	CacheOptions
+
+	// AnalyticsTags holds tags added to the analytics record while proxying the request.
+	AnalyticsTags
+
+	// UpstreamGroup holds the traffic split group the request is sent to.
+	UpstreamGroup
+
+	// ConcurrencySlot holds the concurrency limit slot taken by the request.
+	ConcurrencySlot
+
+	// GRPCCall holds the gRPC method a transcoded request is sent to.
+	GRPCCall
+
+	// GRPCWebCall holds how a gRPC-Web request was translated to native gRPC.
+	GRPCWebCall
+
+	// ClientAddr holds the address of the client an upstream request is sent for.
+	ClientAddr
+
+	// RateLimitState holds the state of the rate limit of the request, when evaluated by a Redis script.
+	RateLimitState
+
+	// JWTClaims holds the claims of the validated JWT of the request.
+	JWTClaims
 )
 
 func setContext(r *http.Request, ctx context.Context) {
@@ -34,6 +34,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -2854,6 +2855,7 @@ func (gw *Gateway) invalidateCacheHandler(w http.ResponseWriter, r *http.Request
 		doJSONWrite(w, http.StatusInternalServerError, apiError("Cache invalidation failed"))
 		return
 	}
+	gw.invalidateLocalResponseCache(apiID)
 
 	doJSONWrite(w, http.StatusOK, apiOk("cache invalidated"))
 }
@@ -3087,6 +3089,116 @@ func ctxGetCacheOptions(r *http.Request) *cacheOptions {
 	return key
 }
 
+// analyticsTags collects the tags added to the analytics record of a request while it is proxied.
+type analyticsTags struct {
+	mu   sync.Mutex
+	tags []string
+}
+
+// ctxInitAnalyticsTags makes sure the request carries an analytics tags holder, so that
+// the tags added on copies of the request end up in the analytics record.
+func ctxInitAnalyticsTags(r *http.Request) {
+	if _, ok := r.Context().Value(ctx.AnalyticsTags).(*analyticsTags); !ok {
+		setCtxValue(r, ctx.AnalyticsTags, &analyticsTags{})
+	}
+}
+
+func ctxAddAnalyticsTags(r *http.Request, tags ...string) {
+	holder, ok := r.Context().Value(ctx.AnalyticsTags).(*analyticsTags)
+	if !ok {
+		return
+	}
+	holder.mu.Lock()
+	holder.tags = append(holder.tags, tags...)
+	holder.mu.Unlock()
+}
+
+func ctxGetAnalyticsTags(r *http.Request) []string {
+	holder, ok := r.Context().Value(ctx.AnalyticsTags).(*analyticsTags)
+	if !ok {
+		return nil
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	return append([]string(nil), holder.tags...)
+}
+
+func ctxSetUpstreamGroup(r *http.Request, group string) {
+	setCtxValue(r, ctx.UpstreamGroup, group)
+}
+
+func ctxGetUpstreamGroup(r *http.Request) string {
+	group, _ := r.Context().Value(ctx.UpstreamGroup).(string)
+	return group
+}
+
+// concurrencySlot is the concurrency limit slot held by a request, released once the response is served.
+type concurrencySlot struct {
+	once    sync.Once
+	release func(latency time.Duration, dropped bool)
+}
+
+func ctxSetConcurrencySlot(r *http.Request, release func(time.Duration, bool)) {
+	setCtxValue(r, ctx.ConcurrencySlot, &concurrencySlot{release: release})
+}
+
+func ctxSetGRPCCall(r *http.Request, call *grpcCall) {
+	setCtxValue(r, ctx.GRPCCall, call)
+}
+
+func ctxGetGRPCCall(r *http.Request) *grpcCall {
+	call, _ := r.Context().Value(ctx.GRPCCall).(*grpcCall)
+	return call
+}
+
+func ctxSetGRPCWebCall(r *http.Request, call *grpcWebCall) {
+	setCtxValue(r, ctx.GRPCWebCall, call)
+}
+
+func ctxGetGRPCWebCall(r *http.Request) *grpcWebCall {
+	call, _ := r.Context().Value(ctx.GRPCWebCall).(*grpcWebCall)
+	return call
+}
+
+func ctxSetClientAddr(r *http.Request, addr *net.TCPAddr) {
+	setCtxValue(r, ctx.ClientAddr, addr)
+}
+
+// ctxGetClientAddr returns the client address from the context of an upstream request, or from
+// the context of the connections dialed for it.
+func ctxGetClientAddr(c context.Context) *net.TCPAddr {
+	addr, _ := c.Value(ctx.ClientAddr).(*net.TCPAddr)
+	return addr
+}
+
+func ctxSetRateLimitState(r *http.Request, state *rateLimitState) {
+	setCtxValue(r, ctx.RateLimitState, state)
+}
+
+// ctxGetRateLimitState returns the state of the rate limit of the request, if evaluated by a
+// scripted rate limiter.
+func ctxGetRateLimitState(r *http.Request) *rateLimitState {
+	state, _ := r.Context().Value(ctx.RateLimitState).(*rateLimitState)
+	return state
+}
+
+func ctxHasConcurrencySlot(r *http.Request) bool {
+	_, ok := r.Context().Value(ctx.ConcurrencySlot).(*concurrencySlot)
+	return ok
+}
+
+// ctxReleaseConcurrencySlot releases the concurrency limit slot of the request, if it holds one.
+// The upstream latency, or whether the upstream request failed, feeds the adaptive limit.
+func ctxReleaseConcurrencySlot(r *http.Request, latency time.Duration, dropped bool) {
+	slot, ok := r.Context().Value(ctx.ConcurrencySlot).(*concurrencySlot)
+	if !ok {
+		return
+	}
+	slot.once.Do(func() {
+		slot.release(latency, dropped)
+	})
+}
+
 func ctxGetSession(r *http.Request) *user.SessionState {
 	return ctx.GetSession(r)
 }
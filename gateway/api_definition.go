@@ -90,6 +90,10 @@ const (
 	Internal
 	GoPlugin
 	PersistGraphQL
+	RetryPolicy
+	Mirrored
+	ConcurrencyLimited
+	RateLimitRuled
 )
 
 // RequestStatus is a custom type to avoid collisions
@@ -126,6 +130,10 @@ const (
 	StatusInternal                 RequestStatus = "Internal path"
 	StatusGoPlugin                 RequestStatus = "Go plugin"
 	StatusPersistGraphQL           RequestStatus = "Persist GraphQL"
+	StatusRetryPolicy              RequestStatus = "Retry policy enforced"
+	StatusMirrored                 RequestStatus = "Mirrored"
+	StatusConcurrencyLimited       RequestStatus = "Concurrency limited"
+	StatusRateLimitRuled           RequestStatus = "Rate limited by rules"
 )
 
 // URLSpec represents a flattened specification for URLs, used to check if a proxy URL
@@ -158,6 +166,10 @@ type URLSpec struct {
 	Internal                  apidef.InternalMeta
 	GoPluginMeta              GoPluginMiddleware
 	PersistGraphQL            apidef.PersistGraphQLMeta
+	RetryPolicy               apidef.RetryPolicyMeta
+	Mirror                    apidef.MirrorMeta
+	ConcurrencyLimit          apidef.ConcurrencyLimitMeta
+	RateLimitRules            apidef.RateLimitRuleMeta
 
 	IgnoreCase bool
 }
@@ -166,6 +178,8 @@ type EndPointCacheMeta struct {
 	Method                 string
 	CacheKeyRegex          string
 	CacheOnlyResponseCodes []int
+	StaleWhileRevalidate   int64
+	StaleIfError           int64
 }
 
 type TransformSpec struct {
@@ -197,9 +211,22 @@ type APISpec struct {
 	JSVM                     JSVM
 	ResponseChain            []TykResponseHandler
 	RoundRobin               RoundRobin
+	WeightedRoundRobin       WeightedRoundRobin
+	ConsistentHash           ConsistentHash
+	UpstreamConnections      UpstreamConnections
+	OutlierDetector          OutlierDetector
+	UpstreamGroups           map[string]*apidef.HostList
+	GRPCTranscoder           *grpcTranscoder
+	UpstreamTokenSource      *UpstreamTokenSource
+	MirrorsInFlight          UpstreamConnections
+	ConcurrencyLimiters      ConcurrencyLimiters
 	URLRewriteEnabled        bool
 	CircuitBreakerEnabled    bool
 	EnforcedTimeoutEnabled   bool
+	RetryPolicyEnabled       bool
+	MirrorEnabled            bool
+	ConcurrencyLimitEnabled  bool
+	RateLimitRulesEnabled    bool
 	LastGoodHostList         *apidef.HostList
 	HasRun                   bool
 	ServiceRefreshInProgress bool
@@ -808,6 +835,8 @@ func (a APIDefinitionLoader) compileCachedPathSpec(oldpaths []string, newpaths [
 		newSpec.CacheConfig.Method = spec.Method
 		newSpec.CacheConfig.CacheKeyRegex = spec.CacheKeyRegex
 		newSpec.CacheConfig.CacheOnlyResponseCodes = spec.CacheOnlyResponseCodes
+		newSpec.CacheConfig.StaleWhileRevalidate = spec.StaleWhileRevalidate
+		newSpec.CacheConfig.StaleIfError = spec.StaleIfError
 		// Extend with method actions
 		urlSpec = append(urlSpec, newSpec)
 	}
@@ -951,6 +980,62 @@ func (a APIDefinitionLoader) compileTimeoutPathSpec(paths []apidef.HardTimeoutMe
 	return urlSpec
 }
 
+func (a APIDefinitionLoader) compileRetryPolicyPathSpec(paths []apidef.RetryPolicyMeta, stat URLStatus, conf config.Config) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat, conf)
+		newSpec.RetryPolicy = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
+func (a APIDefinitionLoader) compileMirrorPathSpec(paths []apidef.MirrorMeta, stat URLStatus, conf config.Config) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat, conf)
+		newSpec.Mirror = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
+func (a APIDefinitionLoader) compileRateLimitRulePathSpec(paths []apidef.RateLimitRuleMeta, stat URLStatus, conf config.Config) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat, conf)
+		newSpec.RateLimitRules = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
+func (a APIDefinitionLoader) compileConcurrencyLimitPathSpec(paths []apidef.ConcurrencyLimitMeta, stat URLStatus, conf config.Config) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat, conf)
+		newSpec.ConcurrencyLimit = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
 func (a APIDefinitionLoader) compileRequestSizePathSpec(paths []apidef.RequestSizeMeta, stat URLStatus, conf config.Config) []URLSpec {
 	// transform an extended configuration URL into an array of URLSpecs
 	// This way we can iterate the whole array once, on match we break with status
@@ -1237,6 +1322,10 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	internalPaths := a.compileInternalPathspathSpec(apiVersionDef.ExtendedPaths.Internal, Internal, conf)
 	goPlugins := a.compileGopluginPathspathSpec(apiVersionDef.ExtendedPaths.GoPlugin, GoPlugin, apiSpec, conf)
 	persistGraphQL := a.compilePersistGraphQLPathSpec(apiVersionDef.ExtendedPaths.PersistGraphQL, PersistGraphQL, apiSpec, conf)
+	retryPolicies := a.compileRetryPolicyPathSpec(apiVersionDef.ExtendedPaths.RetryPolicies, RetryPolicy, conf)
+	mirrors := a.compileMirrorPathSpec(apiVersionDef.ExtendedPaths.Mirrors, Mirrored, conf)
+	concurrencyLimits := a.compileConcurrencyLimitPathSpec(apiVersionDef.ExtendedPaths.ConcurrencyLimits, ConcurrencyLimited, conf)
+	rateLimitRules := a.compileRateLimitRulePathSpec(apiVersionDef.ExtendedPaths.RateLimitRules, RateLimitRuled, conf)
 
 	combinedPath := []URLSpec{}
 	combinedPath = append(combinedPath, mockResponsePaths...)
@@ -1262,6 +1351,10 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	combinedPath = append(combinedPath, unTrackedPaths...)
 	combinedPath = append(combinedPath, validateJSON...)
 	combinedPath = append(combinedPath, internalPaths...)
+	combinedPath = append(combinedPath, retryPolicies...)
+	combinedPath = append(combinedPath, mirrors...)
+	combinedPath = append(combinedPath, concurrencyLimits...)
+	combinedPath = append(combinedPath, rateLimitRules...)
 
 	return combinedPath, len(whiteListPaths) > 0
 }
@@ -1322,6 +1415,14 @@ func (a *APISpec) getURLStatus(stat URLStatus) RequestStatus {
 		return StatusGoPlugin
 	case PersistGraphQL:
 		return StatusPersistGraphQL
+	case RetryPolicy:
+		return StatusRetryPolicy
+	case Mirrored:
+		return StatusMirrored
+	case ConcurrencyLimited:
+		return StatusConcurrencyLimited
+	case RateLimitRuled:
+		return StatusRateLimitRuled
 	default:
 		log.Error("URL Status was not one of Ignored, Blacklist or WhiteList! Blocking.")
 		return EndPointNotAllowed
@@ -1547,6 +1648,22 @@ func (a *APISpec) CheckSpecMatchesStatus(r *http.Request, rxPaths []URLSpec, mod
 			if method == rxPaths[i].PersistGraphQL.Method {
 				return true, &rxPaths[i].PersistGraphQL
 			}
+		case RetryPolicy:
+			if method == rxPaths[i].RetryPolicy.Method {
+				return true, &rxPaths[i].RetryPolicy
+			}
+		case Mirrored:
+			if method == rxPaths[i].Mirror.Method {
+				return true, &rxPaths[i].Mirror
+			}
+		case ConcurrencyLimited:
+			if method == rxPaths[i].ConcurrencyLimit.Method {
+				return true, &rxPaths[i].ConcurrencyLimit
+			}
+		case RateLimitRuled:
+			if method == rxPaths[i].RateLimitRules.Method {
+				return true, &rxPaths[i].RateLimitRules
+			}
 		}
 	}
 	return false, nil
@@ -196,9 +196,34 @@ func (gw *Gateway) processSpec(spec *APISpec, apisByListen map[string]int,
 
 	// Set up LB targets:
 	if spec.Proxy.EnableLoadBalancing {
-		sl := apidef.NewHostListFromList(spec.Proxy.Targets)
+		sl := apidef.NewHostList()
+		sl.SetWithWeights(spec.Proxy.Targets, spec.Proxy.LoadBalancing.Weights(spec.Proxy.Targets))
 		spec.Proxy.StructuredTargetList = sl
 	}
+	if sd := spec.Proxy.ServiceDiscovery; sd.UseDiscoveryService && sd.Provider == apidef.DNSSRVServiceDiscovery &&
+		spec.Proxy.EnableLoadBalancing && !spec.Proxy.LoadBalancing.Algorithm.UsesWeights() {
+		logger.Warning("The weights of the SRV records are ignored by the load balancing algorithm, use weighted_round_robin or consistent_hash to apply them")
+	}
+	if spec.Proxy.TrafficSplit.Enabled {
+		if spec.Proxy.ServiceDiscovery.UseDiscoveryService {
+			logger.Error(apidef.ErrTrafficSplitWithServiceDiscovery.Error() + ", traffic isn't split")
+		}
+		spec.UpstreamGroups = upstreamGroups(spec)
+	}
+	if spec.Proxy.GRPCTranscoding.Enabled {
+		transcoder, err := loadGRPCTranscoder(spec.Proxy.GRPCTranscoding)
+		if err != nil {
+			logger.WithError(err).Error("Couldn't load gRPC transcoding descriptor set")
+		}
+		spec.GRPCTranscoder = transcoder
+	}
+	if spec.Proxy.UpstreamAuth.Enabled {
+		tokenSource, err := gw.newUpstreamTokenSource(spec.OrgID, spec.Proxy.UpstreamAuth.OAuth)
+		if err != nil {
+			logger.WithError(err).Error("Couldn't set up upstream OAuth")
+		}
+		spec.UpstreamTokenSource = tokenSource
+	}
 
 	// Initialise the auth and session managers (use Redis for now)
 	authStore := gs.redisStore
@@ -289,6 +314,30 @@ func (gw *Gateway) processSpec(spec *APISpec, apisByListen map[string]int,
 		if len(v.ExtendedPaths.HardTimeouts) > 0 {
 			baseMid.Spec.EnforcedTimeoutEnabled = true
 		}
+		if len(v.ExtendedPaths.RetryPolicies) > 0 {
+			baseMid.Spec.RetryPolicyEnabled = true
+		}
+		if len(v.ExtendedPaths.Mirrors) > 0 {
+			baseMid.Spec.MirrorEnabled = true
+		}
+		if len(v.ExtendedPaths.ConcurrencyLimits) > 0 {
+			baseMid.Spec.ConcurrencyLimitEnabled = true
+		}
+		if len(v.ExtendedPaths.RateLimitRules) > 0 {
+			baseMid.Spec.RateLimitRulesEnabled = true
+		}
+	}
+	if spec.Proxy.RetryPolicy.Enabled {
+		baseMid.Spec.RetryPolicyEnabled = true
+	}
+	if spec.Proxy.Mirror.Enabled {
+		baseMid.Spec.MirrorEnabled = true
+	}
+	if spec.Proxy.ConcurrencyLimit.Enabled {
+		baseMid.Spec.ConcurrencyLimitEnabled = true
+	}
+	if len(spec.RateLimitRules) > 0 {
+		baseMid.Spec.RateLimitRulesEnabled = true
 	}
 
 	keyPrefix := "cache-" + spec.APIID
@@ -422,6 +471,8 @@ func (gw *Gateway) processSpec(spec *APISpec, apisByListen map[string]int,
 	}
 
 	gw.mwAppendEnabled(&chainArray, &RateLimitForAPI{BaseMiddleware: baseMid})
+	gw.mwAppendEnabled(&chainArray, &RateLimitRulesMiddleware{BaseMiddleware: baseMid})
+	gw.mwAppendEnabled(&chainArray, &ConcurrencyLimitMiddleware{BaseMiddleware: baseMid})
 	gw.mwAppendEnabled(&chainArray, &GraphQLMiddleware{BaseMiddleware: baseMid})
 	if !spec.UseKeylessAccess {
 		gw.mwAppendEnabled(&chainArray, &GraphQLComplexityMiddleware{BaseMiddleware: baseMid})
@@ -436,9 +487,13 @@ func (gw *Gateway) processSpec(spec *APISpec, apisByListen map[string]int,
 	gw.mwAppendEnabled(&chainArray, &TransformHeaders{BaseMiddleware: baseMid})
 	gw.mwAppendEnabled(&chainArray, &URLRewriteMiddleware{BaseMiddleware: baseMid})
 	gw.mwAppendEnabled(&chainArray, &TransformMethod{BaseMiddleware: baseMid})
+	gw.mwAppendEnabled(&chainArray, &GRPCTranscodingMiddleware{BaseMiddleware: baseMid})
+	gw.mwAppendEnabled(&chainArray, &GRPCWebMiddleware{BaseMiddleware: baseMid})
 
 	// Earliest we can respond with cache get 200 ok
-	gw.mwAppendEnabled(&chainArray, &RedisCacheMiddleware{BaseMiddleware: baseMid, store: &cacheStore})
+	cacheMw := &RedisCacheMiddleware{BaseMiddleware: baseMid, store: gw.wrapLocalResponseCache(&cacheStore, spec)}
+	cacheEnabled := gw.mwAppendEnabled(&chainArray, cacheMw)
+	afterCache := len(chainArray)
 
 	gw.mwAppendEnabled(&chainArray, &VirtualEndpoint{BaseMiddleware: baseMid})
 	gw.mwAppendEnabled(&chainArray, &RequestSigning{BaseMiddleware: baseMid})
@@ -464,6 +519,10 @@ func (gw *Gateway) processSpec(spec *APISpec, apisByListen map[string]int,
 	}
 
 	chain = alice.New(chainArray...).Then(&DummyProxyHandler{SH: SuccessHandler{baseMid}, Gw: gw})
+	if cacheEnabled {
+		// expired responses are refreshed, and coalesced requests are led, through the rest of the chain
+		cacheMw.next = alice.New(chainArray[afterCache:]...).Then(&DummyProxyHandler{SH: SuccessHandler{baseMid}, Gw: gw})
+	}
 
 	if !spec.UseKeylessAccess {
 		var simpleArray []alice.Constructor
@@ -948,6 +1007,24 @@ func (gw *Gateway) loadApps(specs []*APISpec) {
 
 	gw.DefaultProxyMux.swap(muxer, gw)
 
+	gw.replaceAPISpecs(specs, tmpSpecRegister, tmpSpecHandles)
+
+	mainLog.Debug("Checker host list")
+
+	// Kick off our host checkers
+	if !gw.GetConfig().UptimeTests.Disable {
+		gw.SetCheckerHostList()
+	}
+
+	mainLog.Debug("Checker host Done")
+
+	mainLog.Info("Initialised API Definitions")
+
+}
+
+// replaceAPISpecs replaces the loaded API specs with the ones of register, releasing the
+// resources of the changed ones.
+func (gw *Gateway) replaceAPISpecs(specs []*APISpec, register map[string]*APISpec, handles *sync.Map) {
 	gw.apisMu.Lock()
 
 	for _, spec := range specs {
@@ -958,26 +1035,17 @@ func (gw *Gateway) loadApps(specs []*APISpec) {
 
 		// Bind versions to base APIs again
 		for _, vID := range spec.VersionDefinition.Versions {
-			if versionAPI, ok := tmpSpecRegister[vID]; ok {
+			if versionAPI, ok := register[vID]; ok {
 				versionAPI.VersionDefinition.BaseID = spec.APIID
 			}
 		}
 	}
 
-	gw.apisByID = tmpSpecRegister
-	gw.apisHandlesByID = tmpSpecHandles
+	gw.apisByID = register
+	gw.apisHandlesByID = handles
 
 	gw.apisMu.Unlock()
 
-	mainLog.Debug("Checker host list")
-
-	// Kick off our host checkers
-	if !gw.GetConfig().UptimeTests.Disable {
-		gw.SetCheckerHostList()
-	}
-
-	mainLog.Debug("Checker host Done")
-
-	mainLog.Info("Initialised API Definitions")
-
+	// the locks of the watchers are never taken while holding apisMu
+	gw.watchedDiscovery.Prune(register)
 }
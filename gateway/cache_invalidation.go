@@ -0,0 +1,235 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/regexp"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	// surrogateKeyHeader lists the tags of an upstream response, which the cached response can be
+	// invalidated by
+	surrogateKeyHeader = "Surrogate-Key"
+
+	// the indexes list the cache entries by request path, tag and session, so they can be
+	// invalidated without flushing the cache of the API
+	cacheIndexPrefix = "index:"
+	cacheIndexPaths  = cacheIndexPrefix + "paths"
+)
+
+func cachePathIndex(methodPath string) string {
+	return cacheIndexPrefix + "path:" + methodPath
+}
+
+func cacheTagIndex(tag string) string {
+	return cacheIndexPrefix + "tag:" + tag
+}
+
+func cacheSessionIndex(keyHash string) string {
+	return cacheIndexPrefix + "session:" + keyHash
+}
+
+// cacheIndexPath returns the entry of the request in the path index, the method and the path
+// relative to the listen path.
+func (m *RedisCacheMiddleware) cacheIndexPath(r *http.Request) string {
+	return r.Method + " " + m.Spec.StripListenPath(r, r.URL.Path)
+}
+
+// surrogateKeys returns the tags the upstream set on a response.
+func surrogateKeys(h http.Header) []string {
+	var tags []string
+	for _, line := range h.Values(surrogateKeyHeader) {
+		tags = append(tags, strings.Fields(line)...)
+	}
+	return tags
+}
+
+// cacheIndexStore updates the cache indexes in a single round trip.
+type cacheIndexStore interface {
+	AddToSortedSetsWithExpiry(sets []storage.SortedSetMembers, score, trimUntil float64, ttl int64) error
+}
+
+// indexCacheEntries lists the cache entries of a response in the indexes of its request path,
+// tags and session, if the API enables the invalidation indexes. The indexes are sorted sets
+// scored by the expiry of their members, so that the expired ones can be trimmed, and are kept
+// as long as their members.
+func indexCacheEntries(store storage.Handler, keys []string, options *cacheOptions, tags []string, ttl int64) {
+	if options.indexPath == "" || len(keys) == 0 {
+		return
+	}
+	if local, ok := store.(*localCacheStore); ok {
+		store = local.Handler
+	}
+	indexStore, ok := store.(cacheIndexStore)
+	if !ok {
+		return
+	}
+
+	sets := []storage.SortedSetMembers{
+		{Key: cachePathIndex(options.indexPath), Members: keys},
+		// the path is listed as long as its index is kept
+		{Key: cacheIndexPaths, Members: []string{options.indexPath}},
+	}
+	if options.indexSession != "" {
+		sets = append(sets, storage.SortedSetMembers{Key: cacheSessionIndex(options.indexSession), Members: keys})
+	}
+	for _, tag := range tags {
+		sets = append(sets, storage.SortedSetMembers{Key: cacheTagIndex(tag), Members: keys})
+	}
+
+	now := time.Now().Unix()
+	if err := indexStore.AddToSortedSetsWithExpiry(sets, float64(now+ttl), float64(now), ttl); err != nil {
+		log.WithError(err).Error("Could not index cache entries")
+	}
+}
+
+// trimCacheIndex removes the expired members of an index.
+func trimCacheIndex(store storage.Handler, index string, now int64) {
+	store.RemoveSortedSetRange(index, "-inf", strconv.FormatInt(now, 10))
+}
+
+// cacheIndexMembers returns the members of an index which haven't expired.
+func cacheIndexMembers(store storage.Handler, index string) ([]string, error) {
+	members, _, err := store.GetSortedSetRange(index, "("+strconv.FormatInt(time.Now().Unix(), 10), "+inf")
+	return members, err
+}
+
+// purgeCacheIndexes deletes the cache entries listed by the indexes, and the indexes. It returns
+// the keys of the deleted entries.
+func purgeCacheIndexes(store storage.Handler, indexes ...string) ([]string, error) {
+	var keys []string
+	for _, index := range indexes {
+		members, err := cacheIndexMembers(store, index)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, members...)
+	}
+
+	if len(keys) > 0 {
+		store.DeleteKeys(append([]string(nil), keys...))
+	}
+	store.DeleteKeys(append([]string(nil), indexes...))
+	return keys, nil
+}
+
+// purgeCachePaths deletes the cache entries of the requests with the given method, all methods if
+// empty, and a path matching. It returns the keys of the deleted entries.
+func purgeCachePaths(store storage.Handler, method string, match func(string) bool) ([]string, error) {
+	paths, err := cacheIndexMembers(store, cacheIndexPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []string
+	for _, methodPath := range paths {
+		i := strings.IndexByte(methodPath, ' ')
+		if i < 0 || (method != "" && methodPath[:i] != method) || !match(methodPath[i+1:]) {
+			continue
+		}
+		// scored as expired, to be trimmed with the others
+		store.AddToSortedSet(cacheIndexPaths, methodPath, 0)
+		indexes = append(indexes, cachePathIndex(methodPath))
+	}
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	trimCacheIndex(store, cacheIndexPaths, time.Now().Unix())
+	return purgeCacheIndexes(store, indexes...)
+}
+
+func (gw *Gateway) cacheInvalidationStore(apiID string) storage.Handler {
+	return &storage.RedisCluster{KeyPrefix: "cache-" + apiID, IsCache: true, RedisController: gw.RedisController}
+}
+
+// invalidateCachePathsHandler invalidates the cached responses of the requests matching a path,
+// a glob or a regular expression, optionally for a single method.
+func (gw *Gateway) invalidateCachePathsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var match func(string) bool
+	switch {
+	case query.Get("path") != "":
+		exact := query.Get("path")
+		match = func(p string) bool {
+			return p == exact
+		}
+	case query.Get("glob") != "":
+		glob := query.Get("glob")
+		if _, err := path.Match(glob, ""); err != nil {
+			doJSONWrite(w, http.StatusBadRequest, apiError("Invalid glob: "+err.Error()))
+			return
+		}
+		match = func(p string) bool {
+			matched, _ := path.Match(glob, p)
+			return matched
+		}
+	case query.Get("regex") != "":
+		rx, err := regexp.Compile(query.Get("regex"))
+		if err != nil {
+			doJSONWrite(w, http.StatusBadRequest, apiError("Invalid regex: "+err.Error()))
+			return
+		}
+		match = rx.MatchString
+	default:
+		doJSONWrite(w, http.StatusBadRequest, apiError("One of path, glob or regex is required"))
+		return
+	}
+
+	apiID := mux.Vars(r)["apiID"]
+	keys, err := purgeCachePaths(gw.cacheInvalidationStore(apiID), strings.ToUpper(query.Get("method")), match)
+	gw.completeCacheInvalidation(w, r, apiID, keys, err)
+}
+
+// invalidateCacheTagHandler invalidates the cached responses tagged by the upstream with the
+// surrogate key.
+func (gw *Gateway) invalidateCacheTagHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keys, err := purgeCacheIndexes(gw.cacheInvalidationStore(vars["apiID"]), cacheTagIndex(vars["tag"]))
+	gw.completeCacheInvalidation(w, r, vars["apiID"], keys, err)
+}
+
+// invalidateCacheKeyHandler invalidates the cached responses to the requests of a key.
+func (gw *Gateway) invalidateCacheKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyHash := vars["keyName"]
+	if r.URL.Query().Get("hashed") == "" {
+		keyHash = storage.HashKey(keyHash, gw.GetConfig().HashKeys)
+	}
+
+	keys, err := purgeCacheIndexes(gw.cacheInvalidationStore(vars["apiID"]), cacheSessionIndex(keyHash))
+	gw.completeCacheInvalidation(w, r, vars["apiID"], keys, err)
+}
+
+// completeCacheInvalidation invalidates the deleted entries in the local response cache of the
+// nodes, and writes the result of the invalidation.
+func (gw *Gateway) completeCacheInvalidation(w http.ResponseWriter, r *http.Request, apiID string, keys []string, err error) {
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		log.WithFields(logrus.Fields{
+			"prefix":  "api",
+			"api_id":  apiID,
+			"status":  "fail",
+			"err":     err,
+			"user_id": "system",
+			"user_ip": requestIPHops(r),
+			"path":    r.URL.Path,
+		}).Error("Failed to invalidate cache entries: ", err)
+
+		doJSONWrite(w, http.StatusInternalServerError, apiError("Cache invalidation failed"))
+		return
+	}
+
+	if len(keys) > 0 {
+		gw.invalidateLocalResponseCache(apiID, keys...)
+	}
+	doJSONWrite(w, http.StatusOK, apiOk(fmt.Sprintf("%d cache entries invalidated", len(keys))))
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/dnscache"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+func TestCacheInvalidation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(surrogateKeyHeader, r.URL.Query().Get("tag")+" all")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	gw := &Gateway{dnsCacheManager: dnscache.NewDnsCacheManager(config.NoCacheStrategy)}
+	gw.SetConfig(config.Config{})
+	gw.templates = template.Must(template.New("error.json").Parse(`{"error": "{{.Message}}"}`))
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", DoNotTrack: true}}
+	spec.Proxy.ListenPath = "/listen/"
+	spec.Proxy.TargetURL = upstream.URL
+	spec.CacheOptions = apidef.CacheOptions{
+		EnableCache:               true,
+		CacheAllSafeRequests:      true,
+		CacheTimeout:              60,
+		EnableInvalidationIndexes: true,
+	}
+
+	store := newMemCacheStore()
+	logger := logrus.NewEntry(log)
+	target, _ := url.Parse(upstream.URL)
+	proxy := gw.TykNewSingleHostReverseProxy(target, spec, logger)
+	base := BaseMiddleware{Spec: spec, Proxy: proxy, Gw: gw, logger: logger}
+	proxy.ErrorHandler = ErrorHandler{BaseMiddleware: base}
+	mw := &RedisCacheMiddleware{BaseMiddleware: base, store: store}
+	mw.Init()
+	resMW := &ResponseCacheMiddleware{store: store}
+	assert.NoError(t, resMW.Init(nil, spec))
+	spec.ResponseChain = []TykResponseHandler{resMW}
+
+	// cache caches the responses to the requests, by path and token
+	cache := func(requests map[string]string) map[string]string {
+		t.Helper()
+		store.mu.Lock()
+		store.entries, store.sets = map[string]string{}, map[string]map[string]float64{}
+		store.mu.Unlock()
+
+		keys := map[string]string{}
+		for target, token := range requests {
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			if token != "" {
+				setCtxValue(r, ctx.AuthToken, token)
+			}
+			w := httptest.NewRecorder()
+			if _, code := mw.ProcessRequest(w, r, nil); code != mwStatusRespond {
+				proxy.ServeHTTPForCache(w, r)
+			}
+			keys[target] = ctxGetCacheOptions(r).key
+		}
+		// the tag set on all the responses is indexed last
+		assert.Eventually(t, func() bool {
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			return len(store.sets[cacheTagIndex("all")]) == len(requests)
+		}, time.Second, 5*time.Millisecond)
+		return keys
+	}
+	cached := func(keys map[string]string, targets ...string) []bool {
+		found := make([]bool, len(targets))
+		for i, target := range targets {
+			_, err := store.GetKey(keys[target])
+			found[i] = err == nil
+		}
+		return found
+	}
+	requests := map[string]string{
+		"/listen/users/1?tag=user1":        "",
+		"/listen/users/2?tag=user2":        "key1",
+		"/listen/users/2/orders?tag=user2": "key2",
+		"/listen/orders/1":                 "key2",
+	}
+	targets := []string{"/listen/users/1?tag=user1", "/listen/users/2?tag=user2", "/listen/users/2/orders?tag=user2", "/listen/orders/1"}
+
+	t.Run("exact path", func(t *testing.T) {
+		keys := cache(requests)
+		deleted, err := purgeCachePaths(store, "POST", func(p string) bool { return p == "/users/2" })
+		assert.NoError(t, err)
+		assert.Empty(t, deleted, "other methods shouldn't be invalidated")
+
+		deleted, err = purgeCachePaths(store, "GET", func(p string) bool { return p == "/users/2" })
+		assert.NoError(t, err)
+		assert.Equal(t, []string{keys["/listen/users/2?tag=user2"]}, deleted)
+		assert.Equal(t, []bool{true, false, true, true}, cached(keys, targets...))
+	})
+
+	t.Run("glob and regex", func(t *testing.T) {
+		keys := cache(requests)
+		_, err := purgeCachePaths(store, "", func(p string) bool {
+			matched, _ := path.Match("/users/*", p)
+			return matched
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{false, false, true, true}, cached(keys, targets...))
+
+		_, err = purgeCachePaths(store, "", regexp.MustCompile(`^/(users|orders)/\d+/orders$`).MatchString)
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{false, false, false, true}, cached(keys, targets...))
+	})
+
+	t.Run("surrogate keys", func(t *testing.T) {
+		keys := cache(requests)
+		deleted, err := purgeCacheIndexes(store, cacheTagIndex("user2"))
+		assert.NoError(t, err)
+		assert.Len(t, deleted, 2)
+		assert.Equal(t, []bool{true, false, false, true}, cached(keys, targets...))
+
+		_, err = purgeCacheIndexes(store, cacheTagIndex("all"))
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{false, false, false, false}, cached(keys, targets...))
+	})
+
+	t.Run("session", func(t *testing.T) {
+		keys := cache(requests)
+		_, err := purgeCacheIndexes(store, cacheSessionIndex(storage.HashKey("key2", false)))
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{true, true, false, false}, cached(keys, targets...))
+	})
+}
+
+func TestCacheIndexesTrimExpiredEntries(t *testing.T) {
+	store := newMemCacheStore()
+	options := &cacheOptions{indexPath: "GET /users", indexSession: "session"}
+	indexCacheEntries(store, []string{"old"}, options, []string{"tag"}, 60)
+
+	// the old entry expired
+	for _, index := range []string{cachePathIndex("GET /users"), cacheSessionIndex("session"), cacheTagIndex("tag")} {
+		store.sets[index]["old"] = float64(time.Now().Unix() - 1)
+	}
+	store.sets[cacheIndexPaths]["GET /expired"] = float64(time.Now().Unix() - 1)
+
+	indexCacheEntries(store, []string{"new"}, options, []string{"tag"}, 60)
+	for _, index := range []string{cachePathIndex("GET /users"), cacheSessionIndex("session"), cacheTagIndex("tag")} {
+		assert.Len(t, store.sets[index], 1, index)
+		assert.Contains(t, store.sets[index], "new", index)
+	}
+	assert.Len(t, store.sets[cacheIndexPaths], 1, "expired paths should be trimmed")
+
+	store.sets[cacheTagIndex("tag")]["expired"] = float64(time.Now().Unix() - 1)
+	deleted, err := purgeCacheIndexes(store, cacheTagIndex("tag"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new"}, deleted, "expired entries shouldn't be deleted again")
+
+	store = newMemCacheStore()
+	indexCacheEntries(store, []string{"key"}, &cacheOptions{}, []string{"tag"}, 60)
+	assert.Empty(t, store.sets, "entries shouldn't be indexed unless the API enables the indexes")
+}
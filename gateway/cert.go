@@ -188,17 +188,8 @@ func validateCommonName(host string, cert *x509.Certificate) error {
 }
 
 func (gw *Gateway) customDialTLSCheck(spec *APISpec, tc *tls.Config) func(network, addr string) (net.Conn, error) {
-	var checkPinnedKeys, checkCommonName bool
-	gwConfig := gw.GetConfig()
-	if (spec != nil && !spec.CertificatePinningDisabled && len(spec.PinnedPublicKeys) != 0) || len(gwConfig.Security.PinnedPublicKeys) != 0 {
-		checkPinnedKeys = true
-	}
-
-	if (spec != nil && spec.Proxy.Transport.SSLForceCommonNameCheck) || gwConfig.SSLForceCommonNameCheck {
-		checkCommonName = true
-	}
-
-	if !checkCommonName && !checkPinnedKeys {
+	check := gw.tlsConnCheck(spec)
+	if check == nil {
 		return nil
 	}
 
@@ -212,11 +203,36 @@ func (gw *Gateway) customDialTLSCheck(spec *APISpec, tc *tls.Config) func(networ
 		}
 
 		host, _, _ := net.SplitHostPort(addr)
+		if err := check(host, c); err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+}
 
+// tlsConnCheck returns the pinned public keys and common name checks of the upstream TLS
+// connections of the API, or nil if there are none.
+func (gw *Gateway) tlsConnCheck(spec *APISpec) func(host string, c *tls.Conn) error {
+	var checkPinnedKeys, checkCommonName bool
+	gwConfig := gw.GetConfig()
+	if (spec != nil && !spec.CertificatePinningDisabled && len(spec.PinnedPublicKeys) != 0) || len(gwConfig.Security.PinnedPublicKeys) != 0 {
+		checkPinnedKeys = true
+	}
+
+	if (spec != nil && spec.Proxy.Transport.SSLForceCommonNameCheck) || gwConfig.SSLForceCommonNameCheck {
+		checkCommonName = true
+	}
+
+	if !checkCommonName && !checkPinnedKeys {
+		return nil
+	}
+
+	return func(host string, c *tls.Conn) error {
 		if checkPinnedKeys {
 			isValid := gw.validatePublicKeys(host, c, spec)
 			if !isValid {
-				return nil, errors.New("https://" + host + " certificate public key pinning error. Public keys do not match.")
+				return errors.New("https://" + host + " certificate public key pinning error. Public keys do not match.")
 			}
 		}
 
@@ -225,11 +241,11 @@ func (gw *Gateway) customDialTLSCheck(spec *APISpec, tc *tls.Config) func(networ
 			leafCert := state.PeerCertificates[0]
 			err := validateCommonName(host, leafCert)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 
-		return c, nil
+		return nil
 	}
 }
 
@@ -0,0 +1,758 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+const (
+	grpcContentType = "application/grpc"
+	// grpcMaxMessageSize caps the size of a gRPC message read from the upstream.
+	grpcMaxMessageSize = 32 << 20
+)
+
+var errUnknownField = errors.New("unknown field")
+
+// grpcTranscoder routes JSON requests to the gRPC methods of an API.
+type grpcTranscoder struct {
+	routes []*grpcRoute
+	format apidef.GRPCStreamingFormat
+}
+
+// grpcRoute is an HTTP binding of a gRPC method.
+type grpcRoute struct {
+	httpMethod   string
+	template     *pathTemplate
+	body         string
+	responseBody string
+	method       protoreflect.MethodDescriptor
+}
+
+// grpcCall is the gRPC method a transcoded request is sent to.
+type grpcCall struct {
+	route  *grpcRoute
+	format apidef.GRPCStreamingFormat
+}
+
+// loadGRPCTranscoder builds the routes of the annotated methods in the descriptor set of the API.
+func loadGRPCTranscoder(conf apidef.GRPCTranscodingConfig) (*grpcTranscoder, error) {
+	var raw []byte
+	var err error
+	switch {
+	case conf.DescriptorSet != "":
+		raw, err = base64.StdEncoding.DecodeString(conf.DescriptorSet)
+	case conf.DescriptorSetPath != "":
+		raw, err = ioutil.ReadFile(conf.DescriptorSetPath)
+	default:
+		err = errors.New("no descriptor set configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string]bool{}
+	for _, name := range conf.Services {
+		services[name] = true
+	}
+
+	t := &grpcTranscoder{format: conf.StreamingFormat}
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			service := file.Services().Get(i)
+			if len(services) > 0 && !services[string(service.FullName())] {
+				continue
+			}
+			for j := 0; j < service.Methods().Len(); j++ {
+				method := service.Methods().Get(j)
+				rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+				if !ok || rule == nil {
+					continue
+				}
+				if method.IsStreamingClient() {
+					log.Warning("[GRPC TRANSCODING] Client streaming method can't be transcoded: ", method.FullName())
+					continue
+				}
+
+				for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+					route, err := newGRPCRoute(method, binding)
+					if err != nil {
+						log.WithError(err).Error("[GRPC TRANSCODING] Couldn't load HTTP binding of ", method.FullName())
+						continue
+					}
+					t.routes = append(t.routes, route)
+				}
+			}
+		}
+		return true
+	})
+
+	// the more literal segments a route has, the more specific it is, and a verb is as specific as a literal
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		return t.routes[i].template.specificity() > t.routes[j].template.specificity()
+	})
+
+	return t, nil
+}
+
+func newGRPCRoute(method protoreflect.MethodDescriptor, rule *annotations.HttpRule) (*grpcRoute, error) {
+	route := &grpcRoute{body: rule.GetBody(), responseBody: rule.GetResponseBody(), method: method}
+
+	var tmpl string
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		route.httpMethod, tmpl = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		route.httpMethod, tmpl = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		route.httpMethod, tmpl = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		route.httpMethod, tmpl = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		route.httpMethod, tmpl = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		route.httpMethod, tmpl = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	default:
+		return nil, errors.New("no HTTP pattern")
+	}
+
+	template, err := parsePathTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	route.template = template
+
+	if route.body != "" && route.body != "*" && method.Input().Fields().ByName(protoreflect.Name(route.body)) == nil {
+		return nil, fmt.Errorf("%w: body %s", errUnknownField, route.body)
+	}
+	if route.responseBody != "" && method.Output().Fields().ByName(protoreflect.Name(route.responseBody)) == nil {
+		return nil, fmt.Errorf("%w: response body %s", errUnknownField, route.responseBody)
+	}
+	return route, nil
+}
+
+// match returns the call for the request and the values of the path variables, if any route matches.
+func (t *grpcTranscoder) match(method, path string) (*grpcCall, []templateBinding) {
+	for _, route := range t.routes {
+		if route.httpMethod != method {
+			continue
+		}
+		if bindings, ok := route.template.match(path); ok {
+			return &grpcCall{route: route, format: t.format}, bindings
+		}
+	}
+	return nil, nil
+}
+
+// transcodeRequest turns the JSON request into a gRPC request to the method of the call.
+func (c *grpcCall) transcodeRequest(r *http.Request, bindings []templateBinding) error {
+	route := c.route
+	msg := dynamicpb.NewMessage(route.method.Input())
+
+	if route.body != "" && r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if route.body != "*" {
+				field := route.method.Input().Fields().ByName(protoreflect.Name(route.body))
+				body = []byte(`{"` + field.JSONName() + `":` + string(body) + `}`)
+			}
+			if err := protojson.Unmarshal(body, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	bound := map[string]bool{}
+	for _, binding := range bindings {
+		if err := setFieldPath(msg, binding.fieldPath, []string{binding.value}); err != nil {
+			return err
+		}
+		bound[strings.Join(binding.fieldPath, ".")] = true
+	}
+
+	if route.body != "*" {
+		for key, values := range r.URL.Query() {
+			if bound[key] || (route.body != "" && strings.Split(key, ".")[0] == route.body) {
+				continue
+			}
+			// other query parameters, e.g. auth keys, aren't for the upstream
+			if err := setFieldPath(msg, strings.Split(key, "."), values); err != nil && !errors.Is(err, errUnknownField) {
+				return err
+			}
+		}
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+
+	r.Method = http.MethodPost
+	r.URL.Path = "/" + string(route.method.Parent().FullName()) + "/" + string(route.method.Name())
+	r.URL.RawPath = ""
+	r.URL.RawQuery = ""
+	r.Body = ioutil.NopCloser(bytes.NewReader(frame))
+	r.ContentLength = int64(len(frame))
+	r.Header.Del(header.ContentLength)
+	r.Header.Set(header.ContentType, grpcContentType)
+	r.Header.Set("Te", "trailers")
+	return nil
+}
+
+// transcodeResponse turns the gRPC response into a JSON response. Server streaming responses are
+// transcoded message by message as they arrive.
+func (c *grpcCall) transcodeResponse(res *http.Response) *http.Response {
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get(header.ContentType), grpcContentType) {
+		return res
+	}
+
+	out := new(http.Response)
+	*out = *res
+	out.Header = res.Header.Clone()
+	out.Trailer = nil
+	for name := range out.Header {
+		if strings.HasPrefix(strings.ToLower(name), "grpc-") {
+			out.Header.Del(name)
+		}
+	}
+	out.Header.Del("Trailer")
+	out.Header.Del(header.ContentLength)
+
+	if c.route.method.IsStreamingServer() {
+		contentType := header.ApplicationJSON
+		if c.format == apidef.GRPCStreamingSSE {
+			contentType = "text/event-stream"
+		}
+		out.Header.Set(header.ContentType, contentType)
+		out.Body = &grpcJSONStream{call: c, res: res}
+		out.ContentLength = -1
+		return out
+	}
+
+	body, code := c.unaryResponse(res)
+	out.StatusCode = code
+	out.Status = strconv.Itoa(code) + " " + http.StatusText(code)
+	out.Header.Set(header.ContentType, header.ApplicationJSON)
+	out.Header.Set(header.ContentLength, strconv.Itoa(len(body)))
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	return out
+}
+
+func (c *grpcCall) unaryResponse(res *http.Response) ([]byte, int) {
+	defer res.Body.Close()
+
+	payload, err := readGRPCMessage(res.Body, res.Header.Get("Grpc-Encoding"))
+	if err != nil && err != io.EOF {
+		return grpcStatusJSON(codes.Internal, err.Error()), http.StatusBadGateway
+	}
+	// the status is in the trailers, which are read with the end of the body
+	io.Copy(ioutil.Discard, res.Body)
+
+	if code, message := grpcStatus(res); code != codes.OK {
+		return grpcStatusJSON(code, message), grpcHTTPStatus(code)
+	}
+	if payload == nil {
+		return grpcStatusJSON(codes.Internal, "no response message"), http.StatusBadGateway
+	}
+
+	msg := dynamicpb.NewMessage(c.route.method.Output())
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return grpcStatusJSON(codes.Internal, err.Error()), http.StatusBadGateway
+	}
+	body, err := c.route.marshalResponse(msg)
+	if err != nil {
+		return grpcStatusJSON(codes.Internal, err.Error()), http.StatusInternalServerError
+	}
+	return body, http.StatusOK
+}
+
+func (rt *grpcRoute) marshalResponse(msg protoreflect.Message) ([]byte, error) {
+	if rt.responseBody == "" {
+		return protojson.Marshal(msg.Interface())
+	}
+
+	field := msg.Descriptor().Fields().ByName(protoreflect.Name(rt.responseBody))
+	if field.Kind() == protoreflect.MessageKind && !field.IsList() && !field.IsMap() {
+		return protojson.Marshal(msg.Get(field).Message().Interface())
+	}
+
+	full, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(full, &fields); err != nil {
+		return nil, err
+	}
+	return fields[field.JSONName()], nil
+}
+
+// grpcJSONStream transcodes the messages of a server streaming gRPC response as they are read.
+type grpcJSONStream struct {
+	call *grpcCall
+	res  *http.Response
+	buf  bytes.Buffer
+	done bool
+}
+
+func (s *grpcJSONStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 && !s.done {
+		s.next()
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+func (s *grpcJSONStream) Close() error {
+	return s.res.Body.Close()
+}
+
+func (s *grpcJSONStream) next() {
+	payload, err := readGRPCMessage(s.res.Body, s.res.Header.Get("Grpc-Encoding"))
+	switch {
+	case err == io.EOF:
+		s.done = true
+		if code, message := grpcStatus(s.res); code != codes.OK {
+			s.write("error", grpcStatusJSON(code, message))
+		}
+		return
+	case err != nil:
+		s.done = true
+		s.write("error", grpcStatusJSON(codes.Internal, err.Error()))
+		return
+	}
+
+	msg := dynamicpb.NewMessage(s.call.route.method.Output())
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		s.done = true
+		s.write("error", grpcStatusJSON(codes.Internal, err.Error()))
+		return
+	}
+	body, err := s.call.route.marshalResponse(msg)
+	if err != nil {
+		s.done = true
+		s.write("error", grpcStatusJSON(codes.Internal, err.Error()))
+		return
+	}
+	s.write("result", body)
+}
+
+func (s *grpcJSONStream) write(kind string, body []byte) {
+	if s.call.format == apidef.GRPCStreamingSSE {
+		if kind == "error" {
+			s.buf.WriteString("event: error\n")
+		}
+		s.buf.WriteString("data: ")
+		s.buf.Write(body)
+		s.buf.WriteString("\n\n")
+		return
+	}
+
+	s.buf.WriteString(`{"` + kind + `":`)
+	s.buf.Write(body)
+	s.buf.WriteString("}\n")
+}
+
+// readGRPCMessage reads a length prefixed gRPC message. It returns io.EOF at the end of the stream.
+func readGRPCMessage(r io.Reader, encoding string) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > grpcMaxMessageSize {
+		return nil, fmt.Errorf("gRPC message of %d bytes exceeds the maximum size", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if prefix[0]&1 == 0 {
+		return payload, nil
+	}
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("unsupported gRPC message encoding %q", encoding)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(io.LimitReader(gz, grpcMaxMessageSize))
+}
+
+// grpcStatus returns the status of a gRPC response, sent in the trailers or in the headers of a
+// trailers-only response.
+func grpcStatus(res *http.Response) (codes.Code, string) {
+	status, message := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return codes.Unknown, "missing gRPC status"
+	}
+
+	code, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return codes.Unknown, "invalid gRPC status: " + status
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return codes.Code(code), message
+}
+
+// grpcStatusJSON returns the JSON representation of a google.rpc.Status.
+func grpcStatusJSON(code codes.Code, message string) []byte {
+	body, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{int(code), message})
+	return body
+}
+
+// grpcHTTPStatus maps a gRPC status code to an HTTP status code.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// setFieldPath sets the field at the dotted field path of msg from request path or query values.
+func setFieldPath(msg protoreflect.Message, fieldPath []string, values []string) error {
+	for i, name := range fieldPath {
+		field := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = msg.Descriptor().Fields().ByJSONName(name)
+		}
+		if field == nil {
+			return fmt.Errorf("%w: %s", errUnknownField, strings.Join(fieldPath, "."))
+		}
+
+		if i < len(fieldPath)-1 {
+			if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+				return fmt.Errorf("field %s isn't a message", name)
+			}
+			msg = msg.Mutable(field).Message()
+			continue
+		}
+
+		if field.IsMap() {
+			return fmt.Errorf("map field %s can't be set from the request", name)
+		}
+		if field.IsList() {
+			list := msg.Mutable(field).List()
+			for _, value := range values {
+				v, err := parseFieldValue(field, value, list.NewElement)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+
+		v, err := parseFieldValue(field, values[len(values)-1], func() protoreflect.Value {
+			return msg.NewField(field)
+		})
+		if err != nil {
+			return err
+		}
+		msg.Set(field, v)
+	}
+	return nil
+}
+
+func parseFieldValue(field protoreflect.FieldDescriptor, value string, newValue func() protoreflect.Value) (protoreflect.Value, error) {
+	var v interface{}
+	var err error
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		v = value
+	case protoreflect.BoolKind:
+		v, err = strconv.ParseBool(value)
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByName(protoreflect.Name(value)); enumValue != nil {
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 32)
+		v = protoreflect.EnumNumber(n)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 32)
+		v = int32(n)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, 32)
+		v = uint32(n)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err = strconv.ParseUint(value, 10, 64)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(value, 32)
+		v = float32(f)
+	case protoreflect.DoubleKind:
+		v, err = strconv.ParseFloat(value, 64)
+	case protoreflect.BytesKind:
+		v, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(value)
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// well-known types such as timestamps and wrappers have a JSON string representation
+		msgValue := newValue()
+		quoted, _ := json.Marshal(value)
+		if err := protojson.Unmarshal(quoted, msgValue.Message().Interface()); err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid value for field %s: %v", field.Name(), err)
+		}
+		return msgValue, nil
+	}
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("invalid value for field %s: %v", field.Name(), err)
+	}
+	return protoreflect.ValueOf(v), nil
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentWildcard
+	segmentDeepWildcard
+)
+
+// pathTemplate is a google.api.http path template, e.g. /v1/{name=shelves/*}/books:list.
+type pathTemplate struct {
+	segments  []templateSegment
+	variables []templateVariable
+	verb      string
+	literals  int
+}
+
+type templateSegment struct {
+	kind    segmentKind
+	literal string
+}
+
+// templateVariable binds the segments from start to end to a field. end is -1 when the
+// variable ends with a deep wildcard.
+type templateVariable struct {
+	fieldPath  []string
+	start, end int
+}
+
+type templateBinding struct {
+	fieldPath []string
+	value     string
+}
+
+func parsePathTemplate(tmpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("path template %q doesn't start with /", tmpl)
+	}
+
+	t := &pathTemplate{}
+	p := tmpl[1:]
+	if i := strings.LastIndex(p, ":"); i > strings.LastIndex(p, "/") && i > strings.LastIndex(p, "}") {
+		p, t.verb = p[:i], p[i+1:]
+	}
+
+	for len(p) > 0 {
+		if p[0] == '{' {
+			end := strings.IndexByte(p, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable in path template %q", tmpl)
+			}
+			fieldPath, pattern := p[1:end], "*"
+			if i := strings.IndexByte(fieldPath, '='); i >= 0 {
+				fieldPath, pattern = fieldPath[:i], fieldPath[i+1:]
+			}
+			p = p[end+1:]
+
+			variable := templateVariable{fieldPath: strings.Split(fieldPath, "."), start: len(t.segments)}
+			for _, segment := range strings.Split(pattern, "/") {
+				if err := t.addSegment(segment); err != nil {
+					return nil, err
+				}
+			}
+			variable.end = len(t.segments)
+			if t.segments[variable.end-1].kind == segmentDeepWildcard {
+				variable.end = -1
+			}
+			t.variables = append(t.variables, variable)
+		} else {
+			end := strings.IndexByte(p, '/')
+			if end < 0 {
+				end = len(p)
+			}
+			if err := t.addSegment(p[:end]); err != nil {
+				return nil, err
+			}
+			p = p[end:]
+		}
+
+		if len(p) > 0 {
+			if p[0] != '/' {
+				return nil, fmt.Errorf("unexpected %q in path template %q", p[0], tmpl)
+			}
+			p = p[1:]
+		}
+	}
+
+	for i, segment := range t.segments {
+		if segment.kind == segmentDeepWildcard && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("** must be the last segment of path template %q", tmpl)
+		}
+	}
+	return t, nil
+}
+
+func (t *pathTemplate) specificity() int {
+	if t.verb != "" {
+		return t.literals + 1
+	}
+	return t.literals
+}
+
+func (t *pathTemplate) addSegment(segment string) error {
+	switch segment {
+	case "":
+		return errors.New("empty path template segment")
+	case "*":
+		t.segments = append(t.segments, templateSegment{kind: segmentWildcard})
+	case "**":
+		t.segments = append(t.segments, templateSegment{kind: segmentDeepWildcard})
+	default:
+		t.segments = append(t.segments, templateSegment{kind: segmentLiteral, literal: segment})
+		t.literals++
+	}
+	return nil
+}
+
+// match matches the escaped request path against the template, returning the values of the variables.
+func (t *pathTemplate) match(path string) ([]templateBinding, bool) {
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	var parts []string
+	if trimmed := strings.TrimPrefix(path, "/"); trimmed != "" {
+		parts = strings.Split(trimmed, "/")
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = unescaped
+	}
+
+	n := len(t.segments)
+	if n > 0 && t.segments[n-1].kind == segmentDeepWildcard {
+		if len(parts) < n-1 {
+			return nil, false
+		}
+	} else if len(parts) != n {
+		return nil, false
+	}
+
+	for i, segment := range t.segments {
+		switch segment.kind {
+		case segmentLiteral:
+			if parts[i] != segment.literal {
+				return nil, false
+			}
+		case segmentWildcard:
+			if parts[i] == "" {
+				return nil, false
+			}
+		}
+	}
+
+	bindings := make([]templateBinding, 0, len(t.variables))
+	for _, variable := range t.variables {
+		end := variable.end
+		if end == -1 {
+			end = len(parts)
+		}
+		bindings = append(bindings, templateBinding{
+			fieldPath: variable.fieldPath,
+			value:     strings.Join(parts[variable.start:end], "/"),
+		})
+	}
+	return bindings, true
+}
@@ -0,0 +1,276 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+func testGreeterDescriptorSet(t *testing.T) string {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	method := func(name string, serverStreaming bool, rule *annotations.HttpRule) *descriptorpb.MethodDescriptorProto {
+		opts := &descriptorpb.MethodOptions{}
+		proto.SetExtension(opts, annotations.E_Http, rule)
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".greeter.v1.HelloRequest"),
+			OutputType:      proto.String(".greeter.v1.HelloReply"),
+			ServerStreaming: proto.Bool(serverStreaming),
+			Options:         opts,
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("greeter.proto"),
+		Package: proto.String("greeter.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("tag", 1, str, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("HelloRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, optional, ""),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					field("inner", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".greeter.v1.Inner"),
+					field("tags", 4, str, repeated, ""),
+				},
+			},
+			{
+				Name: proto.String("HelloReply"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("message", 1, str, optional, ""),
+					field("inner", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".greeter.v1.Inner"),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Greeter"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					method("SayHello", false, &annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Get{Get: "/v1/hello/{name}"},
+						AdditionalBindings: []*annotations.HttpRule{{
+							Pattern: &annotations.HttpRule_Post{Post: "/v1/hello"},
+							Body:    "*",
+						}},
+					}),
+					method("GetInner", false, &annotations.HttpRule{
+						Pattern:      &annotations.HttpRule_Put{Put: "/v1/{name=shelves/*}/inner"},
+						Body:         "inner",
+						ResponseBody: "inner",
+					}),
+					method("StreamHello", true, &annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Get{Get: "/v1/hello/{name}:stream"},
+					}),
+				},
+			},
+		},
+	}
+
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func grpcFrame(t *testing.T, msg proto.Message) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return frame
+}
+
+func TestParsePathTemplate(t *testing.T) {
+	tmpl, err := parsePathTemplate("/v1/{name=shelves/*/books/**}:list")
+	require.NoError(t, err)
+	assert.Equal(t, "list", tmpl.verb)
+	assert.Equal(t, 3, tmpl.literals)
+
+	bindings, ok := tmpl.match("/v1/shelves/1/books/a/b%2Fc:list")
+	assert.True(t, ok)
+	assert.Equal(t, []templateBinding{{fieldPath: []string{"name"}, value: "shelves/1/books/a/b/c"}}, bindings)
+
+	_, ok = tmpl.match("/v1/shelves/1/books/a")
+	assert.False(t, ok, "verb should be required")
+
+	_, err = parsePathTemplate("/v1/**/books")
+	assert.Error(t, err)
+	_, err = parsePathTemplate("v1/books")
+	assert.Error(t, err)
+}
+
+func TestGRPCTranscoding(t *testing.T) {
+	transcoder, err := loadGRPCTranscoder(apidef.GRPCTranscodingConfig{Enabled: true, DescriptorSet: testGreeterDescriptorSet(t)})
+	require.NoError(t, err)
+	require.Len(t, transcoder.routes, 4)
+
+	sayHello := transcoder.routes[0].method
+	for _, route := range transcoder.routes {
+		if route.method.Name() == "SayHello" {
+			sayHello = route.method
+		}
+	}
+
+	decodeRequest := func(t *testing.T, r *http.Request) *dynamicpb.Message {
+		t.Helper()
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		payload, err := readGRPCMessage(bytes.NewReader(body), "")
+		require.NoError(t, err)
+		msg := dynamicpb.NewMessage(sayHello.Input())
+		require.NoError(t, proto.Unmarshal(payload, msg))
+		return msg
+	}
+
+	t.Run("path variables and query parameters", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/hello/world?count=3&inner.tag=x&tags=a&tags=b&authorization=key", nil)
+		call, bindings := transcoder.match(r.Method, r.URL.EscapedPath())
+		require.NotNil(t, call)
+		require.NoError(t, call.transcodeRequest(r, bindings))
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/greeter.v1.Greeter/SayHello", r.URL.Path)
+		assert.Equal(t, grpcContentType, r.Header.Get(header.ContentType))
+
+		body, err := protojson.Marshal(decodeRequest(t, r))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"world","count":3,"inner":{"tag":"x"},"tags":["a","b"]}`, string(body))
+	})
+
+	t.Run("body", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/hello", strings.NewReader(`{"name":"world","count":1}`))
+		call, bindings := transcoder.match(r.Method, r.URL.EscapedPath())
+		require.NotNil(t, call)
+		require.NoError(t, call.transcodeRequest(r, bindings))
+
+		body, err := protojson.Marshal(decodeRequest(t, r))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"world","count":1}`, string(body))
+
+		r = httptest.NewRequest(http.MethodPost, "/v1/hello", strings.NewReader(`{"count":"x"}`))
+		assert.Error(t, call.transcodeRequest(r, nil))
+	})
+
+	t.Run("body field", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/v1/shelves/1/inner", strings.NewReader(`{"tag":"x"}`))
+		call, bindings := transcoder.match(r.Method, r.URL.EscapedPath())
+		require.NotNil(t, call)
+		require.NoError(t, call.transcodeRequest(r, bindings))
+
+		body, err := protojson.Marshal(decodeRequest(t, r))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"shelves/1","inner":{"tag":"x"}}`, string(body))
+	})
+
+	t.Run("unary response", func(t *testing.T) {
+		call, _ := transcoder.match(http.MethodGet, "/v1/hello/world")
+		reply := dynamicpb.NewMessage(sayHello.Output())
+		require.NoError(t, protojson.Unmarshal([]byte(`{"message":"hello world"}`), reply))
+
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{header.ContentType: {grpcContentType}},
+			Body:       ioutil.NopCloser(bytes.NewReader(grpcFrame(t, reply))),
+			Trailer:    http.Header{"Grpc-Status": {"0"}},
+		}
+		out := call.transcodeResponse(res)
+		body, _ := ioutil.ReadAll(out.Body)
+		assert.Equal(t, http.StatusOK, out.StatusCode)
+		assert.Equal(t, header.ApplicationJSON, out.Header.Get(header.ContentType))
+		assert.JSONEq(t, `{"message":"hello world"}`, string(body))
+		assert.Nil(t, out.Trailer)
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		call, _ := transcoder.match(http.MethodGet, "/v1/hello/world")
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{header.ContentType: {grpcContentType}, "Grpc-Status": {"5"}, "Grpc-Message": {"no%20such%20name"}},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}
+		out := call.transcodeResponse(res)
+		body, _ := ioutil.ReadAll(out.Body)
+		assert.Equal(t, http.StatusNotFound, out.StatusCode)
+		assert.JSONEq(t, `{"code":5,"message":"no such name"}`, string(body))
+		assert.Empty(t, out.Header.Get("Grpc-Status"))
+	})
+
+	t.Run("server streaming response", func(t *testing.T) {
+		var frames []byte
+		for _, message := range []string{"a", "b"} {
+			reply := dynamicpb.NewMessage(sayHello.Output())
+			require.NoError(t, protojson.Unmarshal([]byte(`{"message":"`+message+`"}`), reply))
+			frames = append(frames, grpcFrame(t, reply)...)
+		}
+
+		stream := func(format apidef.GRPCStreamingFormat) (*http.Response, string) {
+			transcoder.format = format
+			call, _ := transcoder.match(http.MethodGet, "/v1/hello/world:stream")
+			require.NotNil(t, call)
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{header.ContentType: {grpcContentType}},
+				Body:       ioutil.NopCloser(bytes.NewReader(frames)),
+				Trailer:    http.Header{"Grpc-Status": {"14"}, "Grpc-Message": {"gone"}},
+			}
+			out := call.transcodeResponse(res)
+			body, _ := ioutil.ReadAll(out.Body)
+			return out, strings.ReplaceAll(string(body), " ", "")
+		}
+
+		out, body := stream("")
+		assert.EqualValues(t, -1, out.ContentLength)
+		assert.Equal(t, header.ApplicationJSON, out.Header.Get(header.ContentType))
+		assert.Equal(t, `{"result":{"message":"a"}}`+"\n"+`{"result":{"message":"b"}}`+"\n"+`{"error":{"code":14,"message":"gone"}}`+"\n", body)
+
+		out, body = stream(apidef.GRPCStreamingSSE)
+		assert.Equal(t, "text/event-stream", out.Header.Get(header.ContentType))
+		assert.Equal(t, `data:{"message":"a"}`+"\n\n"+`data:{"message":"b"}`+"\n\n"+"event:error\n"+`data:{"code":14,"message":"gone"}`+"\n\n", body)
+	})
+
+	t.Run("non gRPC responses pass through", func(t *testing.T) {
+		call, _ := transcoder.match(http.MethodGet, "/v1/hello/world")
+		res := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}
+		assert.Equal(t, res, call.transcodeResponse(res))
+	})
+}
@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/TykTechnologies/tyk/header"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame holding the trailers at the end of a gRPC-Web response.
+	grpcWebTrailerFlag = 0x80
+	// grpcWebChunkSize is the size of the upstream response chunks translated at once.
+	grpcWebChunkSize = 32 << 10
+)
+
+var (
+	// grpcWebAllowedHeaders are the request headers gRPC-Web clients send.
+	grpcWebAllowedHeaders = []string{"Accept", "Content-Type", "X-Requested-With", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+	// grpcWebExposedHeaders are the response headers of trailers-only responses gRPC-Web clients read.
+	grpcWebExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// grpcWebCORSHeaders adds the headers gRPC-Web clients need to the allowed and exposed CORS headers.
+func grpcWebCORSHeaders(allowed, exposed []string) ([]string, []string) {
+	if !containsWildcard(allowed) {
+		allowed = appendMissingHeaders(allowed, grpcWebAllowedHeaders)
+	}
+	return allowed, appendMissingHeaders(exposed, grpcWebExposedHeaders)
+}
+
+func containsWildcard(headers []string) bool {
+	for _, h := range headers {
+		if h == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func appendMissingHeaders(headers, add []string) []string {
+	out := append([]string{}, headers...)
+	for _, h := range add {
+		found := false
+		for _, existing := range headers {
+			if strings.EqualFold(existing, h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// grpcWebCall records how a gRPC-Web request was translated, to translate its response back.
+type grpcWebCall struct {
+	// text is set for base64 encoded application/grpc-web-text requests
+	text bool
+	// contentType is the content type of the gRPC-Web response
+	contentType string
+}
+
+// newGRPCWebCall returns the gRPC-Web call of the request, or nil if it isn't a gRPC-Web request.
+func newGRPCWebCall(r *http.Request) *grpcWebCall {
+	contentType := r.Header.Get(header.ContentType)
+	switch {
+	case strings.HasPrefix(contentType, grpcWebTextContentType):
+		return &grpcWebCall{text: true, contentType: contentType}
+	case strings.HasPrefix(contentType, grpcWebContentType):
+		return &grpcWebCall{contentType: contentType}
+	}
+	return nil
+}
+
+// translateRequest turns the gRPC-Web request into a native gRPC request. The message framing of
+// both is the same, so only text requests need their body decoded.
+func (c *grpcWebCall) translateRequest(r *http.Request) error {
+	if c.text && r.Body != nil {
+		encoded, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		body, err := decodeGRPCWebText(encoded)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del(header.ContentLength)
+	}
+
+	// keep the message codec suffix, e.g. +proto
+	prefix := grpcWebContentType
+	if c.text {
+		prefix = grpcWebTextContentType
+	}
+	r.Header.Set(header.ContentType, grpcContentType+strings.TrimPrefix(c.contentType, prefix))
+	r.Header.Del("X-Grpc-Web")
+	r.Header.Set("Te", "trailers")
+	return nil
+}
+
+// decodeGRPCWebText decodes a base64 gRPC-Web body. Clients may send several base64 encoded chunks
+// one after the other, each with its own padding, so the body is decoded 4 characters at a time.
+func decodeGRPCWebText(encoded []byte) ([]byte, error) {
+	encoded = bytes.Join(bytes.Fields(encoded), nil)
+	if len(encoded)%4 != 0 {
+		return nil, errors.New("invalid gRPC-Web text body length")
+	}
+
+	decoded := make([]byte, 0, base64.StdEncoding.DecodedLen(len(encoded)))
+	var quad [3]byte
+	for i := 0; i < len(encoded); i += 4 {
+		n, err := base64.StdEncoding.Decode(quad[:], encoded[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, quad[:n]...)
+	}
+	return decoded, nil
+}
+
+// translateResponse turns the native gRPC response into a gRPC-Web response, with the trailers
+// sent as the last frame of the body.
+func (c *grpcWebCall) translateResponse(res *http.Response) *http.Response {
+	if !strings.HasPrefix(res.Header.Get(header.ContentType), grpcContentType) {
+		return res
+	}
+
+	out := new(http.Response)
+	*out = *res
+	out.Header = res.Header.Clone()
+	out.Trailer = nil
+	out.Header.Del("Trailer")
+	out.Header.Del(header.ContentLength)
+	out.Header.Set(header.ContentType, c.contentType)
+	out.Body = &grpcWebStream{res: res, text: c.text}
+	out.ContentLength = -1
+	return out
+}
+
+// grpcWebStream passes the messages of a gRPC response through as they are read, followed by its
+// trailers. Text responses are base64 encoded chunk by chunk.
+type grpcWebStream struct {
+	res  *http.Response
+	text bool
+	buf  bytes.Buffer
+	done bool
+}
+
+func (s *grpcWebStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 && !s.done {
+		s.next()
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+func (s *grpcWebStream) Close() error {
+	return s.res.Body.Close()
+}
+
+func (s *grpcWebStream) next() {
+	chunk := make([]byte, grpcWebChunkSize)
+	n, err := s.res.Body.Read(chunk)
+	if n > 0 {
+		s.write(chunk[:n])
+	}
+
+	switch {
+	case err == io.EOF:
+		s.done = true
+		// the trailers are read with the end of the body
+		if trailer := s.res.Trailer; len(trailer) > 0 {
+			s.write(grpcWebTrailerFrame(trailer))
+		}
+	case err != nil:
+		s.done = true
+		s.write(grpcWebTrailerFrame(http.Header{
+			"Grpc-Status":  {strconv.Itoa(int(codes.Unavailable))},
+			"Grpc-Message": {err.Error()},
+		}))
+	}
+}
+
+func (s *grpcWebStream) write(data []byte) {
+	if s.text {
+		s.buf.WriteString(base64.StdEncoding.EncodeToString(data))
+		return
+	}
+	s.buf.Write(data)
+}
+
+// grpcWebTrailerFrame encodes trailers as a gRPC-Web trailer frame, an HTTP/1 style header block.
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var block bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailer[name] {
+			block.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:5], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+func grpcWebFrame(payload string) []byte {
+	return append([]byte{0, 0, 0, 0, byte(len(payload))}, payload...)
+}
+
+func TestGRPCWebCORSHeaders(t *testing.T) {
+	allowed, exposed := grpcWebCORSHeaders([]string{"Authorization", "content-type"}, nil)
+	assert.Equal(t, []string{"Authorization", "content-type", "Accept", "X-Requested-With", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}, allowed)
+	assert.Equal(t, grpcWebExposedHeaders, exposed)
+
+	allowed, _ = grpcWebCORSHeaders([]string{"*"}, nil)
+	assert.Equal(t, []string{"*"}, allowed)
+}
+
+func TestGRPCWeb(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.Proxy.GRPCWeb.Enabled = true
+	mw := &GRPCWebMiddleware{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	upstreamResponse := func(body []byte, trailer http.Header) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{header.ContentType: {"application/grpc+proto"}, "Trailer": {"Grpc-Status"}},
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+			Trailer:    trailer,
+		}
+	}
+	trailer := http.Header{"Grpc-Status": {"0"}, "Grpc-Message": {""}}
+	trailerFrame := append([]byte{0x80, 0, 0, 0, 32}, "grpc-message: \r\ngrpc-status: 0\r\n"...)
+
+	t.Run("binary", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/greeter.v1.Greeter/SayHello", bytes.NewReader(grpcWebFrame("hello")))
+		r.Header.Set(header.ContentType, "application/grpc-web+proto")
+		r.Header.Set("X-Grpc-Web", "1")
+
+		_, code := mw.ProcessRequest(nil, r, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "application/grpc+proto", r.Header.Get(header.ContentType))
+		assert.Equal(t, "trailers", r.Header.Get("Te"))
+		assert.Empty(t, r.Header.Get("X-Grpc-Web"))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, grpcWebFrame("hello"), body)
+
+		call := ctxGetGRPCWebCall(r)
+		if !assert.NotNil(t, call) {
+			return
+		}
+		res := call.translateResponse(upstreamResponse(grpcWebFrame("world"), trailer))
+		assert.Equal(t, "application/grpc-web+proto", res.Header.Get(header.ContentType))
+		assert.Empty(t, res.Header.Get("Trailer"))
+		assert.Nil(t, res.Trailer)
+		body, _ = ioutil.ReadAll(res.Body)
+		assert.Equal(t, append(grpcWebFrame("world"), trailerFrame...), body)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		// clients may send separately padded chunks
+		encoded := base64.StdEncoding.EncodeToString(grpcWebFrame("hi")) + base64.StdEncoding.EncodeToString(grpcWebFrame("there"))
+		r := httptest.NewRequest(http.MethodPost, "/greeter.v1.Greeter/SayHello", bytes.NewReader([]byte(encoded)))
+		r.Header.Set(header.ContentType, "application/grpc-web-text")
+
+		_, code := mw.ProcessRequest(nil, r, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "application/grpc", r.Header.Get(header.ContentType))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, append(grpcWebFrame("hi"), grpcWebFrame("there")...), body)
+		assert.Equal(t, int64(len(body)), r.ContentLength)
+
+		res := ctxGetGRPCWebCall(r).translateResponse(upstreamResponse(grpcWebFrame("world"), trailer))
+		assert.Equal(t, "application/grpc-web-text", res.Header.Get(header.ContentType))
+		body, _ = ioutil.ReadAll(res.Body)
+		expected := base64.StdEncoding.EncodeToString(grpcWebFrame("world")) + base64.StdEncoding.EncodeToString(trailerFrame)
+		assert.Equal(t, expected, string(body))
+		decoded, err := decodeGRPCWebText(body)
+		assert.NoError(t, err)
+		assert.Equal(t, append(grpcWebFrame("world"), trailerFrame...), decoded)
+	})
+
+	t.Run("invalid text body", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/greeter.v1.Greeter/SayHello", bytes.NewReader([]byte("abc")))
+		r.Header.Set(header.ContentType, "application/grpc-web-text")
+
+		err, code := mw.ProcessRequest(nil, r, nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("other requests pass through", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/greeter.v1.Greeter/SayHello", bytes.NewReader(grpcWebFrame("hello")))
+		r.Header.Set(header.ContentType, "application/grpc")
+
+		_, code := mw.ProcessRequest(nil, r, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Nil(t, ctxGetGRPCWebCall(r))
+		assert.Equal(t, "application/grpc", r.Header.Get(header.ContentType))
+	})
+
+	t.Run("non gRPC responses pass through", func(t *testing.T) {
+		call := &grpcWebCall{contentType: grpcWebContentType}
+		res := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{header.ContentType: {"text/plain"}}}
+		assert.Equal(t, res, call.translateResponse(res))
+	})
+}
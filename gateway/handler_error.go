@@ -126,6 +126,11 @@ type TemplateExecutor interface {
 // HandleError is the actual error handler and will store the error details in analytics if analytics processing is enabled.
 func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMsg string, errCode int, writeResponse bool) {
 	defer e.Base().UpdateRequestSession(r)
+	ctxReleaseConcurrencySlot(r, 0, errCode == http.StatusBadGateway || errCode == http.StatusGatewayTimeout)
+	if options := ctxGetCacheOptions(r); options != nil {
+		// release the requests coalesced on this one, they can't share its response
+		options.completeFlight(nil, r)
+	}
 	response := &http.Response{}
 
 	if writeResponse {
@@ -254,6 +259,8 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 			tags = append(tags, e.Spec.Tags...)
 		}
 
+		tags = append(tags, ctxGetAnalyticsTags(r)...)
+
 		rawRequest := ""
 		rawResponse := ""
 
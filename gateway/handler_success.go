@@ -157,6 +157,8 @@ func (s *SuccessHandler) RecordHit(r *http.Request, timing analytics.Latency, co
 			tags = append(tags, s.Spec.Tags...)
 		}
 
+		tags = append(tags, ctxGetAnalyticsTags(r)...)
+
 		rawRequest := ""
 		rawResponse := ""
 
@@ -325,6 +327,7 @@ func (s *SuccessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) *http
 
 	t1 := time.Now()
 	resp := s.Proxy.ServeHTTP(w, r)
+	ctxReleaseConcurrencySlot(r, resp.UpstreamLatency, false)
 
 	millisec := DurationToMillisecond(time.Since(t1))
 	log.Debug("Upstream request took (ms): ", millisec)
@@ -350,6 +353,7 @@ func (s *SuccessHandler) ServeHTTPWithCache(w http.ResponseWriter, r *http.Reque
 
 	t1 := time.Now()
 	inRes := s.Proxy.ServeHTTPForCache(w, r)
+	ctxReleaseConcurrencySlot(r, inRes.UpstreamLatency, false)
 	millisec := DurationToMillisecond(time.Since(t1))
 
 	addVersionHeader(w, r, s.Spec.GlobalConfig)
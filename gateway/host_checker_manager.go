@@ -586,6 +586,7 @@ func (gw *Gateway) SetCheckerHostList() {
 		}
 	}
 	gw.apisMu.RUnlock()
+	hostList = append(hostList, gw.watchedDiscovery.TrackingHosts()...)
 
 	gw.GlobalHostChecker.UpdateTrackingList(hostList)
 }
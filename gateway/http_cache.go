@@ -0,0 +1,316 @@
+package gateway
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpCacheVaryPrefix marks the cache entries listing the request headers the responses to a
+// request vary on. The responses are cached under a key per value of these headers.
+const httpCacheVaryPrefix = "vary:"
+
+// heuristicallyCacheable are the status codes of the responses that can be cached without
+// explicit freshness, see RFC 9110 section 15.1.
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// cacheControl holds the directives of the Cache-Control headers of a request or a response.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = strings.TrimSpace(directive[:i]), strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(name)] = value
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the value of a delta-seconds directive, such as max-age.
+func (cc cacheControl) seconds(directive string) (int64, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		// invalid values are treated as stale, see RFC 9111 section 1.2.2
+		return 0, true
+	}
+	return seconds, true
+}
+
+// httpCacheStorable reports whether a response can be stored by a shared cache, see RFC 9111
+// section 3. Responses are always cached per authentication token, so responses to
+// authenticated requests are stored too.
+func httpCacheStorable(res *http.Response, cc cacheControl, cacheOnlyResponseCodes []int) bool {
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	// partial responses aren't combined
+	if res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if _, ok := httpCacheVary(res.Header); !ok {
+		return false
+	}
+
+	if len(cacheOnlyResponseCodes) > 0 {
+		for _, code := range cacheOnlyResponseCodes {
+			if code == res.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	if cc.has("public") || cc.has("max-age") || cc.has("s-maxage") || res.Header.Get("Expires") != "" {
+		return true
+	}
+	return heuristicallyCacheable[res.StatusCode]
+}
+
+// httpCacheLifetime returns the freshness lifetime of a response in seconds, see RFC 9111
+// section 4.2.1. defaultTTL is used for the responses without explicit freshness.
+func httpCacheLifetime(h http.Header, cc cacheControl, defaultTTL int64) int64 {
+	if cc.has("no-cache") {
+		return 0
+	}
+	if seconds, ok := cc.seconds("s-maxage"); ok {
+		return seconds
+	}
+	if seconds, ok := cc.seconds("max-age"); ok {
+		return seconds
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			return 0
+		}
+		if lifetime := int64(expiresAt.Sub(date) / time.Second); lifetime > 0 {
+			return lifetime
+		}
+		return 0
+	}
+	return defaultTTL
+}
+
+// httpCacheInitialAge returns the age of a response when it is received, see RFC 9111
+// section 4.2.3.
+func httpCacheInitialAge(h http.Header, now time.Time) int64 {
+	var age int64
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		if apparentAge := int64(now.Sub(date) / time.Second); apparentAge > 0 {
+			age = apparentAge
+		}
+	}
+	if ageValue, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && ageValue > age {
+		age = ageValue
+	}
+	return age
+}
+
+// hasValidators reports whether a response can be revalidated with a conditional request.
+func hasValidators(h http.Header) bool {
+	return h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// httpCacheAcceptsAge reports whether the client accepts a fresh cached response of the given age.
+func httpCacheAcceptsAge(r *http.Request, age int64) bool {
+	if _, ok := r.Header["Cache-Control"]; !ok {
+		return !strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+	}
+
+	cc := parseCacheControl(r.Header)
+	if cc.has("no-cache") {
+		return false
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	return true
+}
+
+// httpCacheVary returns the request headers the response varies on, and false if the response
+// varies on more than request headers.
+func httpCacheVary(h http.Header) ([]string, bool) {
+	seen := map[string]bool{}
+	var names []string
+	for _, line := range h.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name == "" {
+				continue
+			}
+			name = textproto.CanonicalMIMEHeaderKey(name)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// httpCacheVariantKey returns the cache key of the response to r, for responses varying on the
+// given request headers.
+func httpCacheVariantKey(key string, vary []string, r *http.Request) string {
+	h := md5.New()
+	for _, name := range vary {
+		io.WriteString(h, name+":"+strings.Join(r.Header.Values(name), ",")+"\n")
+	}
+	return key + "-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// notModified evaluates the conditional headers of the request against a cached response, see
+// RFC 9111 section 4.3.2.
+func notModified(r *http.Request, res *http.Response) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, res.Header.Get("ETag"))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		if lastModified, err = http.ParseTime(res.Header.Get("Date")); err != nil {
+			return false
+		}
+	}
+	return !lastModified.After(since)
+}
+
+// etagMatches compares the entity tags of an If-None-Match header with an entity tag, using
+// the weak comparison.
+func etagMatches(condition, etag string) bool {
+	if strings.TrimSpace(condition) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	for _, candidate := range strings.Split(condition, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalHeaders are the headers of the conditional requests answered from the cache.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// revalidate replaces the conditional headers of the client request with the validators of
+// the stale response, so the upstream can answer with a 304 if it is still valid.
+func (o *cacheOptions) revalidate(r *http.Request, stale *http.Response) {
+	o.stale = stale
+	o.conditions = http.Header{}
+	for _, name := range conditionalHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			o.conditions[name] = values
+		}
+		r.Header.Del(name)
+	}
+
+	if etag := stale.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := stale.Header.Get("Last-Modified"); lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// restoreConditions restores the conditional headers of the client request after revalidation.
+func (o *cacheOptions) restoreConditions(r *http.Request) {
+	for _, name := range conditionalHeaders {
+		r.Header.Del(name)
+		if values := o.conditions[name]; len(values) > 0 {
+			r.Header[name] = values
+		}
+	}
+}
+
+// freshenResponse replaces the 304 response validating a stale response with the stale
+// response, updated with the headers of the 304 response, see RFC 9111 section 4.3.4.
+func freshenResponse(res *http.Response, stale *http.Response) {
+	header := stale.Header.Clone()
+	// the age of the stale response is the one it was served with
+	header.Del("Age")
+	for name, values := range res.Header {
+		if name == "Content-Length" {
+			continue
+		}
+		header[name] = values
+	}
+
+	stale.Header = header
+	replaceResponse(res, stale)
+}
+
+// replaceResponse replaces an upstream response with a cached response.
+func replaceResponse(res *http.Response, cached *http.Response) {
+	res.Body.Close()
+	res.Status = cached.Status
+	res.StatusCode = cached.StatusCode
+	res.Header = cached.Header
+	res.Body = cached.Body
+	res.ContentLength = cached.ContentLength
+	res.TransferEncoding = cached.TransferEncoding
+}
+
+// setNotModified turns a response into a 304 response to a conditional request.
+func setNotModified(res *http.Response) {
+	res.Body.Close()
+	res.Status = strconv.Itoa(http.StatusNotModified) + " " + http.StatusText(http.StatusNotModified)
+	res.StatusCode = http.StatusNotModified
+	res.Body = http.NoBody
+	res.ContentLength = 0
+	res.TransferEncoding = nil
+}
@@ -0,0 +1,405 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+// memCacheStore is an in-memory cache store.
+type memCacheStore struct {
+	storage.Handler
+
+	mu      sync.Mutex
+	entries map[string]string
+	ttls    map[string]int64
+	sets    map[string]map[string]float64
+}
+
+func newMemCacheStore() *memCacheStore {
+	return &memCacheStore{entries: map[string]string{}, ttls: map[string]int64{}, sets: map[string]map[string]float64{}}
+}
+
+func (s *memCacheStore) GetKey(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.entries[key]
+	if !ok {
+		return "", storage.ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (s *memCacheStore) SetKey(key, value string, ttl int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key], s.ttls[key] = value, ttl
+	return nil
+}
+
+func (s *memCacheStore) DeleteKey(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[key]
+	delete(s.entries, key)
+	return ok
+}
+
+func (s *memCacheStore) DeleteKeys(keys []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.entries, key)
+		delete(s.sets, key)
+	}
+	return true
+}
+
+func (s *memCacheStore) AddToSortedSet(key, value string, score float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sets[key] == nil {
+		s.sets[key] = map[string]float64{}
+	}
+	s.sets[key][value] = score
+}
+
+// inScoreRange reports whether score is within the range, of exclusive bounds if prefixed by "(".
+func inScoreRange(score float64, from, to string) bool {
+	bound := func(value string) (float64, bool) {
+		exclusive := strings.HasPrefix(value, "(")
+		parsed, _ := strconv.ParseFloat(strings.TrimPrefix(value, "("), 64)
+		return parsed, exclusive
+	}
+	min, minExclusive := bound(from)
+	max, maxExclusive := bound(to)
+	return (score > min || !minExclusive && score == min) && (score < max || !maxExclusive && score == max)
+}
+
+func (s *memCacheStore) GetSortedSetRange(key, from, to string) ([]string, []float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []string
+	var scores []float64
+	for value, score := range s.sets[key] {
+		if inScoreRange(score, from, to) {
+			members, scores = append(members, value), append(scores, score)
+		}
+	}
+	return members, scores, nil
+}
+
+func (s *memCacheStore) RemoveSortedSetRange(key, from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for value, score := range s.sets[key] {
+		if inScoreRange(score, from, to) {
+			delete(s.sets[key], value)
+		}
+	}
+	return nil
+}
+
+func (s *memCacheStore) AddToSortedSetsWithExpiry(sets []storage.SortedSetMembers, score, trimUntil float64, ttl int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, set := range sets {
+		if s.sets[set.Key] == nil {
+			s.sets[set.Key] = map[string]float64{}
+		}
+		for _, member := range set.Members {
+			if current, ok := s.sets[set.Key][member]; !ok || current < score {
+				s.sets[set.Key][member] = score
+			}
+		}
+		for member, memberScore := range s.sets[set.Key] {
+			if memberScore <= trimUntil {
+				delete(s.sets[set.Key], member)
+			}
+		}
+		if s.ttls[set.Key] < ttl {
+			s.ttls[set.Key] = ttl
+		}
+	}
+	return nil
+}
+
+func (s *memCacheStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func TestParseCacheControl(t *testing.T) {
+	h := http.Header{}
+	h.Add("Cache-Control", `public, Max-Age=60`)
+	h.Add("Cache-Control", `s-maxage="120", no-cache="Set-Cookie", max-stale`)
+	cc := parseCacheControl(h)
+
+	assert.True(t, cc.has("public"))
+	assert.True(t, cc.has("max-stale"))
+	assert.Equal(t, "Set-Cookie", cc["no-cache"])
+
+	seconds, ok := cc.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, int64(60), seconds)
+
+	seconds, ok = cc.seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, int64(120), seconds)
+
+	_, ok = cc.seconds("min-fresh")
+	assert.False(t, ok)
+}
+
+func TestHTTPCacheLifetime(t *testing.T) {
+	date := time.Now().UTC().Truncate(time.Second)
+	lifetime := func(headers map[string]string) int64 {
+		h := http.Header{}
+		for name, value := range headers {
+			h.Set(name, value)
+		}
+		return httpCacheLifetime(h, parseCacheControl(h), 30)
+	}
+
+	assert.Equal(t, int64(120), lifetime(map[string]string{"Cache-Control": "max-age=60, s-maxage=120"}))
+	assert.Equal(t, int64(60), lifetime(map[string]string{"Cache-Control": "max-age=60", "Expires": date.Add(time.Hour).Format(http.TimeFormat)}))
+	assert.Equal(t, int64(0), lifetime(map[string]string{"Cache-Control": "no-cache, max-age=60"}))
+	assert.Equal(t, int64(0), lifetime(map[string]string{"Cache-Control": "max-age=invalid"}))
+	assert.Equal(t, int64(600), lifetime(map[string]string{
+		"Date":    date.Format(http.TimeFormat),
+		"Expires": date.Add(10 * time.Minute).Format(http.TimeFormat),
+	}))
+	assert.Equal(t, int64(0), lifetime(map[string]string{"Date": date.Format(http.TimeFormat), "Expires": "0"}))
+	assert.Equal(t, int64(30), lifetime(nil), "the cache timeout should be used without explicit freshness")
+}
+
+func TestHTTPCacheStorable(t *testing.T) {
+	storable := func(status int, codes []int, headers ...string) bool {
+		res := &http.Response{StatusCode: status, Header: http.Header{}}
+		for i := 0; i < len(headers); i += 2 {
+			res.Header.Set(headers[i], headers[i+1])
+		}
+		return httpCacheStorable(res, parseCacheControl(res.Header), codes)
+	}
+
+	assert.True(t, storable(http.StatusOK, nil))
+	assert.True(t, storable(http.StatusNotFound, nil))
+	assert.False(t, storable(http.StatusInternalServerError, nil))
+	assert.True(t, storable(http.StatusInternalServerError, nil, "Cache-Control", "max-age=5"))
+	assert.False(t, storable(http.StatusOK, nil, "Cache-Control", "no-store"))
+	assert.False(t, storable(http.StatusOK, nil, "Cache-Control", "private, max-age=60"))
+	assert.False(t, storable(http.StatusOK, nil, "Vary", "Accept, *"))
+	assert.False(t, storable(http.StatusPartialContent, nil, "Cache-Control", "max-age=60"))
+	assert.False(t, storable(http.StatusNotModified, []int{http.StatusNotModified}))
+	assert.False(t, storable(http.StatusOK, []int{http.StatusCreated}, "Cache-Control", "max-age=60"))
+	assert.True(t, storable(http.StatusCreated, []int{http.StatusCreated}))
+}
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &http.Response{Header: http.Header{}}
+	res.Header.Set("ETag", `W/"v1"`)
+	res.Header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	conditional := func(method string, headers ...string) bool {
+		r := httptest.NewRequest(method, "/", nil)
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		return notModified(r, res)
+	}
+
+	assert.False(t, conditional(http.MethodGet))
+	assert.True(t, conditional(http.MethodGet, "If-None-Match", `"v0", "v1"`))
+	assert.True(t, conditional(http.MethodGet, "If-None-Match", "*"))
+	assert.False(t, conditional(http.MethodGet, "If-None-Match", `"v2"`))
+	assert.True(t, conditional(http.MethodGet, "If-Modified-Since", lastModified.Format(http.TimeFormat)))
+	assert.False(t, conditional(http.MethodGet, "If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat)))
+	assert.False(t, conditional(http.MethodPost, "If-Modified-Since", lastModified.Format(http.TimeFormat)))
+	assert.False(t, conditional(http.MethodGet,
+		"If-None-Match", `"v2"`,
+		"If-Modified-Since", lastModified.Format(http.TimeFormat),
+	), "If-Modified-Since should be ignored along If-None-Match")
+}
+
+func TestHTTPCacheSemantics(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", DoNotTrack: true}}
+	spec.CacheOptions = apidef.CacheOptions{
+		EnableCache:              true,
+		CacheAllSafeRequests:     true,
+		CacheTimeout:             60,
+		EnableHTTPCacheSemantics: true,
+	}
+
+	store := newMemCacheStore()
+	logger := logrus.NewEntry(log)
+	proxy := gw.TykNewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "upstream"}, spec, logger)
+	mw := &RedisCacheMiddleware{BaseMiddleware: BaseMiddleware{Spec: spec, Proxy: proxy, Gw: gw, logger: logger}, store: store}
+	mw.Init()
+	resMW := &ResponseCacheMiddleware{store: store}
+	assert.NoError(t, resMW.Init(nil, spec))
+
+	// do runs the request through the cache middlewares, and upstream if it isn't served from the cache
+	var upstreamRequests []*http.Request
+	do := func(upstream func(r *http.Request) *http.Response, headers ...string) *http.Response {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+
+		w := httptest.NewRecorder()
+		if _, code := mw.ProcessRequest(w, r, nil); code == mwStatusRespond {
+			return w.Result()
+		}
+
+		outreq := r.Clone(r.Context())
+		upstreamRequests = append(upstreamRequests, outreq)
+		res := upstream(outreq)
+		assert.NoError(t, resMW.HandleResponse(w, res, r, nil))
+		return res
+	}
+	waitStored := func(entries int) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return store.len() == entries
+		}, time.Second, 5*time.Millisecond)
+	}
+	respond := func(status int, body string, headers ...string) func(r *http.Request) *http.Response {
+		return func(r *http.Request) *http.Response {
+			res := &http.Response{
+				Status:        http.StatusText(status),
+				StatusCode:    status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{},
+				Body:          ioutil.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       r,
+			}
+			for i := 0; i < len(headers); i += 2 {
+				res.Header.Set(headers[i], headers[i+1])
+			}
+			return res
+		}
+	}
+	readBody := func(res *http.Response) string {
+		t.Helper()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return string(body)
+	}
+	reset := func() {
+		store = newMemCacheStore()
+		mw.store, resMW.store = store, store
+		upstreamRequests = nil
+	}
+
+	t.Run("fresh responses are served from the cache", func(t *testing.T) {
+		reset()
+		do(respond(http.StatusOK, "v1", "Cache-Control", "max-age=100", "ETag", `"v1"`, "Age", "10"))
+		waitStored(1)
+		for _, ttl := range store.ttls {
+			assert.Equal(t, int64(90+60), ttl, "responses with validators should be kept for revalidation")
+		}
+
+		res := do(respond(http.StatusInternalServerError, ""))
+		assert.Len(t, upstreamRequests, 1)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "v1", readBody(res))
+		assert.Equal(t, "10", res.Header.Get("Age"))
+		assert.Equal(t, "1", res.Header.Get(cachedResponseHeader))
+
+		res = do(respond(http.StatusInternalServerError, ""), "If-None-Match", `W/"v1"`)
+		assert.Len(t, upstreamRequests, 1)
+		assert.Equal(t, http.StatusNotModified, res.StatusCode)
+		assert.Empty(t, readBody(res))
+
+		res = do(respond(http.StatusOK, "v2", "Cache-Control", "max-age=100", "ETag", `"v2"`), "Cache-Control", "max-age=5")
+		assert.Len(t, upstreamRequests, 2, "responses older than requested should be revalidated")
+		assert.Equal(t, "v2", readBody(res))
+	})
+
+	t.Run("responses that can't be stored", func(t *testing.T) {
+		reset()
+		do(respond(http.StatusOK, "v1", "Cache-Control", "private, max-age=100"))
+		do(respond(http.StatusOK, "v1", "Cache-Control", "no-store"))
+		do(respond(http.StatusOK, "v1", "Cache-Control", "no-cache"))
+		do(respond(http.StatusServiceUnavailable, "v1"))
+		do(respond(http.StatusOK, "v1", "Cache-Control", "max-age=100"), "Cache-Control", "no-store")
+		time.Sleep(20 * time.Millisecond)
+		assert.Zero(t, store.len())
+	})
+
+	t.Run("stale responses are revalidated", func(t *testing.T) {
+		reset()
+		lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		do(respond(http.StatusOK, "v1", "Cache-Control", "no-cache", "ETag", `"v1"`, "Last-Modified", lastModified, "X-Version", "1"))
+		waitStored(1)
+
+		res := do(respond(http.StatusNotModified, "", "Cache-Control", "max-age=100", "ETag", `"v1"`, "X-Version", "2"), "If-None-Match", `"v0"`)
+		if assert.Len(t, upstreamRequests, 2) {
+			assert.Equal(t, `"v1"`, upstreamRequests[1].Header.Get("If-None-Match"), "the stored validators should be sent upstream")
+			assert.Equal(t, lastModified, upstreamRequests[1].Header.Get("If-Modified-Since"))
+		}
+		assert.Equal(t, http.StatusOK, res.StatusCode, "the stored response should be served when the client has an other version")
+		assert.Equal(t, "v1", readBody(res))
+		assert.Equal(t, "2", res.Header.Get("X-Version"), "the stored response should be updated")
+
+		time.Sleep(20 * time.Millisecond)
+		res = do(respond(http.StatusInternalServerError, ""))
+		assert.Len(t, upstreamRequests, 2, "the revalidated response should be fresh")
+		assert.Equal(t, "v1", readBody(res))
+		assert.Equal(t, "2", res.Header.Get("X-Version"))
+
+		res = do(respond(http.StatusOK, "v2", "ETag", `"v2"`), "Cache-Control", "no-cache", "If-None-Match", `"v2"`)
+		assert.Len(t, upstreamRequests, 3)
+		assert.Equal(t, http.StatusNotModified, res.StatusCode, "the client conditions should apply to the new response")
+		assert.Empty(t, readBody(res))
+	})
+
+	t.Run("responses requiring revalidation aren't served stale", func(t *testing.T) {
+		reset()
+		spec.CacheOptions.StaleWhileRevalidate = 100
+		defer func() {
+			spec.CacheOptions.StaleWhileRevalidate = 0
+		}()
+
+		do(respond(http.StatusOK, "v1", "Cache-Control", "max-age=0, must-revalidate", "ETag", `"v1"`))
+		waitStored(1)
+
+		res := do(respond(http.StatusOK, "v2", "ETag", `"v2"`))
+		assert.Len(t, upstreamRequests, 2)
+		assert.Equal(t, "v2", readBody(res))
+	})
+
+	t.Run("responses are cached per varying header", func(t *testing.T) {
+		reset()
+		do(respond(http.StatusOK, "json", "Cache-Control", "max-age=100", "Vary", "accept"), "Accept", "application/json")
+		waitStored(2)
+		do(respond(http.StatusOK, "xml", "Cache-Control", "max-age=100", "Vary", "accept"), "Accept", "application/xml")
+		waitStored(3)
+
+		assert.Equal(t, "json", readBody(do(respond(http.StatusInternalServerError, ""), "Accept", "application/json")))
+		assert.Equal(t, "xml", readBody(do(respond(http.StatusInternalServerError, ""), "Accept", "application/xml")))
+		assert.Len(t, upstreamRequests, 2)
+	})
+}
@@ -0,0 +1,252 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+const (
+	defaultHybridSyncInterval = 100 * time.Millisecond
+	defaultHybridMaxOvershoot = 0.1
+)
+
+// hybridRateLimitStore is implemented by the stores the hybrid rate limiter synchronises with.
+type hybridRateLimitStore interface {
+	IncrementByWithExpire(keyName string, delta int64, expire time.Duration) (int64, error)
+}
+
+// hybridRateLimiter enforces the rate limits with a sliding window counter local to the node.
+// The requests consumed on the node are added to the counters in Redis in batches, and the
+// counters of Redis, which include the requests of the other nodes, are read back on every
+// synchronisation.
+type hybridRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*hybridBucket
+
+	// now returns the current time, so tests can move the clock
+	now func() time.Time
+}
+
+// hybridBucket is the local state of a rate limit, in fixed windows of the period aligned on the
+// clock so the nodes share the same windows.
+type hybridBucket struct {
+	mu    sync.Mutex
+	key   string
+	store hybridRateLimitStore
+
+	rate float64
+	per  time.Duration
+
+	window int64
+	// previous and current are the requests of the previous and current windows known to Redis,
+	// pending the requests consumed on the node since the last synchronisation
+	previous, current, pending int64
+
+	syncing  bool
+	lastUsed time.Time
+}
+
+func newHybridRateLimiter() *hybridRateLimiter {
+	return &hybridRateLimiter{buckets: map[string]*hybridBucket{}, now: time.Now}
+}
+
+// hybridBatchSize returns the number of requests a node consumes before synchronising.
+func hybridBatchSize(conf config.HybridRateLimiterConfig, rate float64) int64 {
+	overshoot := conf.MaxOvershoot
+	if overshoot <= 0 {
+		overshoot = defaultHybridMaxOvershoot
+	}
+	if batch := int64(rate * overshoot); batch > 1 {
+		return batch
+	}
+	return 1
+}
+
+func (h *hybridRateLimiter) bucket(key string, store hybridRateLimitStore, apiLimit *user.APILimit) *hybridBucket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b, ok := h.buckets[key]
+	if !ok {
+		b = &hybridBucket{key: key}
+		h.buckets[key] = b
+	}
+	b.mu.Lock()
+	b.store = store
+	b.rate = apiLimit.Rate
+	b.per = time.Duration(apiLimit.Per * float64(time.Second))
+	b.mu.Unlock()
+	return b
+}
+
+// limit consumes a request of the bucket unless the rate limit is reached. The requests
+// consumed are synchronised with Redis in the background once a batch is complete.
+func (h *hybridRateLimiter) limit(key string, store hybridRateLimitStore, apiLimit *user.APILimit, batch int64, dryRun bool) rateLimitState {
+	b := h.bucket(key, store, apiLimit)
+	now := h.now()
+
+	b.mu.Lock()
+	if b.per <= 0 {
+		b.mu.Unlock()
+		return rateLimitState{RateLimitResult: storage.RateLimitResult{Allowed: true}, Rate: apiLimit.Rate, Per: apiLimit.Per}
+	}
+	b.lastUsed = now
+
+	elapsed := time.Duration(now.UnixNano() % int64(b.per))
+	rolled := b.roll(now.UnixNano() / int64(b.per))
+
+	// like the sliding window counter, the requests of the previous window are weighted by
+	// their overlap with the sliding window
+	overlap := float64(b.per-elapsed) / float64(b.per)
+	count := int64(math.Floor(float64(b.previous)*overlap)) + b.current + b.pending
+
+	state := rateLimitState{Rate: apiLimit.Rate, Per: apiLimit.Per}
+	state.Reset = b.per - elapsed
+	if float64(count) >= b.rate {
+		state.RetryAfter = b.per - elapsed
+		b.mu.Unlock()
+		return state
+	}
+
+	state.Allowed = true
+	state.Remaining = int64(b.rate) - count - 1
+	if !dryRun {
+		b.pending++
+	}
+	needSync := rolled || b.pending >= batch
+	b.mu.Unlock()
+
+	if needSync {
+		go b.sync()
+	}
+	return state
+}
+
+// roll moves the bucket to the window. The requests of the previous window not synchronised
+// yet are counted as the requests of the previous window, and dropped.
+func (b *hybridBucket) roll(window int64) bool {
+	if window == b.window {
+		return false
+	}
+	if window == b.window+1 {
+		b.previous = b.current + b.pending
+	} else {
+		b.previous = 0
+	}
+	b.window, b.current, b.pending = window, 0, 0
+	return true
+}
+
+// sync adds the requests consumed on the node to the counter of the window in Redis, and reads
+// back the requests of all the nodes.
+func (b *hybridBucket) sync() {
+	b.mu.Lock()
+	if b.syncing || b.per <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.syncing = true
+	window, pending, per, store := b.window, b.pending, b.per, b.store
+	b.pending = 0
+	b.mu.Unlock()
+
+	total, err := store.IncrementByWithExpire(b.key+"."+strconv.FormatInt(window, 10), pending, 2*per)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.syncing = false
+	switch {
+	case err != nil:
+		// the requests are synchronised on the next attempt, unless the window is over
+		if b.window == window {
+			b.pending += pending
+		}
+	case b.window == window:
+		b.current = total
+	case b.window == window+1 && total > b.previous:
+		b.previous = total
+	}
+}
+
+// flush synchronises the buckets with requests pending, and removes the buckets unused for
+// longer than two periods, which are no longer limiting any request.
+func (h *hybridRateLimiter) flush() {
+	now := h.now()
+
+	h.mu.Lock()
+	buckets := make([]*hybridBucket, 0, len(h.buckets))
+	for key, b := range h.buckets {
+		b.mu.Lock()
+		if now.Sub(b.lastUsed) > 2*b.per {
+			delete(h.buckets, key)
+		} else if b.pending > 0 {
+			buckets = append(buckets, b)
+		}
+		b.mu.Unlock()
+	}
+	h.mu.Unlock()
+
+	for _, b := range buckets {
+		b.sync()
+	}
+}
+
+// run flushes the buckets every interval until the context is done.
+func (h *hybridRateLimiter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.flush()
+			return
+		case <-ticker.C:
+			h.flush()
+		}
+	}
+}
+
+// hybridLimiter returns the hybrid rate limiter, started with the gateway on first use.
+func (l *SessionLimiter) hybridLimiter(globalConf *config.Config) *hybridRateLimiter {
+	l.hybridOnce.Do(func() {
+		l.hybrid = newHybridRateLimiter()
+		if l.Gw == nil || l.Gw.ctx == nil {
+			return
+		}
+
+		interval := time.Duration(globalConf.HybridRateLimiter.SyncInterval) * time.Millisecond
+		if interval <= 0 {
+			interval = defaultHybridSyncInterval
+		}
+		go l.hybrid.run(l.Gw.ctx, interval)
+	})
+	return l.hybrid
+}
+
+// limitHybrid evaluates the rate limit with the hybrid rate limiter. It reports false if the
+// store can't be synchronised with.
+func (l *SessionLimiter) limitHybrid(r *http.Request, currentSession *user.SessionState, rateScope string, store storage.Handler,
+	globalConf *config.Config, apiLimit *user.APILimit, dryRun bool) (limited bool, ok bool) {
+
+	syncStore, ok := store.(hybridRateLimitStore)
+	if !ok {
+		return false, false
+	}
+
+	rateLimiterKey := RateLimitKeyPrefix + rateScope + currentSession.KeyHash() + ".hybrid"
+	batch := hybridBatchSize(globalConf.HybridRateLimiter, apiLimit.Rate)
+	state := l.hybridLimiter(globalConf).limit(rateLimiterKey, syncStore, apiLimit, batch, dryRun)
+
+	if r != nil {
+		ctxSetRateLimitState(r, &state)
+	}
+	return !state.Allowed, true
+}
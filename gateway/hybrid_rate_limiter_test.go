@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// counterStore keeps the counters of the hybrid rate limiter in memory, shared by the nodes.
+type counterStore struct {
+	storage.Handler
+
+	mu       sync.Mutex
+	counters map[string]int64
+	err      error
+}
+
+func newCounterStore() *counterStore {
+	return &counterStore{counters: map[string]int64{}}
+}
+
+func (s *counterStore) IncrementByWithExpire(keyName string, delta int64, _ time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.counters[keyName] += delta
+	return s.counters[keyName], nil
+}
+
+func (s *counterStore) total() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for _, count := range s.counters {
+		total += count
+	}
+	return total
+}
+
+func TestHybridRateLimiter(t *testing.T) {
+	limit := &user.APILimit{Rate: 10, Per: 60}
+	// the clock is at the start of a window, so the previous window doesn't overlap
+	start := time.Unix(0, 0).Add(1000 * time.Minute)
+
+	newNode := func(now *time.Time) *hybridRateLimiter {
+		h := newHybridRateLimiter()
+		h.now = func() time.Time {
+			return *now
+		}
+		return h
+	}
+
+	// consume sends requests to the node until it limits them, and returns the allowed requests
+	consume := func(h *hybridRateLimiter, store *counterStore, n int, batch int64) int {
+		allowed := 0
+		for i := 0; i < n; i++ {
+			if h.limit("key", store, limit, batch, false).Allowed {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// synced flushes the node until the store counts the requests, as the synchronisations
+	// started by the requests run in the background
+	synced := func(h *hybridRateLimiter, store *counterStore, total int64) func() bool {
+		return func() bool {
+			h.flush()
+			return store.total() == total
+		}
+	}
+
+	t.Run("local limit", func(t *testing.T) {
+		now := start
+		store := newCounterStore()
+		h := newNode(&now)
+
+		state := h.limit("key", store, limit, 100, false)
+		assert.True(t, state.Allowed)
+		assert.Equal(t, int64(9), state.Remaining)
+		assert.Equal(t, time.Minute, state.Reset)
+
+		state = h.limit("key", store, limit, 100, true)
+		assert.Equal(t, int64(8), state.Remaining, "dry runs shouldn't consume requests")
+
+		assert.Equal(t, 9, consume(h, store, 20, 100), "the limit should be enforced without Redis")
+
+		state = h.limit("key", store, limit, 100, false)
+		assert.False(t, state.Allowed)
+		assert.Equal(t, time.Minute, state.RetryAfter)
+
+		assert.Eventually(t, synced(h, store, 10), time.Second, time.Millisecond, "the consumed requests should be synchronised")
+	})
+
+	t.Run("shared limit", func(t *testing.T) {
+		now := start
+		store := newCounterStore()
+		node1, node2 := newNode(&now), newNode(&now)
+
+		assert.Equal(t, 6, consume(node1, store, 6, 2))
+		assert.Eventually(t, func() bool {
+			return store.total() == 6
+		}, time.Second, time.Millisecond, "complete batches should be synchronised")
+
+		// node2 reads the requests of node1 on its first synchronisation
+		node2.limit("key", store, limit, 2, false)
+		assert.Eventually(t, synced(node2, store, 7), time.Second, time.Millisecond)
+
+		allowed := consume(node2, store, 10, 2)
+		assert.Equal(t, 3, allowed, "the requests of the other nodes should be counted")
+	})
+
+	t.Run("sliding window", func(t *testing.T) {
+		now := start
+		store := newCounterStore()
+		h := newNode(&now)
+		assert.Equal(t, 10, consume(h, store, 10, 100))
+
+		now = start.Add(90 * time.Second)
+		state := h.limit("key", store, limit, 100, true)
+		assert.Equal(t, int64(4), state.Remaining, "the previous window should be weighted by its overlap")
+
+		now = start.Add(3 * time.Minute)
+		state = h.limit("key", store, limit, 100, true)
+		assert.Equal(t, int64(9), state.Remaining, "older windows should be dropped")
+	})
+
+	t.Run("failed synchronisation", func(t *testing.T) {
+		now := start
+		store := newCounterStore()
+		store.err = errors.New("redis down")
+		h := newNode(&now)
+		assert.Equal(t, 5, consume(h, store, 5, 100))
+
+		h.flush()
+		store.mu.Lock()
+		store.err = nil
+		store.mu.Unlock()
+		assert.Eventually(t, synced(h, store, 5), time.Second, time.Millisecond, "requests should be synchronised once Redis is back")
+
+		now = start.Add(5 * time.Minute)
+		h.flush()
+		h.mu.Lock()
+		assert.Empty(t, h.buckets, "unused buckets should be removed")
+		h.mu.Unlock()
+	})
+}
+
+func TestSessionLimiter_HybridRateLimit(t *testing.T) {
+	limiter := &SessionLimiter{Gw: &Gateway{}}
+	session := &user.SessionState{
+		Rate: 1,
+		Per:  60,
+		AccessRights: map[string]user.AccessDefinition{
+			"api": {},
+		},
+	}
+	session.SetKeyHash("hash")
+
+	conf := &config.Config{}
+	conf.HybridRateLimiter.Enabled = true
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+	store := newCounterStore()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, sessionFailNone, limiter.ForwardMessage(r, session, "key", store, true, false, conf, spec, false))
+	if state := ctxGetRateLimitState(r); assert.NotNil(t, state) {
+		assert.Equal(t, int64(0), state.Remaining)
+	}
+	assert.Equal(t, sessionFailRateLimit, limiter.ForwardMessage(r, session, "key", store, true, false, conf, spec, false))
+}
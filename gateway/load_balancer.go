@@ -0,0 +1,334 @@
+package gateway
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TykTechnologies/murmur3"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/request"
+)
+
+// consistentHashReplicas is the number of points each unit of target weight gets on the hash ring.
+const consistentHashReplicas = 100
+
+var errAllHostsDown = errors.New("all hosts are down, uptime tests are failing")
+
+// lbCandidate is an upstream target eligible for selection by the load balancer.
+type lbCandidate struct {
+	target string
+	weight int
+}
+
+// upstreamHost returns the host part of a target URL, which is used to key
+// per-upstream state such as outstanding requests.
+func upstreamHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return target
+	}
+	return u.Host
+}
+
+// lbStateLimit is the number of candidate lists the state of a load balancing algorithm is kept
+// for, e.g. the target lists of the upstream groups and the lists of discovered targets.
+const lbStateLimit = 16
+
+// lbStates keeps the state of a load balancing algorithm per candidate list, so that requests
+// alternating between lists don't reset it. The least recently used state is evicted past
+// lbStateLimit lists. It isn't safe for concurrent use.
+type lbStates struct {
+	clock  uint64
+	states map[string]*lbState
+}
+
+type lbState struct {
+	value   interface{}
+	lastUse uint64
+}
+
+// get returns the state of the candidate list with the given signature, nil if there is none.
+func (s *lbStates) get(signature string) interface{} {
+	state, ok := s.states[signature]
+	if !ok {
+		return nil
+	}
+	s.clock++
+	state.lastUse = s.clock
+	return state.value
+}
+
+func (s *lbStates) put(signature string, value interface{}) {
+	if s.states == nil {
+		s.states = make(map[string]*lbState)
+	}
+	if _, ok := s.states[signature]; !ok && len(s.states) >= lbStateLimit {
+		var oldest string
+		var oldestUse uint64
+		found := false
+		for sig, state := range s.states {
+			if !found || state.lastUse < oldestUse {
+				oldest, oldestUse, found = sig, state.lastUse, true
+			}
+		}
+		delete(s.states, oldest)
+	}
+	s.clock++
+	s.states[signature] = &lbState{value: value, lastUse: s.clock}
+}
+
+// WeightedRoundRobin implements smooth weighted round-robin selection, which
+// spreads the picks of heavier targets instead of sending them in bursts.
+type WeightedRoundRobin struct {
+	mu sync.Mutex
+	// states are the current weights of the targets per candidate list
+	states lbStates
+}
+
+func (w *WeightedRoundRobin) next(candidates []lbCandidate) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	signature := candidatesSignature(candidates)
+	current, _ := w.states.get(signature).(map[string]int)
+	if current == nil {
+		current = make(map[string]int, len(candidates))
+		w.states.put(signature, current)
+	}
+
+	total, best := 0, -1
+	for i, c := range candidates {
+		current[c.target] += c.weight
+		total += c.weight
+		if best == -1 || current[c.target] > current[candidates[best].target] {
+			best = i
+		}
+	}
+
+	current[candidates[best].target] -= total
+	return candidates[best].target
+}
+
+// ConsistentHash maps hash keys onto targets using a hash ring, so that only
+// the keys of an added or removed target move when the target list changes.
+type ConsistentHash struct {
+	mu sync.Mutex
+	// rings are the hash rings per candidate list
+	rings lbStates
+}
+
+type ringPoint struct {
+	hash   uint32
+	target string
+}
+
+func candidatesSignature(candidates []lbCandidate) string {
+	var sb strings.Builder
+	for _, c := range candidates {
+		sb.WriteString(c.target)
+		sb.WriteByte('|')
+		sb.WriteString(strconv.Itoa(c.weight))
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+func (c *ConsistentHash) ringFor(candidates []lbCandidate) []ringPoint {
+	signature := candidatesSignature(candidates)
+
+	c.mu.Lock()
+	ring, _ := c.rings.get(signature).([]ringPoint)
+	c.mu.Unlock()
+	if ring != nil {
+		return ring
+	}
+
+	ring = make([]ringPoint, 0, len(candidates)*consistentHashReplicas)
+	for _, cand := range candidates {
+		for i := 0; i < cand.weight*consistentHashReplicas; i++ {
+			ring = append(ring, ringPoint{
+				hash:   murmur3.Sum32([]byte(cand.target + "#" + strconv.Itoa(i))),
+				target: cand.target,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	c.mu.Lock()
+	c.rings.put(signature, ring)
+	c.mu.Unlock()
+
+	return ring
+}
+
+func (c *ConsistentHash) next(candidates []lbCandidate, key string) string {
+	ring := c.ringFor(candidates)
+	hash := murmur3.Sum32([]byte(key))
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].target
+}
+
+// hashKey returns the consistent hash key of the request as configured for the API.
+func hashKey(r *http.Request, conf apidef.ConsistentHashConfig) string {
+	var key string
+	switch conf.Source {
+	case apidef.HashOnHeader:
+		key = r.Header.Get(conf.Name)
+	case apidef.HashOnCookie:
+		if cookie, err := r.Cookie(conf.Name); err == nil {
+			key = cookie.Value
+		}
+	case apidef.HashOnSession:
+		if session := ctxGetSession(r); session != nil && !session.KeyHashEmpty() {
+			key = session.KeyHash()
+		}
+	}
+
+	if key == "" {
+		key = request.RealIP(r)
+	}
+	return key
+}
+
+// UpstreamConnections tracks the number of outstanding requests per upstream host.
+type UpstreamConnections struct {
+	counters sync.Map
+}
+
+func (u *UpstreamConnections) counter(host string) *int64 {
+	if c, ok := u.counters.Load(host); ok {
+		return c.(*int64)
+	}
+	c, _ := u.counters.LoadOrStore(host, new(int64))
+	return c.(*int64)
+}
+
+// Track marks the start of a request to host and returns a func marking its end.
+func (u *UpstreamConnections) Track(host string) func() {
+	c := u.counter(host)
+	atomic.AddInt64(c, 1)
+	return func() {
+		atomic.AddInt64(c, -1)
+	}
+}
+
+// InFlight returns the number of outstanding requests to host.
+func (u *UpstreamConnections) InFlight(host string) int64 {
+	return atomic.LoadInt64(u.counter(host))
+}
+
+// upstreamHostList returns the host list the load balancer currently picks the targets of the request from.
+func (s *APISpec) upstreamHostList(r *http.Request) *apidef.HostList {
+	if s.Proxy.ServiceDiscovery.UseDiscoveryService {
+		return s.LastGoodHostList
+	}
+	if s.Proxy.TrafficSplit.Enabled {
+		if hl, ok := s.UpstreamGroups[ctxGetUpstreamGroup(r)]; ok {
+			return hl
+		}
+	}
+	return s.Proxy.StructuredTargetList
+}
+
+// targetDown reports whether the outlier detection or the uptime tests consider the target as down.
+func (gw *Gateway) targetDown(host string, spec *APISpec) bool {
+	if spec.outlierEjected(host) {
+		return true
+	}
+	if !spec.Proxy.CheckHostAgainstUptimeTests {
+		return false // we don't care if it's up
+	}
+	// As checked by HostCheckerManager.AmIPolling
+	if gw.GlobalHostChecker.store == nil {
+		return false
+	}
+	return gw.GlobalHostChecker.HostDown(host)
+}
+
+// upTargets returns the targets of the host list that are eligible for selection.
+func (gw *Gateway) upTargets(targetData *apidef.HostList, spec *APISpec) ([]lbCandidate, error) {
+	candidates := make([]lbCandidate, 0, targetData.Len())
+	for i := 0; i < targetData.Len(); i++ {
+		gotHost, err := targetData.GetIndex(i)
+		if err != nil {
+			return nil, err
+		}
+
+		host := EnsureTransport(gotHost, spec.Protocol)
+		if gw.targetDown(host, spec) {
+			continue
+		}
+		candidates = append(candidates, lbCandidate{target: host, weight: targetData.GetWeight(i)})
+	}
+
+	return candidates, nil
+}
+
+// balancedTarget selects a target with one of the non round-robin load balancing algorithms.
+func (gw *Gateway) balancedTarget(targetData *apidef.HostList, spec *APISpec, r *http.Request) (string, error) {
+	if targetData.Len() == 0 {
+		return "", errors.New("index out of range")
+	}
+
+	candidates, err := gw.upTargets(targetData, spec)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", errAllHostsDown
+	}
+
+	switch spec.Proxy.LoadBalancing.Algorithm {
+	case apidef.ConsistentHashLoadBalancing:
+		if r == nil {
+			// no request to hash on, e.g. for TCP proxies
+			return candidates[spec.RoundRobin.WithLen(len(candidates))].target, nil
+		}
+		return spec.ConsistentHash.next(candidates, hashKey(r, spec.Proxy.LoadBalancing.HashOn)), nil
+	case apidef.WeightedRoundRobinLoadBalancing:
+		return spec.WeightedRoundRobin.next(candidates), nil
+	case apidef.LeastConnectionsLoadBalancing:
+		// start from a rotating position so ties are spread evenly
+		start := spec.RoundRobin.WithLen(len(candidates))
+		best := candidates[start].target
+		bestInFlight := spec.UpstreamConnections.InFlight(upstreamHost(best))
+		for i := 1; i < len(candidates); i++ {
+			target := candidates[(start+i)%len(candidates)].target
+			if inFlight := spec.UpstreamConnections.InFlight(upstreamHost(target)); inFlight < bestInFlight {
+				best, bestInFlight = target, inFlight
+			}
+		}
+		return best, nil
+	case apidef.RandomTwoLoadBalancing:
+		if len(candidates) == 1 {
+			return candidates[0].target, nil
+		}
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		first, second := candidates[i].target, candidates[j].target
+		if spec.UpstreamConnections.InFlight(upstreamHost(second)) < spec.UpstreamConnections.InFlight(upstreamHost(first)) {
+			return second, nil
+		}
+		return first, nil
+	}
+
+	return "", errors.New("unknown load balancing algorithm: " + string(spec.Proxy.LoadBalancing.Algorithm))
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+func TestNextTarget_LoadBalancingAlgorithms(t *testing.T) {
+	gw := &Gateway{}
+
+	newSpec := func(algorithm apidef.LoadBalancingAlgorithm, targets []string, weights []apidef.TargetWeight) (*APISpec, *apidef.HostList) {
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+		spec.Proxy.EnableLoadBalancing = true
+		spec.Proxy.Targets = targets
+		spec.Proxy.LoadBalancing.Algorithm = algorithm
+		spec.Proxy.LoadBalancing.TargetWeights = weights
+
+		hl := apidef.NewHostList()
+		hl.SetWithWeights(targets, spec.Proxy.LoadBalancing.Weights(targets))
+		return spec, hl
+	}
+
+	pick := func(t *testing.T, spec *APISpec, hl *apidef.HostList, n int) map[string]int {
+		t.Helper()
+		got := map[string]int{}
+		for i := 0; i < n; i++ {
+			host, err := gw.nextTarget(hl, spec, nil)
+			assert.NoError(t, err)
+			got[host]++
+		}
+		return got
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		spec, hl := newSpec("", []string{"http://a", "http://b"}, nil)
+		assert.Equal(t, map[string]int{"http://a": 2, "http://b": 2}, pick(t, spec, hl, 4))
+	})
+
+	t.Run("weighted round robin", func(t *testing.T) {
+		spec, hl := newSpec(apidef.WeightedRoundRobinLoadBalancing, []string{"http://a", "http://b", "http://c"}, []apidef.TargetWeight{
+			{Target: "http://a", Weight: 5},
+			{Target: "http://b", Weight: 2},
+		})
+		assert.Equal(t, map[string]int{"http://a": 50, "http://b": 20, "http://c": 10}, pick(t, spec, hl, 80))
+
+		targets := []string{"http://b", "http://d"}
+		hl.SetWithWeights(targets, spec.Proxy.LoadBalancing.Weights(targets))
+		assert.Equal(t, map[string]int{"http://b": 20, "http://d": 10}, pick(t, spec, hl, 30))
+	})
+
+	t.Run("weighted round robin over alternating target lists", func(t *testing.T) {
+		weights := []apidef.TargetWeight{{Target: "http://a", Weight: 3}, {Target: "http://c", Weight: 3}}
+		spec, blue := newSpec(apidef.WeightedRoundRobinLoadBalancing, []string{"http://a", "http://b"}, weights)
+		_, green := newSpec(apidef.WeightedRoundRobinLoadBalancing, []string{"http://c", "http://d"}, weights)
+
+		got := map[string]int{}
+		for i := 0; i < 20; i++ {
+			for _, hl := range []*apidef.HostList{blue, green} {
+				host, err := gw.nextTarget(hl, spec, nil)
+				assert.NoError(t, err)
+				got[host]++
+			}
+		}
+		assert.Equal(t, map[string]int{"http://a": 15, "http://b": 5, "http://c": 15, "http://d": 5}, got,
+			"the weights of a target list shouldn't be reset by requests to the others")
+
+		for i := 0; i < 2*lbStateLimit; i++ {
+			targets := []string{fmt.Sprintf("http://discovered-%d", i)}
+			_, hl := newSpec(apidef.WeightedRoundRobinLoadBalancing, targets, nil)
+			gw.nextTarget(hl, spec, nil)
+		}
+		assert.Len(t, spec.WeightedRoundRobin.states.states, lbStateLimit, "the states of old target lists should be evicted")
+	})
+
+	t.Run("least connections", func(t *testing.T) {
+		spec, hl := newSpec(apidef.LeastConnectionsLoadBalancing, []string{"http://a", "http://b", "http://c"}, nil)
+		doneA := spec.UpstreamConnections.Track("a")
+		doneB := spec.UpstreamConnections.Track("b")
+		assert.Equal(t, map[string]int{"http://c": 5}, pick(t, spec, hl, 5))
+
+		doneA()
+		got := pick(t, spec, hl, 6)
+		assert.Zero(t, got["http://b"])
+		assert.NotZero(t, got["http://a"])
+		assert.NotZero(t, got["http://c"])
+		doneB()
+	})
+
+	t.Run("random two", func(t *testing.T) {
+		spec, hl := newSpec(apidef.RandomTwoLoadBalancing, []string{"http://a", "http://b"}, nil)
+		done := spec.UpstreamConnections.Track("a")
+		defer done()
+		assert.Equal(t, map[string]int{"http://b": 10}, pick(t, spec, hl, 10))
+	})
+
+	t.Run("empty host list", func(t *testing.T) {
+		spec, hl := newSpec(apidef.LeastConnectionsLoadBalancing, nil, nil)
+		_, err := gw.nextTarget(hl, spec, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestNextTarget_ConsistentHash(t *testing.T) {
+	gw := &Gateway{}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.Proxy.EnableLoadBalancing = true
+	spec.Proxy.LoadBalancing.Algorithm = apidef.ConsistentHashLoadBalancing
+	spec.Proxy.LoadBalancing.HashOn = apidef.ConsistentHashConfig{Source: apidef.HashOnHeader, Name: "X-User"}
+
+	newRequest := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		return r
+	}
+
+	assign := func(t *testing.T, targets []string) map[string]string {
+		t.Helper()
+		hl := apidef.NewHostListFromList(targets)
+		got := map[string]string{}
+		for i := 0; i < 1000; i++ {
+			user := fmt.Sprintf("user-%d", i)
+			host, err := gw.nextTarget(hl, spec, newRequest(user))
+			assert.NoError(t, err)
+			got[user] = host
+		}
+		return got
+	}
+
+	before := assign(t, []string{"http://a", "http://b", "http://c", "http://d"})
+	assert.Equal(t, before, assign(t, []string{"http://a", "http://b", "http://c", "http://d"}), "assignment should be stable")
+
+	after := assign(t, []string{"http://a", "http://b", "http://c"})
+	moved := 0
+	for user, host := range before {
+		if host != "http://d" {
+			assert.Equal(t, host, after[user], "only users of the removed target should move")
+			continue
+		}
+		moved++
+	}
+	assert.InDelta(t, 250, moved, 100)
+	assert.Len(t, spec.ConsistentHash.rings.states, 2, "the rings of both target lists should be kept")
+
+	t.Run("falls back to client IP", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		assert.Equal(t, "10.0.0.1", hashKey(r, spec.Proxy.LoadBalancing.HashOn))
+	})
+}
@@ -0,0 +1,441 @@
+package gateway
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	defaultLocalResponseCacheSize = 64 << 20
+	// localCacheEntryOverhead approximates the memory used by an entry besides its key and data
+	localCacheEntryOverhead = 128
+
+	localCacheEvictionLFU = "lfu"
+)
+
+// localResponseCache keeps the hot entries of the response cache of the APIs in memory, in front
+// of Redis. It is bounded in bytes, and the entries of each API are bounded by its quota.
+type localResponseCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lfu     bool
+	// tick orders the accesses to the entries
+	tick     uint64
+	segments map[string]*localCacheSegment
+}
+
+func newLocalResponseCache(maxSize int64, evictionPolicy string) *localResponseCache {
+	if maxSize <= 0 {
+		maxSize = defaultLocalResponseCacheSize
+	}
+	return &localResponseCache{
+		maxSize:  maxSize,
+		lfu:      evictionPolicy == localCacheEvictionLFU,
+		segments: map[string]*localCacheSegment{},
+	}
+}
+
+// localCacheSegment holds the entries of an API.
+type localCacheSegment struct {
+	quota   int64
+	size    int64
+	entries map[string]*localCacheEntry
+	// eviction orders the entries by eviction priority
+	eviction localCacheHeap
+}
+
+// localCacheEntry is a decoded response cache payload.
+type localCacheEntry struct {
+	key       string
+	data      string
+	timestamp string
+	expiresAt time.Time
+	size      int64
+
+	hits  uint64
+	tick  uint64
+	index int
+}
+
+// expired reports whether the cached response is past its expiry timestamp, if it has one.
+func (e *localCacheEntry) expired() bool {
+	expiresAt, err := strconv.ParseInt(e.timestamp, 10, 64)
+	return err == nil && time.Unix(expiresAt, 0).Before(time.Now())
+}
+
+// localCacheHeap is a min-heap of the entries of a segment, the first entry is evicted first.
+type localCacheHeap struct {
+	entries []*localCacheEntry
+	lfu     bool
+}
+
+func (h localCacheHeap) Len() int { return len(h.entries) }
+
+func (h localCacheHeap) Less(i, j int) bool {
+	return evictedBefore(h.entries[i], h.entries[j], h.lfu)
+}
+
+func (h localCacheHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *localCacheHeap) Push(x interface{}) {
+	entry := x.(*localCacheEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *localCacheHeap) Pop() interface{} {
+	n := len(h.entries)
+	entry := h.entries[n-1]
+	h.entries[n-1] = nil
+	h.entries = h.entries[:n-1]
+	return entry
+}
+
+// evictedBefore reports whether the entry a is evicted before the entry b. The least frequently
+// used entries are evicted first with the LFU policy, ties evicting the least recently used.
+func evictedBefore(a, b *localCacheEntry, lfu bool) bool {
+	if lfu && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.tick < b.tick
+}
+
+// setQuota sets the quota of an API, evicting its entries above the quota.
+func (c *localResponseCache) setQuota(apiID string, quota int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segment := c.segment(apiID)
+	segment.quota = quota
+	for segment.quota > 0 && segment.size > segment.quota {
+		c.remove(segment, segment.eviction.entries[0])
+	}
+}
+
+func (c *localResponseCache) segment(apiID string) *localCacheSegment {
+	segment, ok := c.segments[apiID]
+	if !ok {
+		segment = &localCacheSegment{
+			entries:  map[string]*localCacheEntry{},
+			eviction: localCacheHeap{lfu: c.lfu},
+		}
+		c.segments[apiID] = segment
+	}
+	return segment
+}
+
+// get returns the entry of an API with the given key, unless it has expired.
+func (c *localResponseCache) get(apiID, key string) (*localCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segment, ok := c.segments[apiID]
+	if !ok {
+		return nil, false
+	}
+	entry, ok := segment.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		c.remove(segment, entry)
+		return nil, false
+	}
+
+	c.tick++
+	entry.tick = c.tick
+	entry.hits++
+	heap.Fix(&segment.eviction, entry.index)
+	return entry, true
+}
+
+// set adds an entry for the given number of seconds, evicting entries to make room for it.
+// Entries larger than the quota of the API or than the cache aren't kept.
+func (c *localResponseCache) set(apiID, key, data, timestamp string, ttl int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segment := c.segment(apiID)
+	if entry, ok := segment.entries[key]; ok {
+		c.remove(segment, entry)
+	}
+
+	size := int64(len(key)+len(data)+len(timestamp)) + localCacheEntryOverhead
+	if ttl <= 0 || size > c.maxSize || (segment.quota > 0 && size > segment.quota) {
+		return
+	}
+
+	for segment.quota > 0 && segment.size+size > segment.quota {
+		c.remove(segment, segment.eviction.entries[0])
+	}
+	for c.size+size > c.maxSize {
+		c.evict()
+	}
+
+	c.tick++
+	entry := &localCacheEntry{
+		key:       key,
+		data:      data,
+		timestamp: timestamp,
+		expiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		size:      size,
+		hits:      1,
+		tick:      c.tick,
+	}
+	segment.entries[key] = entry
+	segment.size += size
+	c.size += size
+	heap.Push(&segment.eviction, entry)
+}
+
+// evict removes the entry evicted first across the APIs.
+func (c *localResponseCache) evict() {
+	var victimSegment *localCacheSegment
+	for _, segment := range c.segments {
+		if segment.eviction.Len() == 0 {
+			continue
+		}
+		if victimSegment == nil || evictedBefore(segment.eviction.entries[0], victimSegment.eviction.entries[0], c.lfu) {
+			victimSegment = segment
+		}
+	}
+	if victimSegment != nil {
+		c.remove(victimSegment, victimSegment.eviction.entries[0])
+	}
+}
+
+func (c *localResponseCache) remove(segment *localCacheSegment, entry *localCacheEntry) {
+	heap.Remove(&segment.eviction, entry.index)
+	delete(segment.entries, entry.key)
+	segment.size -= entry.size
+	c.size -= entry.size
+}
+
+// delete removes the entries of an API with the given keys.
+func (c *localResponseCache) delete(apiID string, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segment, ok := c.segments[apiID]
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		if entry, ok := segment.entries[key]; ok {
+			c.remove(segment, entry)
+		}
+	}
+}
+
+// purge removes the entries of an API.
+func (c *localResponseCache) purge(apiID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if segment, ok := c.segments[apiID]; ok {
+		c.size -= segment.size
+		segment.size = 0
+		segment.entries = map[string]*localCacheEntry{}
+		segment.eviction.entries = nil
+	}
+}
+
+// localCacheStore is the response cache store of an API, keeping the hot entries in the local
+// response cache of the node in front of Redis.
+type localCacheStore struct {
+	storage.Handler
+
+	cache *localResponseCache
+	apiID string
+	// staleWindow is the number of seconds the entries read from Redis are kept past their
+	// expiry, as they may still be served stale
+	staleWindow int64
+}
+
+// wrapLocalResponseCache returns the response cache store of an API, with the local response
+// cache in front of it if enabled.
+func (gw *Gateway) wrapLocalResponseCache(store storage.Handler, spec *APISpec) storage.Handler {
+	cache := gw.getLocalResponseCache()
+	if cache == nil || !spec.CacheOptions.EnableLocalCache {
+		return store
+	}
+
+	cache.setQuota(spec.APIID, spec.CacheOptions.LocalCacheQuota)
+	return &localCacheStore{
+		Handler:     store,
+		cache:       cache,
+		apiID:       spec.APIID,
+		staleWindow: maxStaleWindow(spec),
+	}
+}
+
+// getLocalResponseCache returns the local response cache of the node, nil if it is disabled.
+func (gw *Gateway) getLocalResponseCache() *localResponseCache {
+	conf := gw.GetConfig().LocalResponseCache
+	if !conf.Enabled {
+		return nil
+	}
+
+	gw.localResponseCacheMu.Lock()
+	defer gw.localResponseCacheMu.Unlock()
+	if gw.localResponseCache == nil {
+		gw.localResponseCache = newLocalResponseCache(conf.MaxSize, conf.EvictionPolicy)
+	}
+	return gw.localResponseCache
+}
+
+// maxStaleWindow returns the longest time expired responses of the API can be served for.
+func maxStaleWindow(spec *APISpec) int64 {
+	window := spec.CacheOptions.StaleWhileRevalidate
+	if spec.CacheOptions.StaleIfError > window {
+		window = spec.CacheOptions.StaleIfError
+	}
+	for _, version := range spec.VersionData.Versions {
+		for _, meta := range version.ExtendedPaths.AdvanceCacheConfig {
+			if meta.StaleWhileRevalidate > window {
+				window = meta.StaleWhileRevalidate
+			}
+			if meta.StaleIfError > window {
+				window = meta.StaleIfError
+			}
+		}
+	}
+	if spec.CacheOptions.EnableHTTPCacheSemantics && spec.CacheOptions.CacheTimeout > window {
+		// responses with validators are kept to be revalidated
+		window = spec.CacheOptions.CacheTimeout
+	}
+	return window
+}
+
+// GetPayload returns the decoded payload of an entry, reading it from Redis if it isn't held
+// locally. Once the local entry has expired, the one in Redis is read as it may have been refreshed
+// by another node, and the local entry is only served if Redis can't be read.
+func (s *localCacheStore) GetPayload(key string) (string, string, error) {
+	local, ok := s.cache.get(s.apiID, key)
+	if ok && !local.expired() {
+		return local.data, local.timestamp, nil
+	}
+
+	payload, err := s.Handler.GetKey(key)
+	if err == storage.ErrKeyNotFound {
+		s.cache.delete(s.apiID, key)
+		return "", "", err
+	}
+	if err != nil {
+		if ok {
+			return local.data, local.timestamp, nil
+		}
+		return "", "", err
+	}
+	data, timestamp, err := decodeCachePayload(payload)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the entry is kept as long as in Redis
+	ttl := s.staleWindow
+	if expiresAt, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		ttl += expiresAt - time.Now().Unix()
+	}
+	s.cache.set(s.apiID, key, data, timestamp, ttl)
+	return data, timestamp, nil
+}
+
+func (s *localCacheStore) GetKey(key string) (string, error) {
+	if entry, ok := s.cache.get(s.apiID, key); ok {
+		if entry.timestamp == "" {
+			return entry.data, nil
+		}
+		return base64.StdEncoding.EncodeToString([]byte(entry.data)) + "|" + entry.timestamp, nil
+	}
+	return s.Handler.GetKey(key)
+}
+
+func (s *localCacheStore) SetKey(key, value string, ttl int64) error {
+	if err := s.Handler.SetKey(key, value, ttl); err != nil {
+		s.cache.delete(s.apiID, key)
+		return err
+	}
+
+	if data, timestamp, err := decodeCachePayload(value); err == nil {
+		s.cache.set(s.apiID, key, data, timestamp, ttl)
+	} else {
+		s.cache.delete(s.apiID, key)
+	}
+	return nil
+}
+
+// DeleteKey removes the entry locally. The other nodes aren't notified, their copies expire
+// with their TTL, explicit invalidations go through invalidateLocalResponseCache.
+func (s *localCacheStore) DeleteKey(key string) bool {
+	s.cache.delete(s.apiID, key)
+	return s.Handler.DeleteKey(key)
+}
+
+// localCacheInvalidation is the payload of the notifications invalidating the entries of the
+// local response cache on the other nodes.
+type localCacheInvalidation struct {
+	NodeID string `json:"node_id"`
+	APIID  string `json:"api_id"`
+	// Keys are the invalidated entries, all the entries of the API are invalidated if empty
+	Keys []string `json:"keys,omitempty"`
+}
+
+// notifyLocalCacheInvalidation invalidates entries of the local response cache on the other nodes.
+func (gw *Gateway) notifyLocalCacheInvalidation(apiID string, keys []string) {
+	payload, err := json.Marshal(localCacheInvalidation{NodeID: gw.GetNodeID(), APIID: apiID, Keys: keys})
+	if err != nil {
+		log.WithError(err).Error("Failed to encode local cache invalidation")
+		return
+	}
+
+	gw.MainNotifier.Notify(Notification{
+		Command: NoticeLocalCacheInvalidated,
+		Payload: string(payload),
+		Gw:      gw,
+	})
+}
+
+// invalidateLocalResponseCache removes the entries of an API with the given keys, all of them if
+// none, from the local response cache of all the nodes.
+func (gw *Gateway) invalidateLocalResponseCache(apiID string, keys ...string) {
+	cache := gw.getLocalResponseCache()
+	if cache == nil {
+		return
+	}
+	if len(keys) == 0 {
+		cache.purge(apiID)
+	} else {
+		cache.delete(apiID, keys...)
+	}
+	gw.notifyLocalCacheInvalidation(apiID, keys)
+}
+
+func (gw *Gateway) handleLocalCacheInvalidation(payload string) {
+	var invalidation localCacheInvalidation
+	if err := json.Unmarshal([]byte(payload), &invalidation); err != nil {
+		pubSubLog.Error("Unmarshalling local cache invalidation failed: ", err)
+		return
+	}
+
+	cache := gw.getLocalResponseCache()
+	if cache == nil || invalidation.NodeID == gw.GetNodeID() {
+		return
+	}
+	if len(invalidation.Keys) == 0 {
+		cache.purge(invalidation.APIID)
+		return
+	}
+	cache.delete(invalidation.APIID, invalidation.Keys...)
+}
@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+func TestLocalResponseCache(t *testing.T) {
+	// entrySize is the size of the entries set by the tests, with a key and data of 4 bytes
+	const entrySize = 8 + localCacheEntryOverhead
+
+	has := func(c *localResponseCache, apiID string, keys ...string) []bool {
+		found := make([]bool, len(keys))
+		for i, key := range keys {
+			_, found[i] = c.get(apiID, key)
+		}
+		return found
+	}
+
+	t.Run("lru", func(t *testing.T) {
+		c := newLocalResponseCache(3*entrySize, "lru")
+		c.set("api", "key1", "data", "", 60)
+		c.set("api", "key2", "data", "", 60)
+		c.set("api", "key3", "data", "", 60)
+		c.get("api", "key1")
+		c.get("api", "key2")
+		c.get("api", "key3")
+		c.get("api", "key1")
+
+		c.set("api", "key4", "data", "", 60)
+		assert.Equal(t, []bool{true, false, true, true}, has(c, "api", "key1", "key2", "key3", "key4"))
+		assert.Equal(t, int64(3*entrySize), c.size)
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		c := newLocalResponseCache(3*entrySize, "lfu")
+		c.set("api", "key1", "data", "", 60)
+		c.set("api", "key2", "data", "", 60)
+		c.set("api", "key3", "data", "", 60)
+		c.get("api", "key1")
+		c.get("api", "key1")
+		c.get("api", "key3")
+		c.get("api", "key3")
+		c.get("api", "key2")
+
+		c.set("api", "key4", "data", "", 60)
+		assert.Equal(t, []bool{true, false, true, true}, has(c, "api", "key1", "key2", "key3", "key4"))
+	})
+
+	t.Run("quotas", func(t *testing.T) {
+		c := newLocalResponseCache(4*entrySize, "lru")
+		c.setQuota("small", entrySize)
+		c.set("small", "key1", "data", "", 60)
+		c.set("large", "key1", "data", "", 60)
+		c.set("small", "key2", "data", "", 60)
+		assert.Equal(t, []bool{false, true}, has(c, "small", "key1", "key2"), "APIs should be bounded by their quota")
+
+		c.set("small", "big!", strings.Repeat("data", 100), "", 60)
+		assert.Equal(t, []bool{false, true}, has(c, "small", "big!", "key2"), "entries above the quota shouldn't be kept")
+
+		c.set("large", "key2", "data", "", 60)
+		c.set("large", "key3", "data", "", 60)
+		c.set("large", "key4", "data", "", 60)
+		assert.Equal(t, []bool{true}, has(c, "large", "key4"))
+		assert.Equal(t, int64(4*entrySize), c.size, "the cache should be bounded across APIs")
+
+		c.setQuota("large", 2*entrySize)
+		assert.Equal(t, int64(2*entrySize), c.segments["large"].size, "lowered quotas should evict entries")
+	})
+
+	t.Run("expiry and invalidation", func(t *testing.T) {
+		c := newLocalResponseCache(0, "")
+		c.set("api", "key1", "data", "", 60)
+		c.set("api", "key2", "data", "", 60)
+		c.set("api", "key3", "data", "", 60)
+		c.segments["api"].entries["key1"].expiresAt = time.Now().Add(-time.Second)
+		assert.Equal(t, []bool{false, true}, has(c, "api", "key1", "key2"), "expired entries shouldn't be served")
+
+		c.delete("api", "key2")
+		assert.Equal(t, []bool{false, true}, has(c, "api", "key2", "key3"))
+
+		c.setQuota("api", 10*entrySize)
+		c.purge("api")
+		assert.Equal(t, []bool{false}, has(c, "api", "key3"))
+		assert.Equal(t, int64(0), c.size)
+		assert.Equal(t, int64(10*entrySize), c.segments["api"].quota, "the quota should be kept")
+	})
+}
+
+func TestLocalCacheStore(t *testing.T) {
+	gw := &Gateway{}
+	conf := config.Config{}
+	conf.LocalResponseCache.Enabled = true
+	gw.SetConfig(conf)
+	gw.SetNodeID("node1")
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+	spec.CacheOptions.EnableLocalCache = true
+	spec.CacheOptions.StaleIfError = 30
+
+	backend := newMemCacheStore()
+	store, ok := gw.wrapLocalResponseCache(backend, spec).(*localCacheStore)
+	if !assert.True(t, ok, "the local cache should be in front of the store") {
+		return
+	}
+	mw := &RedisCacheMiddleware{store: store}
+
+	expiresAt := strconv.FormatInt(time.Now().Unix()+60, 10)
+	backend.SetKey("key", (&ResponseCacheMiddleware{}).encodePayload("v1", time.Now().Unix()+60), 90)
+	data, timestamp, err := mw.getCachePayload("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", data)
+	assert.Equal(t, expiresAt, timestamp)
+	entry, ok := store.cache.get("api", "key")
+	if assert.True(t, ok, "entries read from Redis should be kept locally") {
+		assert.WithinDuration(t, time.Now().Add(90*time.Second), entry.expiresAt, 2*time.Second, "entries should be kept while they can be served stale")
+	}
+
+	backend.DeleteKey("key")
+	data, _, err = mw.getCachePayload("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", data, "local entries should be served without Redis")
+	value, err := store.GetKey("key")
+	assert.NoError(t, err)
+	assert.Equal(t, (&ResponseCacheMiddleware{}).encodePayload("v1", time.Now().Unix()+60), value)
+
+	assert.NoError(t, store.SetKey("key", (&ResponseCacheMiddleware{}).encodePayload("v2", time.Now().Unix()+60), 90))
+	data, _, _ = mw.getCachePayload("key")
+	assert.Equal(t, "v2", data, "written entries should be kept locally")
+
+	// the notifier of the gateway isn't set up, writes and deletes must not publish invalidations
+	assert.True(t, store.DeleteKey("key"))
+	_, ok = store.cache.get("api", "key")
+	assert.False(t, ok, "deleted entries should be removed locally")
+	store.cache.set("api", "key", "v2", "", 60)
+
+	invalidation := func(nodeID string, keys ...string) string {
+		payload, _ := json.Marshal(localCacheInvalidation{NodeID: nodeID, APIID: "api", Keys: keys})
+		return string(payload)
+	}
+	gw.handleLocalCacheInvalidation(invalidation("node1", "key"))
+	_, ok = store.cache.get("api", "key")
+	assert.True(t, ok, "invalidations of the node should be ignored")
+
+	gw.handleLocalCacheInvalidation(invalidation("node2", "key"))
+	_, ok = store.cache.get("api", "key")
+	assert.False(t, ok, "invalidations of other nodes should remove the entries")
+
+	store.cache.set("api", "key", "v3", "", 60)
+	gw.handleLocalCacheInvalidation(invalidation("node2"))
+	_, ok = store.cache.get("api", "key")
+	assert.False(t, ok, "invalidations without keys should purge the API")
+
+	spec.CacheOptions.EnableLocalCache = false
+	assert.Equal(t, backend, gw.wrapLocalResponseCache(backend, spec), "APIs should opt in to the local cache")
+}
+
+// unavailableCacheStore is a cache store failing to read the entries.
+type unavailableCacheStore struct {
+	*memCacheStore
+}
+
+func (unavailableCacheStore) GetKey(string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestLocalCacheStoreExpiredEntries(t *testing.T) {
+	backend := newMemCacheStore()
+	store := &localCacheStore{Handler: backend, cache: newLocalResponseCache(0, ""), apiID: "api", staleWindow: 30}
+	resMW := &ResponseCacheMiddleware{}
+
+	expired := time.Now().Unix() - 5
+	store.cache.set("api", "key", "stale", strconv.FormatInt(expired, 10), 25)
+	backend.SetKey("key", resMW.encodePayload("fresh", time.Now().Unix()+60), 90)
+
+	data, timestamp, err := store.GetPayload("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", data, "expired entries should be read from Redis, where other nodes may have refreshed them")
+	data, _, _ = store.GetPayload("key")
+	assert.Equal(t, "fresh", data)
+	entry, ok := store.cache.get("api", "key")
+	if assert.True(t, ok) {
+		assert.Equal(t, timestamp, entry.timestamp, "refreshed entries should replace the expired ones")
+	}
+
+	store.cache.set("api", "key", "stale", strconv.FormatInt(expired, 10), 25)
+	unavailable := &localCacheStore{Handler: unavailableCacheStore{backend}, cache: store.cache, apiID: "api", staleWindow: 30}
+	data, _, err = unavailable.GetPayload("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", data, "expired entries should be served if Redis can't be read")
+
+	backend.DeleteKey("key")
+	_, _, err = store.GetPayload("key")
+	assert.Equal(t, storage.ErrKeyNotFound, err, "expired entries removed from Redis shouldn't be served")
+	_, ok = store.cache.get("api", "key")
+	assert.False(t, ok)
+
+	backend.SetKey("key", resMW.encodePayload("stale", expired), 25)
+	store.GetPayload("key")
+	entry, ok = store.cache.get("api", "key")
+	if assert.True(t, ok) {
+		assert.WithinDuration(t, time.Now().Add(25*time.Second), entry.expiresAt, 2*time.Second, "expired entries should be kept as long as in Redis")
+	}
+}
@@ -168,6 +168,7 @@ func (gw *Gateway) createMiddleware(actualMW TykMiddleware) func(http.Handler) h
 				meta["bypass"] = "1"
 				h.ServeHTTP(w, r)
 			} else {
+				ctxReleaseConcurrencySlot(r, 0, false)
 				mw.Base().UpdateRequestSession(r)
 			}
 		})
@@ -315,6 +316,7 @@ func (t BaseMiddleware) ApplyPolicies(session *user.SessionState) error {
 	}
 
 	didQuota, didRateLimit, didACL, didComplexity := make(map[string]bool), make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	endpoints := make(map[string][]user.EndpointLimit)
 	policies := session.PolicyIDs()
 
 	for _, polID := range policies {
@@ -382,6 +384,7 @@ func (t BaseMiddleware) ApplyPolicies(session *user.SessionState) error {
 
 				// overwrite session access right for this API
 				rights[apiID] = accessRights
+				endpoints[apiID] = accessRights.Endpoints
 
 				// identify that limit for that API is set (to allow set it only once)
 				didACL[apiID] = true
@@ -514,6 +517,12 @@ func (t BaseMiddleware) ApplyPolicies(session *user.SessionState) error {
 					}
 				}
 
+				// endpoint limits are merged like the limits of the API, following the partitions
+				if !usePartitions || policy.Partitions.RateLimit || policy.Partitions.Quota {
+					endpoints[k] = mergeEndpointLimits(endpoints[k], v.Endpoints,
+						!usePartitions || policy.Partitions.RateLimit, !usePartitions || policy.Partitions.Quota)
+				}
+
 				if !usePartitions || policy.Partitions.Complexity {
 					didComplexity[k] = true
 
@@ -625,6 +634,17 @@ func (t BaseMiddleware) ApplyPolicies(session *user.SessionState) error {
 			v.Limit.QuotaRenews = session.QuotaRenews
 		}
 
+		v.Endpoints = nil
+		for _, endpoint := range endpoints[k] {
+			// Respect existing QuotaRenews
+			if r, ok := session.AccessRights[k]; ok {
+				if i := indexEndpointLimit(r.Endpoints, endpoint); i >= 0 {
+					endpoint.Limit.QuotaRenews = r.Endpoints[i].Limit.QuotaRenews
+				}
+			}
+			v.Endpoints = append(v.Endpoints, endpoint)
+		}
+
 		// If multime ACL
 		if len(distinctACL) > 1 {
 			if v.AllowanceScope == "" && v.Limit.SetBy != "" {
@@ -665,6 +685,66 @@ func (t BaseMiddleware) ApplyPolicies(session *user.SessionState) error {
 	return nil
 }
 
+// indexEndpointLimit returns the index of the limit of the same endpoint, or -1.
+func indexEndpointLimit(endpoints []user.EndpointLimit, endpoint user.EndpointLimit) int {
+	sameMethods := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for _, method := range b {
+			if !contains(a, method) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, e := range endpoints {
+		if e.URL == endpoint.URL && sameMethods(e.Methods, endpoint.Methods) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeEndpointLimits merges the endpoint limits of a policy into the limits merged so far, taking
+// the greatest rate limit and quota of every endpoint.
+func mergeEndpointLimits(merged, endpoints []user.EndpointLimit, rateLimit, quota bool) []user.EndpointLimit {
+	for _, endpoint := range endpoints {
+		i := indexEndpointLimit(merged, endpoint)
+		if i < 0 {
+			merged = append(merged, user.EndpointLimit{URL: endpoint.URL, Methods: endpoint.Methods})
+			i = len(merged) - 1
+		}
+		limit, new := &merged[i].Limit, endpoint.Limit
+
+		if rateLimit {
+			if greaterThanFloat64(new.Rate, limit.Rate) {
+				limit.Rate = new.Rate
+			}
+			if new.Per > limit.Per {
+				limit.Per = new.Per
+			}
+			if new.ThrottleRetryLimit > limit.ThrottleRetryLimit {
+				limit.ThrottleRetryLimit = new.ThrottleRetryLimit
+			}
+			if new.ThrottleInterval > limit.ThrottleInterval {
+				limit.ThrottleInterval = new.ThrottleInterval
+			}
+		}
+
+		if quota {
+			if greaterThanInt64(new.QuotaMax, limit.QuotaMax) {
+				limit.QuotaMax = new.QuotaMax
+			}
+			if new.QuotaRenewalRate > limit.QuotaRenewalRate {
+				limit.QuotaRenewalRate = new.QuotaRenewalRate
+			}
+		}
+	}
+	return merged
+}
+
 // CheckSessionAndIdentityForValidKey will check first the Session store for a valid key, if not found, it will try
 // the Auth Handler, if not found it will fail
 func (t BaseMiddleware) CheckSessionAndIdentityForValidKey(originalKey string, r *http.Request) (user.SessionState, bool) {
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/TykTechnologies/tyk/header"
 	"github.com/TykTechnologies/tyk/request"
 	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/user"
@@ -67,6 +68,7 @@ func (k *RateLimitForAPI) ProcessRequest(w http.ResponseWriter, r *http.Request,
 		return nil, http.StatusOK
 	}
 
+	keyState := ctxGetRateLimitState(r)
 	storeRef := k.Gw.GlobalSessionManager.Store()
 	reason := k.Gw.SessionLimiter.ForwardMessage(r, k.apiSess,
 		k.keyName,
@@ -78,9 +80,18 @@ func (k *RateLimitForAPI) ProcessRequest(w http.ResponseWriter, r *http.Request,
 		false,
 	)
 
+	limit := &user.APILimit{Rate: k.Spec.GlobalRateLimit.Rate, Per: k.Spec.GlobalRateLimit.Per}
 	if reason == sessionFailRateLimit {
+		setRateLimitHeaders(w, r, k.Spec, limit, reason)
 		return k.handleRateLimitFailure(r, k.keyName)
 	}
+	// the limits of the key, set by RateLimitAndQuotaCheck, are more specific, and so is their
+	// state for the rate limiters reporting it later in the chain
+	if w.Header().Get(header.RateLimitPolicy) == "" {
+		setRateLimitHeaders(w, r, k.Spec, limit, reason)
+	} else {
+		ctxSetRateLimitState(r, keyState)
+	}
 
 	// Request is valid, carry on
 	return nil, http.StatusOK
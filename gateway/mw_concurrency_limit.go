@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+const (
+	defaultConcurrencyMax          = 100
+	defaultConcurrencyQueueTimeout = 1
+	defaultAdaptiveMinConcurrency  = 1
+	defaultAdaptiveTolerance       = 1.5
+
+	// adaptiveSmoothing is the weight of a new estimate in the adaptive limit.
+	adaptiveSmoothing = 0.2
+	// adaptiveLatencyDecay is the weight of a latency sample in the long-term latency average.
+	adaptiveLatencyDecay = 0.01
+	// adaptiveDropRatio is the factor the adaptive limit is lowered by when an upstream request fails.
+	adaptiveDropRatio = 0.9
+)
+
+var errConcurrencyLimitExceeded = errors.New("Concurrency limit exceeded")
+
+func concurrencyLimitConfig(conf apidef.ConcurrencyLimitConfig) apidef.ConcurrencyLimitConfig {
+	if conf.MaxConcurrency <= 0 {
+		conf.MaxConcurrency = defaultConcurrencyMax
+	}
+	if conf.MaxQueueSize < 0 {
+		conf.MaxQueueSize = 0
+	}
+	if conf.QueueTimeout <= 0 {
+		conf.QueueTimeout = defaultConcurrencyQueueTimeout
+	}
+	if conf.Adaptive.MinConcurrency <= 0 {
+		conf.Adaptive.MinConcurrency = defaultAdaptiveMinConcurrency
+	}
+	if conf.Adaptive.MinConcurrency > conf.MaxConcurrency {
+		conf.Adaptive.MinConcurrency = conf.MaxConcurrency
+	}
+	if conf.Adaptive.Tolerance < 1 {
+		conf.Adaptive.Tolerance = defaultAdaptiveTolerance
+	}
+	return conf
+}
+
+// ConcurrencyLimiter limits the number of requests processed at once. Requests over the limit
+// wait for a slot in a FIFO queue.
+type ConcurrencyLimiter struct {
+	conf apidef.ConcurrencyLimitConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    list.List
+	// longLatency is the long-term average of the upstream latency, in nanoseconds
+	longLatency float64
+}
+
+func NewConcurrencyLimiter(conf apidef.ConcurrencyLimitConfig) *ConcurrencyLimiter {
+	conf = concurrencyLimitConfig(conf)
+	return &ConcurrencyLimiter{conf: conf, limit: float64(conf.MaxConcurrency)}
+}
+
+// Limit returns the current number of slots.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of slots in use.
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// RetryAfter returns the number of seconds a shed client is asked to wait before retrying.
+func (l *ConcurrencyLimiter) RetryAfter() int {
+	return int(math.Ceil(l.conf.QueueTimeout))
+}
+
+// Acquire takes a slot, waiting in the queue for one to be released until the queue timeout
+// or the end of ctx. It reports whether a slot was taken, which must then be released.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.queue.Len() >= l.conf.MaxQueueSize {
+		l.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Duration(l.conf.QueueTimeout * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// a slot was handed over while giving up
+		return true
+	default:
+	}
+	l.queue.Remove(elem)
+	return false
+}
+
+// Release frees a slot, handing it over to the first queued request. In adaptive mode the
+// latency of the upstream request, or whether it failed, adjusts the limit.
+func (l *ConcurrencyLimiter) Release(latency time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if l.conf.Adaptive.Enabled {
+		l.adapt(latency, dropped)
+	}
+
+	for l.inFlight < int(l.limit) && l.queue.Len() > 0 {
+		ready := l.queue.Remove(l.queue.Front()).(chan struct{})
+		l.inFlight++
+		close(ready)
+	}
+}
+
+// adapt applies the gradient of the long-term latency to the latency sample to the limit.
+func (l *ConcurrencyLimiter) adapt(latency time.Duration, dropped bool) {
+	var estimate float64
+	switch {
+	case dropped:
+		estimate = l.limit * adaptiveDropRatio
+	case latency > 0:
+		sample := float64(latency)
+		if l.longLatency == 0 {
+			l.longLatency = sample
+		} else {
+			l.longLatency += adaptiveLatencyDecay * (sample - l.longLatency)
+		}
+
+		gradient := math.Max(0.5, math.Min(1, l.conf.Adaptive.Tolerance*l.longLatency/sample))
+		estimate = l.limit*gradient + math.Sqrt(l.limit)
+		// don't raise a limit that isn't being used
+		if estimate > l.limit && float64(l.inFlight+1) < l.limit/2 {
+			return
+		}
+		estimate = l.limit*(1-adaptiveSmoothing) + estimate*adaptiveSmoothing
+	default:
+		return
+	}
+
+	l.limit = math.Max(float64(l.conf.Adaptive.MinConcurrency), math.Min(float64(l.conf.MaxConcurrency), estimate))
+}
+
+// ConcurrencyLimiters holds the concurrency limiters of an API and of its endpoints.
+type ConcurrencyLimiters struct {
+	limiters sync.Map
+}
+
+// Get returns the limiter for key, creating it with conf if it doesn't exist yet.
+func (c *ConcurrencyLimiters) Get(key string, conf apidef.ConcurrencyLimitConfig) *ConcurrencyLimiter {
+	if l, ok := c.limiters.Load(key); ok {
+		return l.(*ConcurrencyLimiter)
+	}
+	l, _ := c.limiters.LoadOrStore(key, NewConcurrencyLimiter(conf))
+	return l.(*ConcurrencyLimiter)
+}
+
+// ConcurrencyLimitMiddleware limits the number of requests to an API, or to an endpoint of it,
+// that are processed at once. Requests that can't get a slot in time are shed with a 503.
+type ConcurrencyLimitMiddleware struct {
+	BaseMiddleware
+}
+
+func (k *ConcurrencyLimitMiddleware) Name() string {
+	return "ConcurrencyLimitMiddleware"
+}
+
+func (k *ConcurrencyLimitMiddleware) EnabledForSpec() bool {
+	return k.Spec.ConcurrencyLimitEnabled
+}
+
+// ProcessRequest takes a slot for the request, which is released once the response is served.
+func (k *ConcurrencyLimitMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	// looping requests are covered by the slot of the original request
+	if ctxHasConcurrencySlot(r) {
+		return nil, http.StatusOK
+	}
+
+	conf, key := k.Spec.Proxy.ConcurrencyLimit, ""
+
+	versionInfo, _ := k.Spec.Version(r)
+	versionPaths := k.Spec.RxPaths[versionInfo.Name]
+	if found, meta := k.Spec.CheckSpecMatchesStatus(r, versionPaths, ConcurrencyLimited); found {
+		limitMeta := meta.(*apidef.ConcurrencyLimitMeta)
+		conf, key = limitMeta.ConcurrencyLimit(), limitMeta.Method+" "+limitMeta.Path
+	}
+
+	if !conf.Enabled {
+		return nil, http.StatusOK
+	}
+
+	limiter := k.Spec.ConcurrencyLimiters.Get(key, conf)
+	if !limiter.Acquire(r.Context()) {
+		k.Logger().Info("Concurrency limit exceeded, shedding request.")
+		reportHealthValue(k.Spec, Throttle, "-1")
+
+		w.Header().Set(header.RetryAfter, strconv.Itoa(limiter.RetryAfter()))
+		return errConcurrencyLimitExceeded, http.StatusServiceUnavailable
+	}
+
+	ctxSetConcurrencySlot(r, limiter.Release)
+	return nil, http.StatusOK
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Run("sheds over the limit without a queue", func(t *testing.T) {
+		l := NewConcurrencyLimiter(apidef.ConcurrencyLimitConfig{MaxConcurrency: 2})
+		assert.True(t, l.Acquire(context.Background()))
+		assert.True(t, l.Acquire(context.Background()))
+		assert.False(t, l.Acquire(context.Background()))
+
+		l.Release(0, false)
+		assert.True(t, l.Acquire(context.Background()))
+		assert.Equal(t, 2, l.InFlight())
+	})
+
+	t.Run("hands released slots over to queued requests", func(t *testing.T) {
+		l := NewConcurrencyLimiter(apidef.ConcurrencyLimitConfig{MaxConcurrency: 1, MaxQueueSize: 1, QueueTimeout: 5})
+		assert.True(t, l.Acquire(context.Background()))
+
+		acquired := make(chan bool)
+		go func() {
+			acquired <- l.Acquire(context.Background())
+		}()
+		// wait for the request to be queued
+		for {
+			l.mu.Lock()
+			queued := l.queue.Len()
+			l.mu.Unlock()
+			if queued == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		assert.False(t, l.Acquire(context.Background()), "queue should be full")
+
+		l.Release(0, false)
+		assert.True(t, <-acquired)
+		assert.Equal(t, 1, l.InFlight())
+	})
+
+	t.Run("sheds queued requests after the queue timeout", func(t *testing.T) {
+		l := NewConcurrencyLimiter(apidef.ConcurrencyLimitConfig{MaxConcurrency: 1, MaxQueueSize: 1, QueueTimeout: 0.01})
+		assert.True(t, l.Acquire(context.Background()))
+		assert.False(t, l.Acquire(context.Background()))
+		assert.Equal(t, 0, l.queue.Len())
+		assert.Equal(t, 1, l.RetryAfter())
+	})
+
+	t.Run("adaptive limit follows the upstream latency", func(t *testing.T) {
+		l := NewConcurrencyLimiter(apidef.ConcurrencyLimitConfig{
+			MaxConcurrency: 50,
+			Adaptive:       apidef.AdaptiveConcurrencyConfig{Enabled: true, MinConcurrency: 5},
+		})
+
+		release := func(latency time.Duration) {
+			for i := 0; i < 50; i++ {
+				l.Acquire(context.Background())
+			}
+			for i := 0; i < 50; i++ {
+				l.Release(latency, false)
+			}
+		}
+
+		release(10 * time.Millisecond)
+		assert.Equal(t, 50, l.Limit())
+
+		release(100 * time.Millisecond)
+		assert.Less(t, l.Limit(), 10, "limit should drop when the latency grows")
+
+		for i := 0; i < 20; i++ {
+			l.Acquire(context.Background())
+			l.Release(0, true)
+		}
+		assert.Equal(t, 5, l.Limit(), "limit shouldn't drop below the minimum")
+	})
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}, ConcurrencyLimitEnabled: true}
+	spec.Proxy.ConcurrencyLimit = apidef.ConcurrencyLimitConfig{Enabled: true, MaxConcurrency: 1, QueueTimeout: 2}
+	spec.RxPaths = map[string][]URLSpec{
+		"": APIDefinitionLoader{}.compileConcurrencyLimitPathSpec([]apidef.ConcurrencyLimitMeta{
+			{Path: "/slow", Method: http.MethodGet, MaxConcurrency: 1},
+		}, ConcurrencyLimited, config.Config{}),
+	}
+	mw := &ConcurrencyLimitMiddleware{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	process := func(path string) (*http.Request, *httptest.ResponseRecorder, int) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		_, code := mw.ProcessRequest(w, r, nil)
+		return r, w, code
+	}
+
+	first, _, code := process("/")
+	assert.Equal(t, http.StatusOK, code)
+
+	_, w, code := process("/")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "2", w.Header().Get(header.RetryAfter))
+
+	_, _, code = process("/slow")
+	assert.Equal(t, http.StatusOK, code, "endpoint should have its own slots")
+
+	_, _, code = process("/slow")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	ctxReleaseConcurrencySlot(first, 0, false)
+	ctxReleaseConcurrencySlot(first, 0, false)
+	assert.Equal(t, 0, spec.ConcurrencyLimiters.Get("", spec.Proxy.ConcurrencyLimit).InFlight(), "slot should be released once")
+
+	_, _, code = process("/")
+	assert.Equal(t, http.StatusOK, code)
+}
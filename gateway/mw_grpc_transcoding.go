@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+)
+
+// GRPCTranscodingMiddleware turns JSON requests to the HTTP bindings of gRPC methods into gRPC
+// requests. The reverse proxy transcodes the gRPC response back to JSON.
+type GRPCTranscodingMiddleware struct {
+	BaseMiddleware
+}
+
+func (k *GRPCTranscodingMiddleware) Name() string {
+	return "GRPCTranscodingMiddleware"
+}
+
+func (k *GRPCTranscodingMiddleware) EnabledForSpec() bool {
+	return k.Spec.GRPCTranscoder != nil
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (k *GRPCTranscodingMiddleware) ProcessRequest(_ http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	path := k.Spec.StripListenPath(r, r.URL.EscapedPath())
+	call, bindings := k.Spec.GRPCTranscoder.match(r.Method, path)
+	if call == nil {
+		// native gRPC requests and other routes go through untouched
+		return nil, http.StatusOK
+	}
+
+	if err := call.transcodeRequest(r, bindings); err != nil {
+		k.Logger().WithError(err).Debug("Couldn't transcode request to gRPC")
+		return errors.New("Couldn't transcode request: " + err.Error()), http.StatusBadRequest
+	}
+
+	ctxSetGRPCCall(r, call)
+	return nil, http.StatusOK
+}
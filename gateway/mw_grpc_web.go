@@ -0,0 +1,36 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+)
+
+// GRPCWebMiddleware turns gRPC-Web requests from browsers into native gRPC requests. The reverse
+// proxy translates the gRPC response back to gRPC-Web.
+type GRPCWebMiddleware struct {
+	BaseMiddleware
+}
+
+func (k *GRPCWebMiddleware) Name() string {
+	return "GRPCWebMiddleware"
+}
+
+func (k *GRPCWebMiddleware) EnabledForSpec() bool {
+	return k.Spec.Proxy.GRPCWeb.Enabled
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (k *GRPCWebMiddleware) ProcessRequest(_ http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	call := newGRPCWebCall(r)
+	if call == nil || r.Method != http.MethodPost {
+		return nil, http.StatusOK
+	}
+
+	if err := call.translateRequest(r); err != nil {
+		k.Logger().WithError(err).Debug("Couldn't translate gRPC-Web request")
+		return errors.New("Couldn't translate gRPC-Web request: " + err.Error()), http.StatusBadRequest
+	}
+
+	ctxSetGRPCWebCall(r, call)
+	return nil, http.StatusOK
+}
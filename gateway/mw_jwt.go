@@ -21,6 +21,7 @@ import (
 	jose "github.com/square/go-jose"
 
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/ctx"
 	"github.com/TykTechnologies/tyk/storage"
 
 	"github.com/TykTechnologies/tyk/user"
@@ -756,7 +757,21 @@ func (k *JWTMiddleware) timeValidateJWTClaims(c jwt.MapClaims) *jwt.ValidationEr
 		k.Spec.JWTNotBeforeValidationSkew)
 }
 
+func ctxSetJWTClaims(r *http.Request, claims jwt.MapClaims) {
+	setCtxValue(r, ctx.JWTClaims, claims)
+}
+
+func ctxGetJWTClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(ctx.JWTClaims).(jwt.MapClaims)
+	return claims
+}
+
 func ctxSetJWTContextVars(s *APISpec, r *http.Request, token *jwt.Token) {
+	// the claims are kept for the rate limit rules, whether the context variables are enabled or not
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		ctxSetJWTClaims(r, claims)
+	}
+
 	// Flatten claims and add to context
 	if !s.EnableContextVars {
 		return
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/request"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+var (
+	errRateLimitRuleExceeded   = errors.New("Rate limit exceeded")
+	errRateLimitRuleKeyMissing = errors.New("Rate limit key missing")
+)
+
+// burstRateLimiter is implemented by the stores evaluating the rate limit rules.
+type burstRateLimiter interface {
+	RateLimitGCRABurst(keyName string, rate float64, per time.Duration, burst int64, dryRun bool) (storage.RateLimitResult, error)
+}
+
+// RateLimitRulesMiddleware limits the requests of the clients of an API, or of an endpoint of
+// it, identified by request attributes such as their IP address, a header, a JWT claim or a
+// session metadata field. The rules are evaluated with the generic cell rate algorithm.
+type RateLimitRulesMiddleware struct {
+	BaseMiddleware
+}
+
+func (k *RateLimitRulesMiddleware) Name() string {
+	return "RateLimitRulesMiddleware"
+}
+
+func (k *RateLimitRulesMiddleware) EnabledForSpec() bool {
+	return k.Spec.RateLimitRulesEnabled && !k.Spec.DisableRateLimit
+}
+
+// rateLimitRuleKey returns the value of the attributes the rule is keyed on for the request, and
+// whether the request has all of them.
+func rateLimitRuleKey(r *http.Request, rule apidef.RateLimitRule) (string, bool) {
+	values := make([]string, len(rule.KeyBy))
+	for i, key := range rule.KeyBy {
+		switch key.Source {
+		case apidef.RateLimitKeyIP:
+			values[i] = request.RealIP(r)
+		case apidef.RateLimitKeyHeader:
+			values[i] = r.Header.Get(key.Name)
+		case apidef.RateLimitKeyClaim:
+			if claim, ok := ctxGetJWTClaims(r)[key.Name]; ok && claim != nil {
+				values[i] = fmt.Sprint(claim)
+			}
+		case apidef.RateLimitKeyMetadata:
+			if session := ctxGetSession(r); session != nil {
+				if value, ok := session.MetaData[key.Name]; ok && value != nil {
+					values[i] = fmt.Sprint(value)
+				}
+			}
+		}
+		if values[i] == "" {
+			return "", false
+		}
+	}
+	return strings.Join(values, "\x00"), true
+}
+
+// ProcessRequest checks the request against every rule of the API or of the endpoint.
+func (k *RateLimitRulesMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	// Skip rate limiting for looping
+	if !ctxCheckLimits(r) {
+		return nil, http.StatusOK
+	}
+
+	rules, scope := k.Spec.RateLimitRules, ""
+
+	versionInfo, _ := k.Spec.Version(r)
+	versionPaths := k.Spec.RxPaths[versionInfo.Name]
+	if found, meta := k.Spec.CheckSpecMatchesStatus(r, versionPaths, RateLimitRuled); found {
+		ruleMeta := meta.(*apidef.RateLimitRuleMeta)
+		rules, scope = ruleMeta.Rules, ruleMeta.Method+" "+ruleMeta.Path
+		if ruleMeta.Disabled {
+			rules = nil
+		}
+	}
+	if len(rules) == 0 {
+		return nil, http.StatusOK
+	}
+
+	limiter, ok := k.Gw.GlobalSessionManager.Store().(burstRateLimiter)
+	if !ok {
+		k.Logger().Warning("Rate limit rules aren't supported by the session store, skipping.")
+		return nil, http.StatusOK
+	}
+
+	for i, rule := range rules {
+		if rule.Rate <= 0 || rule.Per <= 0 {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		burst := rule.Burst
+		if burst <= 0 {
+			burst = int64(rule.Rate)
+		}
+
+		// requests missing a key attribute don't share a limit, which would throttle all of them
+		ruleKey, ok := rateLimitRuleKey(r, rule)
+		if !ok {
+			if rule.MissingKey == apidef.RateLimitMissingKeyReject {
+				k.Logger().WithField("rule", name).Debug("Rate limit key missing.")
+				return errRateLimitRuleKeyMissing, http.StatusBadRequest
+			}
+			continue
+		}
+
+		key := RateLimitKeyPrefix + "rule-" + k.Spec.APIID + "-" + storage.HashStr(scope+"\x00"+name+"\x00"+ruleKey)
+		per := time.Duration(rule.Per * float64(time.Second))
+		result, err := limiter.RateLimitGCRABurst(key, rule.Rate, per, burst, false)
+		if err != nil {
+			// like the other rate limiters, the rules fail open
+			continue
+		}
+
+		state := &rateLimitState{RateLimitResult: result, Rate: rule.Rate, Per: rule.Per}
+		if current := ctxGetRateLimitState(r); current == nil || !result.Allowed || result.Remaining < current.Remaining {
+			ctxSetRateLimitState(r, state)
+		}
+		if !result.Allowed {
+			setRateLimitHeaders(w, r, k.Spec, nil, sessionFailRateLimit)
+			return k.handleRateLimitFailure(r, name)
+		}
+	}
+	setRateLimitHeaders(w, r, k.Spec, nil, sessionFailNone)
+
+	return nil, http.StatusOK
+}
+
+func (k *RateLimitRulesMiddleware) handleRateLimitFailure(r *http.Request, rule string) (error, int) {
+	k.Logger().WithField("rule", rule).Info("Rate limit rule exceeded.")
+
+	k.FireEvent(EventRateLimitExceeded, EventKeyFailureMeta{
+		EventMetaDefault: EventMetaDefault{Message: "Rate Limit Rule Exceeded", OriginatingRequest: EncodeRequestToEvent(r)},
+		Path:             r.URL.Path,
+		Origin:           request.RealIP(r),
+		Key:              rule,
+	})
+
+	// Report in health check
+	reportHealthValue(k.Spec, Throttle, "-1")
+
+	return errRateLimitRuleExceeded, http.StatusTooManyRequests
+}
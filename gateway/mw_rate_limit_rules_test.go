@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// burstStore allows burst requests per key, without ever refilling.
+type burstStore struct {
+	storage.Handler
+	counts map[string]int64
+}
+
+func (s *burstStore) RateLimitGCRABurst(keyName string, _ float64, per time.Duration, burst int64, _ bool) (storage.RateLimitResult, error) {
+	if s.counts[keyName] >= burst {
+		return storage.RateLimitResult{RetryAfter: per, Reset: per}, nil
+	}
+	s.counts[keyName]++
+	return storage.RateLimitResult{Allowed: true, Remaining: burst - s.counts[keyName], Reset: per}, nil
+}
+
+func TestRateLimitRulesMiddleware(t *testing.T) {
+	store := &burstStore{counts: map[string]int64{}}
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	gw.GlobalSessionManager = &DefaultSessionManager{store: store}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}, RateLimitRulesEnabled: true}
+	spec.RateLimitRules = []apidef.RateLimitRule{
+		{Name: "ip", KeyBy: []apidef.RateLimitKey{{Source: apidef.RateLimitKeyIP}}, Rate: 2, Per: 60},
+		{Name: "tenant", KeyBy: []apidef.RateLimitKey{
+			{Source: apidef.RateLimitKeyHeader, Name: "X-Tenant"},
+			{Source: apidef.RateLimitKeyMetadata, Name: "plan"},
+		}, Rate: 10, Per: 60, Burst: 3},
+	}
+	spec.RxPaths = map[string][]URLSpec{
+		"": APIDefinitionLoader{}.compileRateLimitRulePathSpec([]apidef.RateLimitRuleMeta{
+			{Path: "/login", Method: http.MethodPost, Rules: []apidef.RateLimitRule{
+				{KeyBy: []apidef.RateLimitKey{{Source: apidef.RateLimitKeyIP}}, Rate: 1, Per: 60},
+			}},
+			{Path: "/health", Method: http.MethodGet, Disabled: true},
+		}, RateLimitRuled, config.Config{}),
+	}
+	mw := &RateLimitRulesMiddleware{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	process := func(method, path, ip, tenant string, session *user.SessionState) (*http.Request, int) {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = ip + ":1234"
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		if session != nil {
+			setCtxValue(r, ctx.SessionData, session)
+		}
+		_, code := mw.ProcessRequest(httptest.NewRecorder(), r, nil)
+		return r, code
+	}
+
+	t.Run("client ip", func(t *testing.T) {
+		r, code := process(http.MethodGet, "/", "10.0.0.1", "", nil)
+		assert.Equal(t, http.StatusOK, code)
+		if state := ctxGetRateLimitState(r); assert.NotNil(t, state) {
+			assert.Equal(t, int64(1), state.Remaining, "the most restrictive rule should be reported")
+		}
+		_, code = process(http.MethodGet, "/", "10.0.0.1", "", nil)
+		assert.Equal(t, http.StatusOK, code)
+
+		r, code = process(http.MethodGet, "/", "10.0.0.1", "", nil)
+		assert.Equal(t, http.StatusTooManyRequests, code)
+		if state := ctxGetRateLimitState(r); assert.NotNil(t, state) {
+			assert.Equal(t, time.Minute, state.RetryAfter)
+		}
+
+		_, code = process(http.MethodGet, "/", "10.0.0.2", "", nil)
+		assert.Equal(t, http.StatusOK, code, "clients should have their own limits")
+	})
+
+	t.Run("combined attributes", func(t *testing.T) {
+		gold := &user.SessionState{MetaData: map[string]interface{}{"plan": "gold"}}
+		for i, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+			_, code := process(http.MethodGet, "/", ip, "acme", gold)
+			assert.Equal(t, http.StatusOK, code, i)
+		}
+		_, code := process(http.MethodGet, "/", "10.0.1.4", "acme", gold)
+		assert.Equal(t, http.StatusTooManyRequests, code, "the burst of the rule should be enforced")
+
+		_, code = process(http.MethodGet, "/", "10.0.1.5", "acme", &user.SessionState{MetaData: map[string]interface{}{"plan": "silver"}})
+		assert.Equal(t, http.StatusOK, code, "all the attributes should key the rule")
+	})
+
+	t.Run("endpoint rules", func(t *testing.T) {
+		_, code := process(http.MethodPost, "/login", "10.0.2.1", "", nil)
+		assert.Equal(t, http.StatusOK, code)
+		_, code = process(http.MethodPost, "/login", "10.0.2.1", "", nil)
+		assert.Equal(t, http.StatusTooManyRequests, code, "the rules of the endpoint should replace the rules of the API")
+
+		for i := 0; i < 5; i++ {
+			_, code = process(http.MethodGet, "/health", "10.0.2.1", "", nil)
+			assert.Equal(t, http.StatusOK, code, "disabled endpoints shouldn't be limited")
+		}
+	})
+
+	t.Run("jwt claims and missing attributes", func(t *testing.T) {
+		rules := spec.RateLimitRules
+		defer func() { spec.RateLimitRules = rules }()
+		spec.RateLimitRules = []apidef.RateLimitRule{
+			{Name: "subject", KeyBy: []apidef.RateLimitKey{{Source: apidef.RateLimitKeyClaim, Name: "sub"}}, Rate: 1, Per: 60},
+		}
+
+		claimed := func(sub string) int {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if sub != "" {
+				// the claims are read without the context variables being enabled
+				ctxSetJWTContextVars(spec, r, &jwt.Token{Claims: jwt.MapClaims{"sub": sub}})
+			}
+			_, code := mw.ProcessRequest(httptest.NewRecorder(), r, nil)
+			return code
+		}
+		assert.Equal(t, http.StatusOK, claimed("alice"))
+		assert.Equal(t, http.StatusTooManyRequests, claimed("alice"))
+		assert.Equal(t, http.StatusOK, claimed("bob"), "clients should have their own limits")
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, claimed(""), "requests missing the claim shouldn't share a limit")
+		}
+
+		spec.RateLimitRules[0].MissingKey = apidef.RateLimitMissingKeyReject
+		assert.Equal(t, http.StatusBadRequest, claimed(""))
+		assert.Equal(t, http.StatusOK, claimed("carol"))
+	})
+}
@@ -7,7 +7,10 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/TykTechnologies/tyk/regexp"
 	"github.com/TykTechnologies/tyk/request"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
 )
 
 // RateLimitAndQuotaCheck will check the incomming request and key whether it is within it's quota and
@@ -58,6 +61,120 @@ func (k *RateLimitAndQuotaCheck) handleQuotaFailure(r *http.Request, token strin
 	return errors.New("Quota exceeded"), http.StatusForbidden
 }
 
+// forwardMessage enforces the limits of the endpoint of the session matching the request, if any,
+// then the limits of the API. The endpoint is checked first, so that requests it rejects don't
+// use up the rate limit and quota of the API. It returns the limit the request was checked
+// against: the limit of the API if it rejected the request, otherwise the limits of the endpoint
+// completed by the ones of the API.
+func (k *RateLimitAndQuotaCheck) forwardMessage(r *http.Request, session *user.SessionState, token string, store storage.Handler, dryRun bool) (sessionFailReason, *user.APILimit) {
+	reason, endpoint := k.forwardEndpointMessage(r, session, token, store, dryRun)
+	if reason != sessionFailNone {
+		return reason, k.appliedLimit(session, endpoint)
+	}
+	endpointState := ctxGetRateLimitState(r)
+
+	reason = k.Gw.SessionLimiter.ForwardMessage(
+		r,
+		session,
+		token,
+		store,
+		!k.Spec.DisableRateLimit,
+		!k.Spec.DisableQuota,
+		&k.Spec.GlobalConfig,
+		k.Spec,
+		dryRun,
+	)
+	if reason != sessionFailNone {
+		return reason, k.appliedLimit(session, nil)
+	}
+
+	if endpoint != nil && endpoint.Limit.Rate > 0 {
+		ctxSetRateLimitState(r, endpointState)
+	}
+	return reason, k.appliedLimit(session, endpoint)
+}
+
+// appliedLimit returns the limit of the API for the session, with the rate limit and the quota
+// of the endpoint instead, if set.
+func (k *RateLimitAndQuotaCheck) appliedLimit(session *user.SessionState, endpoint *user.EndpointLimit) *user.APILimit {
+	accessDef, _, err := GetAccessDefinitionByAPIIDOrSession(session, k.Spec)
+	if err != nil {
+		if endpoint != nil {
+			return &endpoint.Limit
+		}
+		return nil
+	}
+
+	limit := &accessDef.Limit
+	if endpoint == nil {
+		return limit
+	}
+	if endpoint.Limit.Rate > 0 {
+		limit.Rate, limit.Per = endpoint.Limit.Rate, endpoint.Limit.Per
+	}
+	if endpoint.Limit.QuotaMax > 0 {
+		limit.QuotaMax, limit.QuotaRemaining = endpoint.Limit.QuotaMax, endpoint.Limit.QuotaRemaining
+		limit.QuotaRenewalRate, limit.QuotaRenews = endpoint.Limit.QuotaRenewalRate, endpoint.Limit.QuotaRenews
+	}
+	return limit
+}
+
+// forwardEndpointMessage enforces the limits of the endpoint of the session matching the request.
+// It returns the endpoint, with its quota updated, if any.
+func (k *RateLimitAndQuotaCheck) forwardEndpointMessage(r *http.Request, session *user.SessionState, token string, store storage.Handler, dryRun bool) (sessionFailReason, *user.EndpointLimit) {
+	rights, ok := session.AccessRights[k.Spec.APIID]
+	if !ok {
+		return sessionFailNone, nil
+	}
+	i := matchEndpointLimit(rights.Endpoints, r)
+	if i < 0 {
+		return sessionFailNone, nil
+	}
+
+	endpoint := rights.Endpoints[i]
+	reason := k.Gw.SessionLimiter.ForwardEndpointMessage(
+		r,
+		session,
+		token,
+		&endpoint,
+		store,
+		!k.Spec.DisableRateLimit,
+		!k.Spec.DisableQuota,
+		&k.Spec.GlobalConfig,
+		k.Spec,
+		dryRun,
+	)
+
+	// keep the quota of the endpoint in the session, without changing the endpoints of the
+	// sessions sharing them
+	if endpoint.Limit != rights.Endpoints[i].Limit {
+		rights.Endpoints = append([]user.EndpointLimit(nil), rights.Endpoints...)
+		rights.Endpoints[i] = endpoint
+		session.AccessRights[k.Spec.APIID] = rights
+	}
+	return reason, &endpoint
+}
+
+// matchEndpointLimit returns the index of the first endpoint limit matching the request, or -1.
+func matchEndpointLimit(endpoints []user.EndpointLimit, r *http.Request) int {
+	for i, endpoint := range endpoints {
+		asRegex, err := regexp.Compile(endpoint.URL)
+		if err != nil {
+			log.WithError(err).Error("Regex error")
+			continue
+		}
+		if !asRegex.MatchString(r.URL.Path) {
+			continue
+		}
+		for _, method := range endpoint.Methods {
+			if method == r.Method {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
 func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
 	if ctxGetRequestStatus(r) == StatusOkAndIgnore {
@@ -73,17 +190,7 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 	token := ctxGetAuthToken(r)
 
 	storeRef := k.Gw.GlobalSessionManager.Store()
-	reason := k.Gw.SessionLimiter.ForwardMessage(
-		r,
-		session,
-		token,
-		storeRef,
-		!k.Spec.DisableRateLimit,
-		!k.Spec.DisableQuota,
-		&k.Spec.GlobalConfig,
-		k.Spec,
-		false,
-	)
+	reason, limit := k.forwardMessage(r, session, token, storeRef, false)
 
 	throttleRetryLimit := session.ThrottleRetryLimit
 	throttleInterval := session.ThrottleInterval
@@ -106,17 +213,7 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 				ctxIncThrottleLevel(r, throttleRetryLimit)
 				time.Sleep(time.Duration(throttleInterval * float64(time.Second)))
 
-				reason = k.Gw.SessionLimiter.ForwardMessage(
-					r,
-					session,
-					token,
-					storeRef,
-					!k.Spec.DisableRateLimit,
-					!k.Spec.DisableQuota,
-					&k.Spec.GlobalConfig,
-					k.Spec,
-					true,
-				)
+				reason, limit = k.forwardMessage(r, session, token, storeRef, true)
 
 				log.WithFields(logrus.Fields{
 					"middleware": "RateLimitAndQuotaCheck",
@@ -132,9 +229,11 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 				}
 			}
 		}
+		setRateLimitHeaders(w, r, k.Spec, limit, reason)
 		return err, errCode
 
 	case sessionFailQuota:
+		setRateLimitHeaders(w, r, k.Spec, limit, reason)
 		return k.handleQuotaFailure(r, token)
 	case sessionFailInternalServerError:
 		return ProxyingRequestFailedErr, http.StatusInternalServerError
@@ -142,6 +241,8 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 		// Other reason? Still not allowed
 		return errors.New("Access denied"), http.StatusForbidden
 	}
+	setRateLimitHeaders(w, r, k.Spec, limit, reason)
+
 	// Run the trigger monitor
 	if k.Spec.GlobalConfig.Monitor.MonitorUserKeys {
 		k.Gw.SessionMonitor.Check(session, token)
@@ -2,13 +2,20 @@ package gateway
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/TykTechnologies/graphql-go-tools/pkg/graphql"
 
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
 	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/test"
 	"github.com/TykTechnologies/tyk/user"
 )
@@ -193,3 +200,69 @@ func TestMwRateLimiting_DepthLimit(t *testing.T) {
 		}...)
 	})
 }
+
+// endpointLimitStore counts the requests and quota of every key in memory, without ever renewing.
+type endpointLimitStore struct {
+	storage.Handler
+	counts map[string]int64
+}
+
+func (s *endpointLimitStore) RateLimitGCRA(keyName string, rate float64, per time.Duration, _ bool) (storage.RateLimitResult, error) {
+	if float64(s.counts[keyName]) >= rate {
+		return storage.RateLimitResult{Reset: per, RetryAfter: per}, nil
+	}
+	s.counts[keyName]++
+	return storage.RateLimitResult{Allowed: true}, nil
+}
+
+func (s *endpointLimitStore) RateLimitSlidingWindow(keyName string, rate float64, per time.Duration, dryRun bool) (storage.RateLimitResult, error) {
+	return s.RateLimitGCRA(keyName, rate, per, dryRun)
+}
+
+func (s *endpointLimitStore) IncrememntWithExpire(keyName string, _ int64) int64 {
+	s.counts[keyName]++
+	return s.counts[keyName]
+}
+
+func TestRateLimitAndQuotaCheck_EndpointLimits(t *testing.T) {
+	store := &endpointLimitStore{counts: map[string]int64{}}
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	gw.GlobalSessionManager = &DefaultSessionManager{store: store}
+	gw.SessionLimiter = SessionLimiter{Gw: gw}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+	spec.GlobalConfig.RateLimitAlgorithm = apidef.RateLimitGCRA
+	mw := &RateLimitAndQuotaCheck{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	session := &user.SessionState{KeyID: "key", Rate: 100, Per: 60, AccessRights: map[string]user.AccessDefinition{
+		"api": {Endpoints: []user.EndpointLimit{
+			{URL: "^/orders$", Methods: []string{http.MethodPost}, Limit: user.APILimit{Rate: 2, Per: 60}},
+			{URL: "^/reports", Methods: []string{http.MethodGet}, Limit: user.APILimit{QuotaMax: 1, QuotaRenewalRate: 3600}},
+		}},
+	}}
+	session.SetKeyHash("hash")
+	endpoints := session.AccessRights["api"].Endpoints
+
+	process := func(method, path string) int {
+		r := httptest.NewRequest(method, path, nil)
+		setCtxValue(r, ctx.SessionData, session)
+		setCtxValue(r, ctx.AuthToken, "key")
+		_, code := mw.ProcessRequest(httptest.NewRecorder(), r, nil)
+		return code
+	}
+
+	assert.Equal(t, http.StatusOK, process(http.MethodPost, "/orders"))
+	assert.Equal(t, http.StatusOK, process(http.MethodPost, "/orders"))
+	assert.Equal(t, http.StatusTooManyRequests, process(http.MethodPost, "/orders"), "the rate limit of the endpoint should be enforced")
+	assert.Equal(t, http.StatusOK, process(http.MethodGet, "/orders"), "other methods should only be limited by the API")
+
+	assert.Equal(t, http.StatusOK, process(http.MethodGet, "/reports/1"))
+	assert.Equal(t, http.StatusForbidden, process(http.MethodGet, "/reports/2"), "the quota of the endpoint should be enforced")
+
+	reports := session.AccessRights["api"].Endpoints[1].Limit
+	assert.Equal(t, int64(0), reports.QuotaRemaining)
+	assert.NotZero(t, reports.QuotaRenews, "the quota of the endpoint should be kept in the session")
+	assert.Zero(t, endpoints[1].Limit.QuotaRenews, "the endpoints shared with other sessions shouldn't change")
+	assert.Equal(t, int64(4), store.counts[RateLimitKeyPrefix+"hash.gcra"], "the requests rejected by the endpoints shouldn't use up the limits of the API")
+}
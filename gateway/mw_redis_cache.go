@@ -2,6 +2,8 @@ package gateway
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
@@ -12,6 +14,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/TykTechnologies/tyk-pump/analytics"
@@ -33,6 +36,13 @@ type RedisCacheMiddleware struct {
 
 	store storage.Handler
 	sh    SuccessHandler
+	// next is the rest of the handler chain of the API
+	next http.Handler
+
+	// refreshing holds the keys of the expired responses refreshed in the background
+	refreshing sync.Map
+	// flights holds the requests missing the cache in flight, identical requests wait on them
+	flights requestFlights
 }
 
 func (m *RedisCacheMiddleware) Name() string {
@@ -44,7 +54,7 @@ func (m *RedisCacheMiddleware) Init() {
 }
 
 func (m *RedisCacheMiddleware) EnabledForSpec() bool {
-	return m.Spec.CacheOptions.EnableCache
+	return m.Spec.CacheOptions.EnableCache || coalescesUncachedRequests(m.Spec)
 }
 
 func (m *RedisCacheMiddleware) CreateCheckSum(req *http.Request, keyName string, regex string, additionalKeyFromHeaders string) (string, error) {
@@ -124,6 +134,11 @@ func (m *RedisCacheMiddleware) isTimeStampExpired(timestamp string) bool {
 }
 
 func (m *RedisCacheMiddleware) decodePayload(payload string) (string, string, error) {
+	return decodeCachePayload(payload)
+}
+
+// decodeCachePayload decodes a cache payload into the cached data and its expiry timestamp.
+func decodeCachePayload(payload string) (string, string, error) {
 	data := strings.Split(payload, "|")
 	switch len(data) {
 	case 1:
@@ -143,6 +158,57 @@ func (m *RedisCacheMiddleware) decodePayload(payload string) (string, string, er
 type cacheOptions struct {
 	key                    string
 	cacheOnlyResponseCodes []int
+
+	// stale is the cached response revalidated with a conditional upstream request, when
+	// following the HTTP caching semantics
+	stale *http.Response
+	// conditions are the conditional headers of the client request, replaced while the stale
+	// response is revalidated
+	conditions http.Header
+
+	httpSemantics bool
+	// staleWhileRevalidate and staleIfError are the number of seconds expired responses are
+	// served for while they are refreshed, and when the upstream fails
+	staleWhileRevalidate int64
+	staleIfError         int64
+	// errorFallback is the expired response served if the upstream fails
+	errorFallback *http.Response
+
+	// flight is the coalesced request led by the request, if any
+	flight *requestFlight
+	// coalesceOnly is set for the requests coalesced on paths that aren't cached
+	coalesceOnly bool
+
+	// indexPath and indexSession are the entries of the request in the indexes used to
+	// invalidate its cached responses, set if the API enables the invalidation indexes
+	indexPath    string
+	indexSession string
+}
+
+// staleWindow returns the number of seconds expired responses are kept for.
+func (o *cacheOptions) staleWindow() int64 {
+	if o.staleWhileRevalidate > o.staleIfError {
+		return o.staleWhileRevalidate
+	}
+	return o.staleIfError
+}
+
+// takeErrorFallback returns the expired response to serve instead of an upstream error, if any.
+func (o *cacheOptions) takeErrorFallback(r *http.Request) *http.Response {
+	res := o.errorFallback
+	if res == nil {
+		return nil
+	}
+	o.errorFallback = nil
+	if o.stale != nil {
+		o.restoreConditions(r)
+	}
+
+	res.Header.Set(cachedResponseHeader, "1")
+	if cachedNotModified(o.httpSemantics, r, res) {
+		setNotModified(res)
+	}
+	return res
 }
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
@@ -156,12 +222,15 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 	version, _ := m.Spec.Version(r)
 	versionPaths := m.Spec.RxPaths[version.Name]
 
+	// The middleware only coalesces requests if the cache is disabled
+	cacheEnabled := m.Spec.CacheOptions.EnableCache
+
 	// Lets see if we can throw a sledgehammer at this
-	if m.Spec.CacheOptions.CacheAllSafeRequests && isSafeMethod(r.Method) {
+	if cacheEnabled && m.Spec.CacheOptions.CacheAllSafeRequests && isSafeMethod(r.Method) {
 		stat = StatusCached
 	}
 
-	if stat != StatusCached {
+	if cacheEnabled && stat != StatusCached {
 		// New request checker, more targeted, less likely to fail
 		found, meta := m.Spec.CheckSpecMatchesStatus(r, versionPaths, Cached)
 		if found {
@@ -171,9 +240,13 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 		}
 	}
 	// Cached route matched, let go
+	coalesceOnly := false
 	if stat != StatusCached {
-		m.Logger().Debug("Not a cached path")
-		return nil, http.StatusOK
+		if !m.coalescesUncached(r) {
+			m.Logger().Debug("Not a cached path")
+			return nil, http.StatusOK
+		}
+		coalesceOnly = true
 	}
 	token := ctxGetAuthToken(r)
 
@@ -182,53 +255,279 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 		token = request.RealIP(r)
 	}
 
-	var retBlob string
 	key, err := m.CreateCheckSum(r, token, cacheKeyRegex, m.getCacheKeyFromHeaders(r))
 	if err != nil {
 		m.Logger().Debug("Error creating checksum. Skipping cache check")
 		return nil, http.StatusOK
 	}
 
+	if coalesceOnly {
+		options := &cacheOptions{key: key, coalesceOnly: true}
+		ctxSetCacheOptions(r, options)
+		return m.coalesce(w, r, options, t1)
+	}
+
 	cacheOnlyResponseCodes := m.Spec.CacheOptions.CacheOnlyResponseCodes
 	// override api main CacheOnlyResponseCodes by endpoint specific if provided
 	if cacheMeta != nil && len(cacheMeta.CacheOnlyResponseCodes) > 0 {
 		cacheOnlyResponseCodes = cacheMeta.CacheOnlyResponseCodes
 	}
 
-	ctxSetCacheOptions(r, &cacheOptions{
+	httpSemantics := m.Spec.CacheOptions.EnableHTTPCacheSemantics
+	if httpSemantics && parseCacheControl(r.Header).has("no-store") {
+		m.Logger().Debug("Request forbids caching")
+		return nil, http.StatusOK
+	}
+
+	options := &cacheOptions{
 		key:                    key,
 		cacheOnlyResponseCodes: cacheOnlyResponseCodes,
-	})
+		httpSemantics:          httpSemantics,
+		staleWhileRevalidate:   m.Spec.CacheOptions.StaleWhileRevalidate,
+		staleIfError:           m.Spec.CacheOptions.StaleIfError,
+	}
+	if m.Spec.CacheOptions.EnableInvalidationIndexes {
+		options.indexPath = m.cacheIndexPath(r)
+		if authToken := ctxGetAuthToken(r); authToken != "" {
+			options.indexSession = storage.HashKey(authToken, m.Gw.GetConfig().HashKeys)
+		}
+	}
+	// override api stale windows by endpoint specific if provided
+	if cacheMeta != nil && cacheMeta.StaleWhileRevalidate > 0 {
+		options.staleWhileRevalidate = cacheMeta.StaleWhileRevalidate
+	}
+	if cacheMeta != nil && cacheMeta.StaleIfError > 0 {
+		options.staleIfError = cacheMeta.StaleIfError
+	}
+	ctxSetCacheOptions(r, options)
 
-	retBlob, err = m.store.GetKey(key)
+	var code int
+	if httpSemantics {
+		err, code = m.processHTTPCache(w, r, options, t1)
+	} else {
+		err, code = m.processCache(w, r, options, t1)
+	}
+	if code != http.StatusOK {
+		return err, code
+	}
+	return m.coalesce(w, r, options, t1)
+}
+
+// processCache serves the cached response to the request if it hasn't expired.
+func (m *RedisCacheMiddleware) processCache(w http.ResponseWriter, r *http.Request, options *cacheOptions, t1 time.Time) (error, int) {
+	key := options.key
+	cachedData, timestamp, err := m.getCachePayload(key)
 	if err != nil {
 		// Record not found, continue with the middleware chain
 		return nil, http.StatusOK
 	}
 
-	cachedData, timestamp, err := m.decodePayload(retBlob)
-	if err != nil {
-		// Tere was an issue with this cache entry - lets remove it:
+	if len(cachedData) == 0 {
 		m.store.DeleteKey(key)
 		return nil, http.StatusOK
 	}
 
-	if m.isTimeStampExpired(timestamp) || len(cachedData) == 0 {
+	newRes, err := m.readCachedResponse(cachedData, r)
+	if err != nil {
+		m.Logger().WithError(err).Error("Could not create response object")
 		m.store.DeleteKey(key)
 		return nil, http.StatusOK
 	}
 
-	bufData := bufio.NewReader(strings.NewReader(cachedData))
-	newRes, err := http.ReadResponse(bufData, r)
+	if m.isTimeStampExpired(timestamp) {
+		expiredAt, _ := strconv.ParseInt(timestamp, 10, 64)
+		if m.handleExpired(r, options, newRes, time.Now().Unix()-expiredAt, "") {
+			return m.writeCachedResponse(w, r, newRes, t1)
+		}
+		if options.errorFallback == nil {
+			m.store.DeleteKey(key)
+		}
+		return nil, http.StatusOK
+	}
+
+	return m.writeCachedResponse(w, r, newRes, t1)
+}
+
+func (m *RedisCacheMiddleware) readCachedResponse(cachedData string, r *http.Request) (*http.Response, error) {
+	newRes, err := http.ReadResponse(bufio.NewReader(strings.NewReader(cachedData)), r)
+	if err != nil {
+		return nil, err
+	}
+	nopCloseResponseBody(newRes)
+	return newRes, nil
+}
+
+// handleExpired reports whether an expired response is served, within the stale-while-revalidate
+// window, while it is refreshed in the background. Otherwise it is kept to be served if the
+// upstream fails within the stale-if-error window.
+func (m *RedisCacheMiddleware) handleExpired(r *http.Request, options *cacheOptions, newRes *http.Response, staleFor int64, cachedData string) bool {
+	if options.staleWhileRevalidate > 0 && staleFor <= options.staleWhileRevalidate {
+		m.refresh(r, options, cachedData)
+		return true
+	}
+	if options.staleIfError > 0 && staleFor <= options.staleIfError {
+		options.errorFallback = newRes
+	}
+	return false
+}
+
+// refresh refreshes an expired response in the background through the rest of the handler chain,
+// with a single request per key at a time. Responses following the HTTP caching semantics are
+// revalidated with a conditional request.
+func (m *RedisCacheMiddleware) refresh(r *http.Request, options *cacheOptions, cachedData string) {
+	if _, refreshing := m.refreshing.LoadOrStore(options.key, struct{}{}); refreshing {
+		return
+	}
+
+	refreshReq := r.Clone(context.WithoutCancel(r.Context()))
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := readBody(r)
+		if err != nil {
+			m.refreshing.Delete(options.key)
+			m.Logger().WithError(err).Error("Could not refresh expired response")
+			return
+		}
+		refreshReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	refreshOptions := &cacheOptions{
+		key:                    options.key,
+		cacheOnlyResponseCodes: options.cacheOnlyResponseCodes,
+		httpSemantics:          options.httpSemantics,
+		staleWhileRevalidate:   options.staleWhileRevalidate,
+		staleIfError:           options.staleIfError,
+		indexPath:              options.indexPath,
+		indexSession:           options.indexSession,
+	}
+	if options.httpSemantics {
+		if stale, err := m.readCachedResponse(cachedData, refreshReq); err == nil && hasValidators(stale.Header) {
+			refreshOptions.revalidate(refreshReq, stale)
+		}
+	}
+	ctxSetCacheOptions(refreshReq, refreshOptions)
+	ctxSetDoNotTrack(refreshReq, true)
+	// the session was updated by the request served the expired response
+	ctxDisableSessionUpdate(refreshReq)
+
+	go func() {
+		defer m.refreshing.Delete(options.key)
+		m.next.ServeHTTP(&discardResponseWriter{header: http.Header{}}, refreshReq)
+	}()
+}
+
+// discardResponseWriter discards the responses to background requests.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+// cachedNotModified evaluates the conditional headers of the request against a cached response.
+func cachedNotModified(httpSemantics bool, r *http.Request, res *http.Response) bool {
+	if httpSemantics {
+		return notModified(r, res)
+	}
+
+	reqEtag, respEtag := r.Header.Get("If-None-Match"), res.Header.Get("Etag")
+	return reqEtag != "" && respEtag != "" && strings.Contains(reqEtag, respEtag)
+}
+
+// processHTTPCache serves the cached response to the request if it is fresh, following the
+// HTTP caching semantics. Stale responses with validators are revalidated by the upstream.
+func (m *RedisCacheMiddleware) processHTTPCache(w http.ResponseWriter, r *http.Request, options *cacheOptions, t1 time.Time) (error, int) {
+	cachedData, timestamp, err := m.getHTTPCacheEntry(r, options.key)
+	if err != nil || len(cachedData) == 0 {
+		return nil, http.StatusOK
+	}
+
+	newRes, err := m.readCachedResponse(cachedData, r)
 	if err != nil {
 		m.Logger().WithError(err).Error("Could not create response object")
-		m.store.DeleteKey(key)
 		return nil, http.StatusOK
 	}
 
-	nopCloseResponseBody(newRes)
+	// the age is derived from the freshness lifetime, as the entry is fresh until
+	// storage time + lifetime - initial age
+	freshUntil, _ := strconv.ParseInt(timestamp, 10, 64)
+	now := time.Now().Unix()
+	lifetime := httpCacheLifetime(newRes.Header, parseCacheControl(newRes.Header), m.Spec.CacheOptions.CacheTimeout)
+	age := lifetime - (freshUntil - now)
+	if age < 0 {
+		age = 0
+	}
 
+	newRes.Header.Set("Age", strconv.FormatInt(age, 10))
+	if now < freshUntil && httpCacheAcceptsAge(r, age) {
+		return m.writeCachedResponse(w, r, newRes, t1)
+	}
+
+	// shared caches must not serve responses requiring revalidation once stale
+	cc := parseCacheControl(newRes.Header)
+	mustRevalidate := cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage") || cc.has("no-cache")
+	if now >= freshUntil && !mustRevalidate && m.handleExpired(r, options, newRes, now-freshUntil, cachedData) {
+		return m.writeCachedResponse(w, r, newRes, t1)
+	}
+
+	if hasValidators(newRes.Header) {
+		options.revalidate(r, newRes)
+	}
+	return nil, http.StatusOK
+}
+
+// getHTTPCacheEntry returns the cached response to the request, looking it up by the request
+// headers the responses vary on.
+func (m *RedisCacheMiddleware) getHTTPCacheEntry(r *http.Request, key string) (string, string, error) {
+	cachedData, timestamp, err := m.getCachePayload(key)
+	if err != nil || !strings.HasPrefix(cachedData, httpCacheVaryPrefix) {
+		return cachedData, timestamp, err
+	}
+
+	var vary []string
+	if names := strings.TrimPrefix(cachedData, httpCacheVaryPrefix); names != "" {
+		vary = strings.Split(names, ",")
+	}
+	return m.getCachePayload(httpCacheVariantKey(key, vary, r))
+}
+
+// getCachePayload returns the cached data and its expiry timestamp, from the memory of the node
+// if the local response cache holds them. Entries that can't be decoded are removed.
+func (m *RedisCacheMiddleware) getCachePayload(key string) (string, string, error) {
+	var (
+		cachedData, timestamp string
+		err                   error
+	)
+	if local, ok := m.store.(*localCacheStore); ok {
+		cachedData, timestamp, err = local.GetPayload(key)
+	} else {
+		var retBlob string
+		if retBlob, err = m.store.GetKey(key); err != nil {
+			return "", "", err
+		}
+		cachedData, timestamp, err = m.decodePayload(retBlob)
+	}
+
+	if err != nil && err != storage.ErrKeyNotFound {
+		// Tere was an issue with this cache entry - lets remove it:
+		m.store.DeleteKey(key)
+	}
+	return cachedData, timestamp, err
+}
+
+// writeCachedResponse writes a cached response to the client.
+func (m *RedisCacheMiddleware) writeCachedResponse(w http.ResponseWriter, r *http.Request, newRes *http.Response, t1 time.Time) (error, int) {
 	defer newRes.Body.Close()
+	if cachedNotModified(m.Spec.CacheOptions.EnableHTTPCacheSemantics, r, newRes) {
+		newRes.StatusCode = http.StatusNotModified
+	}
+
 	for _, h := range hopHeaders {
 		newRes.Header.Del(h)
 	}
@@ -246,14 +545,6 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 
 	copyHeader(w.Header(), newRes.Header, m.Gw.GetConfig().IgnoreCanonicalMIMEHeaderKey)
 
-	if reqEtag := r.Header.Get("If-None-Match"); reqEtag != "" {
-		if respEtag := newRes.Header.Get("Etag"); respEtag != "" {
-			if strings.Contains(reqEtag, respEtag) {
-				newRes.StatusCode = http.StatusNotModified
-			}
-		}
-	}
-
 	w.WriteHeader(newRes.StatusCode)
 	if newRes.StatusCode != http.StatusNotModified {
 		m.Proxy.CopyResponse(w, newRes.Body, 0)
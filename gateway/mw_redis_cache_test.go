@@ -1,21 +1,34 @@
 package gateway
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"html/template"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/justinas/alice"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/TykTechnologies/tyk-pump/analytics"
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/dnscache"
+	"github.com/TykTechnologies/tyk/header"
 	"github.com/TykTechnologies/tyk/test"
 )
 
@@ -251,3 +264,448 @@ func Test_addBodyHash(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisCacheMiddleware_Stale(t *testing.T) {
+	var upstreamMu sync.Mutex
+	upstreamStatus, upstreamBody, upstreamDelay := http.StatusOK, "v1", time.Duration(0)
+	var upstreamRequests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamRequests, 1)
+		upstreamMu.Lock()
+		status, body, delay := upstreamStatus, upstreamBody, upstreamDelay
+		upstreamMu.Unlock()
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+	setUpstream := func(status int, body string, delay time.Duration) {
+		upstreamMu.Lock()
+		defer upstreamMu.Unlock()
+		upstreamStatus, upstreamBody, upstreamDelay = status, body, delay
+	}
+
+	gw := &Gateway{dnsCacheManager: dnscache.NewDnsCacheManager(config.NoCacheStrategy)}
+	gw.SetConfig(config.Config{})
+	gw.templates = template.Must(template.New("error.json").Parse(`{"error": "{{.Message}}"}`))
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", DoNotTrack: true}}
+	spec.Proxy.TargetURL = upstream.URL
+	spec.CacheOptions = apidef.CacheOptions{
+		EnableCache:          true,
+		CacheAllSafeRequests: true,
+		CacheTimeout:         60,
+		StaleWhileRevalidate: 10,
+		StaleIfError:         30,
+	}
+
+	store := newMemCacheStore()
+	logger := logrus.NewEntry(log)
+	target, _ := url.Parse(upstream.URL)
+	proxy := gw.TykNewSingleHostReverseProxy(target, spec, logger)
+	base := BaseMiddleware{Spec: spec, Proxy: proxy, Gw: gw, logger: logger}
+	proxy.ErrorHandler = ErrorHandler{BaseMiddleware: base}
+	mw := &RedisCacheMiddleware{BaseMiddleware: base, store: store}
+	mw.Init()
+	mw.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTPForCache(w, r)
+	})
+	resMW := &ResponseCacheMiddleware{store: store}
+	assert.NoError(t, resMW.Init(nil, spec))
+	spec.ResponseChain = []TykResponseHandler{resMW}
+
+	do := func() *http.Response {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		w := httptest.NewRecorder()
+		if _, code := mw.ProcessRequest(w, r, nil); code != mwStatusRespond {
+			proxy.ServeHTTPForCache(w, r)
+		}
+		return w.Result()
+	}
+	// expire moves the expiry of the cached response to the given number of seconds ago
+	expire := func(seconds int64) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return store.len() == 1
+		}, time.Second, 5*time.Millisecond)
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		for key, value := range store.entries {
+			data, _, err := mw.decodePayload(value)
+			assert.NoError(t, err)
+			store.entries[key] = resMW.encodePayload(data, time.Now().Unix()-seconds)
+			assert.Equal(t, int64(60+30), store.ttls[key], "responses should be kept while they can be served stale")
+		}
+	}
+	body := func(res *http.Response) string {
+		t.Helper()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "v1", body(do()))
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		expire(5)
+		setUpstream(http.StatusOK, "v2", 50*time.Millisecond)
+		atomic.StoreInt32(&upstreamRequests, 0)
+
+		for i := 0; i < 3; i++ {
+			res := do()
+			assert.Equal(t, "v1", body(res), "the expired response should be served while it is refreshed")
+			assert.Equal(t, "1", res.Header.Get(cachedResponseHeader))
+		}
+
+		assert.Eventually(t, func() bool {
+			return body(do()) == "v2"
+		}, time.Second, 10*time.Millisecond, "the response should be refreshed in the background")
+		assert.Equal(t, int32(1), atomic.LoadInt32(&upstreamRequests), "a single refresh should run")
+	})
+
+	t.Run("stale if error", func(t *testing.T) {
+		expire(20)
+		setUpstream(http.StatusBadGateway, "error", 0)
+
+		res := do()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "v2", body(res), "the expired response should be served on upstream errors")
+		assert.Equal(t, "1", res.Header.Get(cachedResponseHeader))
+
+		upstream.Close()
+		res = do()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "v2", body(res), "the expired response should be served when the upstream is down")
+
+		expire(40)
+		res = do()
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode, "responses expired past the windows shouldn't be served")
+		assert.Zero(t, store.len())
+	})
+}
+
+// loadCacheChain loads the cache middlewares of the API, followed by the virtual endpoint and
+// request signing middlewares as in the handler chain of the API.
+func loadCacheChain(t *testing.T, gw *Gateway, def *apidef.APIDefinition) (*RedisCacheMiddleware, *ResponseCacheMiddleware, *memCacheStore) {
+	t.Helper()
+	def.APIID = "api"
+	def.DoNotTrack = true
+	def.Proxy.ListenPath = "/"
+	spec := (APIDefinitionLoader{Gw: gw}).MakeSpec(&nestedApiDefinition{APIDefinition: def}, nil)
+	spec.target, _ = url.Parse(def.Proxy.TargetURL)
+
+	store := newMemCacheStore()
+	logger := logrus.NewEntry(log)
+	proxy := gw.TykNewSingleHostReverseProxy(spec.target, spec, logger)
+	base := BaseMiddleware{Spec: spec, Proxy: proxy, Gw: gw, logger: logger}
+	proxy.ErrorHandler = ErrorHandler{BaseMiddleware: base}
+	mw := &RedisCacheMiddleware{BaseMiddleware: base, store: store}
+	mw.Init()
+	mw.next = alice.New(gw.mwList(
+		&VirtualEndpoint{BaseMiddleware: base},
+		&RequestSigning{BaseMiddleware: base},
+	)...).Then(&DummyProxyHandler{SH: SuccessHandler{base}, Gw: gw})
+	resMW := &ResponseCacheMiddleware{store: store}
+	assert.NoError(t, resMW.Init(nil, spec))
+	spec.ResponseChain = []TykResponseHandler{resMW}
+	return mw, resMW, store
+}
+
+// virtualEndpointVersions returns the versions of an API serving GET /resource with a virtual endpoint.
+func virtualEndpointVersions(functionName, js string) apidef.VersionData {
+	return apidef.VersionData{NotVersioned: true, Versions: map[string]apidef.VersionInfo{
+		"Default": {UseExtendedPaths: true, ExtendedPaths: apidef.ExtendedPathsSet{
+			Virtual: []apidef.VirtualMeta{{
+				ResponseFunctionName: functionName,
+				FunctionSourceType:   apidef.UseBlob,
+				FunctionSourceURI:    base64.StdEncoding.EncodeToString([]byte(js)),
+				Path:                 "/resource",
+				Method:               http.MethodGet,
+			}},
+		}},
+	}}
+}
+
+func TestRedisCacheMiddleware_RefreshThroughChain(t *testing.T) {
+	var upstreamRequests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&upstreamRequests, 1)
+		if !strings.HasPrefix(r.Header.Get(header.Authorization), "Signature ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "unsigned")
+			return
+		}
+		io.WriteString(w, "signed "+strconv.Itoa(int(n)))
+	}))
+	defer upstream.Close()
+
+	gw := &Gateway{dnsCacheManager: dnscache.NewDnsCacheManager(config.NoCacheStrategy)}
+	gw.SetConfig(config.Config{EnableJSVM: true})
+	gw.templates = template.Must(template.New("error.json").Parse(`{"error": "{{.Message}}"}`))
+
+	// load returns a function sending requests through the cache middleware and the middlewares
+	// after it, and a function expiring the cached responses by the given number of seconds
+	load := func(t *testing.T, def *apidef.APIDefinition) (func() *http.Response, func(int64)) {
+		t.Helper()
+		def.Proxy.TargetURL = upstream.URL
+		def.CacheOptions = apidef.CacheOptions{
+			EnableCache:          true,
+			CacheAllSafeRequests: true,
+			CacheTimeout:         60,
+			StaleWhileRevalidate: 10,
+		}
+		mw, resMW, store := loadCacheChain(t, gw, def)
+
+		do := func() *http.Response {
+			t.Helper()
+			r := httptest.NewRequest(http.MethodGet, "/resource", nil)
+			w := httptest.NewRecorder()
+			if _, code := mw.ProcessRequest(w, r, nil); code != mwStatusRespond {
+				mw.next.ServeHTTP(w, r)
+			}
+			return w.Result()
+		}
+		expire := func(seconds int64) {
+			t.Helper()
+			assert.Eventually(t, func() bool {
+				return store.len() == 1
+			}, time.Second, 5*time.Millisecond)
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			for key, value := range store.entries {
+				data, _, err := mw.decodePayload(value)
+				assert.NoError(t, err)
+				store.entries[key] = resMW.encodePayload(data, time.Now().Unix()-seconds)
+			}
+		}
+		return do, expire
+	}
+	body := func(res *http.Response) string {
+		data, _ := ioutil.ReadAll(res.Body)
+		return string(data)
+	}
+
+	t.Run("virtual endpoint", func(t *testing.T) {
+		js := `
+function virtualResource(request, session, config) {
+	return TykJsResponse({Body: "virtual " + Date.now(), Code: 200}, session.meta_data)
+}`
+		def := &apidef.APIDefinition{VersionData: virtualEndpointVersions("virtualResource", js)}
+		do, expire := load(t, def)
+		atomic.StoreInt32(&upstreamRequests, 0)
+
+		cached := body(do())
+		assert.True(t, strings.HasPrefix(cached, "virtual "))
+		expire(5)
+		assert.Equal(t, cached, body(do()), "the expired response should be served while it is refreshed")
+		assert.Eventually(t, func() bool {
+			refreshed := body(do())
+			return refreshed != cached && strings.HasPrefix(refreshed, "virtual ")
+		}, time.Second, 10*time.Millisecond, "the response should be refreshed by the virtual endpoint")
+		assert.Zero(t, atomic.LoadInt32(&upstreamRequests), "the refresh shouldn't be proxied upstream")
+	})
+
+	t.Run("request signing", func(t *testing.T) {
+		def := &apidef.APIDefinition{
+			VersionData: apidef.VersionData{NotVersioned: true, Versions: map[string]apidef.VersionInfo{"Default": {}}},
+			RequestSigning: apidef.RequestSigningMeta{
+				IsEnabled: true,
+				Secret:    "secret",
+				KeyId:     "key",
+				Algorithm: "hmac-sha256",
+			},
+		}
+		do, expire := load(t, def)
+		atomic.StoreInt32(&upstreamRequests, 0)
+
+		assert.Equal(t, "signed 1", body(do()))
+		expire(5)
+		assert.Equal(t, "signed 1", body(do()), "the expired response should be served while it is refreshed")
+		assert.Eventually(t, func() bool {
+			return body(do()) == "signed 2"
+		}, time.Second, 10*time.Millisecond, "the refresh should be signed")
+	})
+}
+
+func TestRedisCacheMiddleware_Coalescing(t *testing.T) {
+	var upstreamRequests int32
+	upstreamDelay := 100 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&upstreamRequests, 1)
+		time.Sleep(upstreamDelay)
+		w.Header().Set("Vary", "Accept")
+		io.WriteString(w, "response "+strconv.Itoa(int(n)))
+	}))
+	defer upstream.Close()
+
+	gw := &Gateway{dnsCacheManager: dnscache.NewDnsCacheManager(config.NoCacheStrategy)}
+	gw.SetConfig(config.Config{EnableJSVM: true})
+	gw.templates = template.Must(template.New("error.json").Parse(`{"error": "{{.Message}}"}`))
+
+	// serveConcurrently sends the requests at once through the cache middleware and the rest of
+	// the chain, and returns the bodies of their responses
+	serveConcurrently := func(mw *RedisCacheMiddleware, requests ...*http.Request) []string {
+		atomic.StoreInt32(&upstreamRequests, 0)
+		bodies := make([]string, len(requests))
+		var wg sync.WaitGroup
+		for i, r := range requests {
+			wg.Add(1)
+			go func(i int, r *http.Request) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				if _, code := mw.ProcessRequest(w, r, nil); code != mwStatusRespond {
+					mw.next.ServeHTTP(w, r)
+				}
+				bodies[i] = w.Body.String()
+			}(i, r)
+		}
+		wg.Wait()
+		return bodies
+	}
+	// doConcurrently sends the requests at once to the upstream, and returns the bodies of their responses
+	doConcurrently := func(t *testing.T, cacheOptions apidef.CacheOptions, requests ...*http.Request) []string {
+		t.Helper()
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", DoNotTrack: true}}
+		spec.Proxy.TargetURL = upstream.URL
+		spec.CacheOptions = cacheOptions
+
+		store := newMemCacheStore()
+		logger := logrus.NewEntry(log)
+		target, _ := url.Parse(upstream.URL)
+		proxy := gw.TykNewSingleHostReverseProxy(target, spec, logger)
+		base := BaseMiddleware{Spec: spec, Proxy: proxy, Gw: gw, logger: logger}
+		proxy.ErrorHandler = ErrorHandler{BaseMiddleware: base}
+		mw := &RedisCacheMiddleware{BaseMiddleware: base, store: store}
+		mw.Init()
+		mw.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxy.ServeHTTPForCache(w, r)
+		})
+		resMW := &ResponseCacheMiddleware{store: store}
+		assert.NoError(t, resMW.Init(nil, spec))
+		spec.ResponseChain = []TykResponseHandler{resMW}
+
+		return serveConcurrently(mw, requests...)
+	}
+	get := func(accept string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		r.Header.Set("Accept", accept)
+		return r
+	}
+
+	t.Run("cached requests", func(t *testing.T) {
+		bodies := doConcurrently(t, apidef.CacheOptions{
+			EnableCache:             true,
+			CacheAllSafeRequests:    true,
+			CacheTimeout:            60,
+			EnableRequestCoalescing: true,
+		}, get("text/plain"), get("text/plain"), get("text/plain"), get("text/plain"))
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&upstreamRequests), "a single request should be sent upstream")
+		for _, body := range bodies {
+			assert.Equal(t, "response 1", body)
+		}
+	})
+
+	t.Run("uncached requests", func(t *testing.T) {
+		options := apidef.CacheOptions{
+			EnableRequestCoalescing:  true,
+			CoalesceUncachedRequests: true,
+		}
+
+		doConcurrently(t, options, get("text/plain"), get("text/plain"), get("text/plain"))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&upstreamRequests), "safe requests should be coalesced without cache")
+
+		bodies := doConcurrently(t, options, get("text/plain"), get("text/plain"), get("application/json"))
+		assert.NotEqual(t, bodies[0], bodies[2], "responses shouldn't be shared across variants")
+		assert.NotEqual(t, bodies[1], bodies[2], "responses shouldn't be shared across variants")
+
+		post := func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/resource", strings.NewReader("body"))
+		}
+		doConcurrently(t, options, post(), post(), post())
+		assert.Equal(t, int32(3), atomic.LoadInt32(&upstreamRequests), "unsafe requests shouldn't be coalesced")
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		doConcurrently(t, apidef.CacheOptions{
+			EnableRequestCoalescing:  true,
+			CoalesceUncachedRequests: true,
+			CoalescingMaxWait:        0.01,
+		}, get("text/plain"), get("text/plain"), get("text/plain"))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&upstreamRequests), "requests should be sent upstream after the max wait")
+	})
+
+	t.Run("responses of middlewares", func(t *testing.T) {
+		cacheOptions := apidef.CacheOptions{
+			EnableCache:             true,
+			CacheAllSafeRequests:    true,
+			CacheTimeout:            60,
+			EnableRequestCoalescing: true,
+		}
+
+		t.Run("virtual endpoint", func(t *testing.T) {
+			js := `
+function slowResource(request, session, config) {
+	var start = Date.now();
+	while (Date.now() - start < 100) {}
+	return TykJsResponse({Body: "virtual " + Math.random(), Code: 200}, session.meta_data)
+}`
+			mw, _, _ := loadCacheChain(t, gw, &apidef.APIDefinition{
+				Proxy:        apidef.ProxyConfig{TargetURL: upstream.URL},
+				CacheOptions: cacheOptions,
+				VersionData:  virtualEndpointVersions("slowResource", js),
+			})
+
+			start := time.Now()
+			bodies := serveConcurrently(mw, get("text/plain"), get("text/plain"), get("text/plain"), get("text/plain"))
+			assert.Less(t, time.Since(start), time.Second, "coalesced requests shouldn't wait for the max wait")
+			assert.Zero(t, atomic.LoadInt32(&upstreamRequests), "coalesced requests shouldn't be sent upstream")
+			for _, body := range bodies {
+				assert.Equal(t, bodies[0], body, "the response of the virtual endpoint should be served to all the requests")
+			}
+		})
+
+		t.Run("plugin", func(t *testing.T) {
+			mw, _, _ := loadCacheChain(t, gw, &apidef.APIDefinition{
+				Proxy:        apidef.ProxyConfig{TargetURL: upstream.URL},
+				CacheOptions: cacheOptions,
+				VersionData:  apidef.VersionData{NotVersioned: true, Versions: map[string]apidef.VersionInfo{"Default": {}}},
+			})
+			// the middlewares responding without the response chain, like Go plugins
+			var pluginRequests int32
+			mw.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&pluginRequests, 1)
+				time.Sleep(100 * time.Millisecond)
+				io.WriteString(w, "plugin "+strconv.Itoa(int(n)))
+			})
+
+			start := time.Now()
+			bodies := serveConcurrently(mw, get("text/plain"), get("text/plain"), get("text/plain"), get("text/plain"))
+			assert.Less(t, time.Since(start), time.Second, "coalesced requests shouldn't wait for the max wait")
+			assert.Equal(t, int32(1), atomic.LoadInt32(&pluginRequests), "a single request should be served by the plugin")
+			for _, body := range bodies {
+				assert.Equal(t, "plugin 1", body)
+			}
+		})
+	})
+
+	t.Run("cancelled requests", func(t *testing.T) {
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+		spec.CacheOptions = apidef.CacheOptions{EnableRequestCoalescing: true}
+		mw := &RedisCacheMiddleware{BaseMiddleware: BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+		flight, leader := mw.flights.join("resource", time.Minute)
+		assert.True(t, leader)
+
+		reqCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := get("text/plain").WithContext(reqCtx)
+		_, code := mw.coalesce(httptest.NewRecorder(), r, &cacheOptions{key: "resource"}, time.Now())
+		assert.Equal(t, mwStatusRespond, code, "requests of gone clients shouldn't be sent upstream")
+
+		flight.complete(nil, nil)
+		assert.False(t, flight.timer.Stop(), "the max wait of completed flights should be stopped")
+	})
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	defaultOutlierConsecutiveFailures = 5
+	defaultOutlierBaseEjectionTime    = 30
+	defaultOutlierMaxEjectionTime     = 300
+	defaultOutlierMaxEjectionPercent  = 50
+)
+
+// OutlierDetector tracks the outcome of proxied requests per upstream host and
+// ejects hosts from load balancing after consecutive failures.
+type OutlierDetector struct {
+	mu    sync.Mutex
+	hosts map[string]*outlierHost
+}
+
+type outlierHost struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+	returnedAt   time.Time
+}
+
+// outlierEvent is the state transition of a host caused by a request outcome or by time passing.
+type outlierEvent int
+
+const (
+	outlierNoChange outlierEvent = iota
+	outlierEjected
+	outlierReturned
+)
+
+func outlierConfig(conf apidef.OutlierDetectionConfig) apidef.OutlierDetectionConfig {
+	if conf.ConsecutiveFailures <= 0 {
+		conf.ConsecutiveFailures = defaultOutlierConsecutiveFailures
+	}
+	if conf.BaseEjectionTime <= 0 {
+		conf.BaseEjectionTime = defaultOutlierBaseEjectionTime
+	}
+	if conf.MaxEjectionTime <= 0 {
+		conf.MaxEjectionTime = defaultOutlierMaxEjectionTime
+	}
+	if conf.MaxEjectionPercent <= 0 {
+		conf.MaxEjectionPercent = defaultOutlierMaxEjectionPercent
+	}
+	return conf
+}
+
+func (o *OutlierDetector) host(host string) *outlierHost {
+	if o.hosts == nil {
+		o.hosts = make(map[string]*outlierHost)
+	}
+	h, ok := o.hosts[host]
+	if !ok {
+		h = &outlierHost{}
+		o.hosts[host] = h
+	}
+	return h
+}
+
+// Ejected reports whether host is currently ejected. The returned event is
+// outlierReturned when the ejection of the host has just expired.
+func (o *OutlierDetector) Ejected(host string, now time.Time) (bool, outlierEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	h, ok := o.hosts[host]
+	if !ok || h.ejectedUntil.IsZero() {
+		return false, outlierNoChange
+	}
+
+	if now.Before(h.ejectedUntil) {
+		return true, outlierNoChange
+	}
+
+	h.ejectedUntil = time.Time{}
+	h.returnedAt = now
+	return false, outlierReturned
+}
+
+// Report records the outcome of a request to host. totalHosts is the number of
+// targets the host was selected from, used to enforce the max ejection percent.
+func (o *OutlierDetector) Report(host string, failed bool, totalHosts int, conf apidef.OutlierDetectionConfig, now time.Time) outlierEvent {
+	conf = outlierConfig(conf)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	h := o.host(host)
+	if !failed {
+		h.failures = 0
+		// forget past ejections once the host has been healthy for a while
+		maxEjection := time.Duration(conf.MaxEjectionTime) * time.Second
+		if h.ejections > 0 && h.ejectedUntil.IsZero() && now.Sub(h.returnedAt) > maxEjection {
+			h.ejections = 0
+		}
+		return outlierNoChange
+	}
+
+	h.failures++
+	if h.failures < conf.ConsecutiveFailures || !h.ejectedUntil.IsZero() {
+		return outlierNoChange
+	}
+
+	ejected := 0
+	for _, other := range o.hosts {
+		if !other.ejectedUntil.IsZero() && now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > totalHosts*conf.MaxEjectionPercent {
+		return outlierNoChange
+	}
+
+	ejectionTime := time.Duration(conf.BaseEjectionTime) * time.Second << uint(h.ejections)
+	if maxEjection := time.Duration(conf.MaxEjectionTime) * time.Second; ejectionTime > maxEjection || ejectionTime <= 0 {
+		ejectionTime = maxEjection
+	}
+
+	h.failures = 0
+	h.ejections++
+	h.ejectedUntil = now.Add(ejectionTime)
+	return outlierEjected
+}
+
+// outlierEjected reports whether the outlier detection has ejected the target,
+// firing the host up event if the ejection just expired.
+func (s *APISpec) outlierEjected(target string) bool {
+	if !s.Proxy.OutlierDetection.Enabled {
+		return false
+	}
+
+	ejected, event := s.OutlierDetector.Ejected(upstreamHost(target), time.Now())
+	if event == outlierReturned {
+		s.fireOutlierEvent(EventHOSTUP, "Outlier ejection expired", target, 0, false)
+	}
+	return ejected
+}
+
+// reportUpstreamOutcome feeds the result of a proxied request into the outlier detection.
+func (p *ReverseProxy) reportUpstreamOutcome(outreq *http.Request, res *http.Response, err error) {
+	spec := p.TykAPISpec
+
+	hostList := spec.upstreamHostList(outreq)
+	if hostList == nil {
+		return
+	}
+
+	var failed, tcpError bool
+	var code int
+	switch {
+	case err != nil:
+		// the client going away or a mock response doesn't say anything about the upstream
+		if strings.Contains(err.Error(), "context canceled") || strings.HasPrefix(err.Error(), "mock:") {
+			return
+		}
+		failed, tcpError = true, true
+	case res != nil:
+		code = res.StatusCode
+		failed = code/100 == 5
+	}
+
+	event := spec.OutlierDetector.Report(outreq.URL.Host, failed, hostList.Len(), spec.Proxy.OutlierDetection, time.Now())
+	if event == outlierEjected {
+		target := outreq.URL.Scheme + "://" + outreq.URL.Host
+		p.logger.WithFields(logrus.Fields{
+			"prefix": "proxy",
+			"api_id": spec.APIID,
+		}).Warning("[OUTLIER DETECTION] Ejecting host: ", target)
+		spec.fireOutlierEvent(EventHOSTDOWN, "Outlier detection ejected host", target, code, tcpError)
+	}
+}
+
+func (s *APISpec) fireOutlierEvent(name apidef.TykEvent, message, target string, code int, tcpError bool) {
+	s.FireEvent(name, EventHostStatusMeta{
+		EventMetaDefault: EventMetaDefault{Message: message},
+		HostInfo: HostHealthReport{
+			HostData: HostData{
+				CheckURL: target,
+				MetaData: map[string]string{UnHealthyHostMetaDataAPIKey: s.APIID},
+			},
+			ResponseCode: code,
+			IsTCPError:   tcpError,
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	conf := apidef.OutlierDetectionConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    10,
+		MaxEjectionTime:     25,
+	}
+	now := time.Now()
+
+	t.Run("ejects after consecutive failures with exponential ejection time", func(t *testing.T) {
+		o := &OutlierDetector{}
+		assert.Equal(t, outlierNoChange, o.Report("a", true, 4, conf, now))
+		assert.Equal(t, outlierNoChange, o.Report("a", false, 4, conf, now), "success should reset failures")
+		assert.Equal(t, outlierNoChange, o.Report("a", true, 4, conf, now))
+		assert.Equal(t, outlierEjected, o.Report("a", true, 4, conf, now))
+
+		ejected, _ := o.Ejected("a", now.Add(9*time.Second))
+		assert.True(t, ejected)
+		ejected, event := o.Ejected("a", now.Add(10*time.Second))
+		assert.False(t, ejected)
+		assert.Equal(t, outlierReturned, event)
+
+		now := now.Add(10 * time.Second)
+		o.Report("a", true, 4, conf, now)
+		assert.Equal(t, outlierEjected, o.Report("a", true, 4, conf, now))
+		ejected, _ = o.Ejected("a", now.Add(19*time.Second))
+		assert.True(t, ejected, "second ejection should last twice as long")
+
+		now = now.Add(20 * time.Second)
+		o.Ejected("a", now)
+		o.Report("a", true, 4, conf, now)
+		assert.Equal(t, outlierEjected, o.Report("a", true, 4, conf, now))
+		ejected, _ = o.Ejected("a", now.Add(25*time.Second))
+		assert.False(t, ejected, "ejection time should be capped")
+	})
+
+	t.Run("respects max ejection percent", func(t *testing.T) {
+		o := &OutlierDetector{}
+		for _, host := range []string{"a", "b"} {
+			o.Report(host, true, 2, conf, now)
+		}
+		assert.Equal(t, outlierEjected, o.Report("a", true, 2, conf, now))
+		assert.Equal(t, outlierNoChange, o.Report("b", true, 2, conf, now))
+
+		ejected, _ := o.Ejected("b", now)
+		assert.False(t, ejected)
+	})
+
+	t.Run("single host is never ejected", func(t *testing.T) {
+		o := &OutlierDetector{}
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, outlierNoChange, o.Report("a", true, 1, conf, now))
+		}
+	})
+}
+
+func TestNextTarget_OutlierEjection(t *testing.T) {
+	gw := &Gateway{}
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.Proxy.EnableLoadBalancing = true
+	spec.Proxy.OutlierDetection = apidef.OutlierDetectionConfig{Enabled: true, ConsecutiveFailures: 1}
+	hl := apidef.NewHostListFromList([]string{"http://a", "http://b"})
+
+	spec.OutlierDetector.Report("a", true, hl.Len(), spec.Proxy.OutlierDetection, time.Now())
+	for i := 0; i < 4; i++ {
+		host, err := gw.nextTarget(hl, spec, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "http://b", host)
+	}
+}
@@ -27,6 +27,7 @@ type DBAccessDefinition struct {
 	DisableIntrospection bool                         `json:"disable_introspection"`
 	FieldAccessRights    []user.FieldAccessDefinition `json:"field_access_rights"`
 	Limit                *user.APILimit               `json:"limit"`
+	Endpoints            []user.EndpointLimit         `json:"endpoints"`
 }
 
 func (d *DBAccessDefinition) ToRegularAD() user.AccessDefinition {
@@ -39,6 +40,7 @@ func (d *DBAccessDefinition) ToRegularAD() user.AccessDefinition {
 		AllowedTypes:         d.AllowedTypes,
 		DisableIntrospection: d.DisableIntrospection,
 		FieldAccessRights:    d.FieldAccessRights,
+		Endpoints:            d.Endpoints,
 	}
 
 	if d.Limit != nil {
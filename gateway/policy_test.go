@@ -1514,3 +1514,36 @@ func TestParsePoliciesFromRPC(t *testing.T) {
 	}
 
 }
+
+func TestApplyPolicies_EndpointLimits(t *testing.T) {
+	gw := &Gateway{policiesByID: map[string]user.Policy{
+		"reads": {ID: "reads", Partitions: user.PolicyPartitions{RateLimit: true}, AccessRights: map[string]user.AccessDefinition{
+			"api": {Endpoints: []user.EndpointLimit{
+				{URL: "/items", Methods: []string{"GET"}, Limit: user.APILimit{Rate: 100, Per: 60, QuotaMax: 10}},
+				{URL: "/items", Methods: []string{"POST"}, Limit: user.APILimit{Rate: 5, Per: 60}},
+			}},
+		}},
+		"writes": {ID: "writes", Partitions: user.PolicyPartitions{RateLimit: true, Quota: true}, AccessRights: map[string]user.AccessDefinition{
+			"api": {Endpoints: []user.EndpointLimit{
+				{URL: "/items", Methods: []string{"POST"}, Limit: user.APILimit{Rate: 10, Per: 60, QuotaMax: 1000, QuotaRenewalRate: 3600}},
+			}},
+		}},
+		"acl": {ID: "acl", Partitions: user.PolicyPartitions{Acl: true}, AccessRights: map[string]user.AccessDefinition{
+			"api": {APIID: "api"},
+		}},
+	}}
+	bmid := &BaseMiddleware{Spec: &APISpec{APIDefinition: &apidef.APIDefinition{}}, Gw: gw}
+
+	session := &user.SessionState{AccessRights: map[string]user.AccessDefinition{
+		"api": {Endpoints: []user.EndpointLimit{
+			{URL: "/items", Methods: []string{"POST"}, Limit: user.APILimit{QuotaRenews: 12345}},
+		}},
+	}}
+	session.SetPolicies("acl", "reads", "writes")
+	assert.NoError(t, bmid.ApplyPolicies(session))
+
+	assert.Equal(t, []user.EndpointLimit{
+		{URL: "/items", Methods: []string{"GET"}, Limit: user.APILimit{Rate: 100, Per: 60}},
+		{URL: "/items", Methods: []string{"POST"}, Limit: user.APILimit{Rate: 10, Per: 60, QuotaMax: 1000, QuotaRenewalRate: 3600, QuotaRenews: 12345}},
+	}, session.AccessRights["api"].Endpoints, "endpoint limits should be merged following the partitions")
+}
@@ -18,6 +18,7 @@ import (
 
 	proxyproto "github.com/pires/go-proxyproto"
 	cache "github.com/pmylund/go-cache"
+	"github.com/quic-go/quic-go/http3"
 
 	"github.com/TykTechnologies/again"
 	"github.com/TykTechnologies/tyk/config"
@@ -35,11 +36,16 @@ type handleWrapper struct {
 
 // h2cWrapper tracks handleWrapper for swapping w.router on reloads.
 type h2cWrapper struct {
-	w *handleWrapper
-	h http.Handler
+	w  *handleWrapper
+	h  http.Handler
+	h3 *http3.Server
 }
 
 func (h *h2cWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.h3 != nil {
+		// advertise the HTTP3 listener, this only fails until it is ready
+		_ = h.h3.SetQuicHeaders(w.Header())
+	}
 	h.h.ServeHTTP(w, r)
 }
 
@@ -62,6 +68,8 @@ type proxy struct {
 	useProxyProtocol bool
 	router           *mux.Router
 	httpServer       *http.Server
+	http3Server      *http3.Server
+	packetConn       net.PacketConn
 	tcpProxy         *tcp.Proxy
 	started          bool
 }
@@ -206,6 +214,14 @@ func (m *proxyMux) addTCPService(spec *APISpec, modifier *tcp.Modifier, gw *Gate
 				// SyncStats:       recordTCPHit(spec.APIID, spec.DoNotTrack),
 			},
 		}
+		if conf := spec.Proxy.Transport.ProxyProtocol; conf.Enabled {
+			dial := dialProxyProtocol(conf.Version, tcpProxyProtocolAddrs, (&net.Dialer{}).DialContext)
+			check := gw.tlsConnCheck(spec)
+			p.tcpProxy.DialContext = gw.dialContextWithServiceDiscovery(spec, dial)
+			p.tcpProxy.DialTLSContext = gw.dialContextWithServiceDiscovery(spec, func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialTLS(ctx, dial, network, address, tlsConfig, check)
+			})
+		}
 		p.tcpProxy.AddDomainHandler(hostname, spec.Proxy.TargetURL, modifier)
 		m.proxies = append(m.proxies, p)
 	}
@@ -277,10 +293,21 @@ func (gw *Gateway) recordTCPHit(specID string, doNotTrack bool) func(tcp.Stat) {
 
 type dialFn func(network string, address string) (net.Conn, error)
 
+type dialContextFn func(ctx context.Context, network string, address string) (net.Conn, error)
+
 func (gw *Gateway) dialWithServiceDiscovery(spec *APISpec, dial dialFn) dialFn {
 	if dial == nil {
 		return nil
 	}
+	dialContext := gw.dialContextWithServiceDiscovery(spec, func(_ context.Context, network, address string) (net.Conn, error) {
+		return dial(network, address)
+	})
+	return func(network, address string) (net.Conn, error) {
+		return dialContext(context.Background(), network, address)
+	}
+}
+
+func (gw *Gateway) dialContextWithServiceDiscovery(spec *APISpec, dial dialContextFn) dialContextFn {
 	if spec.Proxy.ServiceDiscovery.UseDiscoveryService {
 		log.Debug("[PROXY] Service discovery enabled")
 		if ServiceCache == nil {
@@ -294,21 +321,24 @@ func (gw *Gateway) dialWithServiceDiscovery(spec *APISpec, dial dialFn) dialFn {
 			ServiceCache = cache.New(time.Duration(expiry)*time.Second, 15*time.Second)
 		}
 	}
-	return func(network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
 		hostList := spec.Proxy.StructuredTargetList
 		target := address
 		switch {
 		case spec.Proxy.ServiceDiscovery.UseDiscoveryService:
 			var err error
-			hostList, err = urlFromService(spec)
+			hostList, err = gw.urlFromService(spec)
 			if err != nil {
 				log.Error("[PROXY] [SERVICE DISCOVERY] Failed target lookup: ", err)
 				break
 			}
 			log.Debug("[PROXY] [SERVICE DISCOVERY] received host list ", hostList.All())
 			fallthrough // implies load balancing, with replaced host list
-		case spec.Proxy.EnableLoadBalancing:
-			host, err := gw.nextTarget(hostList, spec)
+		case spec.Proxy.EnableLoadBalancing, spec.splitsTraffic():
+			if spec.splitsTraffic() {
+				hostList = spec.upstreamGroupHostList(nil)
+			}
+			host, err := gw.nextTarget(hostList, spec, nil)
 			if err != nil {
 				log.Error("[PROXY] [LOAD BALANCING] ", err)
 				host = allHostsDownURL
@@ -324,7 +354,7 @@ func (gw *Gateway) dialWithServiceDiscovery(spec *APISpec, dial dialFn) dialFn {
 				}
 			}
 		}
-		return dial(network, target)
+		return dial(ctx, network, target)
 	}
 }
 
@@ -348,6 +378,10 @@ func (m *proxyMux) swap(new *proxyMux, gw *Gateway) {
 			} else if curP.listener != nil {
 				curP.listener.Close()
 			}
+			if curP.http3Server != nil {
+				curP.http3Server.Close()
+				curP.packetConn.Close()
+			}
 			m.again.Delete(target(listenAddress, curP.port))
 		} else {
 			m.proxies[i] = curP
@@ -420,10 +454,14 @@ func (m *proxyMux) serve(gw *Gateway) {
 			// by default enabling h2c by wrapping handler in h2c. This ensures all features including tracing work
 			// in h2c services.
 			h2s := &http2.Server{}
-			h = &h2cWrapper{
+			wrapper := &h2cWrapper{
 				w: h.(*handleWrapper),
 				h: h2c.NewHandler(h, h2s),
 			}
+			if p.protocol == "https" && conf.HttpServerOptions.EnableHttp3 {
+				wrapper.h3 = m.serveHTTP3(p, wrapper.w, gw)
+			}
+			h = wrapper
 
 			addr := conf.ListenAddress + ":" + strconv.Itoa(p.port)
 			p.httpServer = &http.Server{
@@ -442,6 +480,26 @@ func (m *proxyMux) serve(gw *Gateway) {
 	}
 }
 
+// serveHTTP3 starts serving h over QUIC on the UDP port matching the TLS
+// listener of p. The returned server is nil if the port can't be opened.
+func (m *proxyMux) serveHTTP3(p *proxy, h http.Handler, gw *Gateway) *http3.Server {
+	addr := target(gw.GetConfig().ListenAddress, p.port)
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		mainLog.WithError(err).Error("Can't start HTTP3 listener")
+		return nil
+	}
+
+	mainLog.Warning("Starting HTTP3 server on:", conn.LocalAddr().String())
+	p.packetConn = conn
+	p.http3Server = &http3.Server{
+		Handler:   h,
+		TLSConfig: gw.serverTLSConfig(p.port),
+	}
+	go p.http3Server.Serve(conn)
+	return p.http3Server
+}
+
 func target(listenAddress string, listenPort int) string {
 	return fmt.Sprintf("%s:%d", listenAddress, listenPort)
 }
@@ -459,6 +517,29 @@ func CheckPortWhiteList(w map[string]config.PortWhiteList, listenPort int, proto
 	return fmt.Errorf("%s:%d trying to open disabled port", protocol, listenPort)
 }
 
+// serverTLSConfig returns the TLS configuration of the gateway listeners on
+// listenPort, serving the certificates known to the CertificateManager.
+func (gw *Gateway) serverTLSConfig(listenPort int) *tls.Config {
+	httpServerOptions := gw.GetConfig().HttpServerOptions
+
+	tlsConfig := tls.Config{
+		GetCertificate:     dummyGetCertificate,
+		ServerName:         httpServerOptions.ServerName,
+		MinVersion:         httpServerOptions.MinVersion,
+		MaxVersion:         httpServerOptions.MaxVersion,
+		ClientAuth:         tls.NoClientCert,
+		InsecureSkipVerify: httpServerOptions.SSLInsecureSkipVerify,
+		CipherSuites:       getCipherAliases(httpServerOptions.Ciphers),
+	}
+
+	if httpServerOptions.EnableHttp2 {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, http2.NextProtoTLS)
+	}
+
+	tlsConfig.GetConfigForClient = gw.getTLSConfigForClient(&tlsConfig, listenPort)
+	return &tlsConfig
+}
+
 func (m *proxyMux) generateListener(listenPort int, protocol string, gw *Gateway) (l net.Listener, err error) {
 	conf := gw.GetConfig()
 	listenAddress := conf.ListenAddress
@@ -475,24 +556,7 @@ func (m *proxyMux) generateListener(listenPort int, protocol string, gw *Gateway
 	switch protocol {
 	case "https", "tls":
 		mainLog.Infof("--> Using TLS (%s)", protocol)
-		httpServerOptions := conf.HttpServerOptions
-
-		tlsConfig := tls.Config{
-			GetCertificate:     dummyGetCertificate,
-			ServerName:         httpServerOptions.ServerName,
-			MinVersion:         httpServerOptions.MinVersion,
-			MaxVersion:         httpServerOptions.MaxVersion,
-			ClientAuth:         tls.NoClientCert,
-			InsecureSkipVerify: httpServerOptions.SSLInsecureSkipVerify,
-			CipherSuites:       getCipherAliases(httpServerOptions.Ciphers),
-		}
-
-		if httpServerOptions.EnableHttp2 {
-			tlsConfig.NextProtos = append(tlsConfig.NextProtos, http2.NextProtoTLS)
-		}
-
-		tlsConfig.GetConfigForClient = gw.getTLSConfigForClient(&tlsConfig, listenPort)
-		l, err = tls.Listen("tcp", targetPort, &tlsConfig)
+		l, err = tls.Listen("tcp", targetPort, gw.serverTLSConfig(listenPort))
 
 	default:
 		mainLog.WithField("port", targetPort).Infof("--> Standard listener (%s)", protocol)
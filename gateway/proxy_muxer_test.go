@@ -1,17 +1,26 @@
 package gateway
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/TykTechnologies/again"
+	"github.com/gorilla/mux"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/TykTechnologies/tyk/certs"
 	"github.com/TykTechnologies/tyk/test"
 
 	"github.com/TykTechnologies/tyk/config"
@@ -296,6 +305,87 @@ func TestHTTP_custom_ports(t *testing.T) {
 	}
 }
 
+func TestHTTP3(t *testing.T) {
+	serverCertPem, serverPrivPem, _, _ := certs.GenServerCertificate()
+
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	certFilePath := filepath.Join(dir, "server.crt")
+	if err := ioutil.WriteFile(certFilePath, serverCertPem, 0666); err != nil {
+		t.Fatal(err)
+	}
+	certKeyPath := filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(certKeyPath, serverPrivPem, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	gw := &Gateway{}
+	conf := config.Config{}
+	conf.ListenAddress = "127.0.0.1"
+	conf.DisablePortWhiteList = true
+	conf.HttpServerOptions.UseSSL = true
+	conf.HttpServerOptions.EnableHttp3 = true
+	conf.HttpServerOptions.Certificates = []config.CertData{{
+		Name:     "localhost",
+		CertFile: certFilePath,
+		KeyFile:  certKeyPath,
+	}}
+	gw.SetConfig(conf)
+	gw.CertificateManager = certs.NewCertificateManager(nil, "", nil, false)
+	defer tlsConfigCache.Flush()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	m := &proxyMux{proxies: []*proxy{{protocol: "https", router: router}}, again: again.New()}
+	m.serve(gw)
+	p := m.proxies[0]
+	defer p.httpServer.Close()
+	if p.http3Server == nil {
+		t.Fatal("HTTP3 server wasn't started")
+	}
+	defer p.packetConn.Close()
+	defer p.http3Server.Close()
+
+	url := fmt.Sprintf("https://127.0.0.1:%d/", p.port)
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	get := func(t *testing.T, transport http.RoundTripper) *http.Response {
+		t.Helper()
+		resp, err := (&http.Client{Transport: transport, Timeout: 5 * time.Second}).Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Proto = string(body)
+		return resp
+	}
+
+	t.Run("serves requests over QUIC", func(t *testing.T) {
+		transport := &http3.RoundTripper{TLSClientConfig: tlsConfig}
+		defer transport.Close()
+
+		if resp := get(t, transport); resp.Proto != "HTTP/3.0" {
+			t.Errorf("expected the request to be served over HTTP/3.0, got %s", resp.Proto)
+		}
+	})
+
+	t.Run("advertises HTTP3 over TLS", func(t *testing.T) {
+		resp := get(t, &http.Transport{TLSClientConfig: tlsConfig})
+		if resp.Proto != "HTTP/1.1" {
+			t.Errorf("expected the request to be served over HTTP/1.1, got %s", resp.Proto)
+		}
+		want := fmt.Sprintf(`h3=":%d"; ma=2592000`, p.port)
+		if got := resp.Header.Get("Alt-Svc"); got != want {
+			t.Errorf("expected Alt-Svc %q, got %q", want, got)
+		}
+	})
+}
+
 func TestHandle404(t *testing.T) {
 	g := StartTest(nil)
 	defer g.Close()
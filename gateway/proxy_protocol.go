@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	proxyproto "github.com/pires/go-proxyproto"
+
+	"github.com/TykTechnologies/tyk/request"
+	"github.com/TykTechnologies/tyk/tcp"
+)
+
+// proxyProtocolAddrs returns the address of the client an upstream connection is opened for and
+// the gateway address the client connected to, or nil if they aren't known.
+type proxyProtocolAddrs func(ctx context.Context) (src, dst *net.TCPAddr)
+
+// dialProxyProtocol wraps dial to write a PROXY protocol header to the upstream connections.
+func dialProxyProtocol(version int, addrs proxyProtocolAddrs, dial dialContextFn) dialContextFn {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		src, dst := addrs(ctx)
+		if _, err := conn.Write(proxyProtocolHeader(version, src, dst)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// dialTLS opens a TLS connection over a connection opened with dial. The connection is checked
+// with check instead of the usual certificate verification if it's set.
+func dialTLS(ctx context.Context, dial dialContextFn, network, address string, config *tls.Config, check func(host string, c *tls.Conn) error) (net.Conn, error) {
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+	clone := config.Clone()
+	if clone.ServerName == "" {
+		clone.ServerName = host
+	}
+	if check != nil {
+		clone.InsecureSkipVerify = true
+	}
+
+	c := tls.Client(conn, clone)
+	if err := c.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if check != nil {
+		if err := check(host, c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// proxyProtocolHeader encodes the PROXY protocol header of a connection from src to dst. The
+// upstream is told to use the addresses of the connection itself if they aren't known.
+func proxyProtocolHeader(version int, src, dst *net.TCPAddr) []byte {
+	if version == 2 {
+		if src == nil || dst == nil {
+			// LOCAL command, without addresses
+			return append(append([]byte{}, proxyproto.SIGV2...), proxyproto.LOCAL, proxyproto.UNSPEC, 0, 0)
+		}
+
+		header := &proxyproto.Header{
+			Version:            2,
+			Command:            proxyproto.PROXY,
+			TransportProtocol:  proxyproto.TCPv4,
+			SourceAddress:      src.IP.To4(),
+			DestinationAddress: dst.IP.To4(),
+			SourcePort:         uint16(src.Port),
+			DestinationPort:    uint16(dst.Port),
+		}
+		if header.SourceAddress == nil || header.DestinationAddress == nil {
+			header.TransportProtocol = proxyproto.TCPv6
+			header.SourceAddress, header.DestinationAddress = src.IP.To16(), dst.IP.To16()
+		}
+		encoded, _ := header.Format()
+		return encoded
+	}
+
+	if src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+	}
+	return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(src.IP), ipv6String(dst.IP), src.Port, dst.Port))
+}
+
+// ipv6String formats ip as an IPv6 address, even if it's an IPv4 one.
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+// httpProxyProtocolAddrs returns the addresses of the request an upstream HTTP connection is
+// opened for.
+func httpProxyProtocolAddrs(ctx context.Context) (src, dst *net.TCPAddr) {
+	src = ctxGetClientAddr(ctx)
+	dst, _ = ctx.Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	return src, dst
+}
+
+// tcpProxyProtocolAddrs returns the addresses of the client connection an upstream TCP connection
+// is opened for.
+func tcpProxyProtocolAddrs(ctx context.Context) (src, dst *net.TCPAddr) {
+	conn := tcp.ClientConn(ctx)
+	if conn == nil {
+		return nil, nil
+	}
+	src, _ = conn.RemoteAddr().(*net.TCPAddr)
+	dst, _ = conn.LocalAddr().(*net.TCPAddr)
+	return src, dst
+}
+
+// clientAddr returns the address of the client of the request. It's the peer of the gateway, or
+// the client of the PROXY protocol header of the inbound connection, unless the forwarded headers
+// set by the trusted proxies in front of the gateway are honoured.
+func clientAddr(r *http.Request, trustForwarded bool) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if trustForwarded {
+		host = request.RealIP(r)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	addr := &net.TCPAddr{IP: ip}
+	// the port is only known if the client is the peer
+	if peer, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && net.ParseIP(peer).Equal(ip) {
+		addr.Port, _ = strconv.Atoi(port)
+	}
+	return addr
+}
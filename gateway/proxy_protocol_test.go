@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/header"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080}
+
+	t.Run("v1", func(t *testing.T) {
+		assert.Equal(t, "PROXY TCP4 192.0.2.1 10.0.0.1 51000 8080\r\n", string(proxyProtocolHeader(1, src, dst)))
+		assert.Equal(t, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::1 51000 8080\r\n", string(proxyProtocolHeader(1, src, dst6)))
+		assert.Equal(t, "PROXY UNKNOWN\r\n", string(proxyProtocolHeader(1, nil, dst)))
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		read := func(encoded []byte) *proxyproto.Header {
+			h, err := proxyproto.Read(bufio.NewReader(bytes.NewReader(encoded)))
+			assert.NoError(t, err)
+			return h
+		}
+
+		h := read(proxyProtocolHeader(2, src, dst))
+		assert.Equal(t, src.String(), h.RemoteAddr().String())
+		assert.Equal(t, dst.String(), h.LocalAddr().String())
+
+		h = read(proxyProtocolHeader(2, src, dst6))
+		assert.True(t, h.TransportProtocol.IsIPv6())
+		assert.True(t, src.IP.Equal(h.SourceAddress))
+
+		local := proxyProtocolHeader(2, nil, nil)
+		assert.Equal(t, append(append([]byte{}, proxyproto.SIGV2...), 0x20, 0x00, 0x00, 0x00), local)
+	})
+}
+
+func TestClientAddr(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:51000"
+	assert.Equal(t, "192.0.2.1:51000", clientAddr(r, false).String())
+
+	r.Header.Set(header.XForwardFor, "198.51.100.7, 192.0.2.1")
+	assert.Equal(t, "192.0.2.1:51000", clientAddr(r, false).String(), "forwarded headers shouldn't be trusted by default")
+	assert.Equal(t, "198.51.100.7:0", clientAddr(r, true).String())
+}
+
+func TestDialProxyProtocol(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+	upstreamListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	upstream.Listener = &proxyproto.Listener{Listener: upstreamListener}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	dial := dialProxyProtocol(2, httpProxyProtocolAddrs, (&net.Dialer{}).DialContext)
+	checked := false
+	transport := &http.Transport{
+		DialContext: dial,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialTLS(ctx, dial, network, addr, &tls.Config{}, func(host string, c *tls.Conn) error {
+				checked = true
+				return nil
+			})
+		},
+		DisableKeepAlives: true,
+	}
+
+	send := func(addr *net.TCPAddr) string {
+		r := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+		r.RequestURI = ""
+		if addr != nil {
+			ctxSetClientAddr(r, addr)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}))
+
+		res, err := transport.RoundTrip(r)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return string(body)
+	}
+
+	assert.Equal(t, "192.0.2.1:51000", send(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51000}))
+	assert.True(t, checked, "TLS connection should be checked")
+	assert.Equal(t, "198.51.100.7:0", send(&net.TCPAddr{IP: net.ParseIP("198.51.100.7")}))
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+const (
+	rateLimitPolicyRate  = "rate"
+	rateLimitPolicyQuota = "quota"
+)
+
+// ceilSeconds rounds the duration up to whole seconds.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// setRateLimitHeaders sets Retry-After when the request is rejected for exceeding its rate limit,
+// and, if enabled for the API, the RateLimit and RateLimit-Policy headers describing the rate
+// limit and the quota of the request. limit is the limit the request was checked against, the
+// state of the scripted rate limiters is used when available. The quota is the one of limit, or
+// of the session for the API if limit has none.
+func setRateLimitHeaders(w http.ResponseWriter, r *http.Request, spec *APISpec, limit *user.APILimit, reason sessionFailReason) {
+	state := ctxGetRateLimitState(r)
+
+	if reason == sessionFailRateLimit {
+		var retryAfter int64
+		switch {
+		case state != nil && !state.Allowed:
+			retryAfter = ceilSeconds(state.RetryAfter)
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+		case limit != nil && limit.Per > 0:
+			// without the state of the rate limiter, a whole window is the safe bet
+			retryAfter = int64(math.Ceil(limit.Per))
+		}
+		if retryAfter > 0 {
+			w.Header().Set(header.RetryAfter, strconv.FormatInt(retryAfter, 10))
+		}
+	}
+
+	if !spec.EnableRateLimitHeaders {
+		return
+	}
+
+	var policies, limits []string
+
+	if !spec.DisableRateLimit {
+		switch {
+		case state != nil && state.Rate > 0:
+			remaining := state.Remaining
+			if reason == sessionFailRateLimit || remaining < 0 {
+				remaining = 0
+			}
+			policies = append(policies, fmt.Sprintf("%q;q=%d;w=%d", rateLimitPolicyRate, int64(state.Rate), int64(math.Ceil(state.Per))))
+			limits = append(limits, fmt.Sprintf("%q;r=%d;t=%d", rateLimitPolicyRate, remaining, ceilSeconds(state.Reset)))
+		case limit != nil && limit.Rate > 0 && limit.Per > 0:
+			// the other rate limiters don't report their remaining requests
+			policies = append(policies, fmt.Sprintf("%q;q=%d;w=%d", rateLimitPolicyRate, int64(limit.Rate), int64(math.Ceil(limit.Per))))
+		}
+	}
+
+	if !spec.DisableQuota {
+		var quotaMax, quotaRemaining, quotaRenewalRate, quotaRenews int64
+		if limit != nil && limit.QuotaMax > 0 {
+			quotaMax, quotaRemaining, quotaRenewalRate, quotaRenews = limit.QuotaMax, limit.QuotaRemaining, limit.QuotaRenewalRate, limit.QuotaRenews
+		} else if session := ctxGetSession(r); session != nil {
+			quotaMax, quotaRemaining, quotaRenewalRate, quotaRenews = session.GetQuotaLimitByAPIID(spec.APIID)
+		}
+		if quotaMax > 0 {
+			if reason == sessionFailQuota || quotaRemaining < 0 {
+				quotaRemaining = 0
+			}
+			reset := quotaRenews - time.Now().Unix()
+			if reset < 0 {
+				reset = 0
+			}
+			policies = append(policies, fmt.Sprintf("%q;q=%d;w=%d", rateLimitPolicyQuota, quotaMax, quotaRenewalRate))
+			limits = append(limits, fmt.Sprintf("%q;r=%d;t=%d", rateLimitPolicyQuota, quotaRemaining, reset))
+		}
+	}
+
+	if len(policies) > 0 {
+		w.Header().Set(header.RateLimitPolicy, strings.Join(policies, ", "))
+	}
+	if len(limits) > 0 {
+		w.Header().Set(header.RateLimit, strings.Join(limits, ", "))
+	}
+}
@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", EnableRateLimitHeaders: true}}
+	limit := &user.APILimit{Rate: 10, Per: 60}
+
+	session := &user.SessionState{QuotaMax: 1000, QuotaRemaining: 990, QuotaRenewalRate: 3600, QuotaRenews: time.Now().Add(30 * time.Minute).Unix()}
+	newRequest := func(state *rateLimitState) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		setCtxValue(r, ctx.SessionData, session)
+		if state != nil {
+			ctxSetRateLimitState(r, state)
+		}
+		return r
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+		w := httptest.NewRecorder()
+		setRateLimitHeaders(w, newRequest(nil), disabled, limit, sessionFailNone)
+		assert.Empty(t, w.Header())
+
+		setRateLimitHeaders(w, newRequest(nil), disabled, limit, sessionFailRateLimit)
+		assert.Equal(t, "60", w.Header().Get(header.RetryAfter), "rate limited requests should always have a Retry-After")
+		assert.Empty(t, w.Header().Get(header.RateLimit))
+		assert.Empty(t, w.Header().Get(header.RateLimitPolicy))
+	})
+
+	t.Run("rate limit and quota", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		state := &rateLimitState{
+			RateLimitResult: storage.RateLimitResult{Allowed: true, Remaining: 3, Reset: 19500 * time.Millisecond},
+			Rate:            10,
+			Per:             60,
+		}
+		setRateLimitHeaders(w, newRequest(state), spec, limit, sessionFailNone)
+		assert.Equal(t, `"rate";q=10;w=60, "quota";q=1000;w=3600`, w.Header().Get(header.RateLimitPolicy))
+
+		rateLimit := w.Header().Get(header.RateLimit)
+		assert.Contains(t, rateLimit, `"rate";r=3;t=20, "quota";r=990;t=`)
+		reset, err := strconv.Atoi(rateLimit[len(`"rate";r=3;t=20, "quota";r=990;t=`):])
+		assert.NoError(t, err)
+		assert.InDelta(t, 1800, reset, 1)
+		assert.Empty(t, w.Header().Get(header.RetryAfter))
+	})
+
+	t.Run("rejected by a scripted rate limiter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		state := &rateLimitState{
+			RateLimitResult: storage.RateLimitResult{RetryAfter: 4200 * time.Millisecond, Reset: time.Minute},
+			Rate:            10,
+			Per:             60,
+		}
+		setRateLimitHeaders(w, newRequest(state), spec, limit, sessionFailRateLimit)
+		assert.Contains(t, w.Header().Get(header.RateLimit), `"rate";r=0;t=60`)
+		assert.Equal(t, "5", w.Header().Get(header.RetryAfter))
+	})
+
+	t.Run("rejected by another rate limiter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		setRateLimitHeaders(w, newRequest(nil), spec, limit, sessionFailRateLimit)
+		assert.Equal(t, `"rate";q=10;w=60, "quota";q=1000;w=3600`, w.Header().Get(header.RateLimitPolicy))
+		assert.NotContains(t, w.Header().Get(header.RateLimit), `"rate"`)
+		assert.Equal(t, "60", w.Header().Get(header.RetryAfter), "a whole window should be waited for")
+	})
+}
+
+func TestRateLimitRulesMiddleware_Headers(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	gw.GlobalSessionManager = &DefaultSessionManager{store: &burstStore{counts: map[string]int64{}}}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", EnableRateLimitHeaders: true}, RateLimitRulesEnabled: true}
+	spec.RateLimitRules = []apidef.RateLimitRule{
+		{KeyBy: []apidef.RateLimitKey{{Source: apidef.RateLimitKeyIP}}, Rate: 1, Per: 30},
+	}
+	mw := &RateLimitRulesMiddleware{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	w := httptest.NewRecorder()
+	_, code := mw.ProcessRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `"rate";q=1;w=30`, w.Header().Get(header.RateLimitPolicy))
+	assert.Equal(t, `"rate";r=0;t=30`, w.Header().Get(header.RateLimit))
+
+	w = httptest.NewRecorder()
+	_, code = mw.ProcessRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "30", w.Header().Get(header.RetryAfter))
+}
+
+func TestRateLimitAndQuotaCheck_Headers(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	gw.SessionLimiter = SessionLimiter{Gw: gw}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", EnableRateLimitHeaders: true}}
+	spec.GlobalConfig.RateLimitAlgorithm = apidef.RateLimitGCRA
+	mw := &RateLimitAndQuotaCheck{BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}}
+
+	newSession := func() *user.SessionState {
+		session := &user.SessionState{KeyID: "key", Rate: 100, Per: 60, QuotaMax: 10, QuotaRenewalRate: 3600, AccessRights: map[string]user.AccessDefinition{
+			"api": {Endpoints: []user.EndpointLimit{
+				{URL: "^/orders$", Methods: []string{http.MethodPost}, Limit: user.APILimit{Rate: 1, Per: 30}},
+				{URL: "^/reports", Methods: []string{http.MethodGet}, Limit: user.APILimit{QuotaMax: 1, QuotaRenewalRate: 600}},
+			}},
+		}}
+		session.SetKeyHash("hash")
+		return session
+	}
+	session := newSession()
+
+	process := func(method, path string) (http.Header, int) {
+		r := httptest.NewRequest(method, path, nil)
+		setCtxValue(r, ctx.SessionData, session)
+		setCtxValue(r, ctx.AuthToken, "key")
+		w := httptest.NewRecorder()
+		_, code := mw.ProcessRequest(w, r, nil)
+		return w.Header(), code
+	}
+
+	t.Run("endpoint rate limit", func(t *testing.T) {
+		gw.GlobalSessionManager = &DefaultSessionManager{store: &endpointLimitStore{counts: map[string]int64{}}}
+
+		h, code := process(http.MethodPost, "/orders")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `"rate";q=1;w=30, "quota";q=10;w=3600`, h.Get(header.RateLimitPolicy), "the limits of the endpoint should be reported")
+
+		h, code = process(http.MethodPost, "/orders")
+		assert.Equal(t, http.StatusTooManyRequests, code)
+		assert.Equal(t, `"rate";q=1;w=30, "quota";q=10;w=3600`, h.Get(header.RateLimitPolicy))
+		assert.Contains(t, h.Get(header.RateLimit), `"rate";r=0;t=30`)
+		assert.Equal(t, "30", h.Get(header.RetryAfter), "the endpoint should be retried once its rate limit allows it")
+
+		h, code = process(http.MethodGet, "/orders")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `"rate";q=100;w=60, "quota";q=10;w=3600`, h.Get(header.RateLimitPolicy), "the limits of the API should be reported")
+	})
+
+	t.Run("endpoint quota", func(t *testing.T) {
+		gw.GlobalSessionManager = &DefaultSessionManager{store: &endpointLimitStore{counts: map[string]int64{}}}
+		session = newSession()
+
+		h, code := process(http.MethodGet, "/reports/1")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `"rate";q=100;w=60, "quota";q=1;w=600`, h.Get(header.RateLimitPolicy))
+		assert.Contains(t, h.Get(header.RateLimit), `"quota";r=0;t=`)
+
+		h, code = process(http.MethodGet, "/reports/2")
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, `"rate";q=100;w=60, "quota";q=1;w=600`, h.Get(header.RateLimitPolicy), "the quota of the endpoint should be reported")
+		assert.Contains(t, h.Get(header.RateLimit), `"quota";r=0;t=`)
+		assert.Empty(t, h.Get(header.RetryAfter), "only rate limited requests should be retried")
+	})
+}
+
+// remainingStore counts the requests per key, without ever rejecting them.
+type remainingStore struct {
+	storage.Handler
+	counts map[string]int64
+}
+
+func (s *remainingStore) RateLimitGCRA(keyName string, rate float64, per time.Duration, _ bool) (storage.RateLimitResult, error) {
+	s.counts[keyName]++
+	return storage.RateLimitResult{Allowed: true, Remaining: int64(rate) - s.counts[keyName], Reset: per}, nil
+}
+
+func (s *remainingStore) RateLimitSlidingWindow(keyName string, rate float64, per time.Duration, dryRun bool) (storage.RateLimitResult, error) {
+	return s.RateLimitGCRA(keyName, rate, per, dryRun)
+}
+
+func (s *remainingStore) RateLimitGCRABurst(keyName string, rate float64, per time.Duration, _ int64, dryRun bool) (storage.RateLimitResult, error) {
+	return s.RateLimitGCRA(keyName, rate, per, dryRun)
+}
+
+func TestRateLimitForAPI_Headers(t *testing.T) {
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	gw.SessionLimiter = SessionLimiter{Gw: gw}
+	gw.GlobalSessionManager = &DefaultSessionManager{store: &remainingStore{counts: map[string]int64{}}}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", EnableRateLimitHeaders: true, DisableQuota: true}, RateLimitRulesEnabled: true}
+	spec.GlobalConfig.RateLimitAlgorithm = apidef.RateLimitGCRA
+	spec.GlobalRateLimit = apidef.GlobalRateLimit{Rate: 500, Per: 60}
+	spec.RateLimitRules = []apidef.RateLimitRule{
+		{KeyBy: []apidef.RateLimitKey{{Source: apidef.RateLimitKeyIP}}, Rate: 1000, Per: 60},
+	}
+
+	base := BaseMiddleware{Spec: spec, Gw: gw, logger: logrus.NewEntry(log)}
+	apiMW := &RateLimitForAPI{BaseMiddleware: base}
+	assert.True(t, apiMW.EnabledForSpec())
+	chain := []TykMiddleware{&RateLimitAndQuotaCheck{base}, apiMW, &RateLimitRulesMiddleware{base}}
+
+	session := &user.SessionState{KeyID: "key", Rate: 100, Per: 60}
+	session.SetKeyHash("hash")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	setCtxValue(r, ctx.SessionData, session)
+	setCtxValue(r, ctx.AuthToken, "key")
+
+	w := httptest.NewRecorder()
+	for _, mw := range chain {
+		_, code := mw.ProcessRequest(w, r, nil)
+		assert.Equal(t, http.StatusOK, code, mw.Name())
+	}
+	assert.Equal(t, `"rate";q=100;w=60`, w.Header().Get(header.RateLimitPolicy), "the limit of the key should be reported over the limit of the API")
+	assert.Equal(t, `"rate";r=99;t=60`, w.Header().Get(header.RateLimit))
+}
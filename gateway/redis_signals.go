@@ -34,6 +34,7 @@ const (
 	NoticeGatewayDRLNotification NotificationCommand = "NoticeGatewayDRLNotification"
 	NoticeGatewayLENotification  NotificationCommand = "NoticeGatewayLENotification"
 	KeySpaceUpdateNotification   NotificationCommand = "KeySpaceUpdateNotification"
+	NoticeLocalCacheInvalidated  NotificationCommand = "LocalCacheInvalidated"
 )
 
 // Notification is a type that encodes a message published to a pub sub channel (shared between implementations)
@@ -133,6 +134,8 @@ func (gw *Gateway) handleRedisEvent(v interface{}, handled func(NotificationComm
 		gw.reloadURLStructure(reloaded)
 	case KeySpaceUpdateNotification:
 		gw.handleKeySpaceEventCacheFlush(notif.Payload)
+	case NoticeLocalCacheInvalidated:
+		gw.handleLocalCacheInvalidation(notif.Payload)
 	default:
 		pubSubLog.Warnf("Unknown notification command: %q", notif.Command)
 		return
@@ -0,0 +1,266 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gocraft/health"
+)
+
+const defaultCoalescingMaxWait = 5 * time.Second
+
+// coalescesUncachedRequests reports whether the identical requests to the API are coalesced on the
+// paths that aren't cached.
+func coalescesUncachedRequests(spec *APISpec) bool {
+	return spec.CacheOptions.EnableRequestCoalescing && spec.CacheOptions.CoalesceUncachedRequests
+}
+
+func (m *RedisCacheMiddleware) coalescesUncached(r *http.Request) bool {
+	return coalescesUncachedRequests(m.Spec) && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+func (m *RedisCacheMiddleware) coalescingMaxWait() time.Duration {
+	if maxWait := m.Spec.CacheOptions.CoalescingMaxWait; maxWait > 0 {
+		return time.Duration(maxWait * float64(time.Second))
+	}
+	return defaultCoalescingMaxWait
+}
+
+// coalesce sends the request through the rest of the handler chain if no identical request is in
+// flight. Otherwise it waits for the response of the identical request, and serves it.
+func (m *RedisCacheMiddleware) coalesce(w http.ResponseWriter, r *http.Request, options *cacheOptions, t1 time.Time) (error, int) {
+	if !m.Spec.CacheOptions.EnableRequestCoalescing {
+		return nil, http.StatusOK
+	}
+
+	maxWait := m.coalescingMaxWait()
+	flight, leader := m.flights.join(options.key, maxWait)
+	if leader {
+		options.flight = flight
+		return m.lead(w, r, flight)
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-flight.done:
+	case <-timer.C:
+		m.Logger().Debug("Coalesced request timed out, sending it upstream")
+		m.coalescingEvent("coalescing_timeout")
+		return nil, http.StatusOK
+	case <-r.Context().Done():
+		// the client is gone, the request isn't sent upstream
+		return nil, mwStatusRespond
+	}
+
+	newRes, ok := flight.response(r)
+	if !ok {
+		m.Logger().Debug("Response of coalesced request can't be shared, sending it upstream")
+		m.coalescingEvent("coalescing_unshared")
+		return nil, http.StatusOK
+	}
+
+	res, err := m.readCachedResponse(string(newRes), r)
+	if err != nil {
+		m.Logger().WithError(err).Error("Could not create response object")
+		return nil, http.StatusOK
+	}
+
+	m.coalescingEvent("coalesced")
+	return m.writeCachedResponse(w, r, res, t1)
+}
+
+// lead sends the request leading a flight through the rest of the handler chain. Responses written
+// by the middlewares, such as virtual endpoints and plugins, are fanned out to the coalesced
+// requests like the responses going through the response chain.
+func (m *RedisCacheMiddleware) lead(w http.ResponseWriter, r *http.Request, flight *requestFlight) (error, int) {
+	rw := &flightResponseWriter{
+		customResponseWriter: &customResponseWriter{ResponseWriter: w, copyData: true},
+		flight:               flight,
+	}
+	m.next.ServeHTTP(rw, r)
+
+	if rw.responseSent && !rw.hijacked {
+		flight.complete(rw.getHttpResponse(r), r)
+	} else {
+		flight.complete(nil, r)
+	}
+	return nil, mwStatusRespond
+}
+
+// coalescingEvent records an instrumentation event counting coalesced requests.
+func (m *RedisCacheMiddleware) coalescingEvent(event string) {
+	if !instrumentationEnabled {
+		return
+	}
+	instrument.NewJob("RequestCoalescing").EventKv(event, health.Kvs{
+		"api_id":   m.Spec.APIID,
+		"api_name": m.Spec.Name,
+		"org_id":   m.Spec.OrgID,
+	})
+}
+
+// requestFlights coalesces identical concurrent requests, so a single one is sent upstream per
+// node and its response is served to the others.
+type requestFlights struct {
+	mu      sync.Mutex
+	flights map[string]*requestFlight
+}
+
+// join returns the flight of the requests with the given key, and whether the request leads it.
+func (f *requestFlights) join(key string, maxWait time.Duration) (*requestFlight, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if flight, ok := f.flights[key]; ok {
+		return flight, false
+	}
+
+	flight := &requestFlight{flights: f, key: key, done: make(chan struct{})}
+	if f.flights == nil {
+		f.flights = make(map[string]*requestFlight)
+	}
+	f.flights[key] = flight
+
+	// leaders that never respond complete their flight as waiters give up
+	flight.timer = time.AfterFunc(maxWait, func() {
+		flight.complete(nil, nil)
+	})
+	return flight, true
+}
+
+// requestFlight is an upstream request identical requests wait on.
+type requestFlight struct {
+	flights *requestFlights
+	key     string
+
+	once  sync.Once
+	done  chan struct{}
+	timer *time.Timer
+
+	// wire is the response in wire format, nil if it can't be shared
+	wire []byte
+	// vary are the request headers the response varies on, variant is the key of their values
+	// in the request leading the flight
+	vary    []string
+	variant string
+}
+
+// completed reports whether the response of the flight was fanned out.
+func (f *requestFlight) completed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// complete fans the response out to the waiting requests, unless it is nil.
+func (f *requestFlight) complete(res *http.Response, r *http.Request) {
+	f.once.Do(func() {
+		f.flights.mu.Lock()
+		if f.flights.flights[f.key] == f {
+			delete(f.flights.flights, f.key)
+		}
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		f.flights.mu.Unlock()
+
+		f.wire, f.vary, f.variant = shareableResponse(res, r)
+		close(f.done)
+	})
+}
+
+// response returns the response of the flight in wire format, if it can be served to r.
+func (f *requestFlight) response(r *http.Request) ([]byte, bool) {
+	if f.wire == nil {
+		return nil, false
+	}
+	if len(f.vary) > 0 && httpCacheVariantKey("", f.vary, r) != f.variant {
+		return nil, false
+	}
+	return f.wire, true
+}
+
+// shareableResponse encodes a response to be served to identical requests. Responses to
+// conditional requests and to upgrades can't be shared.
+func shareableResponse(res *http.Response, r *http.Request) ([]byte, []string, string) {
+	if res == nil || res.StatusCode == http.StatusNotModified || res.StatusCode == http.StatusSwitchingProtocols {
+		return nil, nil, ""
+	}
+	vary, ok := httpCacheVary(res.Header)
+	if !ok {
+		return nil, nil, ""
+	}
+
+	// the body is buffered so it can be read again by the client of the request
+	if _, ok := res.Body.(*nopCloserBuffer); !ok && res.Body != nil {
+		body, err := newNopCloserBuffer(res.Body)
+		if err != nil {
+			return nil, nil, ""
+		}
+		res.Body = body
+	}
+
+	var wire bytes.Buffer
+	if err := res.Write(&wire); err != nil {
+		log.WithError(err).Error("Could not encode coalesced response")
+		return nil, nil, ""
+	}
+
+	var variant string
+	if len(vary) > 0 {
+		variant = httpCacheVariantKey("", vary, r)
+	}
+	return wire.Bytes(), vary, variant
+}
+
+// completeFlight fans the response out to the requests coalesced on the request, if any.
+func (o *cacheOptions) completeFlight(res *http.Response, r *http.Request) {
+	if o.flight != nil {
+		o.flight.complete(res, r)
+	}
+}
+
+// flightResponseWriter copies the response written to the request leading a flight, until the
+// response is fanned out by the response chain.
+type flightResponseWriter struct {
+	*customResponseWriter
+	flight   *requestFlight
+	hijacked bool
+}
+
+func (w *flightResponseWriter) WriteHeader(statusCode int) {
+	w.stopCopyIfCompleted()
+	w.customResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *flightResponseWriter) Write(b []byte) (int, error) {
+	w.stopCopyIfCompleted()
+	return w.customResponseWriter.Write(b)
+}
+
+func (w *flightResponseWriter) stopCopyIfCompleted() {
+	if w.copyData && w.flight.completed() {
+		w.copyData = false
+		w.data = nil
+	}
+}
+
+// Hijack hands the connection over for upgraded requests, whose responses can't be shared.
+func (w *flightResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	w.hijacked = true
+	w.copyData = false
+	return hj.Hijack()
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -38,7 +39,7 @@ func (h *ResponseCacheMiddleware) HandleError(rw http.ResponseWriter, req *http.
 }
 
 func (m *ResponseCacheMiddleware) EnabledForSpec() bool {
-	return m.spec.CacheOptions.EnableCache
+	return m.spec.CacheOptions.EnableCache || coalescesUncachedRequests(m.spec)
 }
 
 func (m *ResponseCacheMiddleware) getTimeTTL(cacheTTL int64) int64 {
@@ -75,6 +76,25 @@ func (m *ResponseCacheMiddleware) HandleResponse(w http.ResponseWriter, res *htt
 		return nil
 	}
 
+	// Fan the response out to the identical requests waiting on it
+	defer options.completeFlight(res, r)
+	if options.coalesceOnly {
+		return nil
+	}
+
+	// Serve the expired response instead of an upstream error if allowed
+	if res.StatusCode >= http.StatusInternalServerError {
+		if stale := options.takeErrorFallback(r); stale != nil {
+			replaceResponse(res, stale)
+			return nil
+		}
+	}
+
+	if m.spec.CacheOptions.EnableHTTPCacheSemantics {
+		m.handleHTTPCacheResponse(res, r, options)
+		return nil
+	}
+
 	cacheThisRequest := true
 	cacheTTL := m.spec.CacheOptions.CacheTimeout
 
@@ -130,12 +150,17 @@ func (m *ResponseCacheMiddleware) HandleResponse(w http.ResponseWriter, res *htt
 
 		ts := m.getTimeTTL(cacheTTL)
 		toStore = m.encodePayload(wireFormatReq.String(), ts)
+		// keep expired responses while they can be served stale
+		storeTTL := cacheTTL + options.staleWindow()
 
+		tags := surrogateKeys(res.Header)
 		go func() {
-			err := m.store.SetKey(options.key, toStore, cacheTTL)
+			err := m.store.SetKey(options.key, toStore, storeTTL)
 			if err != nil {
 				m.Logger().WithError(err).Error("could not save key in cache store")
+				return
 			}
+			indexCacheEntries(m.store, []string{options.key}, options, tags, storeTTL)
 		}()
 	}
 
@@ -150,3 +175,92 @@ func (m *ResponseCacheMiddleware) HandleResponse(w http.ResponseWriter, res *htt
 
 	return nil
 }
+
+// handleHTTPCacheResponse caches the response following the HTTP caching semantics. When the
+// response revalidates a stale cached response, the cached response is served instead of a 304,
+// unless the client request itself was conditional.
+func (m *ResponseCacheMiddleware) handleHTTPCacheResponse(res *http.Response, r *http.Request, options *cacheOptions) {
+	if options.stale != nil {
+		options.restoreConditions(r)
+		if res.StatusCode == http.StatusNotModified {
+			freshenResponse(res, options.stale)
+		}
+	}
+
+	m.storeHTTPCacheResponse(res, r, options)
+	options.completeFlight(res, r)
+
+	if options.stale != nil && res.StatusCode != http.StatusNotModified && notModified(r, res) {
+		setNotModified(res)
+	}
+}
+
+// storeHTTPCacheResponse caches the response if it is storable, until it is stale. Responses are
+// kept past that while they can be served stale, and responses with validators at least for the
+// cache timeout of the API, so they can be revalidated.
+func (m *ResponseCacheMiddleware) storeHTTPCacheResponse(res *http.Response, r *http.Request, options *cacheOptions) {
+	cc := parseCacheControl(res.Header)
+	if !httpCacheStorable(res, cc, options.cacheOnlyResponseCodes) {
+		return
+	}
+
+	now := time.Now()
+	stored := *res
+	stored.Header = res.Header.Clone()
+	if stored.Header.Get("Date") == "" {
+		stored.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	initialAge := httpCacheInitialAge(stored.Header, now)
+	// the age is computed from the freshness when the response is served
+	stored.Header.Del("Age")
+
+	freshUntil := now.Unix() + httpCacheLifetime(stored.Header, cc, m.spec.CacheOptions.CacheTimeout) - initialAge
+	cacheTTL := freshUntil - now.Unix()
+	if cacheTTL < 0 {
+		cacheTTL = 0
+	}
+	staleWindow := options.staleWindow()
+	if hasValidators(stored.Header) && m.spec.CacheOptions.CacheTimeout > staleWindow {
+		staleWindow = m.spec.CacheOptions.CacheTimeout
+	}
+	cacheTTL += staleWindow
+	if cacheTTL <= 0 {
+		return
+	}
+
+	var err error
+	res.Body, err = newNopCloserBuffer(res.Body)
+	if err != nil {
+		m.Logger().WithError(err).Error("error reading cache body")
+		return
+	}
+	stored.Body = res.Body
+
+	var wireFormatReq bytes.Buffer
+	if err := stored.Write(&wireFormatReq); err != nil {
+		m.Logger().WithError(err).Error("error encoding cache")
+		return
+	}
+
+	entries := map[string]string{}
+	key := options.key
+	if vary, _ := httpCacheVary(res.Header); len(vary) > 0 {
+		entries[key] = m.encodePayload(httpCacheVaryPrefix+strings.Join(vary, ","), freshUntil)
+		key = httpCacheVariantKey(key, vary, r)
+	}
+	entries[key] = m.encodePayload(wireFormatReq.String(), freshUntil)
+
+	store := m.store
+	tags := surrogateKeys(res.Header)
+	go func() {
+		keys := make([]string, 0, len(entries))
+		for key, toStore := range entries {
+			if err := store.SetKey(key, toStore, cacheTTL); err != nil {
+				m.Logger().WithError(err).Error("could not save key in cache store")
+				continue
+			}
+			keys = append(keys, key)
+		}
+		indexCacheEntries(store, keys, options, tags, cacheTTL)
+	}()
+}
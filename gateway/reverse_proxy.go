@@ -64,7 +64,20 @@ var corsHeaders = []string{
 var ServiceCache *cache.Cache
 var sdMu sync.RWMutex
 
-func urlFromService(spec *APISpec) (*apidef.HostList, error) {
+func (gw *Gateway) urlFromService(spec *APISpec) (*apidef.HostList, error) {
+	if isWatchedDiscovery(spec.Proxy.ServiceDiscovery.Provider) {
+		// the watcher keeps the host list up to date, no need to cache it
+		data, err := gw.watchedDiscovery.HostList(gw, spec)
+		if err != nil {
+			if spec.LastGoodHostList != nil {
+				log.WithError(err).Warning("[PROXY][SD] Couldn't watch the service, returning last good set.")
+				return spec.LastGoodHostList, nil
+			}
+			return nil, err
+		}
+		spec.LastGoodHostList = data
+		return data, nil
+	}
 
 	doCacheRefresh := func() (*apidef.HostList, error) {
 		log.Debug("--> Refreshing")
@@ -72,10 +85,20 @@ func urlFromService(spec *APISpec) (*apidef.HostList, error) {
 		defer func() { spec.ServiceRefreshInProgress = false }()
 		sd := ServiceDiscovery{}
 		sd.Init(&spec.Proxy.ServiceDiscovery)
+		if gw.dnsCacheManager != nil && gw.dnsCacheManager.IsCacheEnabled() {
+			sd.dnsCache = gw.dnsCacheManager.CacheStorage()
+		}
+		sd.targetDown = func(target string) bool {
+			return gw.targetDown(EnsureTransport(target, spec.Protocol), spec)
+		}
 		data, err := sd.Target(spec.Proxy.ServiceDiscovery.QueryEndpoint)
 		if err != nil {
 			return nil, err
 		}
+		if spec.Proxy.ServiceDiscovery.Provider != apidef.DNSSRVServiceDiscovery {
+			// unlike SRV records, the services don't tell the weights of the targets
+			data.SetWithWeights(data.All(), spec.Proxy.LoadBalancing.Weights(data.All()))
+		}
 		sdMu.Lock()
 		spec.HasRun = true
 		sdMu.Unlock()
@@ -91,7 +114,11 @@ func urlFromService(spec *APISpec) (*apidef.HostList, error) {
 			return spec.LastGoodHostList, nil
 		}
 
-		ServiceCache.Set(spec.APIID, data, cache.DefaultExpiration)
+		expiry := cache.DefaultExpiration
+		if sd.ttl > 0 {
+			expiry = sd.ttl
+		}
+		ServiceCache.Set(spec.APIID, data, expiry)
 		// Stash it too
 		spec.LastGoodHostList = data
 		return data, nil
@@ -144,9 +171,18 @@ func EnsureTransport(host, protocol string) string {
 	return u.String()
 }
 
-func (gw *Gateway) nextTarget(targetData *apidef.HostList, spec *APISpec) (string, error) {
-	if spec.Proxy.EnableLoadBalancing {
+// nextTarget selects the upstream target for the request from the host list. The request
+// may be nil when there is no HTTP request to select on, e.g. for TCP proxies. The targets of
+// upstream groups are balanced even if load balancing isn't enabled.
+func (gw *Gateway) nextTarget(targetData *apidef.HostList, spec *APISpec, r *http.Request) (string, error) {
+	if spec.Proxy.EnableLoadBalancing || spec.splitsTraffic() {
 		log.Debug("[PROXY] [LOAD BALANCING] Load balancer enabled, getting upstream target")
+		switch spec.Proxy.LoadBalancing.Algorithm {
+		case "", apidef.RoundRobinLoadBalancing:
+		default:
+			return gw.balancedTarget(targetData, spec, r)
+		}
+
 		// Use a HostList
 		startPos := spec.RoundRobin.WithLen(targetData.Len())
 		pos := startPos
@@ -157,20 +193,13 @@ func (gw *Gateway) nextTarget(targetData *apidef.HostList, spec *APISpec) (strin
 			}
 
 			host := EnsureTransport(gotHost, spec.Protocol)
-			if !spec.Proxy.CheckHostAgainstUptimeTests {
-				return host, nil // we don't care if it's up
-			}
-			// As checked by HostCheckerManager.AmIPolling
-			if gw.GlobalHostChecker.store == nil {
+			if !gw.targetDown(host, spec) {
 				return host, nil
 			}
-			if !gw.GlobalHostChecker.HostDown(host) {
-				return host, nil // we do care and it's up
-			}
 			// if the host is down, keep trying all the rest
 			// in order from where we started.
 			if pos = (pos + 1) % targetData.Len(); pos == startPos {
-				return "", fmt.Errorf("all hosts are down, uptime tests are failing")
+				return "", errAllHostsDown
 			}
 		}
 
@@ -237,14 +266,17 @@ func (gw *Gateway) TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec,
 		switch {
 		case spec.Proxy.ServiceDiscovery.UseDiscoveryService:
 			var err error
-			hostList, err = urlFromService(spec)
+			hostList, err = gw.urlFromService(spec)
 			if err != nil {
 				log.Error("[PROXY] [SERVICE DISCOVERY] Failed target lookup: ", err)
 				break
 			}
 			fallthrough // implies load balancing, with replaced host list
-		case spec.Proxy.EnableLoadBalancing:
-			host, err := gw.nextTarget(hostList, spec)
+		case spec.Proxy.EnableLoadBalancing, spec.splitsTraffic():
+			if spec.splitsTraffic() {
+				hostList = spec.upstreamGroupHostList(req)
+			}
+			host, err := gw.nextTarget(hostList, spec, req)
 			if err != nil {
 				log.Error("[PROXY] [LOAD BALANCING] ", err)
 				host = allHostsDownURL
@@ -301,6 +333,10 @@ func (gw *Gateway) TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec,
 			req.Header.Set(header.UserAgent, defaultUserAgent)
 		}
 
+		if spec.UpstreamTokenSource != nil {
+			spec.UpstreamTokenSource.Inject(req)
+		}
+
 		if spec.GlobalConfig.HttpServerOptions.SkipTargetPathEscaping {
 			// force RequestURI to skip escaping if API's proxy is set for this
 			// if we set opaque here it will force URL.RequestURI to skip escaping
@@ -687,6 +723,21 @@ func (p *ReverseProxy) httpTransport(timeOut float64, rw http.ResponseWriter, re
 
 	transport.DisableKeepAlives = p.TykAPISpec.GlobalConfig.ProxyCloseConnections
 
+	if conf := p.TykAPISpec.Proxy.Transport.ProxyProtocol; conf.Enabled {
+		dial := dialProxyProtocol(conf.Version, httpProxyProtocolAddrs, transport.DialContext)
+		transport.DialContext = dial
+		// the custom TLS checks dial on their own, without the header
+		if transport.DialTLS != nil {
+			check := p.Gw.tlsConnCheck(p.TykAPISpec)
+			transport.DialTLS = nil
+			transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialTLS(ctx, dial, network, addr, transport.TLSClientConfig, check)
+			}
+		}
+		// connections carry the address of the client they are opened for, so they can't be shared
+		transport.DisableKeepAlives = true
+	}
+
 	if p.Gw.GetConfig().ProxyEnableHttp2 {
 		http2.ConfigureTransport(transport)
 	}
@@ -917,11 +968,12 @@ func handleInMemoryLoop(handler http.Handler, r *http.Request) (resp *http.Respo
 	return memConnClient.Do(r)
 }
 
-func (p *ReverseProxy) handleOutboundRequest(roundTripper *TykRoundTripper, outreq *http.Request, w http.ResponseWriter) (res *http.Response, hijacked bool, latency time.Duration, err error) {
+func (p *ReverseProxy) handleOutboundRequest(roundTripper *TykRoundTripper, outreq *http.Request, w http.ResponseWriter, retryPolicy *apidef.RetryPolicy) (res *http.Response, release func(), hijacked bool, latency time.Duration, err error) {
 	begin := time.Now()
 	defer func() {
 		latency = time.Since(begin)
 	}()
+	release = func() {}
 
 	if p.TykAPISpec.HasMock {
 		if res, err = p.mockResponse(outreq); res != nil {
@@ -930,11 +982,12 @@ func (p *ReverseProxy) handleOutboundRequest(roundTripper *TykRoundTripper, outr
 	}
 
 	if p.TykAPISpec.GraphQL.Enabled {
+		release = p.trackUpstream(outreq)
 		res, hijacked, err = p.handleGraphQL(roundTripper, outreq, w)
 		return
 	}
 
-	res, err = p.sendRequestToUpstream(roundTripper, outreq)
+	res, release, err = p.sendRequestWithRetries(roundTripper, outreq, retryPolicy)
 	return
 }
 
@@ -1177,6 +1230,7 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 
 	// Do this before we make a shallow copy
 	session := ctxGetSession(req)
+	ctxInitAnalyticsTags(req)
 
 	outreq := new(http.Request)
 	logreq := new(http.Request)
@@ -1206,6 +1260,11 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		span := opentracing.SpanFromContext(req.Context())
 		trace.Inject(p.TykAPISpec.Name, span, outreq.Header)
 	}
+
+	// The director rewrites the path to the upstream one, so match the endpoint before
+	retryPolicy := p.CheckRetryPolicyEnforced(p.TykAPISpec, req)
+	shadow := p.CheckMirrorEnforced(p.TykAPISpec, req)
+
 	p.Director(outreq)
 	outreq.Close = false
 
@@ -1248,6 +1307,12 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		outreq.Header.Set(header.XForwardFor, addrs)
 	}
 
+	if proxyProtocol := p.TykAPISpec.Proxy.Transport.ProxyProtocol; proxyProtocol.Enabled {
+		if addr := clientAddr(req, proxyProtocol.TrustForwardedFor); addr != nil {
+			ctxSetClientAddr(outreq, addr)
+		}
+	}
+
 	// Circuit breaker
 	breakerEnforced, breakerConf := p.CheckCircuitBreakerEnforced(p.TykAPISpec, req)
 
@@ -1285,6 +1350,10 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		outreq.URL.Scheme = "http"
 	}
 
+	if shadow != nil {
+		p.mirror(roundTripper, outreq, shadow)
+	}
+
 	if p.TykAPISpec.Proxy.Transport.SSLForceCommonNameCheck || p.Gw.GetConfig().SSLForceCommonNameCheck {
 		// if proxy is enabled, add CommonName verification in verifyPeerCertificate
 		// DialTLS is not executed if proxy is used
@@ -1304,6 +1373,7 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	// do request round trip
 	var (
 		res             *http.Response
+		releaseUpstream func()
 		isHijacked      bool
 		upstreamLatency time.Duration
 		err             error
@@ -1312,19 +1382,28 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	if breakerEnforced {
 		if !breakerConf.CB.Ready() {
 			p.logger.Debug("ON REQUEST: Circuit Breaker is in OPEN state")
+			if stale := p.serveStaleIfError(rw, req, session); stale != nil {
+				return ProxyResponse{Response: stale}
+			}
 			p.ErrorHandler.HandleError(rw, logreq, "Service temporarily unavailable.", 503, true)
 			return ProxyResponse{}
 		}
 		p.logger.Debug("ON REQUEST: Circuit Breaker is in CLOSED or HALF-OPEN state")
 
-		res, isHijacked, upstreamLatency, err = p.handleOutboundRequest(roundTripper, outreq, rw)
+		res, releaseUpstream, isHijacked, upstreamLatency, err = p.handleOutboundRequest(roundTripper, outreq, rw, retryPolicy)
+		defer releaseUpstream()
 		if err != nil || res.StatusCode/100 == 5 {
 			breakerConf.CB.Fail()
 		} else {
 			breakerConf.CB.Success()
 		}
 	} else {
-		res, isHijacked, upstreamLatency, err = p.handleOutboundRequest(roundTripper, outreq, rw)
+		res, releaseUpstream, isHijacked, upstreamLatency, err = p.handleOutboundRequest(roundTripper, outreq, rw, retryPolicy)
+		defer releaseUpstream()
+	}
+
+	if p.TykAPISpec.Proxy.OutlierDetection.Enabled {
+		p.reportUpstreamOutcome(outreq, res, err)
 	}
 
 	if err != nil {
@@ -1350,6 +1429,12 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 			return ProxyResponse{UpstreamLatency: upstreamLatency}
 		}
 
+		if !strings.Contains(err.Error(), "context canceled") {
+			if stale := p.serveStaleIfError(rw, req, session); stale != nil {
+				return ProxyResponse{UpstreamLatency: upstreamLatency, Response: stale}
+			}
+		}
+
 		if strings.Contains(err.Error(), "timeout awaiting response headers") {
 			p.ErrorHandler.HandleError(rw, logreq, "Upstream service reached hard timeout.", http.StatusGatewayTimeout, true)
 
@@ -1380,6 +1465,13 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		return ProxyResponse{UpstreamLatency: upstreamLatency}
 	}
 
+	if call := ctxGetGRPCCall(req); call != nil {
+		res = call.transcodeResponse(res)
+	}
+	if call := ctxGetGRPCWebCall(req); call != nil {
+		res = call.translateResponse(res)
+	}
+
 	upgrade, _ := p.IsUpgrade(req)
 	// Deal with 101 Switching Protocols responses: (WebSocket, h2c, etc)
 	if upgrade {
@@ -1434,6 +1526,24 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	return ProxyResponse{UpstreamLatency: upstreamLatency, Response: inres}
 }
 
+// serveStaleIfError serves the expired cached response to the request instead of an upstream error,
+// if the cache allows it. It returns the response served, if any.
+func (p *ReverseProxy) serveStaleIfError(rw http.ResponseWriter, req *http.Request, ses *user.SessionState) *http.Response {
+	options := ctxGetCacheOptions(req)
+	if options == nil {
+		return nil
+	}
+	stale := options.takeErrorFallback(req)
+	if stale == nil {
+		return nil
+	}
+
+	p.logger.Debug("Upstream failed, serving the expired cached response")
+	options.completeFlight(stale, req)
+	p.HandleResponse(rw, stale, ses)
+	return stale
+}
+
 func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response, ses *user.SessionState) error {
 
 	// Remove hop-by-hop headers listed in the
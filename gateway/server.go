@@ -128,6 +128,9 @@ type Gateway struct {
 	ExpiryCache *cache.Cache
 	// memory cache to store arbitrary items
 	UtilCache *cache.Cache
+	// memory cache holding the hot response cache entries
+	localResponseCache   *localResponseCache
+	localResponseCacheMu sync.Mutex
 
 	// Nonce to use when interacting with the dashboard service
 	ServiceNonce      string
@@ -143,6 +146,12 @@ type Gateway struct {
 
 	dnsCacheManager dnscache.IDnsCacheManager
 
+	// retryBudget limits the upstream retries of all APIs
+	retryBudget RetryBudget
+
+	// watchedDiscovery keeps the targets of the APIs using watched service discovery
+	watchedDiscovery watchedDiscovery
+
 	consulKVStore kv.Store
 	vaultKVStore  kv.Store
 
@@ -665,6 +674,9 @@ func (gw *Gateway) loadControlAPIEndpoints(muxer *mux.Router) {
 
 	r.HandleFunc("/debug", gw.traceHandler).Methods("POST")
 	r.HandleFunc("/cache/{apiID}", gw.invalidateCacheHandler).Methods("DELETE")
+	r.HandleFunc("/cache/{apiID}/paths", gw.invalidateCachePathsHandler).Methods("DELETE")
+	r.HandleFunc("/cache/{apiID}/tags/{tag}", gw.invalidateCacheTagHandler).Methods("DELETE")
+	r.HandleFunc("/cache/{apiID}/keys/{keyName:[^/]*}", gw.invalidateCacheKeyHandler).Methods("DELETE")
 	r.HandleFunc("/keys", gw.keyHandler).Methods("POST", "PUT", "GET", "DELETE")
 	r.HandleFunc("/keys/preview", gw.previewKeyHandler).Methods("POST")
 	r.HandleFunc("/keys/{keyName:[^/]*}", gw.keyHandler).Methods("POST", "PUT", "GET", "DELETE")
@@ -910,7 +922,7 @@ func (gw *Gateway) createResponseMiddlewareChain(spec *APISpec, responseFuncs []
 	cacheStore.Connect()
 
 	// Add cache writer as the final step of the response middleware chain
-	processor := &ResponseCacheMiddleware{store: cacheStore}
+	processor := &ResponseCacheMiddleware{store: gw.wrapLocalResponseCache(cacheStore, spec)}
 	if err := processor.Init(nil, spec); err != nil {
 		mainLog.WithError(err).Debug("Failed to init processor")
 	}
@@ -924,11 +936,16 @@ func handleCORS(router *mux.Router, spec *APISpec) {
 
 	if spec.CORS.Enable {
 		mainLog.Debug("CORS ENABLED")
+		allowedHeaders, exposedHeaders := spec.CORS.AllowedHeaders, spec.CORS.ExposedHeaders
+		if spec.Proxy.GRPCWeb.Enabled {
+			allowedHeaders, exposedHeaders = grpcWebCORSHeaders(allowedHeaders, exposedHeaders)
+		}
+
 		c := cors.New(cors.Options{
 			AllowedOrigins:     spec.CORS.AllowedOrigins,
 			AllowedMethods:     spec.CORS.AllowedMethods,
-			AllowedHeaders:     spec.CORS.AllowedHeaders,
-			ExposedHeaders:     spec.CORS.ExposedHeaders,
+			AllowedHeaders:     allowedHeaders,
+			ExposedHeaders:     exposedHeaders,
 			AllowCredentials:   spec.CORS.AllowCredentials,
 			MaxAge:             spec.CORS.MaxAge,
 			OptionsPassthrough: spec.CORS.OptionsPassthrough,
@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Jeffail/gabs"
 
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/dnscache"
 )
 
 const arrayName = "tyk_array"
@@ -23,6 +25,13 @@ type ServiceDiscovery struct {
 	parentPath          string
 	portPath            string
 	targetPath          string
+
+	// dnsCache is seeded with the addresses of the targets returned along SRV records.
+	dnsCache dnscache.IDnsCacheStorage
+	// targetDown reports whether a target is down, to fall back to SRV records of lower priority.
+	targetDown func(target string) bool
+	// ttl is how long the targets can be cached for, if the service tells.
+	ttl time.Duration
 }
 
 func (s *ServiceDiscovery) Init(spec *apidef.ServiceDiscoveryConfiguration) {
@@ -266,6 +275,10 @@ func (s *ServiceDiscovery) ProcessRawData(rawData string) (*apidef.HostList, err
 }
 
 func (s *ServiceDiscovery) Target(serviceURL string) (*apidef.HostList, error) {
+	if s.spec != nil && s.spec.Provider == apidef.DNSSRVServiceDiscovery {
+		return s.srvTarget(serviceURL)
+	}
+
 	// Get the data
 	rawData, err := s.getServiceData(serviceURL)
 	if err != nil {
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage/kv"
+)
+
+const (
+	consulDiscoveryMinBackoff = time.Second
+	consulDiscoveryMaxBackoff = 30 * time.Second
+)
+
+var errNoPassingInstances = errors.New("no passing instances")
+
+// consulServiceWatcher streams the healthy instances of a Consul service into the host list
+// of an API.
+type consulServiceWatcher struct {
+	health     *api.Health
+	service    string
+	targetPath string
+	hostList   *apidef.HostList
+
+	// index is the Consul index of the last update, blocking queries return once it changes
+	index uint64
+}
+
+// watchConsulService loads the healthy instances of the Consul service of the API and watches
+// them for changes.
+func (gw *Gateway) watchConsulService(ctx context.Context, spec *APISpec) (*consulServiceWatcher, error) {
+	client, err := kv.NewConsulClient(gw.GetConfig().KV.Consul)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &consulServiceWatcher{
+		health:     client.Health(),
+		service:    spec.Proxy.ServiceDiscovery.QueryEndpoint,
+		targetPath: spec.Proxy.ServiceDiscovery.TargetPath,
+		hostList:   apidef.NewHostList(),
+	}
+	if err := w.update(ctx); err != nil {
+		return nil, err
+	}
+
+	go w.watch(ctx)
+	return w, nil
+}
+
+func (w *consulServiceWatcher) watch(ctx context.Context) {
+	backoff := consulDiscoveryMinBackoff
+	for {
+		err := w.update(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil:
+			backoff = consulDiscoveryMinBackoff
+		case errors.Is(err, errNoPassingInstances):
+			log.Warning("[PROXY] [SERVICE DISCOVERY] Consul service ", w.service, " has no passing instances, keeping the last good targets")
+			backoff = consulDiscoveryMinBackoff
+		default:
+			log.WithError(err).Error("[PROXY] [SERVICE DISCOVERY] Consul query for service ", w.service, " failed, retrying in ", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > consulDiscoveryMaxBackoff {
+				backoff = consulDiscoveryMaxBackoff
+			}
+		}
+	}
+}
+
+// update waits for the instances of the service to change, then updates the host list.
+// The first query returns immediately.
+func (w *consulServiceWatcher) update(ctx context.Context) error {
+	opts := (&api.QueryOptions{WaitIndex: w.index}).WithContext(ctx)
+	entries, meta, err := w.health.Service(w.service, "", true, opts)
+	if err != nil {
+		return err
+	}
+
+	// as recommended by Consul, start over if the index goes backwards, and never wait on
+	// an index of 0 which returns immediately
+	if meta.LastIndex < w.index {
+		w.index = 0
+	} else {
+		w.index = meta.LastIndex
+	}
+	if w.index < 1 {
+		w.index = 1
+	}
+
+	if len(entries) == 0 {
+		return errNoPassingInstances
+	}
+
+	hosts := make([]string, 0, len(entries))
+	weights := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+		address := entry.Service.Address
+		if address == "" && entry.Node != nil {
+			// the service is registered on the address of its node
+			address = entry.Node.Address
+		}
+		hosts = append(hosts, net.JoinHostPort(address, strconv.Itoa(entry.Service.Port))+w.targetPath)
+		weights = append(weights, entry.Service.Weights.Passing)
+	}
+	w.hostList.SetWithWeights(hosts, weights)
+	return nil
+}
+
+func (w *consulServiceWatcher) HostList() *apidef.HostList {
+	return w.hostList
+}
+
+// TrackingHosts returns no uptime tests, as Consul only returns the instances passing its
+// health checks.
+func (w *consulServiceWatcher) TrackingHosts() []HostData {
+	return nil
+}
@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+// fakeConsul serves the health endpoint of Consul, with support for blocking queries.
+type fakeConsul struct {
+	mu        sync.Mutex
+	index     uint64
+	instances []map[string]interface{}
+	changed   chan struct{}
+	queries   []string
+}
+
+func (c *fakeConsul) set(instances ...map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index++
+	c.instances = instances
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/v1/health/service/web") {
+		http.NotFound(w, r)
+		return
+	}
+
+	c.mu.Lock()
+	c.queries = append(c.queries, r.URL.RawQuery)
+	index, changed := c.index, c.changed
+	c.mu.Unlock()
+
+	if waitIndex, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); err == nil && waitIndex >= index {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	json.NewEncoder(w).Encode(c.instances)
+}
+
+func consulInstance(nodeAddress, address string, port, weight int) map[string]interface{} {
+	return map[string]interface{}{
+		"Node": map[string]interface{}{"Address": nodeAddress},
+		"Service": map[string]interface{}{
+			"Service": "web",
+			"Address": address,
+			"Port":    port,
+			"Weights": map[string]interface{}{"Passing": weight, "Warning": 1},
+		},
+	}
+}
+
+func TestConsulServiceDiscovery(t *testing.T) {
+	consul := &fakeConsul{changed: make(chan struct{})}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	gw := &Gateway{}
+	conf := config.Config{}
+	conf.KV.Consul.Address = strings.TrimPrefix(server.URL, "http://")
+	gw.SetConfig(conf)
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+	spec.Proxy.ServiceDiscovery = apidef.ServiceDiscoveryConfiguration{
+		UseDiscoveryService: true,
+		Provider:            apidef.ConsulServiceDiscovery,
+		QueryEndpoint:       "web",
+	}
+	defer gw.watchedDiscovery.Prune(map[string]*APISpec{})
+
+	_, err := gw.urlFromService(spec)
+	assert.Error(t, err, "services without passing instances should fail the lookup")
+
+	consul.set(consulInstance("10.0.0.1", "", 8080, 3))
+	var hostList *apidef.HostList
+	if !assert.Eventually(t, func() bool {
+		hostList, err = gw.urlFromService(spec)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond, "failed lookups should be retried after a backoff") {
+		return
+	}
+	assert.Equal(t, []string{"10.0.0.1:8080"}, hostList.All())
+	assert.Equal(t, 3, hostList.GetWeight(0))
+
+	consul.set(consulInstance("10.0.0.1", "", 8080, 3), consulInstance("10.0.0.1", "10.1.0.2", 9090, 1))
+	assert.Eventually(t, func() bool {
+		return hostList.Len() == 2
+	}, 2*time.Second, 10*time.Millisecond, "changes should be streamed into the host list")
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.1.0.2:9090"}, hostList.All())
+
+	consul.set()
+	assert.Eventually(t, func() bool {
+		consul.mu.Lock()
+		defer consul.mu.Unlock()
+		return strings.Contains(consul.queries[len(consul.queries)-1], "index=3")
+	}, 2*time.Second, 10*time.Millisecond, "the watcher should wait for the next change")
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.1.0.2:9090"}, hostList.All(), "last good targets should be kept")
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	for _, query := range consul.queries {
+		assert.Contains(t, query, "passing=1")
+	}
+}
+
+func TestConsulServiceDiscovery_SlowSetup(t *testing.T) {
+	queried, release := make(chan struct{}, 1), make(chan struct{})
+	var queries int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		queried <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "tyk-file-discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.yaml")
+	if err := ioutil.WriteFile(path, []byte("targets:\n  - url: http://10.0.0.1:8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gw := &Gateway{}
+	conf := config.Config{}
+	conf.KV.Consul.Address = strings.TrimPrefix(server.URL, "http://")
+	gw.SetConfig(conf)
+	defer gw.watchedDiscovery.Prune(map[string]*APISpec{})
+
+	newSpec := func(apiID string, provider apidef.ServiceDiscoveryProvider, queryEndpoint string) *APISpec {
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: apiID}}
+		spec.Proxy.ServiceDiscovery = apidef.ServiceDiscoveryConfiguration{
+			UseDiscoveryService: true,
+			Provider:            provider,
+			QueryEndpoint:       queryEndpoint,
+		}
+		return spec
+	}
+	slow := newSpec("slow", apidef.ConsulServiceDiscovery, "web")
+	other := newSpec("other", apidef.FileServiceDiscovery, path)
+
+	slowDone := make(chan error)
+	go func() {
+		_, err := gw.urlFromService(slow)
+		slowDone <- err
+	}()
+	<-queried
+
+	otherDone := make(chan error)
+	go func() {
+		_, err := gw.urlFromService(other)
+		otherDone <- err
+	}()
+	select {
+	case err := <-otherDone:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Error("other APIs shouldn't wait for the Consul query")
+	}
+
+	close(release)
+	assert.Error(t, <-slowDone)
+
+	_, err = gw.urlFromService(slow)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&queries), "failed setups shouldn't be retried before the backoff")
+}
@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const discoveryFileInterval = time.Second
+
+// discoveryFile is the format of the files targets are discovered from, in JSON or YAML:
+//
+//	targets:
+//	  - url: http://10.0.0.1:8080
+//	    weight: 3
+//	    metadata:
+//	      zone: eu-west-1a
+type discoveryFile struct {
+	Targets []discoveryFileTarget `json:"targets" yaml:"targets"`
+}
+
+type discoveryFileTarget struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight" yaml:"weight"`
+	// Metadata is added to the uptime test reports of the target.
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+func parseDiscoveryFile(contents []byte) ([]discoveryFileTarget, error) {
+	var file discoveryFile
+	var err error
+	if json.Valid(contents) {
+		err = json.Unmarshal(contents, &file)
+	} else {
+		err = yaml.Unmarshal(contents, &file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	targets := file.Targets[:0]
+	for _, target := range file.Targets {
+		if target.URL != "" {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets listed")
+	}
+	return targets, nil
+}
+
+// watchDiscoveryFile loads the targets file of the API and watches it for changes.
+func (gw *Gateway) watchDiscoveryFile(ctx context.Context, spec *APISpec) (*discoveryFileWatcher, error) {
+	w := &discoveryFileWatcher{
+		gw:          gw,
+		apiID:       spec.APIID,
+		path:        spec.Proxy.ServiceDiscovery.QueryEndpoint,
+		protocol:    spec.Protocol,
+		checkUptime: spec.Proxy.CheckHostAgainstUptimeTests,
+		hostList:    apidef.NewHostList(),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	go w.watch(ctx, discoveryFileInterval)
+	return w, nil
+}
+
+// discoveryFileWatcher keeps the host list of an API in sync with its targets file. The settings
+// of the API it needs are copied, as the API specs mustn't be looked up while holding mu.
+type discoveryFileWatcher struct {
+	gw          *Gateway
+	apiID       string
+	path        string
+	protocol    string
+	checkUptime bool
+	hostList    *apidef.HostList
+
+	mu       sync.Mutex
+	contents []byte
+	targets  []discoveryFileTarget
+}
+
+func (w *discoveryFileWatcher) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.reload(); err != nil {
+				log.WithError(err).Warning("[PROXY] [SERVICE DISCOVERY] Couldn't reload targets file ", w.path, ", keeping the last good targets")
+			}
+		}
+	}
+}
+
+// reload updates the host list and the uptime tests of the API if the file has changed.
+func (w *discoveryFileWatcher) reload() error {
+	contents, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if w.contents != nil && bytes.Equal(contents, w.contents) {
+		w.mu.Unlock()
+		return nil
+	}
+	// invalid contents are only reported once, until the file changes again
+	w.contents = contents
+	targets, err := parseDiscoveryFile(contents)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	w.targets = targets
+
+	hosts := make([]string, len(targets))
+	weights := make([]int, len(targets))
+	for i, target := range targets {
+		hosts[i], weights[i] = target.URL, target.Weight
+	}
+	w.hostList.SetWithWeights(hosts, weights)
+	tracking := w.trackingHostsLocked()
+	w.mu.Unlock()
+
+	log.Info("[PROXY] [SERVICE DISCOVERY] Loaded ", len(hosts), " targets from ", w.path)
+	if tracking != nil {
+		w.gw.GlobalHostChecker.UpdateTrackingListByAPIID(tracking, w.apiID)
+	}
+	return nil
+}
+
+func (w *discoveryFileWatcher) HostList() *apidef.HostList {
+	return w.hostList
+}
+
+func (w *discoveryFileWatcher) TrackingHosts() []HostData {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.trackingHostsLocked()
+}
+
+// trackingHostsLocked returns the uptime tests of the targets, if the API checks its targets
+// against the uptime tests.
+func (w *discoveryFileWatcher) trackingHostsLocked() []HostData {
+	if !w.checkUptime || w.gw.GetConfig().UptimeTests.Disable {
+		return nil
+	}
+
+	hosts := make([]HostData, 0, len(w.targets))
+	for _, target := range w.targets {
+		checkObject := apidef.HostCheckObject{CheckURL: EnsureTransport(target.URL, w.protocol)}
+		host, err := w.gw.GlobalHostChecker.PrepareTrackingHost(checkObject, w.apiID)
+		if err != nil {
+			continue
+		}
+		for key, value := range target.Metadata {
+			if _, reserved := host.MetaData[key]; !reserved {
+				host.MetaData[key] = value
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+func TestParseDiscoveryFile(t *testing.T) {
+	expected := []discoveryFileTarget{
+		{URL: "http://10.0.0.1:8080", Weight: 3, Metadata: map[string]string{"zone": "a"}},
+		{URL: "http://10.0.0.2:8080"},
+	}
+
+	targets, err := parseDiscoveryFile([]byte(`{
+	"targets": [
+		{"url": "http://10.0.0.1:8080", "weight": 3, "metadata": {"zone": "a"}},
+		{"url": "http://10.0.0.2:8080"},
+		{"weight": 2}
+	]
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, targets)
+
+	targets, err = parseDiscoveryFile([]byte(`
+targets:
+  - url: http://10.0.0.1:8080
+    weight: 3
+    metadata:
+      zone: a
+  - url: http://10.0.0.2:8080
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, targets)
+
+	_, err = parseDiscoveryFile([]byte(`targets: []`))
+	assert.Error(t, err)
+
+	_, err = parseDiscoveryFile([]byte(`targets: {`))
+	assert.Error(t, err)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-file-discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.yaml")
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+	spec.Proxy.ServiceDiscovery = apidef.ServiceDiscoveryConfiguration{
+		UseDiscoveryService: true,
+		Provider:            apidef.FileServiceDiscovery,
+		QueryEndpoint:       path,
+	}
+	spec.Proxy.CheckHostAgainstUptimeTests = true
+	gw.apisByID = map[string]*APISpec{spec.APIID: spec}
+
+	_, err = gw.urlFromService(spec)
+	assert.Error(t, err, "missing file should fail the lookup")
+
+	write("targets:\n  - url: http://10.0.0.1:8080\n    weight: 3\n    metadata:\n      zone: a\n")
+	_, err = gw.urlFromService(spec)
+	assert.Error(t, err, "failed lookups should only be retried after a backoff")
+
+	var hostList *apidef.HostList
+	if !assert.Eventually(t, func() bool {
+		hostList, err = gw.urlFromService(spec)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond) {
+		return
+	}
+	assert.Equal(t, []string{"http://10.0.0.1:8080"}, hostList.All())
+	assert.Equal(t, 3, hostList.GetWeight(0))
+	assert.Equal(t, hostList, spec.LastGoodHostList)
+
+	tracked, ok := gw.GlobalHostChecker.currentHostList["http://10.0.0.1:8080"]
+	assert.True(t, ok, "targets should be tracked by the uptime tests")
+	assert.Equal(t, "a", tracked.MetaData["zone"])
+	assert.Equal(t, "api", tracked.MetaData[UnHealthyHostMetaDataAPIKey])
+
+	watcher := gw.watchedDiscovery.watchers[spec.APIID].watcher.(*discoveryFileWatcher)
+
+	t.Run("file changes", func(t *testing.T) {
+		write("targets:\n  - url: http://10.0.0.2:8080\n  - url: http://10.0.0.3:8080\n")
+		assert.NoError(t, watcher.reload())
+		assert.Equal(t, []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}, hostList.All())
+
+		_, ok := gw.GlobalHostChecker.currentHostList["http://10.0.0.1:8080"]
+		assert.False(t, ok, "removed targets should no longer be tracked")
+		assert.Len(t, gw.GlobalHostChecker.currentHostList, 2)
+		assert.Len(t, gw.watchedDiscovery.TrackingHosts(), 2)
+
+		same, err := gw.urlFromService(spec)
+		assert.NoError(t, err)
+		assert.True(t, same == hostList, "host list should be updated in place")
+	})
+
+	t.Run("invalid changes keep the last good targets", func(t *testing.T) {
+		write("targets: [")
+		assert.Error(t, watcher.reload())
+		assert.NoError(t, watcher.reload(), "the same invalid file should be reported once")
+		assert.Equal(t, []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}, hostList.All())
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		gw.watchedDiscovery.Prune(map[string]*APISpec{spec.APIID: spec})
+		assert.Len(t, gw.watchedDiscovery.watchers, 1)
+
+		gw.watchedDiscovery.Prune(map[string]*APISpec{})
+		assert.Empty(t, gw.watchedDiscovery.watchers)
+	})
+}
+
+func TestFileDiscovery_ConcurrentReloads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-file-discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.yaml")
+	if err := ioutil.WriteFile(path, []byte("targets:\n  - url: http://10.0.0.1:8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	newSpec := func(apiID string) *APISpec {
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: apiID}}
+		spec.Proxy.ServiceDiscovery = apidef.ServiceDiscoveryConfiguration{
+			UseDiscoveryService: true,
+			Provider:            apidef.FileServiceDiscovery,
+			QueryEndpoint:       path,
+		}
+		spec.Proxy.CheckHostAgainstUptimeTests = true
+		return spec
+	}
+
+	// finishes reports whether f returns, rather than waiting on a lock held by the test
+	finishes := func(f func()) bool {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+
+	t.Run("first request during a reload", func(t *testing.T) {
+		spec := newSpec("first")
+		gw.apisMu.Lock()
+		ok := finishes(func() {
+			_, err := gw.urlFromService(spec)
+			assert.NoError(t, err)
+		})
+		gw.apisMu.Unlock()
+		assert.True(t, ok, "discovering targets shouldn't wait for the API reload")
+	})
+
+	t.Run("reload during a discovery", func(t *testing.T) {
+		spec := newSpec("reloaded")
+		gw.watchedDiscovery.mu.Lock()
+		reloaded := make(chan struct{})
+		go func() {
+			defer close(reloaded)
+			gw.replaceAPISpecs([]*APISpec{spec}, map[string]*APISpec{spec.APIID: spec}, new(sync.Map))
+		}()
+		ok := finishes(func() {
+			for gw.getApiSpec(spec.APIID) == nil {
+				time.Sleep(time.Millisecond)
+			}
+		})
+		gw.watchedDiscovery.mu.Unlock()
+		<-reloaded
+		assert.True(t, ok, "the API specs shouldn't be locked while waiting for the discovery")
+		assert.Empty(t, gw.watchedDiscovery.watchers, "the watchers of the removed APIs should be stopped")
+	})
+}
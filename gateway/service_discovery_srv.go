@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	resolvConfPath   = "/etc/resolv.conf"
+	srvLookupTimeout = 5 * time.Second
+	// minSRVTTL keeps records with a TTL of 0 from being resolved on every request.
+	minSRVTTL = time.Second
+)
+
+// srvTarget resolves the SRV records of name into the host list of the targets they point to.
+func (s *ServiceDiscovery) srvTarget(name string) (*apidef.HostList, error) {
+	res, err := lookupSRV(name, s.spec.DNSServer)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*dns.SRV
+	var ttl uint32
+	for _, rr := range res.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if srv.Target == "." {
+			// the service is decidedly not available at this domain
+			continue
+		}
+		if len(records) == 0 || srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+		records = append(records, srv)
+	}
+
+	s.ttl = time.Duration(ttl) * time.Second
+	if s.ttl < minSRVTTL {
+		s.ttl = minSRVTTL
+	}
+	s.cacheSRVAddrs(res.Extra)
+
+	records = orderSRVByWeight(s.srvTier(records))
+	hosts := make([]string, len(records))
+	weights := make([]int, len(records))
+	for i, srv := range records {
+		hosts[i] = srvHost(srv) + s.targetPath
+		weights[i] = int(srv.Weight)
+	}
+
+	hostList := apidef.NewHostList()
+	hostList.SetWithWeights(hosts, weights)
+	return hostList, nil
+}
+
+// lookupSRV queries the SRV records of name from server, or from the nameservers of the system.
+func lookupSRV(name, server string) (*dns.Msg, error) {
+	servers := []string{server}
+	if server == "" {
+		conf, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, err
+		}
+		servers = servers[:0]
+		for _, ns := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(ns, conf.Port))
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), dns.TypeSRV)
+
+	err := errors.New("no nameservers to query")
+	for _, server := range servers {
+		var res *dns.Msg
+		res, _, err = (&dns.Client{Timeout: srvLookupTimeout}).Exchange(query, server)
+		if err == nil && res.Truncated {
+			res, _, err = (&dns.Client{Net: "tcp", Timeout: srvLookupTimeout}).Exchange(query, server)
+		}
+		if err != nil {
+			continue
+		}
+		if res.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("SRV lookup of %s failed: %s", name, dns.RcodeToString[res.Rcode])
+		}
+		return res, nil
+	}
+	return nil, err
+}
+
+// srvTier returns the records of the lowest priority that has a target up. Records of higher
+// priority values are only used while all the targets of the lower ones are down.
+func (s *ServiceDiscovery) srvTier(records []*dns.SRV) []*dns.SRV {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	var first []*dns.SRV
+	for start := 0; start < len(records); {
+		end := start
+		for end < len(records) && records[end].Priority == records[start].Priority {
+			end++
+		}
+		tier := records[start:end]
+		if first == nil {
+			first = tier
+		}
+		if s.targetDown == nil {
+			break
+		}
+		for _, srv := range tier {
+			if !s.targetDown(srvHost(srv) + s.targetPath) {
+				return tier
+			}
+		}
+		start = end
+	}
+	return first
+}
+
+// orderSRVByWeight orders records randomly, with the chance of each record to come first
+// proportional to its weight, as described in RFC 2782.
+func orderSRVByWeight(records []*dns.SRV) []*dns.SRV {
+	remaining := append([]*dns.SRV{}, records...)
+	// records without weight go first, so that they only have a very small chance of being picked
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Weight == 0 && remaining[j].Weight != 0
+	})
+
+	ordered := make([]*dns.SRV, 0, len(remaining))
+	for len(remaining) > 0 {
+		total := 0
+		for _, srv := range remaining {
+			total += int(srv.Weight)
+		}
+
+		pick, sum, i := rand.Intn(total+1), 0, 0
+		for ; i < len(remaining)-1; i++ {
+			if sum += int(remaining[i].Weight); sum >= pick {
+				break
+			}
+		}
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
+
+// cacheSRVAddrs adds the addresses of the targets returned along SRV records to the DNS cache,
+// saving the lookups when connecting to them.
+func (s *ServiceDiscovery) cacheSRVAddrs(extra []dns.RR) {
+	if s.dnsCache == nil {
+		return
+	}
+
+	addrs := map[string][]string{}
+	for _, rr := range extra {
+		host := strings.TrimSuffix(rr.Header().Name, ".")
+		switch x := rr.(type) {
+		case *dns.A:
+			addrs[host] = append(addrs[host], x.A.String())
+		case *dns.AAAA:
+			addrs[host] = append(addrs[host], x.AAAA.String())
+		}
+	}
+	for host, hostAddrs := range addrs {
+		s.dnsCache.Set(host, hostAddrs)
+	}
+}
+
+func srvHost(srv *dns.SRV) string {
+	return net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+}
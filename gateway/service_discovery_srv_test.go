@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/dnscache"
+)
+
+// startSRVServer serves the SRV records of name, along with addresses of their targets, from an
+// in-process DNS server. It returns the address of the server.
+func startSRVServer(t *testing.T, name string, records []*dns.SRV, extra []dns.RR) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, NotifyStartedFunc: func() { close(started) }}
+	server.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Name != name || r.Question[0].Qtype != dns.TypeSRV {
+			msg.SetRcode(r, dns.RcodeNameError)
+			w.WriteMsg(msg)
+			return
+		}
+		for _, srv := range records {
+			msg.Answer = append(msg.Answer, srv)
+		}
+		msg.Extra = extra
+		w.WriteMsg(msg)
+	})
+
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+func srvRecord(name string, ttl uint32, priority, weight, port uint16, target string) *dns.SRV {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   target,
+	}
+}
+
+func TestServiceDiscovery_DNSSRV(t *testing.T) {
+	const name = "_http._tcp.service.example."
+	records := []*dns.SRV{
+		srvRecord(name, 30, 20, 0, 8080, "backup.example."),
+		srvRecord(name, 60, 10, 3, 8080, "primary-1.example."),
+		srvRecord(name, 90, 10, 1, 8081, "primary-2.example."),
+	}
+	extra := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "primary-1.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("10.0.0.1")},
+		&dns.A{Hdr: dns.RR_Header{Name: "primary-1.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("10.0.0.2")},
+	}
+	server := startSRVServer(t, name, records, extra)
+
+	newSD := func() *ServiceDiscovery {
+		sd := &ServiceDiscovery{}
+		sd.Init(&apidef.ServiceDiscoveryConfiguration{
+			Provider:   apidef.DNSSRVServiceDiscovery,
+			DNSServer:  server,
+			TargetPath: "/api",
+		})
+		return sd
+	}
+	weights := func(hostList *apidef.HostList) map[string]int {
+		byHost := map[string]int{}
+		for i, host := range hostList.All() {
+			byHost[host] = hostList.GetWeight(i)
+		}
+		return byHost
+	}
+
+	t.Run("lowest priority", func(t *testing.T) {
+		sd := newSD()
+		cache := dnscache.NewDnsCacheStorage(time.Minute, time.Minute)
+		sd.dnsCache = cache
+
+		hostList, err := sd.Target("_http._tcp.service.example")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"primary-1.example:8080/api": 3, "primary-2.example:8081/api": 1}, weights(hostList))
+		assert.Equal(t, 30*time.Second, sd.ttl, "targets should be cached for the shortest TTL")
+
+		item, ok := cache.Get("primary-1.example")
+		assert.True(t, ok)
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, item.Addrs)
+	})
+
+	t.Run("falls back to higher priority values", func(t *testing.T) {
+		sd := newSD()
+		sd.targetDown = func(target string) bool {
+			return target != "backup.example:8080/api"
+		}
+
+		hostList, err := sd.Target("_http._tcp.service.example")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"backup.example:8080/api"}, hostList.All())
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		_, err := newSD().Target("_http._tcp.unknown.example")
+		assert.Error(t, err)
+	})
+}
+
+func TestOrderSRVByWeight(t *testing.T) {
+	records := []*dns.SRV{
+		{Target: "light.", Weight: 5},
+		{Target: "none.", Weight: 0},
+		{Target: "heavy.", Weight: 9},
+	}
+
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ordered := orderSRVByWeight(records)
+		assert.Len(t, ordered, len(records))
+		first[ordered[0].Target]++
+	}
+
+	assert.True(t, first["heavy."] > first["light."], "heavier targets should come first more often")
+	assert.True(t, first["light."] > first["none."], "targets without weight should rarely come first")
+	assert.Equal(t, "light.", records[0].Target, "records should not be reordered in place")
+}
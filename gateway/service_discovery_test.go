@@ -1,7 +1,16 @@
 package gateway
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/pmylund/go-cache"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
 )
 
 const consul = `
@@ -370,3 +379,35 @@ func TestServiceDiscovery_MESOSPHERE(t *testing.T) {
 	}
 
 }
+
+func TestServiceDiscovery_TargetWeights(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(consul))
+	}))
+	defer server.Close()
+
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	if ServiceCache == nil {
+		ServiceCache = cache.New(time.Minute, time.Minute)
+	}
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "weighted-discovery"}}
+	spec.Proxy.ServiceDiscovery = apidef.ServiceDiscoveryConfiguration{
+		UseDiscoveryService: true,
+		QueryEndpoint:       server.URL,
+		UseTargetList:       true,
+		EndpointReturnsList: true,
+		DataPath:            "Address",
+		PortDataPath:        "ServicePort",
+	}
+	spec.Proxy.LoadBalancing.TargetWeights = []apidef.TargetWeight{{Target: "10.1.10.12:8000", Weight: 3}}
+	defer ServiceCache.Delete(spec.APIID)
+
+	hostList, err := gw.urlFromService(spec)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"10.1.10.12:8000", "10.1.10.13:8000"}, hostList.All())
+		assert.Equal(t, 3, hostList.GetWeight(0), "the configured weights should apply to discovered targets")
+		assert.Equal(t, 1, hostList.GetWeight(1))
+	}
+}
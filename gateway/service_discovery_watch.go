@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	watchedDiscoveryMinBackoff = time.Second
+	watchedDiscoveryMaxBackoff = 30 * time.Second
+)
+
+// discoveryWatcher keeps the host list of an API in sync with a discovery service that
+// notifies of changes, until its context is cancelled.
+type discoveryWatcher interface {
+	HostList() *apidef.HostList
+	// TrackingHosts returns the uptime tests of the targets, if the API checks its targets
+	// against the uptime tests.
+	TrackingHosts() []HostData
+}
+
+// isWatchedDiscovery reports whether the targets of provider are watched rather than looked up.
+func isWatchedDiscovery(provider apidef.ServiceDiscoveryProvider) bool {
+	switch provider {
+	case apidef.FileServiceDiscovery, apidef.ConsulServiceDiscovery:
+		return true
+	}
+	return false
+}
+
+// watchedDiscoverySource identifies what the API discovers its targets from, and how they are
+// checked, so that the watcher is replaced when either changes.
+func watchedDiscoverySource(spec *APISpec) string {
+	sd := spec.Proxy.ServiceDiscovery
+	if !sd.UseDiscoveryService || !isWatchedDiscovery(sd.Provider) {
+		return ""
+	}
+	return string(sd.Provider) + ":" + sd.QueryEndpoint + "|" + spec.Protocol + "|" + strconv.FormatBool(spec.Proxy.CheckHostAgainstUptimeTests)
+}
+
+// watchedDiscoveryEntry is the watcher of an API. The watcher and the setup error are set
+// before ready is closed, and never change after.
+type watchedDiscoveryEntry struct {
+	source  string
+	cancel  context.CancelFunc
+	ready   chan struct{}
+	watcher discoveryWatcher
+	err     error
+
+	// failures counts the consecutive failed setups, which are retried from retryAt on
+	failures int
+	retryAt  time.Time
+}
+
+// done reports whether the setup of the watcher has finished.
+func (e *watchedDiscoveryEntry) done() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *watchedDiscoveryEntry) hostList() (*apidef.HostList, error) {
+	<-e.ready
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.watcher.HostList(), nil
+}
+
+// watchedDiscovery holds the watchers of the APIs discovering their targets from watched
+// files or Consul.
+type watchedDiscovery struct {
+	mu       sync.Mutex
+	watchers map[string]*watchedDiscoveryEntry
+}
+
+// HostList returns the host list of the API, which is kept in sync with the discovery service
+// from the first call on. The watcher is set up without holding mu, so that a slow discovery
+// service only holds up the requests to its own API. Failed setups are retried with a backoff.
+func (d *watchedDiscovery) HostList(gw *Gateway, spec *APISpec) (*apidef.HostList, error) {
+	source := watchedDiscoverySource(spec)
+
+	d.mu.Lock()
+	failures := 0
+	if entry, ok := d.watchers[spec.APIID]; ok {
+		switch {
+		case entry.source != source:
+			entry.cancel()
+		case !entry.done(), entry.err == nil, time.Now().Before(entry.retryAt):
+			// the setup is in progress, succeeded, or failed and isn't due for a retry yet
+			d.mu.Unlock()
+			return entry.hostList()
+		default:
+			failures = entry.failures
+		}
+		delete(d.watchers, spec.APIID)
+	}
+
+	ctx := gw.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	entry := &watchedDiscoveryEntry{source: source, cancel: cancel, ready: make(chan struct{})}
+	if d.watchers == nil {
+		d.watchers = make(map[string]*watchedDiscoveryEntry)
+	}
+	d.watchers[spec.APIID] = entry
+	d.mu.Unlock()
+
+	switch spec.Proxy.ServiceDiscovery.Provider {
+	case apidef.FileServiceDiscovery:
+		entry.watcher, entry.err = gw.watchDiscoveryFile(ctx, spec)
+	case apidef.ConsulServiceDiscovery:
+		entry.watcher, entry.err = gw.watchConsulService(ctx, spec)
+	}
+	if entry.err != nil {
+		cancel()
+		entry.failures = failures + 1
+		entry.retryAt = time.Now().Add(watchedDiscoveryBackoff(entry.failures))
+	}
+	close(entry.ready)
+
+	return entry.hostList()
+}
+
+// watchedDiscoveryBackoff returns how long to wait before setting up a watcher again after
+// the given number of consecutive failures.
+func watchedDiscoveryBackoff(failures int) time.Duration {
+	backoff := watchedDiscoveryMinBackoff
+	for i := 1; i < failures && backoff < watchedDiscoveryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > watchedDiscoveryMaxBackoff {
+		backoff = watchedDiscoveryMaxBackoff
+	}
+	return backoff
+}
+
+// Prune stops the watchers of the APIs that no longer discover their targets from them.
+func (d *watchedDiscovery) Prune(specs map[string]*APISpec) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for apiID, entry := range d.watchers {
+		if spec, ok := specs[apiID]; ok && watchedDiscoverySource(spec) == entry.source {
+			continue
+		}
+		entry.cancel()
+		delete(d.watchers, apiID)
+	}
+}
+
+// TrackingHosts returns the uptime tests of the targets of all the watchers.
+func (d *watchedDiscovery) TrackingHosts() []HostData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var hosts []HostData
+	for _, entry := range d.watchers {
+		if entry.done() && entry.err == nil {
+			hosts = append(hosts, entry.watcher.TrackingHosts()...)
+		}
+	}
+	return hosts
+}
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/TykTechnologies/leakybucket"
 	"github.com/TykTechnologies/leakybucket/memorycache"
 
+	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
 	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/user"
@@ -36,6 +39,9 @@ const (
 type SessionLimiter struct {
 	bucketStore leakybucket.Storage
 	Gw          *Gateway `json:"-"`
+
+	hybridOnce sync.Once
+	hybrid     *hybridRateLimiter
 }
 
 func (l *SessionLimiter) doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey string,
@@ -130,6 +136,66 @@ func (l *SessionLimiter) limitRedis(currentSession *user.SessionState, key strin
 	return false
 }
 
+// scriptedRateLimiter is implemented by the stores evaluating the rate limiting algorithms with
+// an atomic Redis script.
+type scriptedRateLimiter interface {
+	RateLimitGCRA(keyName string, rate float64, per time.Duration, dryRun bool) (storage.RateLimitResult, error)
+	RateLimitSlidingWindow(keyName string, rate float64, per time.Duration, dryRun bool) (storage.RateLimitResult, error)
+}
+
+// rateLimitState is the state of the rate limit of a request, evaluated by a scripted rate limiter.
+type rateLimitState struct {
+	storage.RateLimitResult
+
+	Rate float64
+	Per  float64
+}
+
+// rateLimitAlgorithm returns the scripted rate limiting algorithm used for the API, if any.
+func rateLimitAlgorithm(globalConf *config.Config, api *APISpec) apidef.RateLimitAlgorithm {
+	if api != nil && api.RateLimitAlgorithm != "" {
+		return api.RateLimitAlgorithm
+	}
+	return globalConf.RateLimitAlgorithm
+}
+
+// limitScript evaluates the rate limit with a Redis script. It reports false if the store can't
+// run the scripts.
+func (l *SessionLimiter) limitScript(r *http.Request, currentSession *user.SessionState, rateScope string, store storage.Handler,
+	algorithm apidef.RateLimitAlgorithm, apiLimit *user.APILimit, dryRun bool) (limited bool, ok bool) {
+
+	limiter, ok := store.(scriptedRateLimiter)
+	if !ok {
+		return false, false
+	}
+
+	rateLimiterKey := RateLimitKeyPrefix + rateScope + currentSession.KeyHash() + "." + string(algorithm)
+	per := time.Duration(apiLimit.Per * float64(time.Second))
+
+	var (
+		result storage.RateLimitResult
+		err    error
+	)
+	switch algorithm {
+	case apidef.RateLimitGCRA:
+		result, err = limiter.RateLimitGCRA(rateLimiterKey, apiLimit.Rate, per, dryRun)
+	case apidef.RateLimitSlidingWindow:
+		result, err = limiter.RateLimitSlidingWindow(rateLimiterKey, apiLimit.Rate, per, dryRun)
+	default:
+		log.Warningf("[RATELIMIT] Unknown rate limit algorithm %q", algorithm)
+		return false, false
+	}
+	if err != nil {
+		// like the rolling window, the limiter fails open
+		return false, true
+	}
+
+	if r != nil {
+		ctxSetRateLimitState(r, &rateLimitState{RateLimitResult: result, Rate: apiLimit.Rate, Per: apiLimit.Per})
+	}
+	return !result.Allowed, true
+}
+
 func (l *SessionLimiter) limitDRL(currentSession *user.SessionState, key string, rateScope string,
 	apiLimit *user.APILimit, dryRun bool) bool {
 
@@ -191,21 +257,60 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 		log.WithField("apiID", api.APIID).Debugf("[RATE] %s", err.Error())
 		return sessionFailRateLimit
 	}
+	return l.limit(r, currentSession, key, allowanceScope, &accessDef.Limit, store, enableRL, enableQ, globalConf, api, dryRun)
+}
+
+// ForwardEndpointMessage enforces the rate limit and quota of an endpoint of the session, with
+// counters separate from the limits of the API.
+func (l *SessionLimiter) ForwardEndpointMessage(r *http.Request, currentSession *user.SessionState, key string, endpoint *user.EndpointLimit, store storage.Handler, enableRL, enableQ bool, globalConf *config.Config, api *APISpec, dryRun bool) sessionFailReason {
+	_, allowanceScope, err := GetAccessDefinitionByAPIIDOrSession(currentSession, api)
+	if err != nil {
+		log.WithField("apiID", api.APIID).Debugf("[RATE] %s", err.Error())
+		return sessionFailRateLimit
+	}
+	return l.limit(r, currentSession, key, endpointLimitScope(allowanceScope, endpoint), &endpoint.Limit, store, enableRL, enableQ, globalConf, api, dryRun)
+}
+
+// endpointLimitScope returns the allowance scope of the counters of an endpoint limit.
+func endpointLimitScope(allowanceScope string, endpoint *user.EndpointLimit) string {
+	scope := "endpoint-" + storage.HashStr(endpoint.URL+" "+strings.Join(endpoint.Methods, ","))
+	if allowanceScope != "" {
+		scope = allowanceScope + "-" + scope
+	}
+	return scope
+}
+
+// limit enforces the rate limit and quota of apiLimit, with the counters of allowanceScope.
+func (l *SessionLimiter) limit(r *http.Request, currentSession *user.SessionState, key string, allowanceScope string, apiLimit *user.APILimit, store storage.Handler, enableRL, enableQ bool, globalConf *config.Config, api *APISpec, dryRun bool) sessionFailReason {
 	if l.Gw == nil {
 		panic("viene nulo")
 	}
 	// If rate is -1 or 0, it means unlimited and no need for rate limiting.
-	if enableRL && accessDef.Limit.Rate > 0 {
+	if enableRL && apiLimit.Rate > 0 {
 		rateScope := ""
 		if allowanceScope != "" {
 			rateScope = allowanceScope + "-"
 		}
-		if globalConf.EnableSentinelRateLimiter {
-			if l.limitSentinel(currentSession, key, rateScope, store, globalConf, &accessDef.Limit, dryRun) {
+		// the scripted and hybrid rate limiters fall back to the other ones if the store doesn't support them
+		limited, handled := false, false
+		if algorithm := rateLimitAlgorithm(globalConf, api); algorithm != "" {
+			limited, handled = l.limitScript(r, currentSession, rateScope, store, algorithm, apiLimit, dryRun)
+		}
+
+		if !handled && globalConf.HybridRateLimiter.Enabled {
+			limited, handled = l.limitHybrid(r, currentSession, rateScope, store, globalConf, apiLimit, dryRun)
+		}
+
+		if handled {
+			if limited {
+				return sessionFailRateLimit
+			}
+		} else if globalConf.EnableSentinelRateLimiter {
+			if l.limitSentinel(currentSession, key, rateScope, store, globalConf, apiLimit, dryRun) {
 				return sessionFailRateLimit
 			}
 		} else if globalConf.EnableRedisRollingLimiter {
-			if l.limitRedis(currentSession, key, rateScope, store, globalConf, &accessDef.Limit, dryRun) {
+			if l.limitRedis(currentSession, key, rateScope, store, globalConf, apiLimit, dryRun) {
 				return sessionFailRateLimit
 			}
 		} else {
@@ -213,7 +318,7 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 			if l.Gw.DRLManager.Servers != nil {
 				n = float64(l.Gw.DRLManager.Servers.Count())
 			}
-			rate := accessDef.Limit.Rate / accessDef.Limit.Per
+			rate := apiLimit.Rate / apiLimit.Per
 			c := globalConf.DRLThreshold
 			if c == 0 {
 				// defaults to 5
@@ -223,11 +328,11 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 			if n <= 1 || n*c < rate {
 				// If we have 1 server, there is no need to strain redis at all the leaky
 				// bucket algorithm will suffice.
-				if l.limitDRL(currentSession, key, rateScope, &accessDef.Limit, dryRun) {
+				if l.limitDRL(currentSession, key, rateScope, apiLimit, dryRun) {
 					return sessionFailRateLimit
 				}
 			} else {
-				if l.limitRedis(currentSession, key, rateScope, store, globalConf, &accessDef.Limit, dryRun) {
+				if l.limitRedis(currentSession, key, rateScope, store, globalConf, apiLimit, dryRun) {
 					return sessionFailRateLimit
 				}
 			}
@@ -239,7 +344,7 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 			currentSession.Allowance = currentSession.Allowance - 1
 		}
 
-		if l.RedisQuotaExceeded(r, currentSession, allowanceScope, &accessDef.Limit, store, globalConf.HashKeys) {
+		if l.RedisQuotaExceeded(r, currentSession, allowanceScope, apiLimit, store, globalConf.HashKeys) {
 			return sessionFailQuota
 		}
 	}
@@ -328,6 +433,8 @@ func (l *SessionLimiter) RedisQuotaExceeded(r *http.Request, currentSession *use
 		currentSession.QuotaRemaining = remaining
 		currentSession.QuotaRenews = quotaRenews
 	}
+	limit.QuotaRemaining = remaining
+	limit.QuotaRenews = quotaRenews
 
 	return false
 }
@@ -1,11 +1,16 @@
 package gateway
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/user"
 )
 
@@ -134,3 +139,80 @@ func TestGetAccessDefinitionByAPIIDOrSession(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+// scriptedLimiterStore records the scripted rate limiters called, and allows the first request.
+type scriptedLimiterStore struct {
+	storage.Handler
+	calls []string
+}
+
+func (s *scriptedLimiterStore) limit(algorithm, keyName string) storage.RateLimitResult {
+	s.calls = append(s.calls, algorithm+" "+keyName)
+	if len(s.calls) > 1 {
+		return storage.RateLimitResult{RetryAfter: time.Second, Reset: time.Second}
+	}
+	return storage.RateLimitResult{Allowed: true, Remaining: 1, Reset: time.Second}
+}
+
+func (s *scriptedLimiterStore) RateLimitGCRA(keyName string, _ float64, _ time.Duration, _ bool) (storage.RateLimitResult, error) {
+	return s.limit("gcra", keyName), nil
+}
+
+func (s *scriptedLimiterStore) RateLimitSlidingWindow(keyName string, _ float64, _ time.Duration, _ bool) (storage.RateLimitResult, error) {
+	return s.limit("sliding_window", keyName), nil
+}
+
+func TestSessionLimiter_ScriptedRateLimit(t *testing.T) {
+	limiter := &SessionLimiter{Gw: &Gateway{}}
+	session := &user.SessionState{
+		Rate: 2,
+		Per:  1,
+		AccessRights: map[string]user.AccessDefinition{
+			"api": {},
+		},
+	}
+	session.SetKeyHash("hash")
+
+	forward := func(store storage.Handler, conf *config.Config, spec *APISpec) (sessionFailReason, *rateLimitState) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		reason := limiter.ForwardMessage(r, session, "key", store, true, false, conf, spec, false)
+		return reason, ctxGetRateLimitState(r)
+	}
+
+	t.Run("global algorithm", func(t *testing.T) {
+		store := &scriptedLimiterStore{}
+		conf := &config.Config{RateLimitAlgorithm: apidef.RateLimitGCRA}
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api"}}
+
+		reason, state := forward(store, conf, spec)
+		assert.Equal(t, sessionFailNone, reason)
+		if assert.NotNil(t, state) {
+			assert.Equal(t, int64(1), state.Remaining)
+			assert.Equal(t, 2.0, state.Rate)
+		}
+
+		reason, state = forward(store, conf, spec)
+		assert.Equal(t, sessionFailRateLimit, reason)
+		if assert.NotNil(t, state) {
+			assert.Equal(t, time.Second, state.RetryAfter)
+		}
+		assert.Equal(t, []string{"gcra " + RateLimitKeyPrefix + "hash.gcra", "gcra " + RateLimitKeyPrefix + "hash.gcra"}, store.calls)
+	})
+
+	t.Run("api algorithm", func(t *testing.T) {
+		store := &scriptedLimiterStore{}
+		conf := &config.Config{RateLimitAlgorithm: apidef.RateLimitGCRA}
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", RateLimitAlgorithm: apidef.RateLimitSlidingWindow}}
+
+		reason, _ := forward(store, conf, spec)
+		assert.Equal(t, sessionFailNone, reason)
+		assert.Equal(t, []string{"sliding_window " + RateLimitKeyPrefix + "hash.sliding_window"}, store.calls, "the algorithm of the API should take precedence")
+	})
+
+	t.Run("unsupported store", func(t *testing.T) {
+		store := &scriptedLimiterStore{}
+		limited, scripted := limiter.limitScript(nil, session, "", store.Handler, apidef.RateLimitGCRA, &user.APILimit{Rate: 1, Per: 1}, false)
+		assert.False(t, limited)
+		assert.False(t, scripted, "stores without scripts should fall back to the other limiters")
+	})
+}
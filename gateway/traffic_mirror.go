@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/TykTechnologies/tyk-pump/analytics"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/request"
+)
+
+const (
+	defaultMirrorTimeout     = 10
+	defaultMirrorMaxInFlight = 100
+
+	// shadowAPIIDSuffix is appended to the API ID of the analytics records of shadow requests, so
+	// that they are aggregated apart from the requests to the API
+	shadowAPIIDSuffix = "-shadow"
+)
+
+func mirrorConfig(conf apidef.MirrorConfig) apidef.MirrorConfig {
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultMirrorTimeout
+	}
+	if conf.MaxInFlight <= 0 {
+		conf.MaxInFlight = defaultMirrorMaxInFlight
+	}
+	return conf
+}
+
+// shadowTarget is where a copy of a request is sent to.
+type shadowTarget struct {
+	conf apidef.MirrorConfig
+	// key identifies the mirror configuration for the in-flight limit
+	key string
+	url *url.URL
+}
+
+// CheckMirrorEnforced decides whether the request is mirrored, returning the shadow target
+// if it is. It has to be called before the director rewrites the request URL.
+func (p *ReverseProxy) CheckMirrorEnforced(spec *APISpec, req *http.Request) *shadowTarget {
+	if !spec.MirrorEnabled {
+		return nil
+	}
+	if upgrade, _ := p.IsUpgrade(req); upgrade {
+		return nil
+	}
+
+	conf, key := spec.Proxy.Mirror, ""
+
+	versionInfo, _ := spec.Version(req)
+	versionPaths := spec.RxPaths[versionInfo.Name]
+	if found, meta := spec.CheckSpecMatchesStatus(req, versionPaths, Mirrored); found {
+		mirrorMeta := meta.(*apidef.MirrorMeta)
+		conf, key = mirrorMeta.Mirror(), mirrorMeta.Method+" "+mirrorMeta.Path
+	}
+
+	if !conf.Enabled || rand.Float64()*100 >= conf.Percentage {
+		return nil
+	}
+
+	target, err := url.Parse(conf.Target)
+	if err != nil || target.Host == "" {
+		p.logger.WithError(err).Error("[MIRROR] Couldn't parse shadow target URL: ", conf.Target)
+		return nil
+	}
+
+	shadowURL := *req.URL
+	shadowURL.Host = target.Host
+	switch target.Scheme {
+	case "h2c", "ws":
+		shadowURL.Scheme = "http"
+	case "wss":
+		shadowURL.Scheme = "https"
+	default:
+		shadowURL.Scheme = target.Scheme
+	}
+	shadowURL.Path = singleJoiningSlash(target.Path, req.URL.Path, spec.Proxy.DisableStripSlash)
+	if req.URL.RawPath != "" {
+		shadowURL.RawPath = singleJoiningSlash(target.Path, req.URL.RawPath, spec.Proxy.DisableStripSlash)
+	}
+	if target.RawQuery != "" && req.URL.RawQuery != "" {
+		shadowURL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	} else {
+		shadowURL.RawQuery = target.RawQuery + req.URL.RawQuery
+	}
+
+	return &shadowTarget{conf: mirrorConfig(conf), key: key, url: &shadowURL}
+}
+
+// mirror sends a copy of the outbound request to the shadow target in the background.
+// The shadow response is discarded and recorded in analytics with the shadow tag.
+func (p *ReverseProxy) mirror(roundTripper *TykRoundTripper, outreq *http.Request, shadow *shadowTarget) {
+	spec := p.TykAPISpec
+
+	done := spec.MirrorsInFlight.Track(shadow.key)
+	if spec.MirrorsInFlight.InFlight(shadow.key) > int64(shadow.conf.MaxInFlight) {
+		done()
+		p.logger.Debug("[MIRROR] Too many shadow requests in flight, not mirroring request")
+		return
+	}
+
+	var body []byte
+	if outreq.Body != nil {
+		// streamed bodies can't be replayed
+		if outreq.ContentLength == -1 {
+			done()
+			return
+		}
+
+		copyRequest(outreq)
+		buffered, ok := outreq.Body.(*nopCloserBuffer)
+		if !ok || buffered.copy() != nil {
+			done()
+			p.logger.Debug("[MIRROR] Couldn't buffer request body, not mirroring request")
+			return
+		}
+		body = buffered.buf.Bytes()
+	}
+
+	shadowReq := outreq.Clone(context.Background())
+	shadowReq.URL = shadow.url
+	if !spec.Proxy.PreserveHostHeader {
+		shadowReq.Host = shadow.url.Host
+	}
+	if body != nil {
+		shadowReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// the upstream token is for the upstream, shadow targets are usually trusted less
+	if spec.UpstreamTokenSource != nil {
+		shadowReq.Header.Del(spec.UpstreamTokenSource.conf.HeaderName)
+	}
+
+	record := p.shadowRecord(outreq, shadowReq)
+	ctxAddAnalyticsTags(outreq, "mirrored")
+
+	go func() {
+		defer done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shadow.conf.Timeout*float64(time.Second)))
+		defer cancel()
+
+		begin := time.Now()
+		res, err := roundTripper.RoundTrip(shadowReq.WithContext(ctx))
+		code := 0
+		if err != nil {
+			p.logger.WithError(err).Debug("[MIRROR] Shadow request failed")
+		} else {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			code = res.StatusCode
+		}
+
+		if record != nil {
+			p.recordShadowHit(record, code, time.Since(begin))
+		}
+	}()
+}
+
+// shadowRecord prepares the analytics record of a shadow request, or returns nil if it isn't recorded.
+// The request context isn't available once the shadow response arrives, so the record is prepared upfront.
+// Shadow requests are recorded under their own API ID, without the key and the tags of the request, so
+// that they don't add to the hits and errors of the API, its keys and its tags.
+func (p *ReverseProxy) shadowRecord(outreq, shadowReq *http.Request) *analytics.AnalyticsRecord {
+	spec := p.TykAPISpec
+	if spec.DoNotTrack || ctxGetDoNotTrack(outreq) {
+		return nil
+	}
+
+	ip := request.RealIP(outreq)
+	if !spec.GlobalConfig.StoreAnalytics(ip) {
+		return nil
+	}
+
+	version := spec.getVersionFromRequest(outreq)
+	if version == "" {
+		version = "Non Versioned"
+	}
+
+	return &analytics.AnalyticsRecord{
+		Method:        shadowReq.Method,
+		Host:          shadowReq.URL.Host,
+		Path:          shadowReq.URL.Path,
+		RawPath:       shadowReq.URL.Path,
+		ContentLength: shadowReq.ContentLength,
+		UserAgent:     shadowReq.Header.Get(header.UserAgent),
+		APIVersion:    version,
+		APIName:       spec.Name,
+		APIID:         spec.APIID + shadowAPIIDSuffix,
+		OrgID:         spec.OrgID,
+		IPAddress:     ip,
+		Tags:          []string{"shadow"},
+	}
+}
+
+func (p *ReverseProxy) recordShadowHit(record *analytics.AnalyticsRecord, code int, latency time.Duration) {
+	t := time.Now()
+	millisec := int64(DurationToMillisecond(latency))
+
+	status := "error"
+	if code != 0 {
+		status = strconv.Itoa(code)
+	}
+
+	record.Tags = append(record.Tags, "shadow-status-"+status)
+	record.ResponseCode = code
+	record.Day = t.Day()
+	record.Month = t.Month()
+	record.Year = t.Year()
+	record.Hour = t.Hour()
+	record.TimeStamp = t
+	record.RequestTime = millisec
+	record.Latency = analytics.Latency{Total: millisec, Upstream: millisec}
+	record.ExpireAt = t
+	record.SetExpiry(p.TykAPISpec.ExpireAnalyticsAfter)
+
+	if err := p.Gw.Analytics.RecordHit(record); err != nil {
+		log.WithError(err).Error("could not store shadow analytic record")
+	}
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestCheckMirrorEnforced(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}, MirrorEnabled: true}
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	p := &ReverseProxy{TykAPISpec: spec, Gw: gw, logger: logrus.NewEntry(log)}
+
+	t.Run("builds shadow URL", func(t *testing.T) {
+		spec.Proxy.Mirror = apidef.MirrorConfig{Enabled: true, Target: "http://shadow:8080/v2?a=1", Percentage: 100}
+		shadow := p.CheckMirrorEnforced(spec, httptest.NewRequest(http.MethodGet, "/users?b=2", nil))
+		if assert.NotNil(t, shadow) {
+			assert.Equal(t, "http://shadow:8080/v2/users?a=1&b=2", shadow.url.String())
+			assert.Equal(t, defaultMirrorMaxInFlight, shadow.conf.MaxInFlight)
+		}
+	})
+
+	t.Run("percentage", func(t *testing.T) {
+		spec.Proxy.Mirror = apidef.MirrorConfig{Enabled: true, Target: "http://shadow", Percentage: 25}
+		mirrored := 0
+		for i := 0; i < 1000; i++ {
+			if p.CheckMirrorEnforced(spec, httptest.NewRequest(http.MethodGet, "/", nil)) != nil {
+				mirrored++
+			}
+		}
+		assert.InDelta(t, 250, mirrored, 60)
+	})
+}
+
+func TestMirror(t *testing.T) {
+	shadowBodies := make(chan string, 1)
+	shadowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		shadowBodies <- r.URL.Path + " " + string(body) + r.Header.Get(header.Authorization)
+	}))
+	defer shadowSrv.Close()
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}, MirrorEnabled: true}
+	spec.Proxy.Mirror = apidef.MirrorConfig{Enabled: true, Target: shadowSrv.URL, Percentage: 100}
+	spec.UpstreamTokenSource = newUpstreamTokenSource("org", apidef.UpstreamOAuthConfig{TokenURL: "http://token"}, nil)
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+	p := &ReverseProxy{TykAPISpec: spec, Gw: gw, logger: logrus.NewEntry(log)}
+	rt := &TykRoundTripper{transport: &http.Transport{}, logger: logrus.NewEntry(log)}
+
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("payload"))
+	r.Header.Set(header.Authorization, "Bearer upstream-token")
+	ctxInitAnalyticsTags(r)
+	shadow := p.CheckMirrorEnforced(spec, r)
+	p.mirror(rt, r, shadow)
+
+	body, err := ioutil.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(body), "the request body should still be readable")
+	assert.Equal(t, []string{"mirrored"}, ctxGetAnalyticsTags(r))
+
+	select {
+	case got := <-shadowBodies:
+		assert.Equal(t, "/orders payload", got, "the upstream token shouldn't be sent to the shadow target")
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow request wasn't sent")
+	}
+
+	t.Run("max in flight", func(t *testing.T) {
+		done := spec.MirrorsInFlight.Track("")
+		defer done()
+		spec.Proxy.Mirror.MaxInFlight = 1
+
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		ctxInitAnalyticsTags(r)
+		p.mirror(rt, r, p.CheckMirrorEnforced(spec, r))
+		assert.Empty(t, ctxGetAnalyticsTags(r))
+	})
+}
+
+func TestShadowRecord(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "api", OrgID: "org", Tags: []string{"team"}}}
+	spec.GlobalConfig.EnableAnalytics = true
+	p := &ReverseProxy{TykAPISpec: spec, logger: logrus.NewEntry(log)}
+
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	setCtxValue(r, ctx.AuthToken, "key")
+	setCtxValue(r, ctx.SessionData, &user.SessionState{Alias: "alice", OauthClientID: "client", Tags: []string{"gold"}})
+	shadowReq := httptest.NewRequest(http.MethodGet, "http://shadow/orders", nil)
+
+	record := p.shadowRecord(r, shadowReq)
+	if assert.NotNil(t, record) {
+		assert.Equal(t, "api-shadow", record.APIID, "shadow requests shouldn't add to the hits of the API")
+		assert.Equal(t, "org", record.OrgID)
+		assert.Equal(t, "/orders", record.Path)
+		assert.Empty(t, record.APIKey, "shadow requests shouldn't add to the hits of the key")
+		assert.Empty(t, record.OauthID)
+		assert.Empty(t, record.Alias)
+		assert.Equal(t, []string{"shadow"}, record.Tags)
+	}
+
+	spec.DoNotTrack = true
+	assert.Nil(t, p.shadowRecord(r, shadowReq))
+}
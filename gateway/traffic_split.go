@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// splitsTraffic reports whether the traffic of the API is split between its upstream groups, which
+// it isn't under service discovery.
+func (s *APISpec) splitsTraffic() bool {
+	return s.Proxy.TrafficSplit.Enabled && !s.Proxy.ServiceDiscovery.UseDiscoveryService
+}
+
+// upstreamGroups builds the host lists of the traffic split groups of the API.
+func upstreamGroups(spec *APISpec) map[string]*apidef.HostList {
+	groups := make(map[string]*apidef.HostList, len(spec.Proxy.TrafficSplit.Groups))
+	for _, group := range spec.Proxy.TrafficSplit.Groups {
+		hl := apidef.NewHostList()
+		hl.SetWithWeights(group.Targets, spec.Proxy.LoadBalancing.Weights(group.Targets))
+		groups[group.Name] = hl
+	}
+	return groups
+}
+
+// trafficSplitValue returns the value of the request the override is matched against.
+func trafficSplitValue(r *http.Request, override apidef.TrafficSplitOverride) string {
+	switch override.Source {
+	case apidef.TrafficSplitOnHeader:
+		return r.Header.Get(override.Name)
+	case apidef.TrafficSplitOnCookie:
+		if cookie, err := r.Cookie(override.Name); err == nil {
+			return cookie.Value
+		}
+	case apidef.TrafficSplitOnMetadata:
+		if session := ctxGetSession(r); session != nil {
+			if value, ok := session.MetaData[override.Name]; ok && value != nil {
+				return fmt.Sprint(value)
+			}
+		}
+	}
+	return ""
+}
+
+// selectUpstreamGroup returns the name of the upstream group the request is sent to.
+// Overrides are only matched when there is a request, e.g. not for TCP proxies.
+func (s *APISpec) selectUpstreamGroup(r *http.Request) string {
+	conf := s.Proxy.TrafficSplit
+
+	if r != nil {
+		for _, override := range conf.Overrides {
+			value := trafficSplitValue(r, override)
+			if value == "" || (override.Value != "" && override.Value != value) {
+				continue
+			}
+			if _, ok := s.UpstreamGroups[override.Group]; ok {
+				return override.Group
+			}
+		}
+	}
+
+	total := 0
+	for _, group := range conf.Groups {
+		if group.Weight > 0 {
+			total += group.Weight
+		}
+	}
+	if total == 0 {
+		if len(conf.Groups) > 0 {
+			return conf.Groups[0].Name
+		}
+		return ""
+	}
+
+	n := rand.Intn(total)
+	for _, group := range conf.Groups {
+		if group.Weight <= 0 {
+			continue
+		}
+		if n < group.Weight {
+			return group.Name
+		}
+		n -= group.Weight
+	}
+	return ""
+}
+
+// upstreamGroupHostList selects the upstream group of the request, records it in the
+// analytics of the request and returns the host list of the group.
+func (s *APISpec) upstreamGroupHostList(r *http.Request) *apidef.HostList {
+	name := s.selectUpstreamGroup(r)
+	if r != nil {
+		ctxSetUpstreamGroup(r, name)
+		ctxAddAnalyticsTags(r, "upstream-group-"+name)
+	}
+
+	if hl, ok := s.UpstreamGroups[name]; ok {
+		return hl
+	}
+	return apidef.NewHostList()
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestSelectUpstreamGroup(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.Proxy.TrafficSplit = apidef.TrafficSplitConfig{
+		Enabled: true,
+		Groups: []apidef.UpstreamGroup{
+			{Name: "stable", Targets: []string{"http://stable"}, Weight: 90},
+			{Name: "canary", Targets: []string{"http://canary"}, Weight: 10},
+			{Name: "beta", Targets: []string{"http://beta"}},
+		},
+		Overrides: []apidef.TrafficSplitOverride{
+			{Source: apidef.TrafficSplitOnHeader, Name: "X-Canary", Value: "true", Group: "canary"},
+			{Source: apidef.TrafficSplitOnCookie, Name: "beta", Group: "beta"},
+			{Source: apidef.TrafficSplitOnMetadata, Name: "tier", Value: "internal", Group: "canary"},
+			{Source: apidef.TrafficSplitOnHeader, Name: "X-Missing-Group", Group: "unknown"},
+		},
+	}
+	spec.UpstreamGroups = upstreamGroups(spec)
+
+	t.Run("weights", func(t *testing.T) {
+		got := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			got[spec.selectUpstreamGroup(httptest.NewRequest(http.MethodGet, "/", nil))]++
+		}
+		assert.InDelta(t, 900, got["stable"], 60)
+		assert.InDelta(t, 100, got["canary"], 60)
+		assert.Zero(t, got["beta"], "groups without weight should only get overridden traffic")
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Canary", "true")
+		assert.Equal(t, "canary", spec.selectUpstreamGroup(r))
+
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "beta", Value: "1"})
+		assert.Equal(t, "beta", spec.selectUpstreamGroup(r))
+
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		setCtxValue(r, ctx.SessionData, &user.SessionState{MetaData: map[string]interface{}{"tier": "internal"}})
+		assert.Equal(t, "canary", spec.selectUpstreamGroup(r))
+	})
+
+	t.Run("records group", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Canary", "true")
+		ctxInitAnalyticsTags(r)
+
+		hl := spec.upstreamGroupHostList(r)
+		assert.Equal(t, []string{"http://canary"}, hl.All())
+		assert.Equal(t, hl, spec.upstreamHostList(r))
+		assert.Equal(t, []string{"upstream-group-canary"}, ctxGetAnalyticsTags(r))
+	})
+
+	t.Run("balances the targets of the group", func(t *testing.T) {
+		spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+		spec.Proxy.TrafficSplit = apidef.TrafficSplitConfig{
+			Enabled: true,
+			Groups:  []apidef.UpstreamGroup{{Name: "stable", Targets: []string{"http://a", "http://b"}, Weight: 1}},
+		}
+		spec.UpstreamGroups = upstreamGroups(spec)
+
+		gw := &Gateway{}
+		got := map[string]int{}
+		for i := 0; i < 4; i++ {
+			host, err := gw.nextTarget(spec.upstreamGroupHostList(nil), spec, nil)
+			assert.NoError(t, err)
+			got[host]++
+		}
+		assert.Equal(t, map[string]int{"http://a": 2, "http://b": 2}, got, "load balancing shouldn't need to be enabled")
+	})
+}
@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/header"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	upstreamOAuthKeyPrefix          = "upstream-oauth-token-"
+	defaultUpstreamOAuthExpiryDelta = 10
+	upstreamOAuthTimeout            = 10 * time.Second
+	upstreamOAuthMinBackoff         = time.Second
+	upstreamOAuthMaxBackoff         = 30 * time.Second
+)
+
+// upstreamTokenStore is where upstream tokens are shared between gateways.
+type upstreamTokenStore interface {
+	GetKey(string) (string, error)
+	SetKey(string, string, int64) error
+}
+
+// upstreamToken is an access token for the upstream, as cached.
+type upstreamToken struct {
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// UpstreamTokenSource fetches bearer tokens for the upstream with the OAuth2 client credentials grant.
+type UpstreamTokenSource struct {
+	conf   apidef.UpstreamOAuthConfig
+	store  upstreamTokenStore
+	key    string
+	client *http.Client
+
+	// fetches makes concurrent requests wait for the same token
+	fetches singleflight.Group
+
+	mu    sync.Mutex
+	token *upstreamToken
+	// backoff is the delay before the token endpoint is requested again after failed fetches
+	backoff  time.Duration
+	retryAt  time.Time
+	fetchErr error
+}
+
+// newUpstreamTokenSource returns the token source of the API, with the secrets of conf resolved.
+func (gw *Gateway) newUpstreamTokenSource(orgID string, conf apidef.UpstreamOAuthConfig) (*UpstreamTokenSource, error) {
+	for _, value := range []*string{&conf.TokenURL, &conf.ClientID, &conf.ClientSecret} {
+		resolved, err := gw.kvStore(*value)
+		if err != nil {
+			return nil, err
+		}
+		*value = resolved
+	}
+
+	if _, err := url.ParseRequestURI(conf.TokenURL); err != nil {
+		return nil, fmt.Errorf("invalid token URL: %v", err)
+	}
+
+	store := &storage.RedisCluster{KeyPrefix: upstreamOAuthKeyPrefix, RedisController: gw.RedisController}
+	return newUpstreamTokenSource(orgID, conf, store), nil
+}
+
+func newUpstreamTokenSource(orgID string, conf apidef.UpstreamOAuthConfig, store upstreamTokenStore) *UpstreamTokenSource {
+	if conf.HeaderName == "" {
+		conf.HeaderName = header.Authorization
+	}
+	if conf.ExpiryDelta <= 0 {
+		conf.ExpiryDelta = defaultUpstreamOAuthExpiryDelta
+	}
+
+	// tokens are shared by all the APIs of the organisation requesting them the same way, with
+	// the same client secret so that tokens are neither handed out to wrong secrets nor kept
+	// once the secret is rotated
+	scopes := append([]string{}, conf.Scopes...)
+	sort.Strings(scopes)
+	params := url.Values{}
+	for name, value := range conf.EndpointParams {
+		params.Set(name, value)
+	}
+	authStyle := conf.AuthStyle
+	if authStyle == "" {
+		authStyle = apidef.UpstreamOAuthAuthInHeader
+	}
+	key := orgID + storage.HashStr(strings.Join([]string{
+		conf.TokenURL,
+		conf.ClientID,
+		storage.HashStr(conf.ClientSecret),
+		strings.Join(scopes, " "),
+		params.Encode(),
+		string(authStyle),
+	}, "|"))
+
+	return &UpstreamTokenSource{
+		conf:   conf,
+		store:  store,
+		key:    key,
+		client: &http.Client{Timeout: upstreamOAuthTimeout},
+	}
+}
+
+// Inject sets the token header of the outbound request. The request is sent without a token if
+// none can be fetched, to be rejected by the upstream.
+func (s *UpstreamTokenSource) Inject(r *http.Request) {
+	token, err := s.Token(r.Context())
+	if err != nil {
+		log.WithError(err).Error("[PROXY] Couldn't fetch upstream OAuth token")
+		return
+	}
+	r.Header.Set(s.conf.HeaderName, "Bearer "+token)
+}
+
+// Token returns a valid access token, from memory, from Redis, or from the token endpoint. Once
+// a fetch failed, the token endpoint isn't requested again until the backoff elapsed.
+func (s *UpstreamTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	token, retryAt, fetchErr := s.token, s.retryAt, s.fetchErr
+	s.mu.Unlock()
+
+	if s.valid(token) {
+		return token.AccessToken, nil
+	}
+	if time.Now().Before(retryAt) {
+		return "", fmt.Errorf("token endpoint backing off after failed fetch: %v", fetchErr)
+	}
+
+	// the token isn't fetched with the context of the request, which the other requests waiting
+	// for the token don't share
+	ch := s.fetches.DoChan(s.key, func() (interface{}, error) {
+		return s.refresh()
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(*upstreamToken).AccessToken, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// refresh replaces the token with the one cached in Redis or, if it isn't valid, with a new one
+// from the token endpoint.
+func (s *UpstreamTokenSource) refresh() (*upstreamToken, error) {
+	if cached, err := s.store.GetKey(s.key); err == nil {
+		token := new(upstreamToken)
+		if json.Unmarshal([]byte(cached), token) == nil && s.valid(token) {
+			s.setToken(token, nil)
+			return token, nil
+		}
+	}
+
+	token, err := s.fetch(context.Background())
+	s.setToken(token, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl := int64(s.refreshAt(token).Sub(time.Now()).Seconds()); ttl > 0 {
+		cached, _ := json.Marshal(token)
+		if err := s.store.SetKey(s.key, string(cached), ttl); err != nil {
+			log.WithError(err).Debug("[PROXY] Couldn't cache upstream OAuth token")
+		}
+	}
+	return token, nil
+}
+
+// setToken records the result of a fetch, doubling the backoff on consecutive failures.
+func (s *UpstreamTokenSource) setToken(token *upstreamToken, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.token, s.backoff, s.retryAt, s.fetchErr = token, 0, time.Time{}, nil
+		return
+	}
+
+	s.backoff *= 2
+	if s.backoff < upstreamOAuthMinBackoff {
+		s.backoff = upstreamOAuthMinBackoff
+	}
+	if s.backoff > upstreamOAuthMaxBackoff {
+		s.backoff = upstreamOAuthMaxBackoff
+	}
+	s.retryAt, s.fetchErr = time.Now().Add(s.backoff), err
+}
+
+func (s *UpstreamTokenSource) refreshAt(token *upstreamToken) time.Time {
+	return token.Expiry.Add(-time.Duration(s.conf.ExpiryDelta * float64(time.Second)))
+}
+
+func (s *UpstreamTokenSource) valid(token *upstreamToken) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	return token.Expiry.IsZero() || time.Now().Before(s.refreshAt(token))
+}
+
+// fetch requests a new token from the token endpoint.
+func (s *UpstreamTokenSource) fetch(ctx context.Context) (*upstreamToken, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.conf.Scopes, " "))
+	}
+	for name, value := range s.conf.EndpointParams {
+		form.Set(name, value)
+	}
+	if s.conf.AuthStyle == apidef.UpstreamOAuthAuthInParams {
+		form.Set("client_id", s.conf.ClientID)
+		form.Set("client_secret", s.conf.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(header.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(header.Accept, header.ApplicationJSON)
+	if s.conf.AuthStyle != apidef.UpstreamOAuthAuthInParams {
+		req.SetBasicAuth(url.QueryEscape(s.conf.ClientID), url.QueryEscape(s.conf.ClientSecret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with %d: %s", res.StatusCode, body)
+	}
+
+	var tokenRes struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return nil, fmt.Errorf("couldn't decode token response: %v", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, errors.New("token response has no access token")
+	}
+
+	token := &upstreamToken{AccessToken: tokenRes.AccessToken}
+	if expiresIn, err := tokenRes.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
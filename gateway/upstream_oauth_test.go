@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/header"
+)
+
+type testTokenStore struct {
+	sync.Mutex
+	keys map[string]string
+	ttls map[string]int64
+}
+
+func (s *testTokenStore) GetKey(key string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	value, ok := s.keys[key]
+	if !ok {
+		return "", errors.New("key not found")
+	}
+	return value, nil
+}
+
+func (s *testTokenStore) SetKey(key, value string, ttl int64) error {
+	s.Lock()
+	defer s.Unlock()
+	s.keys[key] = value
+	s.ttls[key] = ttl
+	return nil
+}
+
+func TestUpstreamTokenSource(t *testing.T) {
+	var fetches int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		r.ParseForm()
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" || clientID != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+
+		w.Header().Set(header.ContentType, header.ApplicationJSON)
+		w.Write([]byte(`{"access_token":"token-` + r.PostForm.Get("scope") + r.PostForm.Get("audience") + `","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	newStore := func() *testTokenStore {
+		return &testTokenStore{keys: map[string]string{}, ttls: map[string]int64{}}
+	}
+	conf := apidef.UpstreamOAuthConfig{
+		TokenURL:       tokenServer.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		Scopes:         []string{"read"},
+		EndpointParams: map[string]string{"audience": "-api"},
+	}
+
+	t.Run("fetches and caches tokens", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		store := newStore()
+		source := newUpstreamTokenSource("org", conf, store)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		source.Inject(r)
+		assert.Equal(t, "Bearer token-read-api", r.Header.Get(header.Authorization))
+
+		token, err := source.Token(r.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+		assert.Len(t, store.keys, 1)
+		for _, ttl := range store.ttls {
+			assert.InDelta(t, 3590, ttl, 2, "token should expire from the cache before it expires")
+		}
+
+		// another gateway gets the token from the shared cache
+		other := newUpstreamTokenSource("org", conf, store)
+		token, err = other.Token(r.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("keeps the tokens of other audiences apart", func(t *testing.T) {
+		store := newStore()
+		otherConf := conf
+		otherConf.EndpointParams = map[string]string{"audience": "-other"}
+
+		token, err := newUpstreamTokenSource("org", conf, store).Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+
+		token, err = newUpstreamTokenSource("org", otherConf, store).Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-other", token)
+		assert.Len(t, store.keys, 2)
+	})
+
+	t.Run("keeps the tokens of other secrets and organisations apart", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		store := newStore()
+		token, err := newUpstreamTokenSource("org", conf, store).Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+
+		wrongConf := conf
+		wrongConf.ClientSecret = "wrong"
+		_, err = newUpstreamTokenSource("org", wrongConf, store).Token(context.Background())
+		assert.Error(t, err, "a wrong secret shouldn't get the cached token")
+
+		wrongConf.ClientSecret = ""
+		_, err = newUpstreamTokenSource("org", wrongConf, store).Token(context.Background())
+		assert.Error(t, err, "an empty secret shouldn't get the cached token")
+
+		token, err = newUpstreamTokenSource("other-org", conf, store).Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+		assert.Equal(t, int32(4), atomic.LoadInt32(&fetches), "other organisations should fetch their own tokens")
+		assert.Len(t, store.keys, 2)
+	})
+
+	t.Run("refreshes tokens before their expiry", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		refreshConf := conf
+		refreshConf.ExpiryDelta = 3600
+		source := newUpstreamTokenSource("org", refreshConf, newStore())
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		source.Inject(r)
+		source.Inject(r)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("client credentials in params", func(t *testing.T) {
+		paramsConf := conf
+		paramsConf.AuthStyle = apidef.UpstreamOAuthAuthInParams
+		paramsConf.HeaderName = "X-Upstream-Token"
+		source := newUpstreamTokenSource("org", paramsConf, newStore())
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		source.Inject(r)
+		assert.Equal(t, "Bearer token-read-api", r.Header.Get("X-Upstream-Token"))
+		assert.Empty(t, r.Header.Get(header.Authorization))
+	})
+
+	t.Run("token endpoint errors", func(t *testing.T) {
+		badConf := conf
+		badConf.ClientSecret = "wrong"
+		source := newUpstreamTokenSource("org", badConf, newStore())
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(header.Authorization, "client key")
+		_, err := source.Token(r.Context())
+		assert.Error(t, err)
+
+		source.Inject(r)
+		assert.Equal(t, "client key", r.Header.Get(header.Authorization))
+	})
+
+	t.Run("fetches tokens once for concurrent requests", func(t *testing.T) {
+		var slowFetches int32
+		release := make(chan struct{})
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&slowFetches, 1)
+			<-release
+			w.Write([]byte(`{"access_token":"slow","expires_in":3600}`))
+		}))
+		defer slowServer.Close()
+
+		slowConf := conf
+		slowConf.TokenURL = slowServer.URL
+		source := newUpstreamTokenSource("org", slowConf, newStore())
+
+		var wg sync.WaitGroup
+		tokens := make([]string, 10)
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tokens[i], _ = source.Token(context.Background())
+			}(i)
+		}
+
+		// requests giving up don't wait for the fetch
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := source.Token(ctx)
+		assert.Equal(t, context.Canceled, err)
+
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&slowFetches))
+		for _, token := range tokens {
+			assert.Equal(t, "slow", token)
+		}
+	})
+
+	t.Run("backs off after failed fetches", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		badConf := conf
+		badConf.ClientSecret = "wrong"
+		source := newUpstreamTokenSource("org", badConf, newStore())
+
+		for i := 0; i < 5; i++ {
+			_, err := source.Token(context.Background())
+			assert.Error(t, err)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "the token endpoint shouldn't be requested while backing off")
+		assert.Equal(t, upstreamOAuthMinBackoff, source.backoff)
+
+		source.retryAt = time.Now()
+		_, err := source.Token(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+		assert.Equal(t, 2*upstreamOAuthMinBackoff, source.backoff, "the backoff should grow with consecutive failures")
+
+		// fetches succeeding again reset the backoff
+		source.conf.ClientSecret = "secret"
+		source.retryAt = time.Now()
+		token, err := source.Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token-read-api", token)
+		assert.Zero(t, source.backoff)
+	})
+
+	t.Run("resolves secrets", func(t *testing.T) {
+		gw := &Gateway{}
+		gw.SetConfig(config.Config{Secrets: map[string]string{"upstream_secret": "secret"}})
+
+		secretConf := conf
+		secretConf.ClientSecret = "secrets://upstream_secret"
+		source, err := gw.newUpstreamTokenSource("org", secretConf)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", source.conf.ClientSecret)
+
+		secretConf.ClientSecret = "secrets://missing"
+		_, err = gw.newUpstreamTokenSource("org", secretConf)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,321 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseBackoff = 25
+	defaultRetryMaxBackoff  = 250
+
+	defaultRetryBudgetRatio               = 0.2
+	defaultRetryBudgetMinRetriesPerSecond = 10
+
+	// retryBudgetWindow is the number of seconds the retry budget looks back.
+	retryBudgetWindow = 10
+)
+
+var defaultRetryOnStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+func retryPolicyConfig(policy apidef.RetryPolicy) apidef.RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if len(policy.RetryOnStatusCodes) == 0 {
+		policy.RetryOnStatusCodes = defaultRetryOnStatusCodes
+	}
+	if policy.BaseBackoff <= 0 {
+		policy.BaseBackoff = defaultRetryBaseBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return policy
+}
+
+func retryBudgetConfig(conf config.RetryBudgetConfig) config.RetryBudgetConfig {
+	if conf.Ratio <= 0 {
+		conf.Ratio = defaultRetryBudgetRatio
+	}
+	if conf.MinRetriesPerSecond <= 0 {
+		conf.MinRetriesPerSecond = defaultRetryBudgetMinRetriesPerSecond
+	}
+	return conf
+}
+
+// RetryBudget limits upstream retries to a ratio of the requests sent over the last
+// retryBudgetWindow seconds, so that retries can't amplify an upstream outage.
+type RetryBudget struct {
+	mu      sync.Mutex
+	buckets [retryBudgetWindow]retryBudgetBucket
+}
+
+type retryBudgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func (b *RetryBudget) bucket(now time.Time) *retryBudgetBucket {
+	second := now.Unix()
+	bucket := &b.buckets[second%retryBudgetWindow]
+	if bucket.second != second {
+		*bucket = retryBudgetBucket{second: second}
+	}
+	return bucket
+}
+
+// Request records a request that is subject to a retry policy.
+func (b *RetryBudget) Request(now time.Time) {
+	b.mu.Lock()
+	b.bucket(now).requests++
+	b.mu.Unlock()
+}
+
+// Withdraw reports whether a retry fits in the budget, and records it if it does.
+func (b *RetryBudget) Withdraw(conf config.RetryBudgetConfig, now time.Time) bool {
+	conf = retryBudgetConfig(conf)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var requests, retries int
+	for _, bucket := range b.buckets {
+		if now.Unix()-bucket.second < retryBudgetWindow {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := float64(conf.MinRetriesPerSecond*retryBudgetWindow) + conf.Ratio*float64(requests)
+	if float64(retries+1) > allowed {
+		return false
+	}
+
+	b.bucket(now).retries++
+	return true
+}
+
+// CheckRetryPolicyEnforced returns the retry policy of the requested endpoint, falling back
+// to the retry policy of the API. It returns nil if the request shouldn't be retried.
+func (p *ReverseProxy) CheckRetryPolicyEnforced(spec *APISpec, req *http.Request) *apidef.RetryPolicy {
+	if !spec.RetryPolicyEnabled {
+		return nil
+	}
+
+	policy := spec.Proxy.RetryPolicy
+
+	versionInfo, _ := spec.Version(req)
+	versionPaths := spec.RxPaths[versionInfo.Name]
+	if found, meta := spec.CheckSpecMatchesStatus(req, versionPaths, RetryPolicy); found {
+		policy = meta.(*apidef.RetryPolicyMeta).RetryPolicy()
+		p.logger.Debug("Retry policy enforced for path: ", policy)
+	}
+
+	if !policy.Enabled {
+		return nil
+	}
+
+	policy = retryPolicyConfig(policy)
+	return &policy
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func shouldRetry(policy *apidef.RetryPolicy, res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	for _, code := range policy.RetryOnStatusCodes {
+		if res.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns the exponential backoff with full jitter before the given retry, starting at 0.
+func retryBackoff(policy *apidef.RetryPolicy, retry int) time.Duration {
+	maxBackoff := time.Duration(policy.MaxBackoff) * time.Millisecond
+	backoff := time.Duration(policy.BaseBackoff) * time.Millisecond << uint(retry)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// sendRequestWithRetries sends the request upstream, retrying it on a different target according
+// to the retry policy. Only idempotent requests with a replayable body are retried. The returned
+// func releases the last attempt once its response is served.
+func (p *ReverseProxy) sendRequestWithRetries(roundTripper *TykRoundTripper, outreq *http.Request, policy *apidef.RetryPolicy) (*http.Response, func(), error) {
+	if upgrade, _ := p.IsUpgrade(outreq); policy == nil || upgrade || !isIdempotentMethod(outreq.Method) || outreq.ContentLength == -1 {
+		release := p.trackUpstream(outreq)
+		res, err := p.sendRequestToUpstream(roundTripper, outreq)
+		return res, release, err
+	}
+
+	p.Gw.retryBudget.Request(time.Now())
+	// buffer the body so it can be sent again
+	copyRequest(outreq)
+
+	logger := p.logger.WithFields(logrus.Fields{
+		"prefix": "proxy",
+		"api_id": p.TykAPISpec.APIID,
+	})
+
+	tried := map[string]bool{}
+	retries := 0
+	for {
+		tried[outreq.URL.Host] = true
+		untrack := p.trackUpstream(outreq)
+		res, cancel, err := p.tryUpstream(roundTripper, outreq, policy.PerTryTimeout)
+		release := func() {
+			cancel()
+			untrack()
+		}
+		if retries+1 >= policy.MaxAttempts || outreq.Context().Err() != nil || !shouldRetry(policy, res, err) {
+			p.tagRetries(outreq, retries)
+			return res, release, err
+		}
+
+		if !p.Gw.retryBudget.Withdraw(p.Gw.GetConfig().RetryBudget, time.Now()) {
+			logger.Warning("[RETRY] Retry budget exhausted, not retrying request to ", outreq.URL.Host)
+			p.tagRetries(outreq, retries)
+			return res, release, err
+		}
+
+		timer := time.NewTimer(retryBackoff(policy, retries))
+		select {
+		case <-outreq.Context().Done():
+			timer.Stop()
+			p.tagRetries(outreq, retries)
+			return res, release, err
+		case <-timer.C:
+		}
+
+		if p.TykAPISpec.Proxy.OutlierDetection.Enabled {
+			p.reportUpstreamOutcome(outreq, res, err)
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		release()
+
+		retries++
+		p.retryTarget(outreq, tried)
+		// rewind the buffered body
+		copyRequest(outreq)
+
+		if err != nil {
+			logger.Debug("[RETRY] Retrying request after error: ", err)
+		} else {
+			logger.Debug("[RETRY] Retrying request after response code: ", res.StatusCode)
+		}
+	}
+}
+
+// tryUpstream sends a single attempt of the request. The per-try timeout only limits the wait
+// for the response headers. The returned func releases the attempt once its response is read.
+func (p *ReverseProxy) tryUpstream(roundTripper *TykRoundTripper, outreq *http.Request, perTryTimeout float64) (*http.Response, context.CancelFunc, error) {
+	if perTryTimeout <= 0 {
+		res, err := p.sendRequestToUpstream(roundTripper, outreq)
+		return res, func() {}, err
+	}
+
+	attemptCtx, cancel := context.WithCancel(outreq.Context())
+	timer := time.AfterFunc(time.Duration(perTryTimeout*float64(time.Second)), cancel)
+
+	res, err := p.sendRequestToUpstream(roundTripper, outreq.WithContext(attemptCtx))
+	if !timer.Stop() && err != nil {
+		// reported like the hard timeout, rather than as the client closing the request
+		err = fmt.Errorf("per-try timeout awaiting response headers from %s", outreq.URL.Host)
+	}
+	return res, cancel, err
+}
+
+// trackUpstream marks the start of a request to the target picked by load balancing or service
+// discovery, and returns a func marking its end.
+func (p *ReverseProxy) trackUpstream(outreq *http.Request) func() {
+	spec := p.TykAPISpec
+	if !spec.Proxy.EnableLoadBalancing && !spec.Proxy.ServiceDiscovery.UseDiscoveryService && !spec.Proxy.TrafficSplit.Enabled {
+		return func() {}
+	}
+	return spec.UpstreamConnections.Track(outreq.URL.Host)
+}
+
+// retryTarget points the request to a target that hasn't been tried yet, when load balancing
+// or service discovery give a choice of targets.
+func (p *ReverseProxy) retryTarget(outreq *http.Request, tried map[string]bool) {
+	spec := p.TykAPISpec
+	if !spec.Proxy.EnableLoadBalancing && !spec.Proxy.ServiceDiscovery.UseDiscoveryService && !spec.Proxy.TrafficSplit.Enabled {
+		return
+	}
+	// the target of a host rewrite is fixed
+	if outreq.Context().Value(ctx.RetainHost) == true {
+		return
+	}
+
+	hostList := spec.upstreamHostList(outreq)
+	if hostList == nil {
+		return
+	}
+
+	var target *url.URL
+	for i := 0; i < hostList.Len(); i++ {
+		next, err := p.Gw.nextTarget(hostList, spec, outreq)
+		if err != nil {
+			break
+		}
+		u, err := url.Parse(next)
+		if err != nil {
+			continue
+		}
+		target = u
+		if !tried[u.Host] {
+			break
+		}
+	}
+
+	if target == nil || target.Host == outreq.URL.Host {
+		return
+	}
+
+	outreq.URL.Host = target.Host
+	switch target.Scheme {
+	case "h2c", "ws":
+		outreq.URL.Scheme = "http"
+	case "wss":
+		outreq.URL.Scheme = "https"
+	default:
+		outreq.URL.Scheme = target.Scheme
+	}
+	if !spec.Proxy.PreserveHostHeader {
+		outreq.Host = target.Host
+	}
+}
+
+func (p *ReverseProxy) tagRetries(outreq *http.Request, retries int) {
+	if retries > 0 {
+		ctxAddAnalyticsTags(outreq, "retries-"+strconv.Itoa(retries))
+	}
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+func TestRetryBudget(t *testing.T) {
+	conf := config.RetryBudgetConfig{Ratio: 0.5, MinRetriesPerSecond: 1}
+	now := time.Now()
+
+	b := &RetryBudget{}
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.Withdraw(conf, now), "min retries per second should be allowed")
+	}
+	assert.False(t, b.Withdraw(conf, now))
+
+	for i := 0; i < 4; i++ {
+		b.Request(now)
+	}
+	assert.True(t, b.Withdraw(conf, now))
+	assert.True(t, b.Withdraw(conf, now))
+	assert.False(t, b.Withdraw(conf, now), "retries should be limited to the ratio of requests")
+
+	assert.True(t, b.Withdraw(conf, now.Add(retryBudgetWindow*time.Second)), "budget should recover after the window")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := retryPolicyConfig(apidef.RetryPolicy{BaseBackoff: 10, MaxBackoff: 30})
+	for retry := 0; retry < 5; retry++ {
+		backoff := retryBackoff(&policy, retry)
+		assert.True(t, backoff >= 0 && backoff <= 30*time.Millisecond)
+	}
+	assert.True(t, retryBackoff(&policy, 0) <= 10*time.Millisecond)
+}
+
+func TestSendRequestWithRetries(t *testing.T) {
+	var failingHits, healthyHits int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	gw := &Gateway{}
+	gw.SetConfig(config.Config{})
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.Proxy.EnableLoadBalancing = true
+	spec.Proxy.StructuredTargetList = apidef.NewHostListFromList([]string{failing.URL, healthy.URL})
+
+	p := &ReverseProxy{TykAPISpec: spec, Gw: gw, logger: logrus.NewEntry(log)}
+	rt := &TykRoundTripper{transport: &http.Transport{}, logger: logrus.NewEntry(log), Gw: gw}
+	policy := retryPolicyConfig(apidef.RetryPolicy{Enabled: true, BaseBackoff: 1, MaxBackoff: 1})
+
+	newRequest := func(method string) *http.Request {
+		r := httptest.NewRequest(method, failing.URL+"/test", strings.NewReader("body"))
+		r.RequestURI = ""
+		ctxInitAnalyticsTags(r)
+		return r
+	}
+
+	t.Run("retries idempotent request on a different target", func(t *testing.T) {
+		failingHits, healthyHits = 0, 0
+		r := newRequest(http.MethodPut)
+		res, release, err := p.sendRequestWithRetries(rt, r, &policy)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, 1, failingHits)
+		assert.Equal(t, 1, healthyHits)
+		assert.Equal(t, []string{"retries-1"}, ctxGetAnalyticsTags(r))
+
+		failingHost, healthyHost := strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(healthy.URL, "http://")
+		assert.Zero(t, spec.UpstreamConnections.InFlight(failingHost), "retried attempts should be released")
+		assert.EqualValues(t, 1, spec.UpstreamConnections.InFlight(healthyHost), "the last attempt should be counted on its target")
+		release()
+		assert.Zero(t, spec.UpstreamConnections.InFlight(healthyHost))
+	})
+
+	t.Run("doesn't retry non-idempotent request", func(t *testing.T) {
+		failingHits, healthyHits = 0, 0
+		r := newRequest(http.MethodPost)
+		res, release, err := p.sendRequestWithRetries(rt, r, &policy)
+		defer release()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, 1, failingHits)
+		assert.Empty(t, ctxGetAnalyticsTags(r))
+	})
+
+	t.Run("doesn't retry response code that isn't retryable", func(t *testing.T) {
+		failingHits, healthyHits = 0, 0
+		policy := retryPolicyConfig(apidef.RetryPolicy{Enabled: true, RetryOnStatusCodes: []int{http.StatusBadGateway}})
+		res, release, err := p.sendRequestWithRetries(rt, newRequest(http.MethodGet), &policy)
+		defer release()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, 0, healthyHits)
+	})
+}
@@ -14,6 +14,7 @@ const (
 	Expires                 = "Expires"
 	Connection              = "Connection"
 	WWWAuthenticate         = "WWW-Authenticate"
+	RetryAfter              = "Retry-After"
 )
 
 const (
@@ -55,3 +56,9 @@ const (
 	XRateLimitRemaining = "X-RateLimit-Remaining"
 	XRateLimitReset     = "X-RateLimit-Reset"
 )
+
+// rate limit headers of the IETF httpapi working group
+const (
+	RateLimit       = "RateLimit"
+	RateLimitPolicy = "RateLimit-Policy"
+)
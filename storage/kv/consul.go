@@ -30,6 +30,18 @@ func (c *Consul) Get(key string) (string, error) {
 }
 
 func newConsul(conf config.ConsulConfig) (Store, error) {
+	client, err := NewConsulClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Consul{
+		store: client.KV(),
+	}, nil
+}
+
+// NewConsulClient returns a Consul API client configured with conf
+func NewConsulClient(conf config.ConsulConfig) (*api.Client, error) {
 	defaultCfg := api.DefaultConfig()
 
 	if conf.Address != "" {
@@ -72,12 +84,5 @@ func newConsul(conf config.ConsulConfig) (Store, error) {
 		defaultCfg.TLSConfig.InsecureSkipVerify = conf.TLSConfig.InsecureSkipVerify
 	}
 
-	client, err := api.NewClient(defaultCfg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Consul{
-		store: client.KV(),
-	}, nil
+	return api.NewClient(defaultCfg)
 }
@@ -1089,6 +1089,56 @@ func (r *RedisCluster) RemoveSortedSetRange(keyName, scoreFrom, scoreTo string)
 	return nil
 }
 
+// SortedSetMembers are members of the sorted set Key.
+type SortedSetMembers struct {
+	Key     string
+	Members []string
+}
+
+// addToSortedSetScript scores the members of the key with the later of their score and
+// ARGV[2], removes the members scored up to ARGV[1] and keeps the key for at least ARGV[3]
+// seconds. ARGV[4:] are the members.
+var addToSortedSetScript = redis.NewScript(`
+local score = tonumber(ARGV[2])
+for i = 4, #ARGV do
+	local current = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[i]))
+	if not current or current < score then
+		redis.call('ZADD', KEYS[1], score, ARGV[i])
+	end
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('TTL', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+end
+return 0
+`)
+
+// AddToSortedSetsWithExpiry adds members to several sorted sets in a single round trip. Members
+// are scored with the later of their current score and score, the members scored up to trimUntil
+// are removed, and the sets are kept for at least ttl seconds.
+func (r *RedisCluster) AddToSortedSetsWithExpiry(sets []SortedSetMembers, score, trimUntil float64, ttl int64) error {
+	if err := r.up(); err != nil {
+		return err
+	}
+
+	ctx := r.RedisController.ctx
+	_, err := r.singleton().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, set := range sets {
+			args := make([]interface{}, 0, len(set.Members)+3)
+			args = append(args, trimUntil, score, ttl)
+			for _, member := range set.Members {
+				args = append(args, member)
+			}
+			addToSortedSetScript.Eval(ctx, pipe, []string{r.fixKey(set.Key)}, args...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Error trying to add to sorted sets")
+	}
+	return err
+}
+
 func (r *RedisCluster) ControllerInitiated() bool {
 	return r.RedisController != nil
 }
@@ -179,3 +179,96 @@ func TestRedisExpirationTime(t *testing.T) {
 	assert.Equal(t, nil, errGetExp)
 
 }
+
+func TestRedisRateLimitScripts(t *testing.T) {
+	storage := &RedisCluster{RedisController: rc}
+
+	limiters := map[string]func(key string, dryRun bool) (RateLimitResult, error){
+		"gcra": func(key string, dryRun bool) (RateLimitResult, error) {
+			return storage.RateLimitGCRA(key, 3, time.Minute, dryRun)
+		},
+		"sliding window": func(key string, dryRun bool) (RateLimitResult, error) {
+			return storage.RateLimitSlidingWindow(key, 3, time.Minute, dryRun)
+		},
+	}
+	for name, limit := range limiters {
+		t.Run(name, func(t *testing.T) {
+			key := "test-rate-limit-" + name
+			storage.DeleteRawKey(key)
+			defer storage.DeleteRawKey(key)
+
+			for remaining := int64(2); remaining >= 0; remaining-- {
+				result, err := limit(key, false)
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, remaining, result.Remaining)
+				assert.True(t, result.Reset > 0 && result.Reset <= time.Minute)
+			}
+
+			result, err := limit(key, true)
+			assert.NoError(t, err)
+			assert.False(t, result.Allowed, "dry runs should report the limit")
+
+			result, err = limit(key, false)
+			assert.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, int64(0), result.Remaining)
+			assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= time.Minute)
+
+			ttl, err := storage.GetExp(key)
+			assert.NoError(t, err)
+			assert.True(t, ttl > 0, "keys should expire")
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	rate, window := slidingWindow(3, time.Minute)
+	assert.Equal(t, int64(3), rate)
+	assert.Equal(t, time.Minute, window)
+
+	rate, window = slidingWindow(0.5, time.Second)
+	assert.Equal(t, int64(1), rate, "fractional rates shouldn't block every request")
+	assert.Equal(t, 2*time.Second, window)
+
+	rate, window = slidingWindow(2.5, time.Second)
+	assert.Equal(t, int64(3), rate)
+	assert.Equal(t, 1200*time.Millisecond, window)
+}
+
+func TestRedisIncrementByWithExpire(t *testing.T) {
+	storage := &RedisCluster{RedisController: rc}
+	key := "test-increment-by"
+	storage.DeleteRawKey(key)
+	defer storage.DeleteRawKey(key)
+
+	val, err := storage.IncrementByWithExpire(key, 3, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), val)
+
+	val, err = storage.IncrementByWithExpire(key, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), val, "empty increments should read the counter")
+
+	ttl, err := storage.GetExp(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0, "keys should expire")
+}
+
+func TestRedisRateLimitGCRABurst(t *testing.T) {
+	storage := &RedisCluster{RedisController: rc}
+	key := "test-rate-limit-burst"
+	storage.DeleteRawKey(key)
+	defer storage.DeleteRawKey(key)
+
+	for i := 0; i < 2; i++ {
+		result, err := storage.RateLimitGCRABurst(key, 10, time.Minute, 2, false)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := storage.RateLimitGCRABurst(key, 10, time.Minute, 2, false)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed, "requests over the burst should be limited")
+	assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= 6*time.Second)
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RateLimitResult is the outcome of a rate limited request.
+type RateLimitResult struct {
+	// Allowed is false if the request exceeds the rate limit
+	Allowed bool
+	// Remaining is the number of requests allowed right after this one
+	Remaining int64
+	// Reset is the time until the limit is fully available again
+	Reset time.Duration
+	// RetryAfter is the time until a request is allowed again, if the request isn't allowed
+	RetryAfter time.Duration
+}
+
+// Both scripts use the clock of Redis, so the limits are consistent across gateways, and return
+// {allowed, remaining, reset in ms, retry after in ms}. Commands are replicated instead of the
+// scripts, as the scripts aren't deterministic.
+
+// gcraScript implements the generic cell rate algorithm: the key holds the theoretical arrival
+// time of the next request, in ms. ARGV: emission interval in ms, burst tolerance in ms, dry run.
+var gcraScript = redis.NewScript(`
+if redis.replicate_commands then redis.replicate_commands() end
+local emission = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+
+local newTat = tat + emission
+local allowAt = newTat - period
+if allowAt > now then
+	return {0, 0, math.ceil(tat - now), math.ceil(allowAt - now)}
+end
+
+if ARGV[3] ~= '1' then
+	redis.call('SET', KEYS[1], newTat, 'PX', math.ceil(newTat - now))
+end
+return {1, math.floor((now - allowAt) / emission), math.ceil(newTat - now), 0}
+`)
+
+// slidingWindowScript implements the sliding window counter: the key holds the number of requests
+// of the current and previous fixed windows, and the count of the sliding window is estimated by
+// weighting the previous window by its overlap. ARGV: rate, period in ms, dry run.
+var slidingWindowScript = redis.NewScript(`
+if redis.replicate_commands then redis.replicate_commands() end
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = math.floor(now / period)
+local elapsed = now - window * period
+
+local data = redis.call('HMGET', KEYS[1], 'w', 'c', 'p')
+local current = tonumber(data[2]) or 0
+local previous = tonumber(data[3]) or 0
+if tonumber(data[1]) ~= window then
+	if tonumber(data[1]) == window - 1 then
+		previous = current
+	else
+		previous = 0
+	end
+	current = 0
+end
+
+local count = math.floor(previous * (period - elapsed) / period + current)
+if count >= rate then
+	local retryAfter = period - elapsed
+	if current < rate and previous > 0 then
+		-- the count drops below the rate once the overlap of the previous window is small enough
+		retryAfter = math.floor(period * (1 - (rate - current) / previous)) + 1 - elapsed
+	end
+	return {0, 0, period - elapsed, math.max(retryAfter, 1)}
+end
+
+if ARGV[3] ~= '1' then
+	redis.call('HSET', KEYS[1], 'w', window, 'c', current + 1, 'p', previous)
+	redis.call('PEXPIRE', KEYS[1], 2 * period)
+end
+return {1, rate - count - 1, period - elapsed, 0}
+`)
+
+// RateLimitGCRA limits the requests to the key to rate per period with the generic cell rate
+// algorithm. Keys use O(1) memory, and bursts of rate requests are allowed. Like the rolling
+// window keys, the key isn't prefixed.
+func (r *RedisCluster) RateLimitGCRA(keyName string, rate float64, per time.Duration, dryRun bool) (RateLimitResult, error) {
+	emission := float64(per/time.Millisecond) / rate
+	return r.runRateLimitScript(gcraScript, keyName, emission, int64(per/time.Millisecond), dryRun)
+}
+
+// RateLimitGCRABurst is RateLimitGCRA allowing bursts of the given number of requests instead.
+func (r *RedisCluster) RateLimitGCRABurst(keyName string, rate float64, per time.Duration, burst int64, dryRun bool) (RateLimitResult, error) {
+	if burst < 1 {
+		burst = 1
+	}
+	emission := float64(per/time.Millisecond) / rate
+	return r.runRateLimitScript(gcraScript, keyName, emission, int64(float64(burst)*emission), dryRun)
+}
+
+// RateLimitSlidingWindow limits the requests to the key to rate per period with a sliding window
+// counter. Keys use O(1) memory, and aren't prefixed.
+func (r *RedisCluster) RateLimitSlidingWindow(keyName string, rate float64, per time.Duration, dryRun bool) (RateLimitResult, error) {
+	windowRate, window := slidingWindow(rate, per)
+	return r.runRateLimitScript(slidingWindowScript, keyName, windowRate, int64(window/time.Millisecond), dryRun)
+}
+
+// slidingWindow returns the whole number of requests per window of the sliding window counter
+// allowing rate requests per period. Fractional rates are rounded up, and the window lengthened
+// to keep the same average rate, e.g. 0.5 requests per second are 1 request per 2 seconds.
+func slidingWindow(rate float64, per time.Duration) (int64, time.Duration) {
+	windowRate := math.Ceil(rate)
+	if windowRate == rate || rate <= 0 {
+		return int64(rate), per
+	}
+	return int64(windowRate), time.Duration(float64(per) * windowRate / rate)
+}
+
+func (r *RedisCluster) runRateLimitScript(script *redis.Script, keyName string, arg interface{}, periodMs int64, dryRun bool) (RateLimitResult, error) {
+	if err := r.up(); err != nil {
+		return RateLimitResult{Allowed: true}, err
+	}
+
+	dryRunArg := "0"
+	if dryRun {
+		dryRunArg = "1"
+	}
+	values, err := script.Run(r.RedisController.ctx, r.singleton(), []string{keyName}, arg, periodMs, dryRunArg).Int64Slice()
+	if err == nil && len(values) != 4 {
+		err = fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+	if err != nil {
+		log.WithError(err).Error("Rate limit script failed")
+		return RateLimitResult{Allowed: true}, err
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		Reset:      time.Duration(values[2]) * time.Millisecond,
+		RetryAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// IncrementByWithExpire adds delta to the counter of the key and returns the new value, so the
+// counters of several requests can be synchronised in a single round trip. The expiry is reset on
+// every increment, and the key isn't prefixed.
+func (r *RedisCluster) IncrementByWithExpire(keyName string, delta int64, expire time.Duration) (int64, error) {
+	if err := r.up(); err != nil {
+		return 0, err
+	}
+
+	var incr *redis.IntCmd
+	_, err := r.singleton().Pipelined(r.RedisController.ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(r.RedisController.ctx, keyName, delta)
+		pipe.PExpire(r.RedisController.ctx, keyName, expire)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Error trying to increment value")
+		return 0, err
+	}
+	return incr.Val(), nil
+}
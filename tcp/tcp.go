@@ -62,6 +62,11 @@ type Proxy struct {
 	Dial            func(network, addr string) (net.Conn, error)
 	TLSConfigTarget *tls.Config
 
+	// DialContext and DialTLSContext take precedence over Dial and DialTLS if set. The client
+	// connection the target connection is opened for is available from the context with ClientConn.
+	DialContext    func(ctx context.Context, network, addr string) (net.Conn, error)
+	DialTLSContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 
@@ -72,6 +77,14 @@ type Proxy struct {
 	StatsSyncInterval time.Duration
 }
 
+type clientConnKey struct{}
+
+// ClientConn returns the client connection a target connection is dialed for, or nil.
+func ClientConn(ctx context.Context) net.Conn {
+	conn, _ := ctx.Value(clientConnKey{}).(net.Conn)
+	return conn
+}
+
 func (p *Proxy) AddDomainHandler(domain, target string, modifier *Modifier) {
 	p.Lock()
 	defer p.Unlock()
@@ -217,15 +230,20 @@ func (p *Proxy) handleConn(conn net.Conn) error {
 
 	// connects to target server
 	var rconn net.Conn
+	dialCtx := context.WithValue(ctx, clientConnKey{}, conn)
 	switch u.Scheme {
 	case "tcp":
-		if p.Dial != nil {
+		if p.DialContext != nil {
+			rconn, err = p.DialContext(dialCtx, "tcp", u.Host)
+		} else if p.Dial != nil {
 			rconn, err = p.Dial("tcp", u.Host)
 		} else {
 			rconn, err = net.Dial("tcp", u.Host)
 		}
 	case "tls":
-		if p.DialTLS != nil {
+		if p.DialTLSContext != nil {
+			rconn, err = p.DialTLSContext(dialCtx, "tcp", u.Host)
+		} else if p.DialTLS != nil {
 			rconn, err = p.DialTLS("tcp", u.Host)
 		} else {
 			rconn, err = tls.Dial("tcp", u.Host, p.TLSConfigTarget)
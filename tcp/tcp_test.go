@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"reflect"
@@ -54,6 +55,32 @@ func TestProxyModifier(t *testing.T) {
 		}...)
 	})
 }
+func TestProxyDialContext(t *testing.T) {
+	// Echoing
+	upstream := test.TcpMock(false, func(in []byte, err error) (out []byte) {
+		return in
+	})
+	defer upstream.Close()
+
+	var clientAddr net.Addr
+	proxy := &Proxy{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			clientAddr = ClientConn(ctx).RemoteAddr()
+			return net.Dial(network, addr)
+		},
+	}
+	proxy.AddDomainHandler("", upstream.Addr().String(), nil)
+
+	testRunner(t, proxy, "", false, []test.TCPTestCase{
+		{Action: "write", Payload: "ping"},
+		{Action: "read", Payload: "ping"},
+	}...)
+
+	if clientAddr == nil {
+		t.Fatal("client connection should be in the dial context")
+	}
+}
+
 func TestProxySyncStats(t *testing.T) {
 	t.Skip()
 	// Echoing
@@ -51,6 +51,15 @@ type APILimit struct {
 	SetBy              string  `json:"-" msg:"-"`
 }
 
+// EndpointLimit limits the requests of a key to the endpoints of an API matching URL, a regular
+// expression like the URLs of AccessSpec, and one of the methods. The endpoints have rate limit
+// and quota counters of their own, on top of the limits of the API.
+type EndpointLimit struct {
+	URL     string   `json:"url" msg:"url"`
+	Methods []string `json:"methods" msg:"methods"`
+	Limit   APILimit `json:"limit" msg:"limit"`
+}
+
 // AccessDefinition defines which versions of an API a key has access to
 // NOTE: when adding new fields it is required to map them from DBAccessDefinition
 // in the gateway/policy.go:19
@@ -65,6 +74,7 @@ type AccessDefinition struct {
 	Limit                APILimit                `json:"limit" msg:"limit"`
 	FieldAccessRights    []FieldAccessDefinition `json:"field_access_rights" msg:"field_access_rights"`
 	DisableIntrospection bool                    `json:"disable_introspection" msg:"disable_introspection"`
+	Endpoints            []EndpointLimit         `json:"endpoints" msg:"endpoints"`
 
 	AllowanceScope string `json:"allowance_scope" msg:"allowance_scope"`
 }